@@ -50,6 +50,53 @@ BEGIN
 END
 GO
 
+-- หัวหน้างาน (ใช้กับ SCIM enterprise extension)
+IF COL_LENGTH('dbo.users','manager_id') IS NULL
+BEGIN
+  ALTER TABLE dbo.users ADD manager_id INT NULL
+    CONSTRAINT fk_users_manager REFERENCES dbo.users(id);
+END
+GO
+
+-- เวลาแก้ไขล่าสุดของบริษัท (ใช้ทำ ETag ของ SCIM Groups)
+IF COL_LENGTH('dbo.companies','updated_at') IS NULL
+BEGIN
+  ALTER TABLE dbo.companies ADD updated_at DATETIME2(7) NOT NULL
+    CONSTRAINT df_companies_updated_at DEFAULT SYSUTCDATETIME();
+END
+GO
+
+-- ETag ของ SCIM ใช้ updated_at แบบละเอียดเต็ม (DATETIME2(0) ชนกันเมื่อแก้ไขสองครั้งในวินาทีเดียว)
+-- companies.updated_at ประกาศเป็น DATETIME2(7) ตั้งแต่เพิ่มคอลัมน์ด้านบน
+IF EXISTS (SELECT 1 FROM sys.columns WHERE object_id=OBJECT_ID('dbo.users') AND name='updated_at' AND scale<7)
+BEGIN
+  ALTER TABLE dbo.users ALTER COLUMN updated_at DATETIME2(7) NOT NULL;
+END
+GO
+
+-- สถานะบัญชี (SCIM active): 0 = ปิดบัญชี login ไม่ได้
+IF COL_LENGTH('dbo.users','active') IS NULL
+BEGIN
+  ALTER TABLE dbo.users ADD active BIT NOT NULL
+    CONSTRAINT df_users_active DEFAULT 1;
+END
+GO
+
+-- ประวัติการแก้ไขข้อมูลผู้ใช้ (ไม่ผูก FK กับ users เพื่อให้ประวัติอยู่ต่อหลังลบ)
+IF OBJECT_ID('dbo.user_history','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.user_history (
+    id         BIGINT IDENTITY(1,1) PRIMARY KEY,
+    user_id    INT NOT NULL,
+    actor_id   INT NULL,                    -- ผู้ทำรายการ (จาก JWT), NULL = ไม่ทราบ
+    action     NVARCHAR(20) NOT NULL,       -- create / update / delete
+    changes    NVARCHAR(MAX) NOT NULL,      -- JSON {field: {before, after}}
+    created_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+  CREATE INDEX ix_user_history_user ON dbo.user_history(user_id, id DESC);
+END
+GO
+
 
 -- --- Time Attendance per user (summary + array items) ---
 IF OBJECT_ID('dbo.user_time_attendance','U') IS NULL
@@ -111,6 +158,13 @@ END
 GO
 
 
+-- สถานะเก็บถาวรของฟอร์ม (ซ่อนจากรายการ แต่ข้อมูลยังอยู่)
+IF COL_LENGTH('dbo.eval_form','archived_at') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_form ADD archived_at DATETIME2(0) NULL;
+END
+GO
+
 -- มอบหมายฟอร์มให้ผู้ใช้ (หนึ่งฟอร์ม ต่อหนึ่ง user หนึ่งแถว)
 IF OBJECT_ID('dbo.eval_assignment','U') IS NULL
 BEGIN
@@ -222,21 +276,7 @@ BEGIN
 END
 GO
 
-/* 4) การประเมินเพิ่มเติม (5 ข้อ แบบข้อความ) */
-IF OBJECT_ID('dbo.eval_additional','U') IS NULL
-BEGIN
-  CREATE TABLE dbo.eval_additional (
-    assignment_id INT PRIMARY KEY
-      REFERENCES dbo.eval_assignment(id) ON DELETE CASCADE,
-    q1 NVARCHAR(2000) NULL,
-    q2 NVARCHAR(2000) NULL,
-    q3 NVARCHAR(2000) NULL,
-    q4 NVARCHAR(2000) NULL,
-    q5 NVARCHAR(2000) NULL,
-    updated_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
-  );
-END
-GO
+/* 4) การประเมินเพิ่มเติม — ย้ายไปเป็นคำถามที่ตั้งค่าได้ต่อฟอร์ม (eval_question / eval_answer) ท้ายไฟล์ */
 
 IF OBJECT_ID('dbo.eval_grade_band','U') IS NULL
 BEGIN
@@ -252,6 +292,15 @@ END
 GO
 
 
+-- band ระดับบริษัท (form_id NULL + company_id = ใช้กับพนักงานของบริษัทนั้น)
+IF COL_LENGTH('dbo.eval_grade_band','company_id') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_grade_band ADD company_id UNIQUEIDENTIFIER NULL
+    CONSTRAINT fk_band_company REFERENCES dbo.companies(id) ON DELETE CASCADE;
+END
+GO
+
+
 -- ตารางเก็บขั้นตอนการประเมิน
 IF OBJECT_ID('dbo.eval_step','U') IS NULL
 BEGIN
@@ -268,3 +317,460 @@ BEGIN
 END
 GO
 
+
+-- ขยายเวลาช่วงตั้งค่า KPI / ช่วงประเมิน รายคน (HR อนุมัติ)
+IF OBJECT_ID('dbo.eval_window_extension','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_window_extension (
+    id             INT IDENTITY(1,1) PRIMARY KEY,
+    assignment_id  INT NOT NULL REFERENCES dbo.eval_assignment(id) ON DELETE CASCADE,
+    window_name    NVARCHAR(20) NOT NULL,      -- kpi_cfg / eval
+    extended_until DATE NOT NULL,
+    reason         NVARCHAR(500) NULL,
+    granted_by     INT NULL,                   -- users.id ของ HR
+    created_at     DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at     DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    CONSTRAINT uq_eval_window_ext UNIQUE (assignment_id, window_name)
+  );
+END
+GO
+
+
+-- น้ำหนักของแต่ละขั้น (self / หัวหน้า / ผู้บริหาร) ใช้รวมเป็นคะแนนสุดท้าย; NULL ทุกขั้น = ใช้คะแนนขั้นล่าสุด
+IF COL_LENGTH('dbo.eval_step','weight') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_step ADD weight DECIMAL(5,2) NULL;
+END
+GO
+
+-- คะแนนแยกตามขั้นการประเมิน (kind: kpi=eval_kpi_user.id, comp=eval_competency.id, ta=0)
+-- replace KPI ทั้งชุดทำให้ id เปลี่ยน → ผูกใหม่ด้วย code / title (relinkStepScores) แทน FK
+IF OBJECT_ID('dbo.eval_step_score','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_step_score (
+    id         INT IDENTITY(1,1) PRIMARY KEY,
+    step_id    INT NOT NULL REFERENCES dbo.eval_step(id) ON DELETE CASCADE,
+    kind       NVARCHAR(10) NOT NULL,
+    item_id    INT NOT NULL DEFAULT 0,
+    score      DECIMAL(6,2) NOT NULL DEFAULT 0,
+    note       NVARCHAR(1000) NULL,
+    updated_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    CONSTRAINT uq_eval_step_score UNIQUE (step_id, kind, item_id)
+  );
+END
+GO
+
+-- คะแนนสุดท้ายที่รวมทุกขั้นแล้ว (CombineStepScores) แยกจาก score ซึ่งเป็นคะแนนที่พนักงานกรอกเอง
+-- NULL = ยังไม่มีคะแนนรายขั้น → ใช้ score
+IF COL_LENGTH('dbo.eval_kpi_user','final_score') IS NULL
+  ALTER TABLE dbo.eval_kpi_user ADD final_score DECIMAL(6,2) NULL;
+GO
+IF COL_LENGTH('dbo.eval_competency_score','final_score') IS NULL
+  ALTER TABLE dbo.eval_competency_score ADD final_score DECIMAL(6,2) NULL;
+GO
+IF COL_LENGTH('dbo.eval_ta_score','final_score') IS NULL
+  ALTER TABLE dbo.eval_ta_score ADD final_score DECIMAL(6,2) NULL;
+GO
+
+-- log การเปลี่ยนสถานะของ assignment / step (ใคร ทำอะไร เมื่อไร)
+IF OBJECT_ID('dbo.eval_transition_log','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_transition_log (
+    id            INT IDENTITY(1,1) PRIMARY KEY,
+    assignment_id INT NOT NULL REFERENCES dbo.eval_assignment(id) ON DELETE CASCADE,
+    step_id       INT NULL,                  -- eval_step.id (ถ้าเป็นเหตุการณ์ระดับ step)
+    action        NVARCHAR(30) NOT NULL,     -- submit / start_review / step_complete / return / approve / acknowledge / close
+    from_status   TINYINT NOT NULL,
+    to_status     TINYINT NOT NULL,
+    actor_id      INT NULL,
+    comment       NVARCHAR(1000) NULL,
+    created_at    DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+  CREATE INDEX ix_eval_transition_log_assignment ON dbo.eval_transition_log(assignment_id, created_at);
+END
+GO
+
+-- ===== Calibration (HR ปรับเกรดให้สอดคล้องกันข้ามแผนก) =====
+IF OBJECT_ID('dbo.eval_calibration','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_calibration (
+    id         INT IDENTITY(1,1) PRIMARY KEY,
+    form_id    INT NOT NULL REFERENCES dbo.eval_form(id) ON DELETE CASCADE,
+    name       NVARCHAR(200) NOT NULL,
+    status     TINYINT NOT NULL DEFAULT 0,   -- 0=open, 1=locked
+    created_by INT NULL,
+    locked_by  INT NULL,
+    locked_at  DATETIME2(0) NULL,
+    created_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+END
+GO
+
+-- สัดส่วนเป้าหมายของแต่ละเกรด (เช่น A 10%, B 20%)
+IF OBJECT_ID('dbo.eval_calibration_target','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_calibration_target (
+    calibration_id INT NOT NULL REFERENCES dbo.eval_calibration(id) ON DELETE CASCADE,
+    grade          NVARCHAR(10) NOT NULL,
+    target_pct     DECIMAL(5,2) NOT NULL,
+    CONSTRAINT pk_eval_calibration_target PRIMARY KEY (calibration_id, grade)
+  );
+END
+GO
+
+-- assignment ใน session: calc_* = เกรดจากการคำนวณ, final_grade = เกรดที่ HR ปรับ (NULL = ใช้ calc_grade)
+-- assignment หนึ่งอยู่ได้ session เดียว
+IF OBJECT_ID('dbo.eval_calibration_item','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_calibration_item (
+    id             INT IDENTITY(1,1) PRIMARY KEY,
+    calibration_id INT NOT NULL REFERENCES dbo.eval_calibration(id) ON DELETE CASCADE,
+    assignment_id  INT NOT NULL REFERENCES dbo.eval_assignment(id), -- ไม่ cascade (multiple cascade paths จาก eval_form) → eval.DeleteUserData ลบก่อนลบ user
+    calc_pct       DECIMAL(6,2) NOT NULL DEFAULT 0,
+    calc_grade     NVARCHAR(10) NOT NULL DEFAULT 'N/A',
+    final_grade    NVARCHAR(10) NULL,
+    reason         NVARCHAR(1000) NULL,
+    overridden_by  INT NULL,
+    overridden_at  DATETIME2(0) NULL,
+    CONSTRAINT uq_eval_calibration_item UNIQUE (assignment_id)
+  );
+END
+GO
+
+-- ===== KPI library =====
+-- คลัง KPI กลาง: company_id / department NULL = ใช้ได้ทุกบริษัท / ทุกแผนก
+IF OBJECT_ID('dbo.eval_kpi_catalog','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_kpi_catalog (
+    id                INT IDENTITY(1,1) PRIMARY KEY,
+    company_id        UNIQUEIDENTIFIER NULL REFERENCES dbo.companies(id),
+    department        NVARCHAR(120) NULL,
+    code              NVARCHAR(50)  NOT NULL,
+    title             NVARCHAR(300) NOT NULL,
+    measure           NVARCHAR(500) NULL,
+    criteria          NVARCHAR(1000) NULL,
+    unit              NVARCHAR(50)  NULL,
+    default_max_score DECIMAL(6,2)  NOT NULL DEFAULT 5.0,
+    active            BIT NOT NULL DEFAULT 1,   -- ลบ = ปิดใช้งาน (ยังถูกอ้างอิงจาก eval_kpi_user)
+    created_at        DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at        DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    CONSTRAINT uq_eval_kpi_catalog_code UNIQUE (company_id, department, code)
+  );
+END
+GO
+
+-- template ตามตำแหน่งงาน (company_id NULL = ทุกบริษัท)
+IF OBJECT_ID('dbo.eval_kpi_template','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_kpi_template (
+    id         INT IDENTITY(1,1) PRIMARY KEY,
+    company_id UNIQUEIDENTIFIER NULL REFERENCES dbo.companies(id),
+    position   NVARCHAR(120) NOT NULL,
+    name       NVARCHAR(200) NOT NULL,
+    created_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+END
+GO
+
+-- รายการใน template: max_score NULL = ใช้ default_max_score ของคลัง
+IF OBJECT_ID('dbo.eval_kpi_template_item','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_kpi_template_item (
+    id             INT IDENTITY(1,1) PRIMARY KEY,
+    template_id    INT NOT NULL REFERENCES dbo.eval_kpi_template(id) ON DELETE CASCADE,
+    catalog_id     INT NOT NULL REFERENCES dbo.eval_kpi_catalog(id),
+    idx            INT NOT NULL DEFAULT 0,
+    weight         INT NOT NULL DEFAULT 0 CHECK (weight BETWEEN 0 AND 100),
+    max_score      DECIMAL(6,2) NULL,
+    expected_score DECIMAL(6,2) NULL
+  );
+END
+GO
+
+-- KPI ของพนักงานอ้างกลับไปที่คลัง (สำหรับรายงาน)
+IF COL_LENGTH('dbo.eval_kpi_user','catalog_id') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_kpi_user
+    ADD catalog_id INT NULL CONSTRAINT fk_kpi_user_catalog REFERENCES dbo.eval_kpi_catalog(id);
+END
+GO
+
+-- ===== Goal cascading =====
+-- เป้าหมายบริษัท → แผนก → ทีม ต่อรอบประเมิน (form); weight = สัดส่วนที่ส่งผลต่อเป้าหมายแม่
+IF OBJECT_ID('dbo.eval_objective','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_objective (
+    id            INT IDENTITY(1,1) PRIMARY KEY,
+    form_id       INT NOT NULL REFERENCES dbo.eval_form(id) ON DELETE CASCADE,
+    parent_id     INT NULL CONSTRAINT fk_eval_objective_parent REFERENCES dbo.eval_objective(id),
+    level         NVARCHAR(20)  NOT NULL CHECK (level IN (N'company', N'department', N'team')),
+    company_id    UNIQUEIDENTIFIER NULL REFERENCES dbo.companies(id),
+    department    NVARCHAR(120) NULL,
+    code          NVARCHAR(50)  NULL,
+    title         NVARCHAR(300) NOT NULL,
+    description   NVARCHAR(1000) NULL,
+    owner_user_id INT NULL REFERENCES dbo.users(id),
+    weight        INT NOT NULL DEFAULT 100 CHECK (weight BETWEEN 0 AND 100),
+    idx           INT NOT NULL DEFAULT 0,
+    created_at    DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at    DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+  CREATE INDEX ix_eval_objective_form ON dbo.eval_objective(form_id, parent_id);
+END
+GO
+
+-- KPI ของพนักงานส่งผลถึงเป้าหมายใด (ไม่ cascade: ลบเป้าหมายต้องเคลียร์ลิงก์ก่อน)
+IF COL_LENGTH('dbo.eval_kpi_user','objective_id') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_kpi_user
+    ADD objective_id INT NULL CONSTRAINT fk_kpi_user_objective REFERENCES dbo.eval_objective(id);
+END
+GO
+
+-- ===== KPI check-ins =====
+-- ความคืบหน้าระหว่างรอบ; kpi_user_id ไม่มี FK เพราะ replace KPI ทั้งชุดทำให้ id เปลี่ยน
+-- (ผูกใหม่ด้วย kpi_code / kpi_title เหมือน eval_step_score.item_id)
+IF OBJECT_ID('dbo.eval_kpi_checkin','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_kpi_checkin (
+    id               INT IDENTITY(1,1) PRIMARY KEY,
+    assignment_id    INT NOT NULL REFERENCES dbo.eval_assignment(id) ON DELETE CASCADE,
+    kpi_user_id      INT NOT NULL,
+    kpi_code         NVARCHAR(50)  NULL,
+    kpi_title        NVARCHAR(300) NOT NULL,
+    checkin_date     DATE NOT NULL,
+    actual_value     DECIMAL(18,4) NULL,
+    percent_complete DECIMAL(5,2)  NULL CHECK (percent_complete BETWEEN 0 AND 100),
+    comment          NVARCHAR(1000) NULL,
+    author_id        INT NULL REFERENCES dbo.users(id),
+    author_role      NVARCHAR(20) NOT NULL CHECK (author_role IN (N'employee', N'manager')),
+    created_at       DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+  CREATE INDEX ix_eval_kpi_checkin_kpi ON dbo.eval_kpi_checkin(assignment_id, kpi_user_id, checkin_date);
+END
+GO
+
+-- ===== KPI scoring rules =====
+-- scoring_rule = JSON {method: linear|bands, direction: higher|lower, target, baseline, bands[]}
+-- มี rule + actual_value และไม่ได้ override → score คำนวณอัตโนมัติ
+IF COL_LENGTH('dbo.eval_kpi_user','scoring_rule') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_kpi_user ADD
+    scoring_rule   NVARCHAR(2000) NULL,
+    actual_value   DECIMAL(18,4) NULL,
+    score_override BIT NOT NULL CONSTRAINT df_kpi_user_score_override DEFAULT 0;
+END
+GO
+
+-- ===== Competency rubric =====
+-- พจนานุกรมสมรรถนะ ใช้ซ้ำได้หลายฟอร์ม
+IF OBJECT_ID('dbo.eval_competency_def','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_competency_def (
+    id          INT IDENTITY(1,1) PRIMARY KEY,
+    code        NVARCHAR(50)  NOT NULL CONSTRAINT uq_eval_competency_def_code UNIQUE,
+    title       NVARCHAR(300) NOT NULL,
+    title_en    NVARCHAR(300) NULL,
+    description NVARCHAR(1000) NULL,
+    active      BIT NOT NULL DEFAULT 1,
+    created_at  DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at  DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+END
+GO
+
+-- คำอธิบายแต่ละระดับคะแนน; indicators = JSON array ของพฤติกรรมบ่งชี้
+IF OBJECT_ID('dbo.eval_competency_def_level','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_competency_def_level (
+    id         INT IDENTITY(1,1) PRIMARY KEY,
+    def_id     INT NOT NULL REFERENCES dbo.eval_competency_def(id) ON DELETE CASCADE,
+    score      DECIMAL(10,2) NOT NULL,
+    label      NVARCHAR(100) NOT NULL,
+    indicators NVARCHAR(MAX) NULL,
+    CONSTRAINT uq_eval_competency_def_level UNIQUE (def_id, score)
+  );
+END
+GO
+
+IF COL_LENGTH('dbo.eval_competency','def_id') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_competency
+    ADD def_id INT NULL CONSTRAINT fk_eval_competency_def REFERENCES dbo.eval_competency_def(id);
+END
+GO
+
+-- ระดับเฉพาะฟอร์ม (มี = ใช้แทนของพจนานุกรม)
+IF OBJECT_ID('dbo.eval_competency_level','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_competency_level (
+    id         INT IDENTITY(1,1) PRIMARY KEY,
+    comp_id    INT NOT NULL REFERENCES dbo.eval_competency(id) ON DELETE CASCADE,
+    score      DECIMAL(10,2) NOT NULL,
+    label      NVARCHAR(100) NOT NULL,
+    indicators NVARCHAR(MAX) NULL,
+    CONSTRAINT uq_eval_competency_level UNIQUE (comp_id, score)
+  );
+END
+GO
+
+-- ===== Additional questions =====
+-- คำถามเพิ่มเติมของแต่ละฟอร์ม (แทน q1–q5 แบบตายตัว)
+IF OBJECT_ID('dbo.eval_question','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_question (
+    id         INT IDENTITY(1,1) PRIMARY KEY,
+    form_id    INT NOT NULL REFERENCES dbo.eval_form(id) ON DELETE CASCADE,
+    idx        INT NOT NULL DEFAULT 0,
+    code       NVARCHAR(50)  NOT NULL,
+    qtype      VARCHAR(20)   NOT NULL
+      CONSTRAINT ck_eval_question_type CHECK (qtype IN ('text','long_text','single_choice','multi_choice','rating')),
+    label_th   NVARCHAR(500) NOT NULL,
+    label_en   NVARCHAR(500) NULL,
+    required   BIT NOT NULL DEFAULT 0,
+    options    NVARCHAR(MAX) NULL, -- JSON [{value,label_th,label_en}]
+    scale_min  INT NULL,
+    scale_max  INT NULL,
+    created_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    CONSTRAINT uq_eval_question_code UNIQUE (form_id, code)
+  );
+END
+GO
+
+-- คำตอบ: value_text = text/choice (multi_choice เป็น JSON array), value_num = rating
+-- question_id ไม่ cascade (ลบคำถามที่มีคำตอบไม่ได้; ฟอร์มลบได้เฉพาะตอนยังไม่มี assignment)
+IF OBJECT_ID('dbo.eval_answer','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_answer (
+    assignment_id INT NOT NULL REFERENCES dbo.eval_assignment(id) ON DELETE CASCADE,
+    question_id   INT NOT NULL REFERENCES dbo.eval_question(id),
+    value_text    NVARCHAR(MAX) NULL,
+    value_num     DECIMAL(10,2) NULL,
+    updated_at    DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    CONSTRAINT pk_eval_answer PRIMARY KEY (assignment_id, question_id)
+  );
+END
+GO
+
+-- ย้ายข้อมูล q1–q5 เดิม: ฟอร์มเดิมได้คำถาม q1..q5 (ข้อความยาว) แล้วคัดลอกคำตอบ
+-- ตารางเดิมเปลี่ยนชื่อเป็น eval_additional_legacy เพื่อไม่ให้ย้ายซ้ำ
+IF OBJECT_ID('dbo.eval_additional','U') IS NOT NULL
+BEGIN
+  INSERT INTO dbo.eval_question(form_id, idx, code, qtype, label_th, label_en)
+  SELECT f.id, n.i, 'q' + CAST(n.i AS varchar(1)), 'long_text',
+         N'คำถามที่ ' + CAST(n.i AS nvarchar(1)), 'Question ' + CAST(n.i AS varchar(1))
+  FROM dbo.eval_form f
+  CROSS JOIN (VALUES (1),(2),(3),(4),(5)) n(i)
+  WHERE NOT EXISTS (SELECT 1 FROM dbo.eval_question q WHERE q.form_id = f.id);
+
+  INSERT INTO dbo.eval_answer(assignment_id, question_id, value_text)
+  SELECT ad.assignment_id, q.id, v.val
+  FROM dbo.eval_additional ad
+  JOIN dbo.eval_assignment a ON a.id = ad.assignment_id
+  CROSS APPLY (VALUES ('q1', ad.q1), ('q2', ad.q2), ('q3', ad.q3), ('q4', ad.q4), ('q5', ad.q5)) v(code, val)
+  JOIN dbo.eval_question q ON q.form_id = a.form_id AND q.code = v.code
+  WHERE NULLIF(LTRIM(RTRIM(v.val)), '') IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM dbo.eval_answer x WHERE x.assignment_id = ad.assignment_id AND x.question_id = q.id);
+
+  EXEC sp_rename 'dbo.eval_additional', 'eval_additional_legacy';
+END
+GO
+
+-- ===== Development plan tracking =====
+-- สถานะ planned → in_progress → done / cancelled, หัวหน้ารับรอง (sign-off) รายการที่จบแล้ว
+-- carried_from_id = รายการของรอบก่อนที่ยกมา (ไม่มี FK: ลบรอบเก่าได้โดยไม่กระทบ)
+IF COL_LENGTH('dbo.eval_dev_plan','status') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_dev_plan ADD
+    status          NVARCHAR(20) NOT NULL CONSTRAINT df_eval_dev_plan_status DEFAULT N'planned'
+      CONSTRAINT ck_eval_dev_plan_status CHECK (status IN (N'planned', N'in_progress', N'done', N'cancelled')),
+    completed_on    DATE NULL,
+    signed_off_by   INT NULL CONSTRAINT fk_eval_dev_plan_signoff REFERENCES dbo.users(id),
+    signed_off_at   DATETIME2(0) NULL,
+    carried_from_id INT NULL,
+    updated_at      DATETIME2(0) NOT NULL CONSTRAINT df_eval_dev_plan_updated DEFAULT SYSUTCDATETIME();
+END
+GO
+
+IF NOT EXISTS (SELECT 1 FROM sys.indexes WHERE name = 'ix_eval_dev_plan_carried_from')
+  CREATE INDEX ix_eval_dev_plan_carried_from ON dbo.eval_dev_plan(carried_from_id) WHERE carried_from_id IS NOT NULL;
+GO
+
+-- บันทึกความคืบหน้าของแต่ละรายการ
+IF OBJECT_ID('dbo.eval_dev_plan_note','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_dev_plan_note (
+    id          INT IDENTITY(1,1) PRIMARY KEY,
+    plan_id     INT NOT NULL REFERENCES dbo.eval_dev_plan(id) ON DELETE CASCADE,
+    note        NVARCHAR(1000) NOT NULL,
+    author_id   INT NULL REFERENCES dbo.users(id),
+    author_role NVARCHAR(20) NOT NULL CHECK (author_role IN (N'employee', N'manager')),
+    created_at  DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+  CREATE INDEX ix_eval_dev_plan_note_plan ON dbo.eval_dev_plan_note(plan_id, created_at);
+END
+GO
+
+-- ===== Training catalogue =====
+-- คลังหลักสูตรอบรม; tag สมรรถนะอ้างพจนานุกรม (eval_competency_def)
+IF OBJECT_ID('dbo.eval_course','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_course (
+    id          INT IDENTITY(1,1) PRIMARY KEY,
+    code        NVARCHAR(50)   NOT NULL CONSTRAINT uq_eval_course_code UNIQUE,
+    title       NVARCHAR(300)  NOT NULL,
+    provider    NVARCHAR(200)  NULL,
+    hours       DECIMAL(7,2)   NOT NULL,
+    description NVARCHAR(1000) NULL,
+    active      BIT NOT NULL DEFAULT 1,
+    created_at  DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at  DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+END
+GO
+
+IF OBJECT_ID('dbo.eval_course_competency','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_course_competency (
+    course_id INT NOT NULL REFERENCES dbo.eval_course(id) ON DELETE CASCADE,
+    def_id    INT NOT NULL REFERENCES dbo.eval_competency_def(id) ON DELETE CASCADE,
+    CONSTRAINT pk_eval_course_competency PRIMARY KEY (course_id, def_id)
+  );
+END
+GO
+
+-- การลงทะเบียนอบรมของพนักงาน + ข้อมูลใบประกาศ
+-- dev_plan_id = รายการแผนพัฒนาที่มา (ลบรายการแผน → NULL)
+-- user_id ไม่ cascade: users มาถึงได้อีกทางผ่าน eval_assignment → eval_dev_plan → eval.DeleteUserData ลบเอง
+IF OBJECT_ID('dbo.eval_enrolment','U') IS NULL
+BEGIN
+  CREATE TABLE dbo.eval_enrolment (
+    id              INT IDENTITY(1,1) PRIMARY KEY,
+    course_id       INT NOT NULL REFERENCES dbo.eval_course(id),
+    user_id         INT NOT NULL REFERENCES dbo.users(id),
+    status          NVARCHAR(20) NOT NULL DEFAULT N'enrolled'
+      CONSTRAINT ck_eval_enrolment_status CHECK (status IN (N'enrolled', N'in_progress', N'completed', N'cancelled')),
+    enrolled_on     DATE NOT NULL,
+    completed_on    DATE NULL,
+    cert_number     NVARCHAR(100) NULL,
+    cert_issuer     NVARCHAR(200) NULL,
+    cert_issued_on  DATE NULL,
+    cert_expires_on DATE NULL,
+    cert_url        NVARCHAR(500) NULL,
+    dev_plan_id     INT NULL CONSTRAINT fk_eval_enrolment_dev_plan REFERENCES dbo.eval_dev_plan(id) ON DELETE SET NULL,
+    created_at      DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME(),
+    updated_at      DATETIME2(0) NOT NULL DEFAULT SYSUTCDATETIME()
+  );
+  CREATE INDEX ix_eval_enrolment_user ON dbo.eval_enrolment(user_id, course_id, status);
+END
+GO
+
+IF COL_LENGTH('dbo.eval_dev_plan','course_id') IS NULL
+BEGIN
+  ALTER TABLE dbo.eval_dev_plan
+    ADD course_id INT NULL CONSTRAINT fk_eval_dev_plan_course REFERENCES dbo.eval_course(id);
+END
+GO
@@ -71,19 +71,21 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	var (
 		u                user.User
 		pwHash           []byte
+		active           bool
 		startD, confirmD sql.NullTime
 	)
 	err := h.DB.QueryRowContext(ctx, `
     SELECT id, name, email, role, password_hash,
            person_code, position, department, url_image,
            TRY_CONVERT(date, NULLIF(start_date, ''))   AS start_date,
-           TRY_CONVERT(date, NULLIF(confirm_date, '')) AS confirm_date
+           TRY_CONVERT(date, NULLIF(confirm_date, '')) AS confirm_date,
+           active
     FROM dbo.users
     WHERE person_code = @p1
 `, req.Username).Scan(
 		&u.ID, &u.Name, &u.Email, &u.Role, &pwHash,
 		&u.PersonCode, &u.Position, &u.Department, &u.UrlImage,
-		&startD, &confirmD,
+		&startD, &confirmD, &active,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -95,27 +97,15 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 	if err := bcrypt.CompareHashAndPassword(pwHash, []byte(req.Password)); err != nil {
 		return c.Status(401).JSON(fiber.Map{"error": "invalid credentials"})
 	}
+	if !active {
+		return c.Status(403).JSON(fiber.Map{"error": "account disabled"})
+	}
 
-	rows, err := h.DB.QueryContext(ctx, `
-		SELECT c.id, c.code, c.name, c.image
-		FROM dbo.companies c
-		INNER JOIN dbo.user_companies uc ON uc.company_id = c.id
-		WHERE uc.user_id = @p1
-	`, u.ID)
+	byUser, err := user.NewRepo(h.DB).LoadCompanies(ctx, []int{u.ID})
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	defer rows.Close()
-
-	var companies []user.Company
-	for rows.Next() {
-		var cp user.Company
-		if err := rows.Scan(&cp.ID, &cp.Code, &cp.Name, &cp.Image); err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-		}
-		companies = append(companies, cp)
-	}
-	u.Company = companies
+	u.Company = byUser[u.ID]
 
 	if startD.Valid {
 		u.StartDate = startD.Time.Format("2006-01-02")
@@ -217,6 +207,13 @@ func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
 		return c.Status(401).JSON(fiber.Map{"error": "invalid refresh token type"})
 	}
 
+	// บัญชีที่ถูกปิด (SCIM active=false) ต่ออายุ token ไม่ได้
+	sub, _ := claims["sub"].(float64)
+	var active bool
+	if err := h.DB.QueryRowContext(c.Context(), `SELECT active FROM dbo.users WHERE id=@p1;`, int(sub)).Scan(&active); err != nil || !active {
+		return c.Status(401).JSON(fiber.Map{"error": "account disabled"})
+	}
+
 	newClaims := jwt.MapClaims{
 		"sub": claims["sub"],
 		"iss": h.JWTIssuer,
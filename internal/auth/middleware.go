@@ -36,3 +36,42 @@ func JWTMiddleware(secret string) fiber.Handler {
 		return c.Next()
 	}
 }
+
+// OptionalJWTMiddleware: ถ้ามี token ที่ถูกต้องจะใส่ claims ใน c.Locals("user"), ถ้าไม่มีก็ผ่านไปเลย
+func OptionalJWTMiddleware(secret string) fiber.Handler {
+	strict := JWTMiddleware(secret)
+	return func(c *fiber.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.Next()
+		}
+		return strict(c)
+	}
+}
+
+// RequireRole ใช้ต่อจาก JWTMiddleware: role ใน token ต้องอยู่ในรายการ (ไม่สนตัวพิมพ์)
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := c.Locals("user").(jwt.MapClaims); !ok {
+			return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+		}
+		if HasRole(c, roles...) {
+			return c.Next()
+		}
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+}
+
+// HasRole: role ใน JWT ตรงกับตัวใดตัวหนึ่งใน roles (ไม่สนตัวพิมพ์เล็ก/ใหญ่)
+func HasRole(c *fiber.Ctx, roles ...string) bool {
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+	role, _ := claims["role"].(string)
+	for _, r := range roles {
+		if strings.EqualFold(role, r) {
+			return true
+		}
+	}
+	return false
+}
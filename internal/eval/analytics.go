@@ -0,0 +1,181 @@
+package eval
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// ===== Analytics (รวมผลจาก Engine เดียวกับ ComputeSummary) =====
+
+// scoredAssignment ผลคำนวณของหนึ่ง assignment
+type scoredAssignment struct {
+	AssignmentID int
+	UserID       int
+	Department   string
+	Result       ScoreResult
+	Grade        string
+}
+
+// bandRow หนึ่งแถวของ eval_grade_band (form_id / company_id ว่าง = ไม่ระบุ)
+type bandRow struct {
+	FormID    int
+	CompanyID string
+	Grade     string
+	MinPct    float64
+	MaxPct    float64
+}
+
+// bandSet หาเกรดในหน่วยความจำ: ฟอร์ม → บริษัทของพนักงาน → ค่าเริ่มต้น, ช่วง [min, max+0.01)
+// ใช้ทั้ง lookupGrade (ทีละ assignment / preview) และ analytics (ทั้งฟอร์ม) → เกรดตรงกันเสมอ
+type bandSet struct {
+	form, global []bandRow
+	company      map[string][]bandRow
+}
+
+func newBandSet(formID int, rows []bandRow) bandSet {
+	bs := bandSet{company: map[string][]bandRow{}}
+	for _, b := range rows {
+		switch {
+		case b.FormID == formID:
+			bs.form = append(bs.form, b)
+		case b.FormID == 0 && b.CompanyID != "":
+			k := strings.ToLower(b.CompanyID)
+			bs.company[k] = append(bs.company[k], b)
+		case b.FormID == 0:
+			bs.global = append(bs.global, b)
+		}
+	}
+	return bs
+}
+
+func matchBand(bands []bandRow, pct float64) (bandRow, bool) {
+	best, found := bandRow{}, false
+	for _, b := range bands {
+		if pct >= b.MinPct && pct < b.MaxPct+bandStep && (!found || b.MinPct > best.MinPct) {
+			best, found = b, true
+		}
+	}
+	return best, found
+}
+
+// pick คืน band ที่ได้ และ scope (form / company / global; ไม่พบ = none)
+func (bs bandSet) pick(companyIDs []string, pct float64) (bandRow, string, bool) {
+	if b, ok := matchBand(bs.form, pct); ok {
+		return b, "form", true
+	}
+	var comp []bandRow
+	for _, id := range companyIDs {
+		comp = append(comp, bs.company[strings.ToLower(strings.TrimSpace(id))]...)
+	}
+	if b, ok := matchBand(comp, pct); ok {
+		return b, "company", true
+	}
+	if b, ok := matchBand(bs.global, pct); ok {
+		return b, "global", true
+	}
+	return bandRow{}, "none", false
+}
+
+func (bs bandSet) grade(companyIDs []string, pct float64) string {
+	if b, _, ok := bs.pick(companyIDs, pct); ok {
+		return b.Grade
+	}
+	return "N/A"
+}
+
+type SectionAverages struct {
+	KPIPct   float64 `json:"kpi_pct"`
+	CompPct  float64 `json:"comp_pct"`
+	TAPct    float64 `json:"ta_pct"`
+	TotalPct float64 `json:"total_pct"`
+}
+
+type HistogramBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"` // ถังสุดท้ายรวม 100
+	Count int     `json:"count"`
+}
+
+type ScoreAnalytics struct {
+	FormID    int               `json:"form_id"`
+	Count     int               `json:"count"`
+	Averages  SectionAverages   `json:"averages"`
+	Histogram []HistogramBucket `json:"histogram"`
+}
+
+type DepartmentGrades struct {
+	Department string         `json:"department"`
+	Total      int            `json:"total"`
+	Grades     map[string]int `json:"grades"`
+}
+
+type CompetencyGap struct {
+	CompID        int     `json:"comp_id"`
+	Title         string  `json:"title"`
+	MaxScore      float64 `json:"max_score"`
+	ExpectedScore float64 `json:"expected_score"`
+	AvgScore      float64 `json:"avg_score"`
+	Gap           float64 `json:"gap"` // avg - expected (ติดลบ = ต่ำกว่าที่คาด)
+	Scored        int     `json:"scored"`
+}
+
+func averages(rows []scoredAssignment) SectionAverages {
+	var out SectionAverages
+	if len(rows) == 0 {
+		return out
+	}
+	for _, r := range rows {
+		out.KPIPct += r.Result.KPIPct
+		out.CompPct += r.Result.CompPct
+		out.TAPct += r.Result.TAPct
+		out.TotalPct += r.Result.TotalPct
+	}
+	n := float64(len(rows))
+	out.KPIPct = round2(out.KPIPct / n)
+	out.CompPct = round2(out.CompPct / n)
+	out.TAPct = round2(out.TAPct / n)
+	out.TotalPct = round2(out.TotalPct / n)
+	return out
+}
+
+// histogram ถังละ bucket% ตั้งแต่ 0 ถึง 100 (100 ตกถังสุดท้าย)
+func histogram(rows []scoredAssignment, bucket float64) []HistogramBucket {
+	if bucket <= 0 || bucket > 100 {
+		bucket = 10
+	}
+	n := int(math.Ceil(100 / bucket))
+	out := make([]HistogramBucket, n)
+	for i := range out {
+		out[i].From = round2(float64(i) * bucket)
+		out[i].To = round2(math.Min(float64(i+1)*bucket, 100))
+	}
+	for _, r := range rows {
+		i := int(math.Max(0, r.Result.TotalPct) / bucket)
+		if i >= n {
+			i = n - 1
+		}
+		out[i].Count++
+	}
+	return out
+}
+
+func gradesByDepartment(rows []scoredAssignment) []DepartmentGrades {
+	idx := map[string]int{}
+	var out []DepartmentGrades
+	for _, r := range rows {
+		i, ok := idx[r.Department]
+		if !ok {
+			i = len(out)
+			idx[r.Department] = i
+			out = append(out, DepartmentGrades{Department: r.Department, Grades: map[string]int{}})
+		}
+		out[i].Total++
+		out[i].Grades[r.Grade]++
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Department < out[b].Department })
+	if out == nil {
+		out = make([]DepartmentGrades, 0)
+	}
+	return out
+}
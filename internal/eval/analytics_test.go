@@ -0,0 +1,47 @@
+package eval
+
+import "testing"
+
+func TestBandSetPick(t *testing.T) {
+	const comp = "6F9619FF-8B86-D011-B42D-00C04FC964FF"
+	bs := newBandSet(7, []bandRow{
+		{FormID: 7, Grade: "FA", MinPct: 90, MaxPct: 100},
+		{FormID: 8, Grade: "X", MinPct: 0, MaxPct: 100}, // ฟอร์มอื่น → ไม่ใช้
+		{CompanyID: comp, Grade: "CA", MinPct: 80, MaxPct: 89.99},
+		{CompanyID: comp, Grade: "CB", MinPct: 60, MaxPct: 79.99},
+		{Grade: "A", MinPct: 80, MaxPct: 100},
+		{Grade: "B", MinPct: 60, MaxPct: 79.99},
+		{Grade: "F", MinPct: 0, MaxPct: 59.99},
+	})
+
+	tests := []struct {
+		name      string
+		companies []string
+		pct       float64
+		grade     string
+		scope     string
+	}{
+		{"form band first", []string{comp}, 95, "FA", "form"},
+		{"company band before global", []string{comp}, 85, "CA", "company"},
+		{"company id is case-insensitive", []string{"6f9619ff-8b86-d011-b42d-00c04fc964ff"}, 70, "CB", "company"},
+		{"no company falls back to global", nil, 85, "A", "global"},
+		{"gap above max stays in band", nil, 79.995, "B", "global"},
+		{"company without match falls back to global", []string{comp}, 10, "F", "global"},
+		{"nothing matches", nil, 101, "N/A", "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, scope, ok := bs.pick(tt.companies, tt.pct)
+			got := "N/A"
+			if ok {
+				got = b.Grade
+			}
+			if got != tt.grade || scope != tt.scope {
+				t.Errorf("pick(%v, %v) = %q/%q, want %q/%q", tt.companies, tt.pct, got, scope, tt.grade, tt.scope)
+			}
+			if g := bs.grade(tt.companies, tt.pct); g != tt.grade {
+				t.Errorf("grade(%v, %v) = %q, want %q", tt.companies, tt.pct, g, tt.grade)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package eval
+
+// ===== Goal cascading (รวมผลจากลูกขึ้นไปหาแม่) =====
+
+// cascadeKPI แถว KPI ที่ผูกกับเป้าหมาย (โหลดครั้งเดียวทั้งฟอร์ม)
+type cascadeKPI struct {
+	ObjectiveID int
+	KPI         ObjectiveKPI
+}
+
+// kpiScorePct คะแนนเทียบคะแนนเต็ม (%); คะแนนเต็ม 0 = ไม่มีข้อมูล
+func kpiScorePct(score, maxScore float64) *float64 {
+	if maxScore <= 0 {
+		return nil
+	}
+	v := round2(score / maxScore * 100)
+	return &v
+}
+
+// buildCascade สร้างต้นไม้จากรายการแบน root = 0 → ทุกเป้าหมายที่ไม่มีแม่
+func buildCascade(objs []Objective, kpis []cascadeKPI, root int) []ObjectiveNode {
+	children := map[int][]Objective{}
+	byID := map[int]Objective{}
+	for _, o := range objs {
+		byID[o.ID] = o
+		parent := 0
+		if o.ParentID != nil {
+			parent = *o.ParentID
+		}
+		children[parent] = append(children[parent], o)
+	}
+	linked := map[int][]ObjectiveKPI{}
+	for _, k := range kpis {
+		linked[k.ObjectiveID] = append(linked[k.ObjectiveID], k.KPI)
+	}
+
+	var build func(o Objective) ObjectiveNode
+	build = func(o Objective) ObjectiveNode {
+		n := ObjectiveNode{Objective: o, Children: []ObjectiveNode{}, KPIs: []ObjectiveKPI{}}
+		for _, c := range children[o.ID] {
+			n.Children = append(n.Children, build(c))
+		}
+		n.KPIs = append(n.KPIs, linked[o.ID]...)
+		rollUp(&n)
+		return n
+	}
+
+	var roots []Objective
+	if root != 0 {
+		if o, ok := byID[root]; ok {
+			roots = []Objective{o}
+		}
+	} else {
+		roots = children[0]
+	}
+	out := make([]ObjectiveNode, 0, len(roots))
+	for _, o := range roots {
+		n := build(o)
+		n.ContributionPct = 100
+		out = append(out, n)
+	}
+	return out
+}
+
+// weightedPct เฉลี่ยถ่วงน้ำหนักเฉพาะลูกที่มีค่า
+type weightedPct struct{ sum, w float64 }
+
+func (a *weightedPct) add(w int, pct *float64) {
+	if pct != nil {
+		a.sum += float64(w) * *pct
+		a.w += float64(w)
+	}
+}
+
+func (a weightedPct) value() *float64 {
+	if a.w == 0 {
+		return nil
+	}
+	v := round2(a.sum / a.w)
+	return &v
+}
+
+// rollUp: ลูกแต่ละตัว (เป้าหมายย่อยตาม weight ของเป้าหมาย, KPI ตาม weight ของ KPI)
+// ได้ contribution = weight / weight รวมของลูกทั้งหมด; score / progress ของแม่ = เฉลี่ยถ่วงน้ำหนักของลูกที่มีค่า
+func rollUp(n *ObjectiveNode) {
+	total := 0
+	for _, c := range n.Children {
+		total += c.Weight
+	}
+	for _, k := range n.KPIs {
+		total += k.Weight
+	}
+	share := func(w int) float64 {
+		if total == 0 {
+			return 0
+		}
+		return round2(float64(w) * 100 / float64(total))
+	}
+
+	var score, progress weightedPct
+	for i := range n.Children {
+		c := &n.Children[i]
+		c.ContributionPct = share(c.Weight)
+		score.add(c.Weight, c.ScorePct)
+		progress.add(c.Weight, c.ProgressPct)
+	}
+	for i := range n.KPIs {
+		k := &n.KPIs[i]
+		k.ContributionPct = share(k.Weight)
+		score.add(k.Weight, k.ScorePct)
+		progress.add(k.Weight, k.ProgressPct)
+	}
+	n.ScorePct = score.value()
+	n.ProgressPct = progress.value()
+}
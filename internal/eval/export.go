@@ -0,0 +1,137 @@
+package eval
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"go-sqlserver-demo/internal/xlsx"
+)
+
+// ===== XLSX export (stream ทีละแถว) =====
+
+// groupCursor อ่านแถวที่เรียงตาม assignment_id แล้วดึงทีละกลุ่มคู่กับ query หลัก
+// (merge แบบเดินหน้าอย่างเดียว จึงไม่ต้องโหลดรายการของทุก assignment ไว้ก่อน)
+type groupCursor[T any] struct {
+	rows *sql.Rows
+	scan func(*sql.Rows) (int, T, error)
+	aid  int
+	val  T
+	ok   bool
+	err  error
+}
+
+func newGroupCursor[T any](rows *sql.Rows, scan func(*sql.Rows) (int, T, error)) *groupCursor[T] {
+	c := &groupCursor[T]{rows: rows, scan: scan}
+	c.advance()
+	return c
+}
+
+func (c *groupCursor[T]) advance() {
+	if c.ok = c.err == nil && c.rows.Next(); c.ok {
+		if c.aid, c.val, c.err = c.scan(c.rows); c.err != nil {
+			c.ok = false
+		}
+	} else if c.err == nil {
+		c.err = c.rows.Err()
+	}
+}
+
+// take คืนแถวทั้งหมดของ aid (ข้ามแถวของ assignment ที่ไม่อยู่ในผลหลัก)
+func (c *groupCursor[T]) take(aid int) []T {
+	for c.ok && c.aid < aid {
+		c.advance()
+	}
+	var out []T
+	for c.ok && c.aid == aid {
+		out = append(out, c.val)
+		c.advance()
+	}
+	return out
+}
+
+func scanScoreItem(rows *sql.Rows) (int, ScoreItem, error) {
+	var aid int
+	var it ScoreItem
+	err := rows.Scan(&aid, &it.Score, &it.MaxScore, &it.Weight)
+	return aid, it, err
+}
+
+type exportStep struct {
+	Idx       int
+	Evaluator string
+	Status    int
+	Date      string
+}
+
+func scanExportStep(rows *sql.Rows) (int, exportStep, error) {
+	var aid int
+	var s exportStep
+	err := rows.Scan(&aid, &s.Idx, &s.Evaluator, &s.Status, &s.Date)
+	return aid, s, err
+}
+
+var stepStatusNames = map[int]string{StepWaiting: "waiting", StepActive: "active", StepDone: "done"}
+
+func summaryHeader(maxSteps int) []string {
+	h := []string{
+		"Assignment ID", "รหัสพนักงาน", "ชื่อ-สกุล", "ตำแหน่ง", "แผนก", "สถานะ", "กำหนดส่ง",
+		"KPI (%)", "Competency (%)", "Time Attendance (%)", "รวม (%)", "คะแนนรวม", "เกรด", "เกรดหลัง calibration",
+	}
+	for i := 1; i <= maxSteps; i++ {
+		h = append(h,
+			fmt.Sprintf("ขั้นที่ %d ผู้ประเมิน", i),
+			fmt.Sprintf("ขั้นที่ %d สถานะ", i),
+			fmt.Sprintf("ขั้นที่ %d วันที่", i))
+	}
+	return h
+}
+
+var kpiDetailHeader = []string{
+	"Assignment ID", "รหัสพนักงาน", "ชื่อ-สกุล", "ลำดับ", "รหัส KPI", "หัวข้อ", "วิธีการวัด", "เกณฑ์การให้คะแนน",
+	"หน่วย", "น้ำหนัก (%)", "คะแนนเต็ม", "คาดหวัง", "คะแนน", "หมายเหตุ",
+}
+
+var compDetailHeader = []string{
+	"Assignment ID", "รหัสพนักงาน", "ชื่อ-สกุล", "ลำดับ", "หัวข้อ", "น้ำหนัก (%)", "คะแนนเต็ม", "คาดหวัง", "คะแนน", "หมายเหตุ",
+}
+
+// streamRows เขียนผล query ลงชีตตรง ๆ ทีละแถว
+// DECIMAL / NUMERIC / MONEY ที่ไม่ได้ CAST มาจาก driver เป็น []byte → แปลงเป็นตัวเลข (ไม่งั้นจะกลายเป็นข้อความใน Excel)
+func streamRows(xw *xlsx.Writer, rows *sql.Rows) error {
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	numeric := make([]bool, len(types))
+	for i, t := range types {
+		switch t.DatabaseTypeName() {
+		case "DECIMAL", "NUMERIC", "MONEY", "SMALLMONEY":
+			numeric[i] = true
+		}
+	}
+	vals := make([]any, len(types))
+	ptrs := make([]any, len(types))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range vals {
+			if b, ok := v.([]byte); ok && numeric[i] {
+				f, err := strconv.ParseFloat(string(b), 64)
+				if err != nil {
+					return fmt.Errorf("column %s: %w", types[i].Name(), err)
+				}
+				vals[i] = f
+			}
+		}
+		if err := xw.WriteRow(vals...); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
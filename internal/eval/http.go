@@ -1,8 +1,18 @@
 package eval
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"log"
 	"strconv"
+	"strings"
+	"time"
+
+	"go-sqlserver-demo/internal/auth"
+	"go-sqlserver-demo/internal/pdf"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
@@ -13,8 +23,16 @@ type Handler struct{ Repo *Repo }
 func NewHandler(r *Repo) *Handler { return &Handler{Repo: r} }
 
 func (h *Handler) RegisterRoutes(r fiber.Router) {
+	hr := auth.RequireRole("hr", "admin")
+
 	r.Post("/forms", h.createForm)
 	r.Get("/forms", h.listForms)
+	r.Get("/forms/:id", h.getForm)
+	r.Patch("/forms/:id", hr, h.updateForm)
+	r.Delete("/forms/:id", hr, h.deleteForm)
+	r.Post("/forms/:id/archive", hr, h.archiveForm)
+	r.Post("/forms/:id/unarchive", hr, h.unarchiveForm)
+	r.Post("/forms/:id/clone", hr, h.cloneForm)
 
 	r.Post("/forms/:id/add/kpis", h.addMyKPIsBulk)
 	r.Get("/forms/:id/getdata/kpis", h.listMyKPIs)
@@ -25,10 +43,122 @@ func (h *Handler) RegisterRoutes(r fiber.Router) {
 
 	r.Post("/forms/:id/competencies", h.addCompsBulk)
 	r.Get("/forms/:id/competencies", h.listComps)
+	r.Put("/forms/:id/competencies/:compId/levels", hr, h.replaceCompLevels)
 
 	r.Post("/forms/:id/save", h.saveAll)
 	r.Get("/forms/:id/getdataform", h.getMyData)
 
+	r.Get("/grade-bands", h.listGradeBands)
+	r.Put("/grade-bands", hr, h.replaceGradeBands)
+	r.Delete("/grade-bands", hr, h.deleteGradeBands)
+	r.Get("/grade-bands/preview", h.previewGrade)
+
+	// ผู้ประเมิน (หัวหน้า / ผู้บริหาร) ประเมินแทนพนักงาน
+	r.Get("/inbox", h.listInbox)
+	r.Get("/assignments/:id/getdataform", h.getAssignmentData)
+	r.Post("/assignments/:id/save", h.saveAsEvaluator)
+
+	// workflow: ส่งกลับ / รับทราบ / ปิด + log
+	r.Post("/assignments/:id/return", h.returnStep)
+	r.Post("/assignments/:id/acknowledge", h.acknowledge)
+	r.Get("/assignments/:id/transitions", h.listTransitions)
+	r.Get("/assignments/:id/pdf", h.assignmentPDF)
+
+	// ขยายช่วงเวลารายคน (HR เท่านั้น)
+	r.Get("/assignments/:id/extensions", hr, h.listExtensions)
+	r.Post("/assignments/:id/extensions", hr, h.grantExtension)
+	r.Post("/assignments/:id/close", hr, h.closeAssignment)
+	r.Put("/assignments/:id/kpis/:kpiId", hr, h.correctKPI) // HR แก้ KPI ของพนักงานหลังส่งแล้ว
+	r.Post("/forms/:id/assign", hr, h.bulkAssign)
+
+	// calibration (HR)
+	r.Get("/calibrations", hr, h.listCalibrations)
+	r.Post("/calibrations", hr, h.createCalibration)
+	r.Get("/calibrations/:id", hr, h.getCalibration)
+	r.Put("/calibrations/:id/targets", hr, h.setCalibrationTargets)
+	r.Post("/calibrations/:id/items", hr, h.addCalibrationItems)
+	r.Post("/calibrations/:id/refresh", hr, h.refreshCalibration)
+	r.Put("/calibrations/:id/items/:aid", hr, h.overrideGrade)
+	r.Delete("/calibrations/:id/items/:aid", hr, h.removeCalibrationItem)
+	r.Post("/calibrations/:id/lock", hr, h.lockCalibration)
+
+	// dashboard ความคืบหน้า (HR) — filter: company_id, department, position
+	r.Get("/reports/forms/:id/progress", hr, h.progressReport)
+	r.Get("/reports/forms/:id/breakdown", hr, h.breakdownReport)
+	r.Get("/reports/forms/:id/overdue", hr, h.overdueReport)
+
+	// analytics คะแนน (HR) — status=submitted (ค่าเริ่มต้น) | approved | all
+	r.Get("/analytics/forms/:id/scores", hr, h.scoreAnalytics)
+	r.Get("/analytics/forms/:id/grades", hr, h.gradeAnalytics)
+	r.Get("/analytics/forms/:id/competency-gaps", hr, h.competencyGaps)
+
+	// export ผลทั้งฟอร์มเป็น Excel (HR)
+	r.Get("/exports/forms/:id/xlsx", hr, h.exportFormXLSX)
+
+	// คลัง KPI + template ตามตำแหน่ง
+	r.Get("/kpi-catalog", h.listKPICatalog)
+	r.Get("/kpi-catalog/mine", h.listMyKPICatalog)
+	r.Post("/kpi-catalog", hr, h.createKPICatalog)
+	r.Put("/kpi-catalog/:id", hr, h.updateKPICatalog)
+	r.Delete("/kpi-catalog/:id", hr, h.deactivateKPICatalog)
+	r.Post("/kpi-catalog/:id/activate", hr, h.activateKPICatalog)
+	r.Get("/kpi-templates", h.listKPITemplates)
+	r.Get("/kpi-templates/:id", h.getKPITemplate)
+	r.Post("/kpi-templates", hr, h.createKPITemplate)
+	r.Put("/kpi-templates/:id", hr, h.updateKPITemplate)
+	r.Delete("/kpi-templates/:id", hr, h.deleteKPITemplate)
+	r.Post("/assignments/:id/kpis/apply-template", h.applyKPITemplate)
+	r.Get("/reports/forms/:id/kpi-catalog", hr, h.kpiCatalogReport)
+
+	// คำถามเพิ่มเติมของฟอร์ม (แทน q1–q5)
+	r.Get("/forms/:id/questions", h.listQuestions)
+	r.Post("/forms/:id/questions", hr, h.createQuestion)
+	r.Put("/questions/:id", hr, h.updateQuestion)
+	r.Delete("/questions/:id", hr, h.deleteQuestion)
+
+	// พจนานุกรมสมรรถนะ + ระดับพฤติกรรม
+	r.Get("/competency-dictionary", h.listCompetencyDefs)
+	r.Get("/competency-dictionary/:id", h.getCompetencyDef)
+	r.Post("/competency-dictionary", hr, h.createCompetencyDef)
+	r.Put("/competency-dictionary/:id", hr, h.updateCompetencyDef)
+	r.Delete("/competency-dictionary/:id", hr, h.deactivateCompetencyDef)
+	r.Post("/competency-dictionary/:id/activate", hr, h.activateCompetencyDef)
+
+	// เป้าหมายบริษัท → แผนก → ทีม และ KPI ที่ส่งผลถึง
+	r.Get("/forms/:id/objectives", h.listObjectives)
+	r.Post("/forms/:id/objectives", hr, h.createObjective)
+	r.Get("/forms/:id/objectives/cascade", hr, h.objectiveCascade)
+	r.Put("/objectives/:id", hr, h.updateObjective)
+	r.Delete("/objectives/:id", hr, h.deleteObjective)
+	r.Put("/assignments/:id/kpis/:kpiId/objective", h.linkKPIObjective)
+
+	// check-in ความคืบหน้า KPI ระหว่างรอบ (เจ้าของ / ผู้ประเมิน / HR)
+	r.Get("/assignments/:id/checkins", h.listKPICheckins)
+	r.Get("/assignments/:id/kpis/:kpiId/checkins", h.listKPIItemCheckins)
+	r.Post("/assignments/:id/kpis/:kpiId/checkins", h.addKPICheckin)
+	r.Delete("/assignments/:id/checkins/:checkinId", h.deleteKPICheckin)
+
+	// ติดตามแผนพัฒนา (ทำได้นอกช่วงประเมิน)
+	r.Get("/assignments/:id/dev-plan", h.listDevPlan)
+	r.Post("/assignments/:id/dev-plan/carry-over", h.carryOverDevPlan)
+	r.Put("/assignments/:id/dev-plan/:itemId/status", h.setDevPlanStatus)
+	r.Post("/assignments/:id/dev-plan/:itemId/notes", h.addDevPlanNote)
+	r.Post("/assignments/:id/dev-plan/:itemId/sign-off", h.signOffDevPlan)
+	r.Delete("/assignments/:id/dev-plan/:itemId/sign-off", h.undoDevPlanSignOff)
+
+	// คลังหลักสูตรอบรม + การลงทะเบียน
+	r.Get("/courses", h.listCourses)
+	r.Get("/courses/:id", h.getCourse)
+	r.Post("/courses", hr, h.createCourse)
+	r.Put("/courses/:id", hr, h.updateCourse)
+	r.Delete("/courses/:id", hr, h.deactivateCourse)
+	r.Post("/courses/:id/activate", hr, h.activateCourse)
+	r.Get("/enrolments", hr, h.listEnrolments)
+	r.Get("/enrolments/mine", h.listMyEnrolments)
+	r.Post("/enrolments", h.createEnrolment)
+	r.Put("/enrolments/:id", h.updateEnrolment)
+	r.Get("/reports/forms/:id/training-hours", hr, h.trainingHoursReport)
+
 	r.Post("/forms/:id/steps/add", h.addEvalSteps)
 	r.Get("/forms/:id/steps", h.listEvalSteps)
 	r.Put("/forms/:id/steps/:stepId", h.updateEvalStep)
@@ -42,6 +172,9 @@ func (h *Handler) createForm(c *fiber.Ctx) error {
 	if in.TotalWeight == 0 {
 		in.TotalWeight = 100
 	} // กัน user ลืม
+	if err := ValidateForm(in); err != nil {
+		return validationFailed(c, err)
+	}
 	f, err := h.Repo.CreateForm(c.Context(), in)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
@@ -52,13 +185,98 @@ func (h *Handler) createForm(c *fiber.Ctx) error {
 func (h *Handler) listForms(c *fiber.Ctx) error {
 	limit, _ := strconv.Atoi(c.Query("limit", "50"))
 	offset, _ := strconv.Atoi(c.Query("offset", "0"))
-	fs, err := h.Repo.ListForms(c.Context(), limit, offset)
+	includeArchived := c.QueryBool("include_archived", false)
+	fs, err := h.Repo.ListForms(c.Context(), limit, offset, includeArchived)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.JSON(fiber.Map{"data": fs})
 }
 
+func (h *Handler) getForm(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	f, ok, err := h.Repo.GetForm(c.Context(), formID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(f)
+}
+
+func (h *Handler) updateForm(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	var in UpdateFormInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	f, ok, err := h.Repo.UpdateForm(c.Context(), formID, in)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateCode) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "DUPLICATE_CODE"})
+		}
+		return validationFailed(c, err)
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(f)
+}
+
+func (h *Handler) deleteForm(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	ok, err := h.Repo.DeleteForm(c.Context(), formID)
+	if err != nil {
+		if errors.Is(err, ErrFormInUse) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "FORM_IN_USE"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
+
+func (h *Handler) archiveForm(c *fiber.Ctx) error   { return h.setArchived(c, true) }
+func (h *Handler) unarchiveForm(c *fiber.Ctx) error { return h.setArchived(c, false) }
+
+func (h *Handler) setArchived(c *fiber.Ctx, archived bool) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	f, ok, err := h.Repo.SetFormArchived(c.Context(), formID, archived)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(f)
+}
+
+// POST /forms/:id/clone
+func (h *Handler) cloneForm(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	var in CloneFormInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if in.Code == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "code required"})
+	}
+	f, ok, err := h.Repo.CloneForm(c.Context(), formID, in)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateCode) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "DUPLICATE_CODE"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.Status(201).JSON(f)
+}
+
 func (h *Handler) addMyKPIsBulk(c *fiber.Ctx) error {
 	formID, _ := strconv.Atoi(c.Params("id"))
 
@@ -66,6 +284,14 @@ func (h *Handler) addMyKPIsBulk(c *fiber.Ctx) error {
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
 	}
+	if err := h.Repo.CheckWindow(c.Context(), formID, uid, WindowKPICfg); err != nil {
+		return windowClosed(c, err)
+	}
+	if locked, err := h.myKPIsLocked(c, formID, uid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if locked {
+		return c.Status(409).JSON(fiber.Map{"error": "already submitted: cannot modify", "code": "ALREADY_SUBMITTED"})
+	}
 
 	var in MyKPIBulkInput
 	if err := c.BodyParser(&in); err != nil {
@@ -75,6 +301,21 @@ func (h *Handler) addMyKPIsBulk(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "items required"})
 	}
 
+	existing, err := h.Repo.ListMyKPIsByForm(c.Context(), formID, uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	existingWeight := 0
+	for _, k := range existing {
+		existingWeight += k.Weight
+	}
+	if err := ValidateKPIs(in.Items, existingWeight); err != nil {
+		return validationFailed(c, err)
+	}
+	if err := h.Repo.CheckObjectiveLinks(c.Context(), formID, "items", in.Items); err != nil {
+		return validationFailed(c, err)
+	}
+
 	// สร้าง/ดึง assignment ของ user กับฟอร์มนี้
 	a, err := h.Repo.EnsureAssignment(c.Context(), formID, uid, in.DueDate)
 
@@ -90,6 +331,15 @@ func (h *Handler) addMyKPIsBulk(c *fiber.Ctx) error {
 	return c.Status(201).JSON(fiber.Map{"assignment_id": a.ID, "data": out})
 }
 
+// myKPIsLocked: แก้ KPI ของตัวเองไม่ได้เมื่อส่งฟอร์มแล้ว (HR แก้ให้ผ่าน PUT /assignments/:id/kpis/:kpiId)
+func (h *Handler) myKPIsLocked(c *fiber.Ctx, formID, uid int) (bool, error) {
+	a, found, err := h.Repo.FindAssignment(c.Context(), formID, uid)
+	if err != nil || !found {
+		return false, err
+	}
+	return IsLocked(a.Status), nil
+}
+
 func (h *Handler) listMyKPIs(c *fiber.Ctx) error {
 	formID, _ := strconv.Atoi(c.Params("id"))
 	uid, ok := currentUserID(c)
@@ -110,11 +360,25 @@ func (h *Handler) replaceMyKPIs(c *fiber.Ctx) error {
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
 	}
+	if err := h.Repo.CheckWindow(c.Context(), formID, uid, WindowKPICfg); err != nil {
+		return windowClosed(c, err)
+	}
+	if locked, err := h.myKPIsLocked(c, formID, uid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if locked {
+		return c.Status(409).JSON(fiber.Map{"error": "already submitted: cannot modify", "code": "ALREADY_SUBMITTED"})
+	}
 
 	var in MyKPIBulkInput
 	if err := c.BodyParser(&in); err != nil || len(in.Items) == 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json or empty items"})
 	}
+	if err := ValidateKPIs(in.Items, 0); err != nil {
+		return validationFailed(c, err)
+	}
+	if err := h.Repo.CheckObjectiveLinks(c.Context(), formID, "items", in.Items); err != nil {
+		return validationFailed(c, err)
+	}
 	out, err := h.Repo.ReplaceMyKPIs(c.Context(), formID, uid, in.DueDate, in.Items)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
@@ -129,11 +393,22 @@ func (h *Handler) updateMyKPI(c *fiber.Ctx) error {
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
 	}
+	if err := h.Repo.CheckWindow(c.Context(), formID, uid, WindowKPICfg); err != nil {
+		return windowClosed(c, err)
+	}
+	if locked, err := h.myKPIsLocked(c, formID, uid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if locked {
+		return c.Status(409).JSON(fiber.Map{"error": "already submitted: cannot modify", "code": "ALREADY_SUBMITTED"})
+	}
 
 	var in MyKPIInput
 	if err := c.BodyParser(&in); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
 	}
+	if err := h.Repo.CheckObjectiveLinks(c.Context(), formID, "items", []MyKPIInput{in}); err != nil {
+		return validationFailed(c, err)
+	}
 	row, ok2, err := h.Repo.UpdateMyKPI(c.Context(), formID, uid, kpiID, in)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
@@ -144,6 +419,35 @@ func (h *Handler) updateMyKPI(c *fiber.Ctx) error {
 	return c.JSON(row)
 }
 
+// correctKPI HR แก้ KPI หนึ่งรายการของ assignment ใดก็ได้ (ไม่สนสถานะ / ช่วงเวลา)
+func (h *Handler) correctKPI(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	kpiID, _ := strconv.Atoi(c.Params("kpiId"))
+	a, found, err := h.Repo.GetAssignment(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "assignment not found"})
+	}
+
+	var in MyKPIInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := h.Repo.CheckObjectiveLinks(c.Context(), a.FormID, "items", []MyKPIInput{in}); err != nil {
+		return validationFailed(c, err)
+	}
+	row, ok, err := h.Repo.UpdateMyKPI(c.Context(), a.FormID, a.UserID, kpiID, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(row)
+}
+
 func (h *Handler) deleteMyKPI(c *fiber.Ctx) error {
 	formID, _ := strconv.Atoi(c.Params("id"))
 	kpiID, _ := strconv.Atoi(c.Params("kpiId"))
@@ -151,6 +455,14 @@ func (h *Handler) deleteMyKPI(c *fiber.Ctx) error {
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
 	}
+	if err := h.Repo.CheckWindow(c.Context(), formID, uid, WindowKPICfg); err != nil {
+		return windowClosed(c, err)
+	}
+	if locked, err := h.myKPIsLocked(c, formID, uid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if locked {
+		return c.Status(409).JSON(fiber.Map{"error": "already submitted: cannot modify", "code": "ALREADY_SUBMITTED"})
+	}
 
 	okDel, err := h.Repo.DeleteMyKPI(c.Context(), formID, uid, kpiID)
 	if err != nil {
@@ -162,6 +474,37 @@ func (h *Handler) deleteMyKPI(c *fiber.Ctx) error {
 	return c.SendStatus(204)
 }
 
+// validationFailed ตอบ 422 พร้อม error รายฟิลด์
+func validationFailed(c *fiber.Ctx, err error) error {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return c.Status(422).JSON(fiber.Map{
+			"error":  "validation failed",
+			"code":   "VALIDATION_FAILED",
+			"fields": ve.Fields,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// windowClosed ตอบ 403 เมื่อเขียนนอกช่วงเวลา (KPI_CFG_CLOSED, EVAL_NOT_OPEN, ...)
+func windowClosed(c *fiber.Ctx, err error) error {
+	var we *WindowError
+	if errors.As(err, &we) {
+		return c.Status(403).JSON(fiber.Map{
+			"error":  we.Error(),
+			"code":   we.Code(),
+			"window": we.Window,
+			"start":  we.Start,
+			"end":    we.End,
+		})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// isHR: role ใน JWT เป็น hr หรือ admin
+func isHR(c *fiber.Ctx) bool { return auth.HasRole(c, "hr", "admin") }
+
 // ดึง user id จาก JWT (ใส่ใน c.Locals("user") โดย middleware)
 func currentUserID(c *fiber.Ctx) (int, bool) {
 	claims, ok := c.Locals("user").(jwt.MapClaims)
@@ -181,6 +524,35 @@ func (h *Handler) addCompsBulk(c *fiber.Ctx) error {
 	if err := c.BodyParser(&in); err != nil || len(in.Items) == 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json or empty items"})
 	}
+	// def_id: ตรวจว่ามีในพจนานุกรม และเติมชื่อถ้าไม่ส่งมา
+	var ve ValidationError
+	for i := range in.Items {
+		it := &in.Items[i]
+		if it.DefID == nil {
+			continue
+		}
+		d, found, err := h.Repo.GetCompetencyDef(c.Context(), *it.DefID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !found {
+			ve.add(fmt.Sprintf("items[%d].def_id", i), "not_found", "competency definition not found")
+			continue
+		}
+		if strings.TrimSpace(it.Title) == "" {
+			it.Title = d.Title
+		}
+	}
+	if err := ve.errOrNil(); err != nil {
+		return validationFailed(c, err)
+	}
+	existing, err := h.Repo.ListCompsByForm(c.Context(), fid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := ValidateComps(in.Items, existing); err != nil {
+		return validationFailed(c, err)
+	}
 	out, err := h.Repo.AddCompsBulk(c.Context(), fid, in.Items)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
@@ -197,19 +569,77 @@ func (h *Handler) listComps(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"data": out})
 }
 
+// PUT /forms/:id/competencies/:compId/levels — ระดับพฤติกรรมเฉพาะฟอร์ม
+func (h *Handler) replaceCompLevels(c *fiber.Ctx) error {
+	fid, _ := strconv.Atoi(c.Params("id"))
+	compID, _ := strconv.Atoi(c.Params("compId"))
+	var in CompLevelsInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	comps, err := h.Repo.ListCompsByForm(c.Context(), fid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var comp *CompItem
+	for i := range comps {
+		if comps[i].ID == compID {
+			comp = &comps[i]
+			break
+		}
+	}
+	if comp == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "competency not found"})
+	}
+	if err := ValidateCompLevels(in.Levels, comp.MaxScore); err != nil {
+		return validationFailed(c, err)
+	}
+	if _, err := h.Repo.ReplaceCompLevels(c.Context(), fid, compID, in.Levels); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	rubrics, err := h.Repo.loadRubrics(c.Context(), fid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	comp.Rubric = rubricOf(rubrics, compID)
+	return c.JSON(comp)
+}
+
 func (h *Handler) saveAll(c *fiber.Ctx) error {
 	formID, _ := strconv.Atoi(c.Params("id"))
 	uid, ok := currentUserID(c)
 	if !ok {
 		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
 	}
+	if err := h.Repo.CheckWindow(c.Context(), formID, uid, WindowEval); err != nil {
+		return windowClosed(c, err)
+	}
 
 	var in SaveAllInput
 	if err := c.BodyParser(&in); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
 	}
 
-	aid, err := h.Repo.SaveAll(c.Context(), formID, uid, in)
+	// draft ตรวจแบบผ่อนปรน, submit (status=2) ตรวจเต็ม
+	comps, err := h.Repo.ListCompsByForm(c.Context(), formID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	questions, err := h.Repo.ListQuestions(c.Context(), formID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := ValidateSaveAll(in, comps, questions, in.Status == AssignSubmitted); err != nil {
+		return validationFailed(c, err)
+	}
+	if err := h.Repo.CheckObjectiveLinks(c.Context(), formID, "kpis", in.KPIs); err != nil {
+		return validationFailed(c, err)
+	}
+	if err := h.Repo.CheckDevPlanCourses(c.Context(), "development_plan", in.DevelopmentPlan); err != nil {
+		return validationFailed(c, err)
+	}
+
+	aid, sum, err := h.Repo.SaveAll(c.Context(), formID, uid, in)
 	if err != nil {
 		if errors.Is(err, ErrAlreadySubmitted) {
 			return c.Status(409).JSON(fiber.Map{
@@ -217,13 +647,12 @@ func (h *Handler) saveAll(c *fiber.Ctx) error {
 				"code":  "ALREADY_SUBMITTED",
 			})
 		}
+		if errors.Is(err, ErrDevPlanKept) {
+			return devPlanConflict(c, err)
+		}
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	sum, err := h.Repo.ComputeSummary(c.Context(), formID, uid)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
 	return c.JSON(fiber.Map{
 		"assignment_id": aid,
 		"status":        in.Status,
@@ -260,16 +689,20 @@ func (h *Handler) addEvalSteps(c *fiber.Ctx) error {
 	}
 
 	var in struct {
-		Evaluators []int `json:"evaluators"`
+		Evaluators []int     `json:"evaluators"`
+		Weights    []float64 `json:"weights"` // ไม่รับ: น้ำหนักรายขั้นตั้งโดย HR ผ่าน bulk assign เท่านั้น
 	}
 	if err := c.BodyParser(&in); err != nil || len(in.Evaluators) == 0 {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json or no evaluators"})
 	}
+	// พนักงานตั้งน้ำหนักขั้นของตัวเองได้ = ให้ self 100% แล้วลบคะแนนหัวหน้าทิ้งได้
+	if len(in.Weights) > 0 {
+		return c.Status(403).JSON(fiber.Map{"error": "step weights can only be set by HR", "code": "STEP_WEIGHTS_HR_ONLY"})
+	}
 
-	// Self + คนอื่น
+	// Self + คนอื่น (ไม่มีน้ำหนัก = ใช้คะแนนขั้นล่าสุด)
 	evaluators := append([]int{uid}, in.Evaluators...)
-
-	steps, err := h.Repo.AddEvalSteps(c.Context(), a.ID, evaluators)
+	steps, err := h.Repo.AddEvalSteps(c.Context(), a.ID, evaluators, nil)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -291,10 +724,11 @@ func (h *Handler) listEvalSteps(c *fiber.Ctx) error {
 	}
 
 	type EvalStepResp struct {
-		ID            int     `json:"id"`
-		EvaluatorName string  `json:"evaluator_name"`
-		Status        int     `json:"status"`
-		EvalDate      *string `json:"eval_date"`
+		ID            int      `json:"id"`
+		EvaluatorName string   `json:"evaluator_name"`
+		Status        int      `json:"status"`
+		EvalDate      *string  `json:"eval_date"`
+		Weight        *float64 `json:"weight"`
 	}
 
 	out := make([]EvalStepResp, 0, len(steps))
@@ -304,6 +738,7 @@ func (h *Handler) listEvalSteps(c *fiber.Ctx) error {
 			EvaluatorName: s.EvaluatorName,
 			Status:        s.Status,
 			EvalDate:      s.EvalDate,
+			Weight:        s.Weight,
 		})
 	}
 
@@ -322,12 +757,1739 @@ func (h *Handler) updateEvalStep(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
 	}
 
-	step, ok, err := h.Repo.UpdateEvalStep(c.Context(), stepID, in.Status, in.EvalDate)
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	step, ok, err := h.Repo.UpdateEvalStep(c.Context(), stepID, uid, in.Status, in.EvalDate)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		return workflowError(c, err)
 	}
 	if !ok {
 		return c.Status(404).JSON(fiber.Map{"error": "not found"})
 	}
 	return c.JSON(step)
 }
+
+// bandScopeQuery อ่าน ?form_id= / ?company_id= (ไม่ส่งทั้งคู่ = ค่าเริ่มต้น)
+func bandScopeQuery(c *fiber.Ctx) (*int, *string, error) {
+	var fid *int
+	var cid *string
+	if v := c.Query("form_id"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, errors.New("invalid form_id")
+		}
+		fid = &n
+	}
+	if v := c.Query("company_id"); v != "" {
+		cid = &v
+	}
+	if fid != nil && cid != nil {
+		return nil, nil, errors.New("use either form_id or company_id, not both")
+	}
+	return fid, cid, nil
+}
+
+// GET /grade-bands?form_id=|company_id=
+func (h *Handler) listGradeBands(c *fiber.Ctx) error {
+	fid, cid, err := bandScopeQuery(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	out, err := h.Repo.ListGradeBands(c.Context(), fid, cid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+// PUT /grade-bands  (body: form_id | company_id | ไม่ส่ง, bands: [...])
+func (h *Handler) replaceGradeBands(c *fiber.Ctx) error {
+	var in GradeBandSetInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if in.FormID != nil && in.CompanyID != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "use either form_id or company_id, not both"})
+	}
+	out, err := h.Repo.ReplaceGradeBands(c.Context(), in)
+	if err != nil {
+		return validationFailed(c, err)
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+// DELETE /grade-bands?form_id=|company_id=
+func (h *Handler) deleteGradeBands(c *fiber.Ctx) error {
+	fid, cid, err := bandScopeQuery(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	n, err := h.Repo.DeleteGradeBands(c.Context(), fid, cid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// GET /grade-bands/preview?pct=73.5&form_id=&company_id=
+func (h *Handler) previewGrade(c *fiber.Ctx) error {
+	pct, err := strconv.ParseFloat(c.Query("pct"), 64)
+	if err != nil || pct < 0 || pct > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "pct must be a number between 0 and 100"})
+	}
+	formID, _ := strconv.Atoi(c.Query("form_id", "0"))
+	return c.JSON(h.Repo.PreviewGrade(c.Context(), formID, c.Query("company_id"), pct))
+}
+
+// GET /assignments/:id/extensions
+func (h *Handler) listExtensions(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	out, err := h.Repo.ListExtensions(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+// POST /assignments/:id/extensions  {window: kpi_cfg|eval, extended_until, reason}
+func (h *Handler) grantExtension(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, _ := currentUserID(c)
+
+	var in WindowExtensionInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateExtension(in); err != nil {
+		return validationFailed(c, err)
+	}
+
+	x, ok, err := h.Repo.GrantExtension(c.Context(), aid, uid, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "assignment not found"})
+	}
+	return c.JSON(x)
+}
+
+// GET /inbox — assignment ที่รอฉันประเมิน
+func (h *Handler) listInbox(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	out, err := h.Repo.ListInbox(c.Context(), uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+// workflowError: 403 ไม่ใช่ผู้ประเมิน/เจ้าของ, 409 ยังไม่ถึงขั้นของตัวเอง หรือเปลี่ยนสถานะไม่ได้
+func workflowError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrNotEvaluator):
+		return c.Status(403).JSON(fiber.Map{"error": err.Error(), "code": "NOT_EVALUATOR"})
+	case errors.Is(err, ErrNotOwner):
+		return c.Status(403).JSON(fiber.Map{"error": err.Error(), "code": "NOT_OWNER"})
+	case errors.Is(err, ErrStepNotActive):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "STEP_NOT_ACTIVE"})
+	case errors.Is(err, ErrIllegalTransition):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "ILLEGAL_TRANSITION"})
+	}
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return validationFailed(c, err)
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// GET /assignments/:id/getdataform
+func (h *Handler) getAssignmentData(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	data, found, err := h.Repo.LoadAssignmentAsEvaluator(c.Context(), aid, uid)
+	if err != nil {
+		return workflowError(c, err)
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(data)
+}
+
+// POST /assignments/:id/save — บันทึกคะแนนของ step ที่ฉันถืออยู่
+func (h *Handler) saveAsEvaluator(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	var in EvaluatorSaveInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+
+	a, found, err := h.Repo.GetAssignment(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err := h.Repo.CheckWindow(c.Context(), a.FormID, a.UserID, WindowEval); err != nil {
+		return windowClosed(c, err)
+	}
+
+	data, _, err := h.Repo.LoadAssignmentAsEvaluator(c.Context(), aid, uid)
+	if err != nil {
+		return workflowError(c, err)
+	}
+	comps, err := h.Repo.ListCompsByForm(c.Context(), a.FormID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	// KPI ที่มี scoring rule: กรอก actual_value แล้วคำนวณ score ให้ (ยกเว้น override)
+	rules := make(map[int]MyKPIItem, len(data.KPIs))
+	for _, k := range data.KPIs {
+		rules[k.ID] = k
+	}
+	for i, it := range in.KPIScores {
+		if k, ok := rules[it.ID]; ok && k.ScoringRule != nil && it.ActualValue != nil && !it.ScoreOverride {
+			in.KPIScores[i].Score = k.ScoringRule.Score(*it.ActualValue, k.MaxScore)
+		}
+	}
+	if err := ValidateEvaluatorSave(in, data.KPIs, comps, data.TimeAttendance.FullScore); err != nil {
+		return validationFailed(c, err)
+	}
+
+	sum, err := h.Repo.SaveAsEvaluator(c.Context(), a, uid, in)
+	if err != nil {
+		return workflowError(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"assignment_id": aid,
+		"status":        in.Status,
+		"summary":       sum,
+	})
+}
+
+// POST /assignments/:id/return  {comment} — ส่งกลับขั้นก่อนหน้า (ต้องระบุเหตุผล)
+func (h *Handler) returnStep(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	var in TransitionInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateTransition(in, true); err != nil {
+		return validationFailed(c, err)
+	}
+
+	prev, err := h.Repo.ReturnToPreviousStep(c.Context(), aid, uid, strings.TrimSpace(in.Comment))
+	if err != nil {
+		return workflowError(c, err)
+	}
+	return c.JSON(fiber.Map{"assignment_id": aid, "active_step": prev})
+}
+
+// POST /assignments/:id/acknowledge — พนักงานรับทราบผล
+func (h *Handler) acknowledge(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	var in TransitionInput
+	_ = c.BodyParser(&in) // comment ไม่บังคับ
+	if err := ValidateTransition(in, false); err != nil {
+		return validationFailed(c, err)
+	}
+
+	a, found, err := h.Repo.GetAssignment(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err := h.Repo.Acknowledge(c.Context(), a, uid, strings.TrimSpace(in.Comment)); err != nil {
+		return workflowError(c, err)
+	}
+	return c.JSON(fiber.Map{"assignment_id": aid, "status": AssignAcknowledged, "status_name": AssignStatusName(AssignAcknowledged)})
+}
+
+// POST /assignments/:id/close — HR ปิดรอบ
+func (h *Handler) closeAssignment(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, _ := currentUserID(c)
+	var in TransitionInput
+	_ = c.BodyParser(&in)
+	if err := ValidateTransition(in, false); err != nil {
+		return validationFailed(c, err)
+	}
+
+	if _, found, err := h.Repo.GetAssignment(c.Context(), aid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err := h.Repo.Close(c.Context(), aid, uid, strings.TrimSpace(in.Comment)); err != nil {
+		return workflowError(c, err)
+	}
+	return c.JSON(fiber.Map{"assignment_id": aid, "status": AssignClosed, "status_name": AssignStatusName(AssignClosed)})
+}
+
+// GET /assignments/:id/transitions — เจ้าของ, ผู้ประเมิน หรือ HR
+func (h *Handler) listTransitions(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	a, found, err := h.Repo.GetAssignment(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if !isHR(c) {
+		can, err := h.Repo.CanViewAssignment(c.Context(), a, uid)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !can {
+			return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+		}
+	}
+
+	out, err := h.Repo.ListTransitions(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+// POST /forms/:id/assign — HR มอบหมายฟอร์มให้หลายคนพร้อม chain ผู้ประเมิน (ซ้ำได้ ไม่สร้างซ้ำ)
+func (h *Handler) bulkAssign(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	var in BulkAssignInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateBulkAssign(in); err != nil {
+		return validationFailed(c, err)
+	}
+
+	f, ok, err := h.Repo.GetForm(c.Context(), formID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if f.Archived {
+		return c.Status(409).JSON(fiber.Map{"error": "form is archived", "code": "FORM_ARCHIVED"})
+	}
+
+	out, err := h.Repo.BulkAssign(c.Context(), formID, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	counts := map[string]int{BulkCreated: 0, BulkStepsAdded: 0, BulkExists: 0, BulkFailed: 0}
+	for _, r := range out {
+		counts[r.Result]++
+	}
+	return c.JSON(fiber.Map{"form_id": formID, "counts": counts, "data": out})
+}
+
+// calibrationError: 409 เมื่อ session ล็อกแล้ว / assignment ไม่ใช่ของฟอร์มนี้
+func calibrationError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrCalibrationLocked):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "CALIBRATION_LOCKED"})
+	case errors.Is(err, ErrWrongForm):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "WRONG_FORM"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// GET /calibrations?form_id=
+func (h *Handler) listCalibrations(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Query("form_id", "0"))
+	out, err := h.Repo.ListCalibrations(c.Context(), formID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+// POST /calibrations {form_id, name, targets:[{grade, target_pct}]}
+func (h *Handler) createCalibration(c *fiber.Ctx) error {
+	uid, _ := currentUserID(c)
+	var in CalibrationInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateCalibration(in); err != nil {
+		return validationFailed(c, err)
+	}
+	if _, ok, err := h.Repo.GetForm(c.Context(), in.FormID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "form not found"})
+	}
+
+	out, err := h.Repo.CreateCalibration(c.Context(), uid, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(out)
+}
+
+// GET /calibrations/:id — รายการ + สัดส่วนเกรดเทียบเป้า
+func (h *Handler) getCalibration(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	out, ok, err := h.Repo.GetCalibration(c.Context(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(out)
+}
+
+// PUT /calibrations/:id/targets {targets:[...]}
+func (h *Handler) setCalibrationTargets(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var in struct {
+		Targets []CalibrationTarget `json:"targets"`
+	}
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateTargets(in.Targets); err != nil {
+		return validationFailed(c, err)
+	}
+	ok, err := h.Repo.SetCalibrationTargets(c.Context(), id, in.Targets)
+	if err != nil {
+		return calibrationError(c, err)
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return h.getCalibration(c)
+}
+
+// POST /calibrations/:id/items {assignment_ids:[...]}
+func (h *Handler) addCalibrationItems(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var in struct {
+		AssignmentIDs []int `json:"assignment_ids"`
+	}
+	if err := c.BodyParser(&in); err != nil || len(in.AssignmentIDs) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json or empty assignment_ids"})
+	}
+	added, skipped, ok, err := h.Repo.AddCalibrationItems(c.Context(), id, in.AssignmentIDs)
+	if err != nil {
+		return calibrationError(c, err)
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(fiber.Map{"added": added, "skipped": skipped}) // skipped = อยู่ใน session อื่นแล้ว
+}
+
+// POST /calibrations/:id/refresh — คำนวณเกรดของทุกรายการใหม่จากคะแนนปัจจุบัน
+func (h *Handler) refreshCalibration(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	ok, err := h.Repo.RefreshCalibration(c.Context(), id)
+	if err != nil {
+		return calibrationError(c, err)
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return h.getCalibration(c)
+}
+
+// PUT /calibrations/:id/items/:aid {grade, reason}
+func (h *Handler) overrideGrade(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	aid, _ := strconv.Atoi(c.Params("aid"))
+	uid, _ := currentUserID(c)
+	var in CalibrationOverrideInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateOverride(in); err != nil {
+		return validationFailed(c, err)
+	}
+	ok, err := h.Repo.OverrideGrade(c.Context(), id, aid, uid, in)
+	if err != nil {
+		return calibrationError(c, err)
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// DELETE /calibrations/:id/items/:aid
+func (h *Handler) removeCalibrationItem(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	aid, _ := strconv.Atoi(c.Params("aid"))
+	ok, err := h.Repo.RemoveCalibrationItem(c.Context(), id, aid)
+	if err != nil {
+		return calibrationError(c, err)
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// POST /calibrations/:id/lock
+func (h *Handler) lockCalibration(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	uid, _ := currentUserID(c)
+	ok, err := h.Repo.LockCalibration(c.Context(), id, uid)
+	if err != nil {
+		return calibrationError(c, err)
+	}
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return h.getCalibration(c)
+}
+
+func reportFilter(c *fiber.Ctx) ReportFilter {
+	return ReportFilter{
+		CompanyID:  c.Query("company_id"),
+		Department: c.Query("department"),
+		Position:   c.Query("position"),
+		Status:     c.Query("status"),
+	}
+}
+
+// analyticsFilter: reportFilter + ตรวจ status (submitted / approved / all)
+func analyticsFilter(c *fiber.Ctx) (ReportFilter, bool) {
+	f := reportFilter(c)
+	switch f.Status {
+	case "", ReportStatusSubmitted, ReportStatusApproved, ReportStatusAll:
+		return f, true
+	}
+	return f, false
+}
+
+func badStatusFilter(c *fiber.Ctx) error {
+	return c.Status(400).JSON(fiber.Map{"error": "status must be submitted, approved or all"})
+}
+
+// GET /reports/forms/:id/progress — จำนวนตามสถานะ / ขั้นปัจจุบัน / เลยกำหนด
+func (h *Handler) progressReport(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	out, err := h.Repo.ProgressReport(c.Context(), formID, reportFilter(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(out)
+}
+
+// GET /reports/forms/:id/breakdown?by=department|company
+func (h *Handler) breakdownReport(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	by := c.Query("by", "department")
+	if by != "department" && by != "company" {
+		return c.Status(400).JSON(fiber.Map{"error": "by must be department or company"})
+	}
+	out, err := h.Repo.Breakdown(c.Context(), formID, by, reportFilter(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"by": by, "data": out})
+}
+
+// GET /reports/forms/:id/overdue?limit=&offset=
+func (h *Handler) overdueReport(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+	out, total, err := h.Repo.ListOverdue(c.Context(), formID, reportFilter(c), limit, offset)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"total": total, "data": out})
+}
+
+// GET /analytics/forms/:id/scores?bucket=10 — ค่าเฉลี่ยรายส่วน + histogram
+func (h *Handler) scoreAnalytics(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	bucket, _ := strconv.ParseFloat(c.Query("bucket", "10"), 64)
+	f, ok := analyticsFilter(c)
+	if !ok {
+		return badStatusFilter(c)
+	}
+	out, err := h.Repo.ScoreAnalytics(c.Context(), formID, f, bucket)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(out)
+}
+
+// GET /analytics/forms/:id/grades — การกระจายเกรดต่อแผนก
+func (h *Handler) gradeAnalytics(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	f, ok := analyticsFilter(c)
+	if !ok {
+		return badStatusFilter(c)
+	}
+	out, err := h.Repo.GradesByDepartment(c.Context(), formID, f)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"form_id": formID, "data": out})
+}
+
+// GET /analytics/forms/:id/competency-gaps
+func (h *Handler) competencyGaps(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	f, ok := analyticsFilter(c)
+	if !ok {
+		return badStatusFilter(c)
+	}
+	out, err := h.Repo.CompetencyGaps(c.Context(), formID, f)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"form_id": formID, "data": out})
+}
+
+// GET /assignments/:id/pdf — เอกสารประเมินที่เสร็จแล้ว (เจ้าของ / ผู้ประเมิน / HR)
+func (h *Handler) assignmentPDF(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	font, err := pdf.DefaultFont()
+	if err != nil {
+		return c.Status(503).JSON(fiber.Map{"error": err.Error(), "code": "PDF_FONT_MISSING"})
+	}
+	a, found, err := h.Repo.GetAssignment(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if !isHR(c) {
+		can, err := h.Repo.CanViewAssignment(c.Context(), a, uid)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !can {
+			return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+		}
+	}
+	data, err := h.Repo.LoadPrintData(c.Context(), a)
+	if errors.Is(err, ErrNotFinished) {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "EVAL_NOT_FINISHED", "status": AssignStatusName(a.Status)})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var buf bytes.Buffer
+	if err := RenderPDF(&buf, font, data); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="evaluation-%d.pdf"`, aid))
+	return c.Send(buf.Bytes())
+}
+
+// GET /exports/forms/:id/xlsx — stream ไฟล์ Excel (filter เดียวกับ reports)
+func (h *Handler) exportFormXLSX(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	form, found, err := h.Repo.GetForm(c.Context(), formID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "form not found"})
+	}
+	// ค่าจาก c.Query ใช้ buffer ของ request — ต้อง copy ก่อนใช้ใน stream writer
+	f := reportFilter(c)
+	f = ReportFilter{
+		CompanyID:  strings.Clone(f.CompanyID),
+		Department: strings.Clone(f.Department),
+		Position:   strings.Clone(f.Position),
+	}
+
+	c.Set(fiber.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="eval-%d-%s.xlsx"`, formID, today()))
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		// request ctx ใช้ไม่ได้หลัง handler return จึงใช้ context แยกพร้อม timeout
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+		if err := h.Repo.ExportFormXLSX(ctx, formID, f, w); err != nil {
+			log.Printf("[EVAL][XLSX] export form=%d (%s) failed: %v", formID, form.Code, err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// ===== KPI library / templates =====
+
+// GET /kpi-catalog — filter: company_id, department, q, include_inactive
+func (h *Handler) listKPICatalog(c *fiber.Ctx) error {
+	out, err := h.Repo.ListKPICatalog(c.Context(), KPICatalogFilter{
+		CompanyID:       c.Query("company_id"),
+		Department:      c.Query("department"),
+		Q:               c.Query("q"),
+		IncludeInactive: c.QueryBool("include_inactive", false),
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+// GET /kpi-catalog/mine — รายการที่ใช้ได้กับบริษัท/แผนกของผู้ใช้
+func (h *Handler) listMyKPICatalog(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	out, err := h.Repo.ListKPICatalogForUser(c.Context(), uid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+func parseKPICatalogInput(c *fiber.Ctx) (KPICatalogInput, error) {
+	var in KPICatalogInput
+	if err := c.BodyParser(&in); err != nil {
+		return in, err
+	}
+	if in.DefaultMaxScore == 0 {
+		in.DefaultMaxScore = 5
+	}
+	return in, nil
+}
+
+func (h *Handler) createKPICatalog(c *fiber.Ctx) error {
+	in, err := parseKPICatalogInput(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateKPICatalog(in); err != nil {
+		return validationFailed(c, err)
+	}
+	k, err := h.Repo.CreateKPICatalog(c.Context(), in)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateCode) {
+			return c.Status(409).JSON(fiber.Map{"error": "kpi code already exists in this scope", "code": "DUPLICATE_CODE"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(k)
+}
+
+func (h *Handler) updateKPICatalog(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	in, err := parseKPICatalogInput(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateKPICatalog(in); err != nil {
+		return validationFailed(c, err)
+	}
+	k, found, err := h.Repo.UpdateKPICatalog(c.Context(), id, in)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateCode) {
+			return c.Status(409).JSON(fiber.Map{"error": "kpi code already exists in this scope", "code": "DUPLICATE_CODE"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(k)
+}
+
+// DELETE /kpi-catalog/:id — ปิดใช้งาน (KPI ที่อ้างอยู่แล้วไม่กระทบ)
+func (h *Handler) deactivateKPICatalog(c *fiber.Ctx) error { return h.setKPICatalogActive(c, false) }
+
+func (h *Handler) activateKPICatalog(c *fiber.Ctx) error { return h.setKPICatalogActive(c, true) }
+
+func (h *Handler) setKPICatalogActive(c *fiber.Ctx, active bool) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	found, err := h.Repo.SetKPICatalogActive(c.Context(), id, active)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(fiber.Map{"id": id, "active": active})
+}
+
+func (h *Handler) listKPITemplates(c *fiber.Ctx) error {
+	out, err := h.Repo.ListKPITemplates(c.Context(), c.Query("position"), c.Query("company_id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+func (h *Handler) getKPITemplate(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	t, found, err := h.Repo.GetKPITemplate(c.Context(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(t)
+}
+
+func (h *Handler) createKPITemplate(c *fiber.Ctx) error {
+	var in KPITemplateInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateKPITemplate(in); err != nil {
+		return validationFailed(c, err)
+	}
+	t, err := h.Repo.CreateKPITemplate(c.Context(), in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(t)
+}
+
+func (h *Handler) updateKPITemplate(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var in KPITemplateInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateKPITemplate(in); err != nil {
+		return validationFailed(c, err)
+	}
+	t, found, err := h.Repo.UpdateKPITemplate(c.Context(), id, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(t)
+}
+
+func (h *Handler) deleteKPITemplate(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	found, err := h.Repo.DeleteKPITemplate(c.Context(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// POST /assignments/:id/kpis/apply-template — เจ้าของ (ภายในช่วงตั้งค่า KPI) หรือ HR
+func (h *Handler) applyKPITemplate(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	var in ApplyTemplateInput
+	_ = c.BodyParser(&in)
+	if in.Mode == "" {
+		in.Mode = ApplyReplace
+	}
+	if in.Mode != ApplyReplace && in.Mode != ApplyAppend {
+		return c.Status(400).JSON(fiber.Map{"error": "mode must be replace or append"})
+	}
+
+	a, found, err := h.Repo.GetAssignment(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if !isHR(c) {
+		if a.UserID != uid {
+			return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+		}
+		if err := h.Repo.CheckWindow(c.Context(), a.FormID, uid, WindowKPICfg); err != nil {
+			return windowClosed(c, err)
+		}
+	}
+	if IsLocked(a.Status) {
+		return c.Status(409).JSON(fiber.Map{"error": "already submitted: cannot modify", "code": "ALREADY_SUBMITTED"})
+	}
+
+	if in.TemplateID == 0 {
+		id, found, err := h.Repo.TemplateForUser(c.Context(), a.UserID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !found {
+			return c.Status(404).JSON(fiber.Map{"error": "no kpi template for this position", "code": "TEMPLATE_NOT_FOUND"})
+		}
+		in.TemplateID = id
+	} else if _, found, err := h.Repo.GetKPITemplate(c.Context(), in.TemplateID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "template not found"})
+	}
+
+	items, err := h.Repo.TemplateKPIs(c.Context(), in.TemplateID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if in.Mode == ApplyReplace {
+		if err := ValidateKPIs(items, 0); err != nil {
+			return validationFailed(c, err)
+		}
+		out, err := h.Repo.ReplaceMyKPIs(c.Context(), a.FormID, a.UserID, "", items)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"assignment_id": a.ID, "template_id": in.TemplateID, "mode": in.Mode, "data": out})
+	}
+
+	// append: ข้ามรายการคลังที่มีอยู่แล้ว และต่อ idx จากตัวสุดท้าย
+	existing, err := h.Repo.ListMyKPIsByForm(c.Context(), a.FormID, a.UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	have := map[int]bool{}
+	existingWeight, lastIdx := 0, 0
+	for _, k := range existing {
+		existingWeight += k.Weight
+		lastIdx = max(lastIdx, k.Idx)
+		if k.CatalogID != nil {
+			have[*k.CatalogID] = true
+		}
+	}
+	add := make([]MyKPIInput, 0, len(items))
+	for _, k := range items {
+		if have[*k.CatalogID] {
+			continue
+		}
+		lastIdx++
+		k.Idx = lastIdx
+		add = append(add, k)
+	}
+	if len(add) == 0 {
+		return c.JSON(fiber.Map{"assignment_id": a.ID, "template_id": in.TemplateID, "mode": in.Mode, "data": []MyKPIItem{}})
+	}
+	if err := ValidateKPIs(add, existingWeight); err != nil {
+		return validationFailed(c, err)
+	}
+	out, err := h.Repo.AddMyKPIsBulk(c.Context(), a.ID, add)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(fiber.Map{"assignment_id": a.ID, "template_id": in.TemplateID, "mode": in.Mode, "data": out})
+}
+
+// GET /reports/forms/:id/kpi-catalog — การใช้คลัง KPI ในฟอร์ม
+func (h *Handler) kpiCatalogReport(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	out, unlinked, err := h.Repo.KPICatalogUsage(c.Context(), formID, reportFilter(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"form_id": formID, "unlinked_kpis": unlinked, "data": out})
+}
+
+// ===== Goal cascading =====
+
+func (h *Handler) listObjectives(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	out, err := h.Repo.ListObjectives(c.Context(), formID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"form_id": formID, "data": out})
+}
+
+func (h *Handler) createObjective(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	var in ObjectiveInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if _, found, err := h.Repo.GetForm(c.Context(), formID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "form not found"})
+	}
+	all, err := h.Repo.ListObjectives(c.Context(), formID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := ValidateObjective(in, all, 0); err != nil {
+		return validationFailed(c, err)
+	}
+	o, err := h.Repo.CreateObjective(c.Context(), formID, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(o)
+}
+
+func (h *Handler) updateObjective(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var in ObjectiveInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	cur, found, err := h.Repo.GetObjective(c.Context(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	all, err := h.Repo.ListObjectives(c.Context(), cur.FormID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := ValidateObjective(in, all, id); err != nil {
+		return validationFailed(c, err)
+	}
+	o, _, err := h.Repo.UpdateObjective(c.Context(), id, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(o)
+}
+
+func (h *Handler) deleteObjective(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	found, err := h.Repo.DeleteObjective(c.Context(), id)
+	if errors.Is(err, ErrObjectiveHasChildren) {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "OBJECTIVE_HAS_CHILDREN"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// GET /forms/:id/objectives/cascade?root= — ต้นไม้เป้าหมาย + contribution (%) ของลูกแต่ละตัว
+func (h *Handler) objectiveCascade(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	root := c.QueryInt("root", 0)
+	out, err := h.Repo.ObjectiveCascade(c.Context(), formID, root)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if root != 0 && len(out) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "objective not found"})
+	}
+	return c.JSON(fiber.Map{"form_id": formID, "data": out})
+}
+
+// PUT /assignments/:id/kpis/:kpiId/objective — เจ้าของ (ตอน draft ในช่วงตั้งค่า KPI) หรือ HR
+func (h *Handler) linkKPIObjective(c *fiber.Ctx) error {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	kpiID, _ := strconv.Atoi(c.Params("kpiId"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	var in LinkObjectiveInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+
+	a, found, err := h.Repo.GetAssignment(c.Context(), aid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if !isHR(c) {
+		if a.UserID != uid {
+			return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+		}
+		if err := h.Repo.CheckWindow(c.Context(), a.FormID, uid, WindowKPICfg); err != nil {
+			return windowClosed(c, err)
+		}
+		if IsLocked(a.Status) {
+			return c.Status(409).JSON(fiber.Map{"error": "already submitted: cannot modify", "code": "ALREADY_SUBMITTED"})
+		}
+	}
+	if in.ObjectiveID != nil {
+		o, found, err := h.Repo.GetObjective(c.Context(), *in.ObjectiveID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !found || o.FormID != a.FormID {
+			ve := &ValidationError{}
+			ve.add("objective_id", "not_found", "objective %d is not in this form", *in.ObjectiveID)
+			return validationFailed(c, ve)
+		}
+	}
+	found, err = h.Repo.LinkKPIObjective(c.Context(), aid, kpiID, in.ObjectiveID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "kpi not found"})
+	}
+	return c.JSON(fiber.Map{"assignment_id": aid, "kpi_id": kpiID, "objective_id": in.ObjectiveID})
+}
+
+// ===== KPI check-ins =====
+
+// checkinRole บทบาทของผู้ใช้ต่อ assignment: เจ้าของ = employee, ผู้ประเมิน/HR = manager, "" = ไม่มีสิทธิ์
+func (h *Handler) checkinRole(c *fiber.Ctx, a Assign, uid int) (string, error) {
+	if a.UserID == uid {
+		return CheckinByEmployee, nil
+	}
+	if isHR(c) {
+		return CheckinByManager, nil
+	}
+	can, err := h.Repo.CanViewAssignment(c.Context(), a, uid)
+	if err != nil || !can {
+		return "", err
+	}
+	return CheckinByManager, nil
+}
+
+// checkinAssignment โหลด assignment พร้อมบทบาท (ตอบ error ให้แล้วถ้า ok = false)
+func (h *Handler) checkinAssignment(c *fiber.Ctx) (Assign, string, int, bool, error) {
+	aid, _ := strconv.Atoi(c.Params("id"))
+	uid, ok := currentUserID(c)
+	if !ok {
+		return Assign{}, "", 0, false, c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	a, found, err := h.Repo.GetAssignment(c.Context(), aid)
+	if err != nil {
+		return Assign{}, "", 0, false, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return Assign{}, "", 0, false, c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	role, err := h.checkinRole(c, a, uid)
+	if err != nil {
+		return Assign{}, "", 0, false, c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if role == "" {
+		return Assign{}, "", 0, false, c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	return a, role, uid, true, nil
+}
+
+func checkinClosed(c *fiber.Ctx) error {
+	return c.Status(409).JSON(fiber.Map{"error": "evaluation is finished: check-ins are closed", "code": "CHECKIN_CLOSED"})
+}
+
+// GET /assignments/:id/checkins?kpi_id= — timeline (เจ้าของ, ผู้ประเมิน หรือ HR)
+func (h *Handler) listKPICheckins(c *fiber.Ctx) error {
+	a, _, _, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	out, err := h.Repo.ListKPICheckins(c.Context(), a.ID, c.QueryInt("kpi_id", 0))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"assignment_id": a.ID, "data": out})
+}
+
+// GET /assignments/:id/kpis/:kpiId/checkins
+func (h *Handler) listKPIItemCheckins(c *fiber.Ctx) error {
+	a, _, _, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	kpiID, _ := strconv.Atoi(c.Params("kpiId"))
+	out, err := h.Repo.ListKPICheckins(c.Context(), a.ID, kpiID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"assignment_id": a.ID, "kpi_id": kpiID, "data": out})
+}
+
+// POST /assignments/:id/kpis/:kpiId/checkins — บันทึกได้จนกว่าการประเมินจะอนุมัติ
+func (h *Handler) addKPICheckin(c *fiber.Ctx) error {
+	a, role, uid, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	kpiID, _ := strconv.Atoi(c.Params("kpiId"))
+	var in KPICheckinInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateKPICheckin(in); err != nil {
+		return validationFailed(c, err)
+	}
+	if a.Status >= AssignApproved {
+		return checkinClosed(c)
+	}
+	out, found, err := h.Repo.AddKPICheckin(c.Context(), a.ID, kpiID, uid, role, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "kpi not found"})
+	}
+	return c.Status(201).JSON(out)
+}
+
+// DELETE /assignments/:id/checkins/:checkinId — ผู้บันทึกเองหรือ HR
+func (h *Handler) deleteKPICheckin(c *fiber.Ctx) error {
+	a, _, uid, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	id, _ := strconv.Atoi(c.Params("checkinId"))
+	ci, found, err := h.Repo.GetKPICheckin(c.Context(), a.ID, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if !isHR(c) && (ci.AuthorID == nil || *ci.AuthorID != uid) {
+		return c.Status(403).JSON(fiber.Map{"error": "only the author can delete a check-in"})
+	}
+	if a.Status >= AssignApproved {
+		return checkinClosed(c)
+	}
+	if _, err := h.Repo.DeleteKPICheckin(c.Context(), a.ID, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.SendStatus(204)
+}
+
+// ===== Competency dictionary =====
+
+// GET /competency-dictionary — filter: q, include_inactive
+func (h *Handler) listCompetencyDefs(c *fiber.Ctx) error {
+	out, err := h.Repo.ListCompetencyDefs(c.Context(), c.Query("q"), c.QueryBool("include_inactive", false))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+func (h *Handler) getCompetencyDef(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	d, found, err := h.Repo.GetCompetencyDef(c.Context(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(d)
+}
+
+func (h *Handler) createCompetencyDef(c *fiber.Ctx) error {
+	var in CompetencyDefInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateCompetencyDef(in); err != nil {
+		return validationFailed(c, err)
+	}
+	d, err := h.Repo.CreateCompetencyDef(c.Context(), in)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateCode) {
+			return c.Status(409).JSON(fiber.Map{"error": "competency code already exists", "code": "DUPLICATE_CODE"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(d)
+}
+
+func (h *Handler) updateCompetencyDef(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	var in CompetencyDefInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateCompetencyDef(in); err != nil {
+		return validationFailed(c, err)
+	}
+	d, found, err := h.Repo.UpdateCompetencyDef(c.Context(), id, in)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateCode) {
+			return c.Status(409).JSON(fiber.Map{"error": "competency code already exists", "code": "DUPLICATE_CODE"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(d)
+}
+
+// DELETE /competency-dictionary/:id — ปิดใช้งาน (ฟอร์มที่อ้างอยู่ไม่กระทบ)
+func (h *Handler) deactivateCompetencyDef(c *fiber.Ctx) error {
+	return h.setCompetencyDefActive(c, false)
+}
+
+func (h *Handler) activateCompetencyDef(c *fiber.Ctx) error { return h.setCompetencyDefActive(c, true) }
+
+func (h *Handler) setCompetencyDefActive(c *fiber.Ctx, active bool) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	found, err := h.Repo.SetCompetencyDefActive(c.Context(), id, active)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(fiber.Map{"id": id, "active": active})
+}
+
+// ===== Additional questions =====
+
+func (h *Handler) listQuestions(c *fiber.Ctx) error {
+	fid, _ := strconv.Atoi(c.Params("id"))
+	out, err := h.Repo.ListQuestions(c.Context(), fid)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+func parseQuestionInput(c *fiber.Ctx) (QuestionInput, error) {
+	var in QuestionInput
+	if err := c.BodyParser(&in); err != nil {
+		return in, err
+	}
+	if in.Type == "" {
+		in.Type = QuestionLongText
+	}
+	// rating ไม่ระบุสเกล = 1..5
+	if in.Type == QuestionRating && in.ScaleMin == nil && in.ScaleMax == nil {
+		lo, hi := 1, 5
+		in.ScaleMin, in.ScaleMax = &lo, &hi
+	}
+	return in, nil
+}
+
+func questionConflict(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrDuplicateCode):
+		return c.Status(409).JSON(fiber.Map{"error": "question code already exists in this form", "code": "DUPLICATE_CODE"})
+	case errors.Is(err, ErrQuestionAnswered):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "QUESTION_ANSWERED"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+func (h *Handler) createQuestion(c *fiber.Ctx) error {
+	fid, _ := strconv.Atoi(c.Params("id"))
+	in, err := parseQuestionInput(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateQuestion(in); err != nil {
+		return validationFailed(c, err)
+	}
+	if _, found, err := h.Repo.GetForm(c.Context(), fid); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "form not found"})
+	}
+	q, err := h.Repo.CreateQuestion(c.Context(), fid, in)
+	if err != nil {
+		return questionConflict(c, err)
+	}
+	return c.Status(201).JSON(q)
+}
+
+// PUT /questions/:id — เปลี่ยนชนิดไม่ได้เมื่อมีคำตอบแล้ว
+func (h *Handler) updateQuestion(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	in, err := parseQuestionInput(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateQuestion(in); err != nil {
+		return validationFailed(c, err)
+	}
+	q, found, err := h.Repo.UpdateQuestion(c.Context(), id, in)
+	if err != nil {
+		return questionConflict(c, err)
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(q)
+}
+
+// DELETE /questions/:id — ลบได้เฉพาะคำถามที่ยังไม่มีคำตอบ
+func (h *Handler) deleteQuestion(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	found, err := h.Repo.DeleteQuestion(c.Context(), id)
+	if err != nil {
+		return questionConflict(c, err)
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
+
+// ===== Development plan tracking =====
+// ใช้สิทธิ์แบบเดียวกับ check-in: เจ้าของ = employee, ผู้ประเมิน/HR = manager
+
+// GET /assignments/:id/dev-plan — รายการพร้อมบันทึกความคืบหน้า
+func (h *Handler) listDevPlan(c *fiber.Ctx) error {
+	a, _, _, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	out, err := h.Repo.ListDevPlan(c.Context(), a.ID, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+func devPlanConflict(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrDevPlanSignedOff):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "DEV_PLAN_SIGNED_OFF"})
+	case errors.Is(err, ErrDevPlanNotFinished):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "DEV_PLAN_NOT_FINISHED"})
+	case errors.Is(err, ErrDevPlanKept):
+		return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "DEV_PLAN_ITEM_KEPT"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// PUT /assignments/:id/dev-plan/:itemId/status
+func (h *Handler) setDevPlanStatus(c *fiber.Ctx) error {
+	a, role, uid, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	id, _ := strconv.Atoi(c.Params("itemId"))
+	var in DevPlanStatusInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateDevPlanStatus(in); err != nil {
+		return validationFailed(c, err)
+	}
+	out, found, err := h.Repo.SetDevPlanStatus(c.Context(), a.ID, id, uid, role, in)
+	if err != nil {
+		return devPlanConflict(c, err)
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "development plan item not found"})
+	}
+	return c.JSON(out)
+}
+
+// POST /assignments/:id/dev-plan/:itemId/notes
+func (h *Handler) addDevPlanNote(c *fiber.Ctx) error {
+	a, role, uid, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	id, _ := strconv.Atoi(c.Params("itemId"))
+	var in DevPlanNoteInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateDevPlanNote(in); err != nil {
+		return validationFailed(c, err)
+	}
+	out, found, err := h.Repo.AddDevPlanNote(c.Context(), a.ID, id, uid, role, in.Note)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "development plan item not found"})
+	}
+	return c.Status(201).JSON(out)
+}
+
+// POST /assignments/:id/dev-plan/:itemId/sign-off — หัวหน้า (ผู้ประเมิน) หรือ HR เท่านั้น
+func (h *Handler) signOffDevPlan(c *fiber.Ctx) error { return h.devPlanSignOff(c, false) }
+
+// DELETE /assignments/:id/dev-plan/:itemId/sign-off
+func (h *Handler) undoDevPlanSignOff(c *fiber.Ctx) error { return h.devPlanSignOff(c, true) }
+
+func (h *Handler) devPlanSignOff(c *fiber.Ctx, undo bool) error {
+	a, role, uid, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	if role != CheckinByManager {
+		return c.Status(403).JSON(fiber.Map{"error": "only a manager can sign off development plan items"})
+	}
+	id, _ := strconv.Atoi(c.Params("itemId"))
+	out, found, err := h.Repo.SignOffDevPlan(c.Context(), a.ID, id, uid, undo)
+	if err != nil {
+		return devPlanConflict(c, err)
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "development plan item not found"})
+	}
+	return c.JSON(out)
+}
+
+// POST /assignments/:id/dev-plan/carry-over — ยกรายการค้างจากรอบก่อน (ปกติทำอัตโนมัติตอนสร้าง assignment)
+func (h *Handler) carryOverDevPlan(c *fiber.Ctx) error {
+	a, _, _, ok, err := h.checkinAssignment(c)
+	if !ok {
+		return err
+	}
+	n, err := h.Repo.CarryOverDevPlan(c.Context(), a.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	out, err := h.Repo.ListDevPlan(c.Context(), a.ID, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"carried": n, "data": out})
+}
+
+// ===== Training catalogue =====
+
+// GET /courses — filter: q, competency_id, include_inactive
+func (h *Handler) listCourses(c *fiber.Ctx) error {
+	out, err := h.Repo.ListCourses(c.Context(), CourseFilter{
+		Q:               c.Query("q"),
+		CompetencyID:    c.QueryInt("competency_id", 0),
+		IncludeInactive: c.QueryBool("include_inactive", false),
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+func (h *Handler) getCourse(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	out, found, err := h.Repo.GetCourse(c.Context(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(out)
+}
+
+// parseCourseInput อ่าน + ตรวจ body (ตอบ error ให้แล้วถ้า ok = false)
+func (h *Handler) parseCourseInput(c *fiber.Ctx) (CourseInput, bool, error) {
+	var in CourseInput
+	if err := c.BodyParser(&in); err != nil {
+		return in, false, c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateCourse(in); err != nil {
+		return in, false, validationFailed(c, err)
+	}
+	if err := h.Repo.CheckCourseCompetencies(c.Context(), in.CompetencyIDs); err != nil {
+		return in, false, validationFailed(c, err)
+	}
+	return in, true, nil
+}
+
+func (h *Handler) createCourse(c *fiber.Ctx) error {
+	in, ok, err := h.parseCourseInput(c)
+	if !ok {
+		return err
+	}
+	out, err := h.Repo.CreateCourse(c.Context(), in)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateCode) {
+			return c.Status(409).JSON(fiber.Map{"error": "course code already exists", "code": "DUPLICATE_CODE"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(out)
+}
+
+func (h *Handler) updateCourse(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	in, ok, err := h.parseCourseInput(c)
+	if !ok {
+		return err
+	}
+	out, found, err := h.Repo.UpdateCourse(c.Context(), id, in)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateCode) {
+			return c.Status(409).JSON(fiber.Map{"error": "course code already exists", "code": "DUPLICATE_CODE"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(out)
+}
+
+// DELETE /courses/:id — ปิดใช้งาน (แผนพัฒนาและการลงทะเบียนเดิมไม่กระทบ)
+func (h *Handler) deactivateCourse(c *fiber.Ctx) error { return h.setCourseActive(c, false) }
+
+func (h *Handler) activateCourse(c *fiber.Ctx) error { return h.setCourseActive(c, true) }
+
+func (h *Handler) setCourseActive(c *fiber.Ctx, active bool) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	found, err := h.Repo.SetCourseActive(c.Context(), id, active)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(fiber.Map{"id": id, "active": active})
+}
+
+// GET /enrolments — filter: user_id, course_id, status (HR)
+func (h *Handler) listEnrolments(c *fiber.Ctx) error {
+	out, err := h.Repo.ListEnrolments(c.Context(), EnrolmentFilter{
+		UserID:   c.QueryInt("user_id", 0),
+		CourseID: c.QueryInt("course_id", 0),
+		Status:   c.Query("status"),
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+func (h *Handler) listMyEnrolments(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	out, err := h.Repo.ListEnrolments(c.Context(), EnrolmentFilter{UserID: uid, Status: c.Query("status")})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
+
+// POST /enrolments — ลงทะเบียนให้ตัวเอง (HR ลงให้คนอื่นได้)
+func (h *Handler) createEnrolment(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	var in EnrolmentInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if in.UserID == 0 {
+		in.UserID = uid
+	}
+	if in.UserID != uid && !isHR(c) {
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	if err := ValidateEnrolment(in); err != nil {
+		return validationFailed(c, err)
+	}
+	out, err := h.Repo.CreateEnrolment(c.Context(), in)
+	if err != nil {
+		if errors.Is(err, ErrCourseInactive) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error(), "code": "COURSE_INACTIVE"})
+		}
+		return validationFailed(c, err)
+	}
+	return c.Status(201).JSON(out)
+}
+
+// PUT /enrolments/:id — สถานะและใบประกาศ (เจ้าของหรือ HR)
+func (h *Handler) updateEnrolment(c *fiber.Ctx) error {
+	uid, ok := currentUserID(c)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	id, _ := strconv.Atoi(c.Params("id"))
+	var in EnrolmentUpdateInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if err := ValidateEnrolmentUpdate(in); err != nil {
+		return validationFailed(c, err)
+	}
+	cur, found, err := h.Repo.GetEnrolment(c.Context(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !found {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if cur.UserID != uid && !isHR(c) {
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	out, _, err := h.Repo.UpdateEnrolment(c.Context(), id, in)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(out)
+}
+
+// GET /reports/forms/:id/training-hours — ชั่วโมงตามแผนเทียบกับที่อบรมจบ ต่อแผนก
+func (h *Handler) trainingHoursReport(c *fiber.Ctx) error {
+	formID, _ := strconv.Atoi(c.Params("id"))
+	out, err := h.Repo.TrainingHours(c.Context(), formID, reportFilter(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	total := TrainingHoursRow{Department: "ALL"}
+	for _, t := range out {
+		total.Employees += t.Employees
+		total.PlannedItems += t.PlannedItems
+		total.PlannedHours += t.PlannedHours
+		total.CompletedItems += t.CompletedItems
+		total.CompletedHours += t.CompletedHours
+	}
+	total.PlannedHours = round2(total.PlannedHours)
+	total.CompletedHours = round2(total.CompletedHours)
+	if total.PlannedHours > 0 {
+		total.CompletionPct = round2(total.CompletedHours * 100 / total.PlannedHours)
+	}
+	return c.JSON(fiber.Map{"form_id": formID, "total": total, "data": out})
+}
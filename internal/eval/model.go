@@ -1,5 +1,7 @@
 package eval
 
+import "time"
+
 type Form struct {
 	ID            int    `json:"id"`
 	Code          string `json:"code"`
@@ -20,6 +22,7 @@ type Form struct {
 	AnnualLvStart string `json:"annual_lv_start,omitempty"`
 	AnnualLvEnd   string `json:"annual_lv_end,omitempty"`
 	Remark        string `json:"remark,omitempty"`
+	Archived      bool   `json:"archived"`
 }
 
 type CreateFormInput struct {
@@ -43,6 +46,44 @@ type CreateFormInput struct {
 	Remark        string `json:"remark"`
 }
 
+// แก้ไขฟอร์ม (ส่งเฉพาะฟิลด์ที่ต้องการเปลี่ยน)
+type UpdateFormInput struct {
+	Code          *string `json:"code,omitempty"`
+	TitleTH       *string `json:"title_th,omitempty"`
+	TitleEN       *string `json:"title_en,omitempty"`
+	KPIWeight     *int    `json:"kpi_weight,omitempty"`
+	CompWeight    *int    `json:"comp_weight,omitempty"`
+	TAWeight      *int    `json:"ta_weight,omitempty"`
+	TotalWeight   *int    `json:"total_weight,omitempty"`
+	CalcMethod    *int    `json:"calc_method,omitempty"`
+	ScoreScheme   *int    `json:"score_scheme,omitempty"`
+	KPICfgStart   *string `json:"kpi_cfg_start,omitempty"`
+	KPICfgEnd     *string `json:"kpi_cfg_end,omitempty"`
+	EvalStart     *string `json:"eval_start,omitempty"`
+	EvalEnd       *string `json:"eval_end,omitempty"`
+	OtherLvStart  *string `json:"other_lv_start,omitempty"`
+	OtherLvEnd    *string `json:"other_lv_end,omitempty"`
+	AnnualLvStart *string `json:"annual_lv_start,omitempty"`
+	AnnualLvEnd   *string `json:"annual_lv_end,omitempty"`
+	Remark        *string `json:"remark,omitempty"`
+}
+
+// คัดลอกฟอร์มไปรอบถัดไป: ช่วงวันที่ที่ไม่ส่งมาจะเลื่อนจากฟอร์มต้นทาง ShiftYears ปี (ค่าเริ่มต้น 1)
+type CloneFormInput struct {
+	Code          string `json:"code"`
+	TitleTH       string `json:"title_th"`
+	TitleEN       string `json:"title_en"`
+	ShiftYears    int    `json:"shift_years"`
+	KPICfgStart   string `json:"kpi_cfg_start"`
+	KPICfgEnd     string `json:"kpi_cfg_end"`
+	EvalStart     string `json:"eval_start"`
+	EvalEnd       string `json:"eval_end"`
+	OtherLvStart  string `json:"other_lv_start"`
+	OtherLvEnd    string `json:"other_lv_end"`
+	AnnualLvStart string `json:"annual_lv_start"`
+	AnnualLvEnd   string `json:"annual_lv_end"`
+}
+
 // การมอบหมายฟอร์มให้ผู้ใช้
 type Assign struct {
 	ID      int    `json:"id"`
@@ -62,11 +103,23 @@ type MyKPIItem struct {
 	MaxScore      float64 `json:"max_score"` // คะแนนเต็ม
 	Weight        int     `json:"weight"`    // ค่าถ่วงน้ำหนัก (%)
 	ExpectedScore float64 `json:"expected_score"`
-	Score         float64 `json:"score"`    // คะแนน
+	Score         float64 `json:"score"`    // คะแนนที่พนักงานกรอกเอง
 	Note          string  `json:"note"`     // หมายเหตุ
 	Measure       string  `json:"measure"`  // วิธีการวัด
 	Criteria      string  `json:"criteria"` // เกณฑ์การให้คะแนน
 	Unit          string  `json:"unit,omitempty"`
+	CatalogID     *int    `json:"catalog_id"`   // อ้างอิงคลัง KPI (null = พิมพ์เอง)
+	ObjectiveID   *int    `json:"objective_id"` // เป้าหมายแม่ที่ KPI นี้ส่งผลถึง
+
+	ScoringRule   *KPIScoringRule `json:"scoring_rule"`
+	ActualValue   *float64        `json:"actual_value"`
+	ScoreOverride bool            `json:"score_override"` // true = score กรอกเอง ไม่คำนวณจาก rule
+
+	LatestCheckin  *KPICheckinLatest `json:"latest_checkin,omitempty"`
+	SuggestedScore *float64          `json:"suggested_score,omitempty"` // ใช้ pre-fill score ตอนประเมิน
+
+	FinalScore *float64        `json:"final_score,omitempty"` // คะแนนสุดท้ายรวมทุกขั้น (null = ใช้ score)
+	StepScores []StepItemScore `json:"step_scores,omitempty"` // คะแนนแยกตามขั้น
 }
 
 type MyKPIInput struct {
@@ -81,6 +134,13 @@ type MyKPIInput struct {
 	Measure       string  `json:"measure"`
 	Criteria      string  `json:"criteria"`
 	Unit          string  `json:"unit"`
+	CatalogID     *int    `json:"catalog_id,omitempty"`
+	ObjectiveID   *int    `json:"objective_id,omitempty"`
+
+	// ไม่ส่ง scoring_rule = คง rule เดิม, {"method":"manual"} = ล้าง rule
+	ScoringRule   *KPIScoringRule `json:"scoring_rule,omitempty"`
+	ActualValue   *float64        `json:"actual_value,omitempty"`
+	ScoreOverride bool            `json:"score_override"`
 }
 
 type MyKPIBulkInput struct {
@@ -99,15 +159,21 @@ type CompItem struct {
 	Weight        float64 `json:"weight"`         // ค่าถ่วงน้ำหนัก (0.70 หรือ 70 ตามที่ UI ส่ง)
 	FullTotal     float64 `json:"full_total"`     // คะแนนเต็มรวม
 	ExpectedScore float64 `json:"expected_score"` // คะแนนคาดหวัง
+	DefID         *int    `json:"def_id"`         // อ้างอิงพจนานุกรมสมรรถนะ
+
+	Rubric []CompLevel `json:"rubric"` // ระดับของฟอร์ม ถ้าไม่มีใช้ของพจนานุกรม
 }
 
 type CompInput struct {
 	Idx           int     `json:"idx"`
-	Title         string  `json:"title"`
+	Title         string  `json:"title"` // ว่าง + def_id = ใช้ชื่อจากพจนานุกรม
 	MaxScore      float64 `json:"max_score"`
 	Weight        float64 `json:"weight"`
 	FullTotal     float64 `json:"full_total"`
 	ExpectedScore float64 `json:"expected_score"`
+	DefID         *int    `json:"def_id,omitempty"`
+
+	Levels []CompLevel `json:"levels,omitempty"` // ระดับเฉพาะฟอร์มนี้
 }
 
 type CompBulkInput struct {
@@ -136,19 +202,64 @@ type TAScoreInput struct {
 }
 
 type DevPlanItemInput struct {
+	ID       int    `json:"id,omitempty"` // รายการเดิม (คงสถานะ/บันทึกความคืบหน้าไว้)
 	Idx      int    `json:"idx"`
 	Content  string `json:"content"`
 	Priority string `json:"priority"` // High/Medium/Low
 	Timing   string `json:"timing"`   // yyyy-mm-dd
 	Remarks  string `json:"remarks"`
+	CourseID *int   `json:"course_id,omitempty"` // หลักสูตรจากคลังอบรม
 }
 
-type AdditionalInput struct {
-	Q1 string `json:"q1"`
-	Q2 string `json:"q2"`
-	Q3 string `json:"q3"`
-	Q4 string `json:"q4"`
-	Q5 string `json:"q5"`
+// ชนิดคำถามเพิ่มเติม
+const (
+	QuestionText         = "text"
+	QuestionLongText     = "long_text"
+	QuestionSingleChoice = "single_choice"
+	QuestionMultiChoice  = "multi_choice"
+	QuestionRating       = "rating"
+)
+
+type QuestionOption struct {
+	Value   string `json:"value"`
+	LabelTH string `json:"label_th"`
+	LabelEN string `json:"label_en,omitempty"`
+}
+
+// Question คำถามเพิ่มเติมของฟอร์ม (แทน q1–q5 แบบตายตัว)
+type Question struct {
+	ID       int              `json:"id"`
+	FormID   int              `json:"form_id"`
+	Idx      int              `json:"idx"`
+	Code     string           `json:"code"`
+	Type     string           `json:"type"`
+	LabelTH  string           `json:"label_th"`
+	LabelEN  string           `json:"label_en"`
+	Required bool             `json:"required"`
+	Options  []QuestionOption `json:"options"`             // single/multi choice
+	ScaleMin *int             `json:"scale_min,omitempty"` // rating
+	ScaleMax *int             `json:"scale_max,omitempty"`
+}
+
+type QuestionInput struct {
+	Idx      int              `json:"idx"`
+	Code     string           `json:"code"`
+	Type     string           `json:"type"`
+	LabelTH  string           `json:"label_th"`
+	LabelEN  string           `json:"label_en"`
+	Required bool             `json:"required"`
+	Options  []QuestionOption `json:"options"`
+	ScaleMin *int             `json:"scale_min"`
+	ScaleMax *int             `json:"scale_max"`
+}
+
+// AdditionalAnswer คำตอบหนึ่งข้อ: value (text/long_text/single_choice), values (multi_choice), rating
+type AdditionalAnswer struct {
+	QuestionID int      `json:"question_id"`
+	Code       string   `json:"code,omitempty"` // ส่งแทน question_id ได้
+	Value      string   `json:"value,omitempty"`
+	Values     []string `json:"values,omitempty"`
+	Rating     *float64 `json:"rating,omitempty"`
 }
 
 type SaveAllInput struct {
@@ -158,7 +269,7 @@ type SaveAllInput struct {
 	CompetencyScores []CompScoreInput   `json:"competency_scores"`
 	TimeAttendance   TAScoreInput       `json:"time_attendance"`
 	DevelopmentPlan  []DevPlanItemInput `json:"development_plan"`
-	Additional       AdditionalInput    `json:"additional"`
+	Additional       []AdditionalAnswer `json:"additional"`
 }
 
 type EvalSummary struct {
@@ -173,6 +284,11 @@ type EvalSummary struct {
 	Grade      string  `json:"grade"`     // "N/A" ถ้าไม่มี band
 	GradeMin   float64 `json:"grade_min"` // ช่วงคะแนนของเกรด (ถ้ามี)
 	GradeMax   float64 `json:"grade_max"`
+
+	ScoreScheme int     `json:"score_scheme"`
+	TotalScore  float64 `json:"total_score"` // total_pct ในสเกลของ score_scheme
+
+	CalibratedGrade string `json:"calibrated_grade,omitempty"` // เกรดหลัง calibration (เมื่อ session ล็อกแล้ว)
 }
 
 // คะแนน competency พร้อมหัวข้อ
@@ -186,27 +302,725 @@ type MyCompWithScore struct {
 	ExpectedScore float64 `json:"expected_score"`
 	Score         float64 `json:"score"`
 	Note          string  `json:"note"`
+
+	Rubric     []CompLevel     `json:"rubric"`
+	FinalScore *float64        `json:"final_score,omitempty"` // คะแนนสุดท้ายรวมทุกขั้น (null = ใช้ score)
+	StepScores []StepItemScore `json:"step_scores,omitempty"`
+}
+
+// คะแนนของหนึ่งรายการจากหนึ่งขั้น
+type StepItemScore struct {
+	StepID  int     `json:"step_id"`
+	StepIdx int     `json:"step_idx"`
+	Score   float64 `json:"score"`
+	Note    string  `json:"note,omitempty"`
+}
+
+// StepResult สรุปคะแนนของแต่ละขั้น (self / หัวหน้า / ...) สำหรับแสดงเทียบกัน
+type StepResult struct {
+	StepID        int         `json:"step_id"`
+	Idx           int         `json:"idx"`
+	EvaluatorID   int         `json:"evaluator_id"`
+	EvaluatorName string      `json:"evaluator_name"`
+	Status        int         `json:"status"`
+	Weight        *float64    `json:"weight"`
+	TAScore       float64     `json:"ta_score"`
+	Summary       EvalSummary `json:"summary"`
 }
 
 // response สำหรับโหลดทุกแท็บ
 type LoadMyFormData struct {
 	AssignmentID    int                `json:"assignment_id"`
 	Status          int                `json:"status"`
+	StatusName      string             `json:"status_name"`
 	DueDate         string             `json:"due_date,omitempty"`
 	KPIs            []MyKPIItem        `json:"kpis"`
 	Competencies    []MyCompWithScore  `json:"competencies"`
 	TimeAttendance  TAScoreInput       `json:"time_attendance"`
-	DevelopmentPlan []DevPlanItemInput `json:"development_plan"`
-	Additional      AdditionalInput    `json:"additional"`
-	Summary         EvalSummary        `json:"summary"`
+	TAFinalScore    *float64           `json:"ta_final_score,omitempty"` // คะแนน TA รวมทุกขั้น (null = ใช้ time_attendance.score)
+	DevelopmentPlan []DevPlanItem      `json:"development_plan"`
+	Questions       []Question         `json:"questions"`
+	Additional      []AdditionalAnswer `json:"additional"`
+	Summary         EvalSummary        `json:"summary"` // คะแนนสุดท้าย (รวมทุกขั้นตามน้ำหนัก)
+	Steps           []StepResult       `json:"steps"`
 }
 
 type EvalStep struct {
-	ID            int     `json:"id"`
-	AssignmentID  int     `json:"assignment_id"`
-	Idx           int     `json:"idx"`
-	EvaluatorID   int     `json:"evaluator_id"`
-	EvaluatorName string  `json:"evaluator_name"`
-	Status        int     `json:"status"`
-	EvalDate      *string `json:"eval_date"`
+	ID            int      `json:"id"`
+	AssignmentID  int      `json:"assignment_id"`
+	Idx           int      `json:"idx"`
+	EvaluatorID   int      `json:"evaluator_id"`
+	EvaluatorName string   `json:"evaluator_name"`
+	Status        int      `json:"status"`
+	EvalDate      *string  `json:"eval_date"`
+	Weight        *float64 `json:"weight"`
+}
+
+// ===== Grade bands =====
+
+// GradeBand: form_id != nil = เฉพาะฟอร์ม, company_id != nil = เฉพาะบริษัท, ทั้งคู่ nil = ค่าเริ่มต้น
+type GradeBand struct {
+	ID        int     `json:"id"`
+	FormID    *int    `json:"form_id"`
+	CompanyID *string `json:"company_id"`
+	Grade     string  `json:"grade"`
+	MinPct    float64 `json:"min_pct"`
+	MaxPct    float64 `json:"max_pct"`
+}
+
+type GradeBandInput struct {
+	Grade  string  `json:"grade"`
+	MinPct float64 `json:"min_pct"`
+	MaxPct float64 `json:"max_pct"`
+}
+
+// ชุด band หนึ่งขอบเขต (แทนที่ทั้งชุด)
+type GradeBandSetInput struct {
+	FormID    *int             `json:"form_id"`
+	CompanyID *string          `json:"company_id"`
+	Bands     []GradeBandInput `json:"bands"`
+}
+
+type GradePreview struct {
+	Pct    float64 `json:"pct"`
+	Scope  string  `json:"scope"` // form / company / global / none
+	Grade  string  `json:"grade"`
+	MinPct float64 `json:"min_pct"`
+	MaxPct float64 `json:"max_pct"`
+}
+
+// ===== Period windows =====
+
+const (
+	WindowKPICfg = "kpi_cfg" // ช่วงตั้งค่า KPI
+	WindowEval   = "eval"    // ช่วงประเมิน
+)
+
+// WindowExtension ขยายวันสิ้นสุดของช่วงเวลาให้ assignment เดียว
+type WindowExtension struct {
+	ID            int    `json:"id"`
+	AssignmentID  int    `json:"assignment_id"`
+	Window        string `json:"window"`
+	ExtendedUntil string `json:"extended_until"`
+	Reason        string `json:"reason,omitempty"`
+	GrantedBy     int    `json:"granted_by,omitempty"`
+}
+
+type WindowExtensionInput struct {
+	Window        string `json:"window"`
+	ExtendedUntil string `json:"extended_until"` // yyyy-mm-dd
+	Reason        string `json:"reason"`
+}
+
+// ===== Evaluator inbox =====
+
+// InboxItem งานที่รอฉันประเมิน (ฉันถือ step ที่ active)
+type InboxItem struct {
+	AssignmentID   int    `json:"assignment_id"`
+	FormID         int    `json:"form_id"`
+	FormCode       string `json:"form_code"`
+	FormTitle      string `json:"form_title"`
+	EmployeeID     int    `json:"employee_id"`
+	EmployeeName   string `json:"employee_name"`
+	PersonCode     string `json:"person_code,omitempty"`
+	StepID         int    `json:"step_id"`
+	StepIdx        int    `json:"step_idx"`
+	DueDate        string `json:"due_date,omitempty"`
+	EvalEnd        string `json:"eval_end,omitempty"`
+	AssignmentStat int    `json:"assignment_status"`
+}
+
+type ItemScoreInput struct {
+	ID    int     `json:"id"` // kpi id (eval_kpi_user) หรือ comp id (eval_competency)
+	Score float64 `json:"score"`
+	Note  string  `json:"note"`
+
+	// KPI เท่านั้น: มี actual_value + scoring_rule และไม่ override → score คำนวณอัตโนมัติ
+	ActualValue   *float64 `json:"actual_value,omitempty"`
+	ScoreOverride bool     `json:"score_override,omitempty"`
+}
+
+// EvaluatorSaveInput คะแนนของผู้ประเมินใน step ของตัวเอง (ไม่แก้หัวข้อ KPI)
+type EvaluatorSaveInput struct {
+	Status           int              `json:"status"` // 0=draft, 2=submit (ปิด step แล้วส่งต่อ)
+	KPIScores        []ItemScoreInput `json:"kpi_scores"`
+	CompetencyScores []ItemScoreInput `json:"competency_scores"`
+	TAScore          *float64         `json:"ta_score"`
+}
+
+// ===== Workflow =====
+
+// TransitionLog หนึ่งเหตุการณ์ใน workflow (ใคร ทำอะไร เมื่อไร)
+type TransitionLog struct {
+	ID           int       `json:"id"`
+	AssignmentID int       `json:"assignment_id"`
+	StepID       *int      `json:"step_id,omitempty"`
+	Action       string    `json:"action"`
+	FromStatus   int       `json:"from_status"`
+	ToStatus     int       `json:"to_status"`
+	From         string    `json:"from"`
+	To           string    `json:"to"`
+	ActorID      *int      `json:"actor_id,omitempty"`
+	ActorName    string    `json:"actor_name,omitempty"`
+	Comment      string    `json:"comment,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type TransitionInput struct {
+	Comment string `json:"comment"`
+}
+
+// ===== Bulk assignment =====
+
+// BulkAssignInput เลือกผู้ใช้: (บริษัท AND แผนก AND ตำแหน่ง) หรืออยู่ใน user_ids
+type BulkAssignInput struct {
+	CompanyIDs  []string  `json:"company_ids"`
+	Departments []string  `json:"departments"`
+	Positions   []string  `json:"positions"`
+	UserIDs     []int     `json:"user_ids"`
+	DueDate     string    `json:"due_date"`     // yyyy-mm-dd (ไม่ส่ง = วันนี้)
+	Levels      int       `json:"levels"`       // จำนวนชั้นหัวหน้าใน chain (ไม่รวม self)
+	StepWeights []float64 `json:"step_weights"` // น้ำหนักรายขั้น (self แรก) ใช้เมื่อ chain ครบ levels
+}
+
+const (
+	BulkCreated    = "created"
+	BulkStepsAdded = "steps_added" // assignment เดิมที่ยังไม่มี chain
+	BulkExists     = "exists"
+	BulkFailed     = "failed"
+)
+
+type BulkAssignResult struct {
+	UserID       int    `json:"user_id"`
+	Name         string `json:"name"`
+	Result       string `json:"result"` // created / steps_added / exists / failed
+	AssignmentID int    `json:"assignment_id,omitempty"`
+	Steps        int    `json:"steps,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Warning      string `json:"warning,omitempty"` // สร้างแล้วแต่ไม่ได้ตามที่ขอทั้งหมด (เช่น ไม่ได้ใช้ step_weights)
+}
+
+// ===== Calibration =====
+
+const (
+	CalibrationOpen   = 0
+	CalibrationLocked = 1
+)
+
+type CalibrationTarget struct {
+	Grade     string  `json:"grade"`
+	TargetPct float64 `json:"target_pct"`
+}
+
+type Calibration struct {
+	ID        int                 `json:"id"`
+	FormID    int                 `json:"form_id"`
+	Name      string              `json:"name"`
+	Status    int                 `json:"status"`
+	Locked    bool                `json:"locked"`
+	LockedAt  *time.Time          `json:"locked_at,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+	Targets   []CalibrationTarget `json:"targets"`
+}
+
+type CalibrationInput struct {
+	FormID  int                 `json:"form_id"`
+	Name    string              `json:"name"`
+	Targets []CalibrationTarget `json:"targets"`
+}
+
+type CalibrationItem struct {
+	AssignmentID int     `json:"assignment_id"`
+	UserID       int     `json:"user_id"`
+	Name         string  `json:"name"`
+	Department   string  `json:"department,omitempty"`
+	CalcPct      float64 `json:"calc_pct"`
+	CalcGrade    string  `json:"calc_grade"`
+	FinalGrade   string  `json:"final_grade"` // calibrated หรือ calc_grade ถ้าไม่ได้ปรับ
+	Overridden   bool    `json:"overridden"`
+	Reason       string  `json:"reason,omitempty"`
+}
+
+// GradeDistribution สัดส่วนเกรดปัจจุบันเทียบเป้า
+type GradeDistribution struct {
+	Grade     string  `json:"grade"`
+	Count     int     `json:"count"`
+	Pct       float64 `json:"pct"`
+	TargetPct float64 `json:"target_pct"`
+	DiffPct   float64 `json:"diff_pct"` // pct - target_pct
+}
+
+type CalibrationDetail struct {
+	Calibration
+	Items        []CalibrationItem   `json:"items"`
+	Distribution []GradeDistribution `json:"distribution"`
+}
+
+type CalibrationOverrideInput struct {
+	Grade  string `json:"grade"` // "" = ยกเลิกการปรับ
+	Reason string `json:"reason"`
+}
+
+// ===== HR reports =====
+
+// ReportFilter ตัวกรองร่วมของรายงาน (ค่าว่าง = ไม่กรอง)
+type ReportFilter struct {
+	CompanyID  string
+	Department string
+	Position   string
+	Status     string // เฉพาะ analytics: "" = submitted, "approved", "all" (รวม draft)
+}
+
+// ReportFilter.Status: สถานะขั้นต่ำของ assignment ที่นับในคะแนน/เกรด
+const (
+	ReportStatusSubmitted = "submitted" // ส่งแล้วขึ้นไป (ค่าเริ่มต้น)
+	ReportStatusApproved  = "approved"  // อนุมัติแล้วขึ้นไป
+	ReportStatusAll       = "all"       // รวม draft ที่ยังไม่ได้ให้คะแนน
+)
+
+type StatusCount struct {
+	Status int    `json:"status"`
+	Name   string `json:"name"`
+	Count  int    `json:"count"`
+}
+
+// StepCount จำนวน assignment ที่ค้างอยู่ที่ขั้น step_idx (0 = ไม่มีขั้นที่ active)
+type StepCount struct {
+	StepIdx int `json:"step_idx"`
+	Count   int `json:"count"`
+}
+
+type ProgressReport struct {
+	FormID   int           `json:"form_id"`
+	AsOf     string        `json:"as_of"`
+	Total    int           `json:"total"`
+	Overdue  int           `json:"overdue"`
+	ByStatus []StatusCount `json:"by_status"`
+	ByStep   []StepCount   `json:"by_step"`
+}
+
+// BreakdownRow ตัวเลขต่อแผนก / บริษัท
+type BreakdownRow struct {
+	Key          string `json:"key"`
+	Name         string `json:"name"`
+	Total        int    `json:"total"`
+	Draft        int    `json:"draft"`
+	Submitted    int    `json:"submitted"`
+	InReview     int    `json:"in_review"`
+	Approved     int    `json:"approved"`
+	Acknowledged int    `json:"acknowledged"`
+	Closed       int    `json:"closed"`
+	Overdue      int    `json:"overdue"`
+}
+
+type OverdueItem struct {
+	AssignmentID  int    `json:"assignment_id"`
+	UserID        int    `json:"user_id"`
+	Name          string `json:"name"`
+	Department    string `json:"department,omitempty"`
+	DueDate       string `json:"due_date"`
+	DaysOverdue   int    `json:"days_overdue"`
+	Status        int    `json:"status"`
+	StatusName    string `json:"status_name"`
+	StepIdx       int    `json:"step_idx,omitempty"`
+	EvaluatorName string `json:"evaluator_name,omitempty"`
+}
+
+// ===== PDF export =====
+
+type PrintEmployee struct {
+	ID         int    `json:"id"`
+	PersonCode string `json:"person_code"`
+	Name       string `json:"name"`
+	Position   string `json:"position"`
+	Department string `json:"department"`
+	StartDate  string `json:"start_date"`
+}
+
+// PrintData ข้อมูลทั้งหมดของเอกสารประเมินที่พิมพ์/เซ็นได้
+type PrintData struct {
+	Form     Form
+	Employee PrintEmployee
+	Data     LoadMyFormData
+	Steps    []EvalStep
+}
+
+// ===== KPI library / templates =====
+
+// KPICatalogItem รายการในคลัง KPI (company_id / department null = ใช้ได้ทุกที่)
+type KPICatalogItem struct {
+	ID              int     `json:"id"`
+	CompanyID       *string `json:"company_id"`
+	Department      *string `json:"department"`
+	Code            string  `json:"code"`
+	Title           string  `json:"title"`
+	Measure         string  `json:"measure"`
+	Criteria        string  `json:"criteria"`
+	Unit            string  `json:"unit"`
+	DefaultMaxScore float64 `json:"default_max_score"`
+	Active          bool    `json:"active"`
+}
+
+type KPICatalogInput struct {
+	CompanyID       *string `json:"company_id"`
+	Department      *string `json:"department"`
+	Code            string  `json:"code"`
+	Title           string  `json:"title"`
+	Measure         string  `json:"measure"`
+	Criteria        string  `json:"criteria"`
+	Unit            string  `json:"unit"`
+	DefaultMaxScore float64 `json:"default_max_score"`
+}
+
+type KPICatalogFilter struct {
+	CompanyID       string
+	Department      string
+	Q               string // ค้นใน code / title
+	IncludeInactive bool
+}
+
+type KPITemplateItem struct {
+	ID            int      `json:"id"`
+	CatalogID     int      `json:"catalog_id"`
+	Idx           int      `json:"idx"`
+	Weight        int      `json:"weight"`
+	MaxScore      *float64 `json:"max_score"` // null = default_max_score ของคลัง
+	ExpectedScore *float64 `json:"expected_score"`
+	Code          string   `json:"code"`
+	Title         string   `json:"title"`
+	Active        bool     `json:"active"` // รายการคลังที่ปิดใช้งานจะไม่ถูกนำไปใช้
+}
+
+type KPITemplate struct {
+	ID        int               `json:"id"`
+	CompanyID *string           `json:"company_id"`
+	Position  string            `json:"position"`
+	Name      string            `json:"name"`
+	Items     []KPITemplateItem `json:"items,omitempty"`
+}
+
+type KPITemplateItemInput struct {
+	CatalogID     int      `json:"catalog_id"`
+	Weight        int      `json:"weight"`
+	MaxScore      *float64 `json:"max_score"`
+	ExpectedScore *float64 `json:"expected_score"`
+}
+
+type KPITemplateInput struct {
+	CompanyID *string                `json:"company_id"`
+	Position  string                 `json:"position"`
+	Name      string                 `json:"name"`
+	Items     []KPITemplateItemInput `json:"items"`
+}
+
+// ApplyTemplateInput: template_id = 0 → เลือกตามตำแหน่งของพนักงาน; mode = replace (ค่าเริ่มต้น) | append
+type ApplyTemplateInput struct {
+	TemplateID int    `json:"template_id"`
+	Mode       string `json:"mode"`
+}
+
+const (
+	ApplyReplace = "replace"
+	ApplyAppend  = "append"
+)
+
+// KPICatalogUsage การใช้รายการคลังในฟอร์ม (สำหรับรายงาน)
+type KPICatalogUsage struct {
+	CatalogID   int     `json:"catalog_id"`
+	Code        string  `json:"code"`
+	Title       string  `json:"title"`
+	Assignments int     `json:"assignments"`
+	AvgWeight   float64 `json:"avg_weight"`
+	AvgScorePct float64 `json:"avg_score_pct"`
+}
+
+// ===== Goal cascading =====
+
+const (
+	ObjectiveCompany    = "company"
+	ObjectiveDepartment = "department"
+	ObjectiveTeam       = "team"
+)
+
+// Objective เป้าหมายระดับบริษัท / แผนก / ทีม ในรอบประเมิน
+type Objective struct {
+	ID          int     `json:"id"`
+	FormID      int     `json:"form_id"`
+	ParentID    *int    `json:"parent_id"`
+	Level       string  `json:"level"`
+	CompanyID   *string `json:"company_id"`
+	Department  *string `json:"department"`
+	Code        string  `json:"code"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	OwnerUserID *int    `json:"owner_user_id"`
+	Weight      int     `json:"weight"` // สัดส่วนต่อเป้าหมายแม่
+	Idx         int     `json:"idx"`
+}
+
+type ObjectiveInput struct {
+	ParentID    *int    `json:"parent_id"`
+	Level       string  `json:"level"`
+	CompanyID   *string `json:"company_id"`
+	Department  *string `json:"department"`
+	Code        string  `json:"code"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	OwnerUserID *int    `json:"owner_user_id"`
+	Weight      *int    `json:"weight"` // null = 100
+	Idx         int     `json:"idx"`
+}
+
+// LinkObjectiveInput: objective_id null = ยกเลิกการผูก
+type LinkObjectiveInput struct {
+	ObjectiveID *int `json:"objective_id"`
+}
+
+// ObjectiveNode โหนดใน cascade; score_pct / progress_pct = ค่าเฉลี่ยถ่วงน้ำหนักของลูก (null = ยังไม่มีข้อมูล)
+type ObjectiveNode struct {
+	Objective
+	ScorePct        *float64        `json:"score_pct"`
+	ProgressPct     *float64        `json:"progress_pct"`     // จาก check-in ล่าสุดของ KPI
+	ContributionPct float64         `json:"contribution_pct"` // สัดส่วนต่อเป้าหมายแม่
+	Children        []ObjectiveNode `json:"children"`
+	KPIs            []ObjectiveKPI  `json:"kpis"`
+}
+
+// ObjectiveKPI KPI ของพนักงานที่ผูกกับเป้าหมาย (น้ำหนักใช้ weight ของ KPI)
+type ObjectiveKPI struct {
+	KPIID           int      `json:"kpi_id"`
+	AssignmentID    int      `json:"assignment_id"`
+	UserID          int      `json:"user_id"`
+	UserName        string   `json:"user_name"`
+	Code            string   `json:"code"`
+	Title           string   `json:"title"`
+	Weight          int      `json:"weight"`
+	ScorePct        *float64 `json:"score_pct"`
+	ProgressPct     *float64 `json:"progress_pct"`
+	ContributionPct float64  `json:"contribution_pct"`
+}
+
+// ===== KPI check-ins =====
+
+const (
+	CheckinByEmployee = "employee"
+	CheckinByManager  = "manager" // ผู้ประเมินหรือ HR
+)
+
+type KPICheckin struct {
+	ID              int       `json:"id"`
+	AssignmentID    int       `json:"assignment_id"`
+	KPIID           int       `json:"kpi_id"`
+	KPICode         string    `json:"kpi_code"`
+	KPITitle        string    `json:"kpi_title"`
+	CheckinDate     string    `json:"checkin_date"`
+	ActualValue     *float64  `json:"actual_value"`
+	PercentComplete *float64  `json:"percent_complete"`
+	Comment         string    `json:"comment"`
+	AuthorID        *int      `json:"author_id"`
+	AuthorName      string    `json:"author_name"`
+	AuthorRole      string    `json:"author_role"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// KPICheckinInput: checkin_date ว่าง = วันนี้
+type KPICheckinInput struct {
+	CheckinDate     string   `json:"checkin_date"`
+	ActualValue     *float64 `json:"actual_value"`
+	PercentComplete *float64 `json:"percent_complete"`
+	Comment         string   `json:"comment"`
+}
+
+// KPICheckinLatest check-in ล่าสุดของ KPI (แนบไปกับ MyKPIItem)
+type KPICheckinLatest struct {
+	CheckinDate     string   `json:"checkin_date"`
+	ActualValue     *float64 `json:"actual_value"`
+	PercentComplete *float64 `json:"percent_complete"`
+}
+
+// ===== Competency rubric =====
+
+// CompLevel ความหมายของคะแนนหนึ่งระดับ พร้อมพฤติกรรมบ่งชี้
+type CompLevel struct {
+	Score      float64  `json:"score"`
+	Label      string   `json:"label"`
+	Indicators []string `json:"indicators"`
+}
+
+// CompetencyDef รายการในพจนานุกรมสมรรถนะ
+type CompetencyDef struct {
+	ID          int         `json:"id"`
+	Code        string      `json:"code"`
+	Title       string      `json:"title"`
+	TitleEN     string      `json:"title_en"`
+	Description string      `json:"description"`
+	Active      bool        `json:"active"`
+	Levels      []CompLevel `json:"levels"`
+}
+
+type CompetencyDefInput struct {
+	Code        string      `json:"code"`
+	Title       string      `json:"title"`
+	TitleEN     string      `json:"title_en"`
+	Description string      `json:"description"`
+	Levels      []CompLevel `json:"levels"`
+}
+
+type CompLevelsInput struct {
+	Levels []CompLevel `json:"levels"` // ว่าง = กลับไปใช้ของพจนานุกรม
+}
+
+// ===== Development plan tracking =====
+
+// สถานะรายการแผนพัฒนา
+const (
+	DevPlanPlanned    = "planned"
+	DevPlanInProgress = "in_progress"
+	DevPlanDone       = "done"
+	DevPlanCancelled  = "cancelled"
+)
+
+// devPlanOpen ยังไม่จบ → ยกไปรอบถัดไปได้
+func devPlanOpen(status string) bool {
+	return status == DevPlanPlanned || status == DevPlanInProgress
+}
+
+type DevPlanItem struct {
+	ID            int           `json:"id"`
+	AssignmentID  int           `json:"assignment_id"`
+	Idx           int           `json:"idx"`
+	Content       string        `json:"content"`
+	Priority      string        `json:"priority"`
+	Timing        string        `json:"timing"`
+	Remarks       string        `json:"remarks"`
+	Status        string        `json:"status"`
+	CompletedOn   string        `json:"completed_on,omitempty"`
+	SignedOffBy   *int          `json:"signed_off_by"`
+	SignedOffName string        `json:"signed_off_name,omitempty"`
+	SignedOffAt   *time.Time    `json:"signed_off_at"`
+	CarriedFromID *int          `json:"carried_from_id"` // รายการของรอบก่อนที่ยกมา
+	CourseID      *int          `json:"course_id"`
+	CourseTitle   string        `json:"course_title,omitempty"`
+	CourseHours   *float64      `json:"course_hours,omitempty"`
+	Notes         []DevPlanNote `json:"notes,omitempty"`
+}
+
+type DevPlanNote struct {
+	ID         int       `json:"id"`
+	PlanID     int       `json:"plan_id"`
+	Note       string    `json:"note"`
+	AuthorID   *int      `json:"author_id"`
+	AuthorName string    `json:"author_name"`
+	AuthorRole string    `json:"author_role"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DevPlanStatusInput เปลี่ยนสถานะ; done ไม่ส่ง completed_on = วันนี้
+type DevPlanStatusInput struct {
+	Status      string `json:"status"`
+	CompletedOn string `json:"completed_on"`
+	Note        string `json:"note"` // บันทึกความคืบหน้าพร้อมกัน (ไม่บังคับ)
+}
+
+type DevPlanNoteInput struct {
+	Note string `json:"note"`
+}
+
+// ===== Training catalogue =====
+
+// สถานะการลงทะเบียนอบรม
+const (
+	EnrolEnrolled   = "enrolled"
+	EnrolInProgress = "in_progress"
+	EnrolCompleted  = "completed"
+	EnrolCancelled  = "cancelled"
+)
+
+type Course struct {
+	ID           int                `json:"id"`
+	Code         string             `json:"code"`
+	Title        string             `json:"title"`
+	Provider     string             `json:"provider"`
+	Hours        float64            `json:"hours"`
+	Description  string             `json:"description"`
+	Active       bool               `json:"active"`
+	Competencies []CourseCompetency `json:"competencies"` // tag จากพจนานุกรมสมรรถนะ
+}
+
+type CourseCompetency struct {
+	DefID int    `json:"def_id"`
+	Code  string `json:"code"`
+	Title string `json:"title"`
+}
+
+type CourseInput struct {
+	Code          string  `json:"code"`
+	Title         string  `json:"title"`
+	Provider      string  `json:"provider"`
+	Hours         float64 `json:"hours"`
+	Description   string  `json:"description"`
+	CompetencyIDs []int   `json:"competency_ids"`
+}
+
+type CourseFilter struct {
+	Q               string
+	CompetencyID    int
+	IncludeInactive bool
+}
+
+// Certificate ข้อมูลใบประกาศ (ไม่เก็บไฟล์ เก็บเลขที่/ลิงก์)
+type Certificate struct {
+	Number    string `json:"number"`
+	Issuer    string `json:"issuer"`
+	IssuedOn  string `json:"issued_on"`
+	ExpiresOn string `json:"expires_on,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type Enrolment struct {
+	ID          int          `json:"id"`
+	CourseID    int          `json:"course_id"`
+	CourseCode  string       `json:"course_code"`
+	CourseTitle string       `json:"course_title"`
+	Hours       float64      `json:"hours"`
+	UserID      int          `json:"user_id"`
+	UserName    string       `json:"user_name"`
+	Status      string       `json:"status"`
+	EnrolledOn  string       `json:"enrolled_on"`
+	CompletedOn string       `json:"completed_on,omitempty"`
+	Certificate *Certificate `json:"certificate"`
+	DevPlanID   *int         `json:"dev_plan_id"` // รายการแผนพัฒนาที่มาของการอบรม
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// EnrolmentInput user_id ไม่ส่ง = ตัวเอง (HR ลงให้คนอื่นได้)
+type EnrolmentInput struct {
+	CourseID   int    `json:"course_id"`
+	UserID     int    `json:"user_id"`
+	EnrolledOn string `json:"enrolled_on"`
+	DevPlanID  *int   `json:"dev_plan_id"`
+}
+
+// EnrolmentUpdateInput completed ไม่ส่ง completed_on = วันนี้
+type EnrolmentUpdateInput struct {
+	Status      string       `json:"status"`
+	CompletedOn string       `json:"completed_on"`
+	Certificate *Certificate `json:"certificate"`
+}
+
+type EnrolmentFilter struct {
+	UserID   int
+	CourseID int
+	Status   string
+}
+
+// TrainingHoursRow ชั่วโมงอบรมตามแผนเทียบกับที่อบรมจบแล้ว (ต่อแผนก)
+type TrainingHoursRow struct {
+	Department     string  `json:"department"`
+	Employees      int     `json:"employees"`
+	PlannedItems   int     `json:"planned_items"`
+	PlannedHours   float64 `json:"planned_hours"`
+	CompletedItems int     `json:"completed_items"`
+	CompletedHours float64 `json:"completed_hours"`
+	CompletionPct  float64 `json:"completion_pct"`
 }
@@ -0,0 +1,333 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"go-sqlserver-demo/internal/pdf"
+)
+
+// ===== PDF export (เอกสารประเมินสำหรับพิมพ์/เซ็น) =====
+
+const (
+	printMargin   = 40.0
+	printBodySize = 11.0
+	printHeadSize = 14.0
+	printCellPad  = 4.0
+)
+
+type printCol struct {
+	Title string
+	Width float64
+	Right bool // ชิดขวา (ตัวเลข)
+}
+
+// printer เขียนต่อลงมาทีละส่วน ขึ้นหน้าใหม่อัตโนมัติ
+type printer struct {
+	d *pdf.Document
+	y float64
+}
+
+func (p *printer) lineHeight() float64 { return p.d.FontSize() * 1.4 }
+
+func (p *printer) bottom() float64 { return p.d.H - printMargin - 16 } // เว้นที่เลขหน้า
+
+func (p *printer) width() float64 { return p.d.W - 2*printMargin }
+
+func (p *printer) newPage() {
+	p.d.AddPage()
+	p.y = printMargin
+}
+
+// ensure ขึ้นหน้าใหม่ถ้าพื้นที่เหลือไม่พอ h
+func (p *printer) ensure(h float64) bool {
+	if p.y+h > p.bottom() {
+		p.newPage()
+		return true
+	}
+	return false
+}
+
+func (p *printer) heading(s string) {
+	p.d.SetFontSize(printHeadSize)
+	p.ensure(p.lineHeight() * 3) // ไม่ให้หัวข้อค้างท้ายหน้า
+	p.y += p.lineHeight()
+	p.d.Text(printMargin, p.y, s)
+	p.y += 6
+	p.d.SetFontSize(printBodySize)
+}
+
+// paragraph ข้อความยาว ตัดบรรทัดตามความกว้าง
+func (p *printer) paragraph(x float64, s string) {
+	for _, ln := range p.d.Wrap(s, p.width()-(x-printMargin)) {
+		p.ensure(p.lineHeight())
+		p.y += p.lineHeight()
+		p.d.Text(x, p.y, ln)
+	}
+}
+
+// fields คู่ label: value สองคอลัมน์
+func (p *printer) fields(pairs [][2]string) {
+	half := p.width() / 2
+	for i := 0; i < len(pairs); i += 2 {
+		p.ensure(p.lineHeight())
+		p.y += p.lineHeight()
+		for j := 0; j < 2 && i+j < len(pairs); j++ {
+			p.d.Text(printMargin+float64(j)*half, p.y, pairs[i+j][0]+": "+pairs[i+j][1])
+		}
+	}
+}
+
+// table ตารางมีกรอบ หัวตารางพิมพ์ซ้ำเมื่อขึ้นหน้าใหม่
+func (p *printer) table(cols []printCol, rows [][]string) {
+	p.y += 6
+	header := make([]string, len(cols))
+	for i, c := range cols {
+		header[i] = c.Title
+	}
+	p.row(cols, header, 0.9, true)
+	for _, r := range rows {
+		if !p.row(cols, r, -1, false) {
+			p.row(cols, header, 0.9, true)
+			p.row(cols, r, -1, true) // แถวสูงเกินหน้าก็วาดไปเลย
+		}
+	}
+}
+
+// row คืน false ถ้าพื้นที่ไม่พอ (ขึ้นหน้าใหม่แล้ว แต่ยังไม่ได้วาด); force = วาดเสมอ
+func (p *printer) row(cols []printCol, cells []string, gray float64, force bool) bool {
+	lh := p.lineHeight()
+	wrapped := make([][]string, len(cols))
+	lines := 1
+	for i, c := range cols {
+		wrapped[i] = p.d.Wrap(cells[i], c.Width-2*printCellPad)
+		lines = max(lines, len(wrapped[i]))
+	}
+	h := float64(lines)*lh + 2*printCellPad
+	if !force && p.y+h > p.bottom() {
+		p.newPage()
+		return false
+	}
+	x := printMargin
+	for i, c := range cols {
+		p.d.Rect(x, p.y, c.Width, h, gray)
+		for j, ln := range wrapped[i] {
+			baseline := p.y + printCellPad + float64(j+1)*lh - lh*0.3
+			if c.Right {
+				p.d.TextRight(x+c.Width-printCellPad, baseline, ln)
+			} else {
+				p.d.Text(x+printCellPad, baseline, ln)
+			}
+		}
+		x += c.Width
+	}
+	p.y += h
+	return true
+}
+
+func fmtNum(v float64) string { return strconv.FormatFloat(round2(v), 'f', -1, 64) }
+
+// finalOr คะแนนสุดท้ายรวมทุกขั้น (ไม่มี = คะแนนที่พนักงานกรอก)
+func finalOr(final *float64, score float64) float64 {
+	if final != nil {
+		return *final
+	}
+	return score
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// RenderPDF เขียนเอกสารประเมินของ assignment ลง w
+func RenderPDF(w io.Writer, font *pdf.Font, pd PrintData) error {
+	d := pdf.New(font)
+	p := &printer{d: d}
+	p.newPage()
+	data := pd.Data
+
+	// หัวเอกสาร
+	d.SetFontSize(16)
+	p.y += p.lineHeight()
+	d.Text(printMargin, p.y, "แบบประเมินผลการปฏิบัติงาน")
+	d.SetFontSize(printBodySize)
+	p.y += p.lineHeight()
+	d.Text(printMargin, p.y, fmt.Sprintf("%s (%s)", pd.Form.TitleTH, pd.Form.Code))
+	d.TextRight(d.W-printMargin, p.y, "พิมพ์เมื่อ "+today())
+	p.y += 4
+	d.Line(printMargin, p.y, d.W-printMargin, p.y)
+
+	e := pd.Employee
+	p.fields([][2]string{
+		{"ชื่อ-สกุล", e.Name},
+		{"รหัสพนักงาน", orDash(e.PersonCode)},
+		{"ตำแหน่ง", orDash(e.Position)},
+		{"แผนก", orDash(e.Department)},
+		{"วันเริ่มงาน", orDash(e.StartDate)},
+		{"ช่วงประเมิน", orDash(pd.Form.EvalStart) + " ถึง " + orDash(pd.Form.EvalEnd)},
+		{"สถานะ", data.StatusName},
+		{"กำหนดส่ง", orDash(data.DueDate)},
+	})
+
+	// KPI
+	p.heading(fmt.Sprintf("1. ผลงานตามตัวชี้วัด (KPI) — น้ำหนัก %d%%", data.Summary.KPIWeight))
+	kpiRows := make([][]string, 0, len(data.KPIs))
+	for i, k := range data.KPIs {
+		title := k.Title
+		if k.Code != "" {
+			title = k.Code + " " + title
+		}
+		if k.Note != "" {
+			title += "\nหมายเหตุ: " + k.Note
+		}
+		kpiRows = append(kpiRows, []string{strconv.Itoa(i + 1), title, strconv.Itoa(k.Weight),
+			fmtNum(k.MaxScore), fmtNum(k.ExpectedScore), fmtNum(finalOr(k.FinalScore, k.Score))})
+	}
+	p.table([]printCol{
+		{Title: "ลำดับ", Width: 30, Right: true}, {Title: "หัวข้อ", Width: 265},
+		{Title: "น้ำหนัก (%)", Width: 55, Right: true}, {Title: "คะแนนเต็ม", Width: 55, Right: true},
+		{Title: "คาดหวัง", Width: 55, Right: true}, {Title: "คะแนน", Width: 55, Right: true},
+	}, kpiRows)
+
+	// Competency
+	p.heading(fmt.Sprintf("2. สมรรถนะ (Competency) — น้ำหนัก %d%%", data.Summary.CompWeight))
+	compRows := make([][]string, 0, len(data.Competencies))
+	for i, c := range data.Competencies {
+		title := c.Title
+		if c.Note != "" {
+			title += "\nหมายเหตุ: " + c.Note
+		}
+		compRows = append(compRows, []string{strconv.Itoa(i + 1), title, fmtNum(compWeightFraction(c.Weight) * 100),
+			fmtNum(c.MaxScore), fmtNum(c.ExpectedScore), fmtNum(finalOr(c.FinalScore, c.Score))})
+	}
+	p.table([]printCol{
+		{Title: "ลำดับ", Width: 30, Right: true}, {Title: "หัวข้อ", Width: 265},
+		{Title: "น้ำหนัก (%)", Width: 55, Right: true}, {Title: "คะแนนเต็ม", Width: 55, Right: true},
+		{Title: "คาดหวัง", Width: 55, Right: true}, {Title: "คะแนน", Width: 55, Right: true},
+	}, compRows)
+
+	// Time attendance
+	p.heading(fmt.Sprintf("3. การมาทำงาน (Time Attendance) — น้ำหนัก %d%%", data.Summary.TAWeight))
+	p.paragraph(printMargin, fmt.Sprintf("คะแนน %s จากคะแนนเต็ม %s",
+		fmtNum(finalOr(data.TAFinalScore, data.TimeAttendance.Score)), fmtNum(data.TimeAttendance.FullScore)))
+
+	// Development plan
+	p.heading("4. แผนพัฒนารายบุคคล")
+	devRows := make([][]string, 0, len(data.DevelopmentPlan))
+	for i, dp := range data.DevelopmentPlan {
+		devRows = append(devRows, []string{strconv.Itoa(i + 1), dp.Content, orDash(dp.Priority), orDash(dp.Timing), devPlanStatusTH[dp.Status], dp.Remarks})
+	}
+	p.table([]printCol{
+		{Title: "ลำดับ", Width: 30, Right: true}, {Title: "เรื่องที่ต้องพัฒนา", Width: 185},
+		{Title: "ความสำคัญ", Width: 60}, {Title: "กำหนดเสร็จ", Width: 65}, {Title: "สถานะ", Width: 65}, {Title: "หมายเหตุ", Width: 110},
+	}, devRows)
+
+	// Additional
+	p.heading("5. ข้อมูลเพิ่มเติม")
+	answers := make(map[int]AdditionalAnswer, len(data.Additional))
+	for _, a := range data.Additional {
+		answers[a.QuestionID] = a
+	}
+	for i, q := range data.Questions {
+		p.paragraph(printMargin, fmt.Sprintf("%d. %s", i+1, q.LabelTH))
+		p.paragraph(printMargin+15, orDash(answerText(q, answers[q.ID])))
+	}
+
+	// Summary
+	s := data.Summary
+	p.heading("6. สรุปผลการประเมิน")
+	p.table([]printCol{
+		{Title: "ส่วน", Width: 275}, {Title: "น้ำหนัก (%)", Width: 120, Right: true}, {Title: "คะแนนที่ได้ (%)", Width: 120, Right: true},
+	}, [][]string{
+		{"ผลงานตามตัวชี้วัด (KPI)", strconv.Itoa(s.KPIWeight), fmtNum(s.KPIPct)},
+		{"สมรรถนะ (Competency)", strconv.Itoa(s.CompWeight), fmtNum(s.CompPct)},
+		{"การมาทำงาน (Time Attendance)", strconv.Itoa(s.TAWeight), fmtNum(s.TAPct)},
+		{"รวม", strconv.Itoa(s.KPIWeight + s.CompWeight + s.TAWeight), fmtNum(s.TotalPct)},
+	})
+	grade := "เกรด: " + s.Grade
+	if s.CalibratedGrade != "" && s.CalibratedGrade != s.Grade {
+		grade = fmt.Sprintf("เกรด: %s (ผ่านการ calibration, เดิม %s)", s.CalibratedGrade, s.Grade)
+	}
+	p.paragraph(printMargin, grade)
+
+	// ลายเซ็นของแต่ละขั้น สองช่องต่อแถว
+	p.heading("7. ลงนามผู้ประเมิน")
+	boxH := 6 * p.lineHeight()
+	half := p.width() / 2
+	for i, st := range pd.Steps {
+		col := i % 2
+		if col == 0 {
+			p.ensure(boxH)
+		}
+		x := printMargin + float64(col)*half
+		lh := p.lineHeight()
+		date := "...................."
+		if st.EvalDate != nil && *st.EvalDate != "" {
+			date = *st.EvalDate
+		}
+		d.Text(x, p.y+lh, fmt.Sprintf("ขั้นที่ %d", st.Idx))
+		d.Text(x, p.y+3*lh, "ลงชื่อ ......................................")
+		d.Text(x+25, p.y+4*lh, "("+st.EvaluatorName+")")
+		d.Text(x, p.y+5*lh, "วันที่ "+date)
+		if col == 1 || i == len(pd.Steps)-1 {
+			p.y += boxH
+		}
+	}
+
+	// เลขหน้า
+	n := d.PageCount()
+	for i := 0; i < n; i++ {
+		d.SetPage(i)
+		d.SetFontSize(9)
+		d.TextRight(d.W-printMargin, d.H-printMargin+4, fmt.Sprintf("หน้า %d/%d", i+1, n))
+	}
+
+	_, err := d.WriteTo(w)
+	return err
+}
+
+var devPlanStatusTH = map[string]string{
+	DevPlanPlanned:    "วางแผน",
+	DevPlanInProgress: "กำลังดำเนินการ",
+	DevPlanDone:       "เสร็จแล้ว",
+	DevPlanCancelled:  "ยกเลิก",
+}
+
+// answerText แปลงคำตอบเป็นข้อความ (ตัวเลือกแสดงเป็น label)
+func answerText(q Question, a AdditionalAnswer) string {
+	label := func(v string) string {
+		for _, o := range q.Options {
+			if o.Value == v {
+				return o.LabelTH
+			}
+		}
+		return v
+	}
+	switch q.Type {
+	case QuestionSingleChoice:
+		if a.Value == "" {
+			return ""
+		}
+		return label(a.Value)
+	case QuestionMultiChoice:
+		labels := make([]string, 0, len(a.Values))
+		for _, v := range a.Values {
+			labels = append(labels, label(v))
+		}
+		return strings.Join(labels, ", ")
+	case QuestionRating:
+		if a.Rating == nil {
+			return ""
+		}
+		if q.ScaleMax != nil {
+			return fmt.Sprintf("%s / %d", fmtNum(*a.Rating), *q.ScaleMax)
+		}
+		return fmtNum(*a.Rating)
+	}
+	return a.Value
+}
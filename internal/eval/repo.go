@@ -3,14 +3,25 @@ package eval
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"time"
+
+	"go-sqlserver-demo/internal/xlsx"
+
+	mssql "github.com/microsoft/go-mssqldb"
 )
 
-type Repo struct{ DB *sql.DB }
+type Repo struct {
+	DB     *sql.DB
+	Engine *Engine
+}
 
-func NewRepo(db *sql.DB) *Repo { return &Repo{DB: db} }
+func NewRepo(db *sql.DB) *Repo { return &Repo{DB: db, Engine: DefaultEngine()} }
 
 func (r *Repo) CreateForm(ctx context.Context, in CreateFormInput) (Form, error) {
 	const q = `
@@ -44,28 +55,46 @@ VALUES
 	return f, err
 }
 
-func (r *Repo) ListForms(ctx context.Context, limit, offset int) ([]Form, error) {
+const formCols = `
+id, code, title_th, ISNULL(title_en,''), kpi_weight, comp_weight, ta_weight, total_weight, calc_method, score_scheme,
+ISNULL(CONVERT(varchar(10),kpi_cfg_start,23),''),
+ISNULL(CONVERT(varchar(10),kpi_cfg_end,23),''),
+ISNULL(CONVERT(varchar(10),eval_start,23),''),
+ISNULL(CONVERT(varchar(10),eval_end,23),''),
+ISNULL(CONVERT(varchar(10),other_lv_start,23),''),
+ISNULL(CONVERT(varchar(10),other_lv_end,23),''),
+ISNULL(CONVERT(varchar(10),annual_lv_start,23),''),
+ISNULL(CONVERT(varchar(10),annual_lv_end,23),''),
+ISNULL(remark,''),
+CASE WHEN archived_at IS NULL THEN 0 ELSE 1 END`
+
+type rowScanner interface{ Scan(dest ...any) error }
+
+func scanForm(sc rowScanner) (Form, error) {
+	var f Form
+	err := sc.Scan(
+		&f.ID, &f.Code, &f.TitleTH, &f.TitleEN, &f.KPIWeight, &f.CompWeight, &f.TAWeight, &f.TotalWeight, &f.CalcMethod, &f.ScoreScheme,
+		&f.KPICfgStart, &f.KPICfgEnd, &f.EvalStart, &f.EvalEnd, &f.OtherLvStart, &f.OtherLvEnd, &f.AnnualLvStart, &f.AnnualLvEnd, &f.Remark,
+		&f.Archived,
+	)
+	return f, err
+}
+
+// ListForms: ไม่รวมฟอร์มที่เก็บถาวร เว้นแต่ includeArchived
+func (r *Repo) ListForms(ctx context.Context, limit, offset int, includeArchived bool) ([]Form, error) {
 	if limit <= 0 || limit > 200 {
 		limit = 50
 	}
 	if offset < 0 {
 		offset = 0
 	}
-	const q = `
-SELECT id, code, title_th, title_en, kpi_weight, comp_weight, ta_weight, total_weight, calc_method, score_scheme,
-       CONVERT(varchar(10),kpi_cfg_start,23),
-       CONVERT(varchar(10),kpi_cfg_end,23),
-       CONVERT(varchar(10),eval_start,23),
-       CONVERT(varchar(10),eval_end,23),
-       CONVERT(varchar(10),other_lv_start,23),
-       CONVERT(varchar(10),other_lv_end,23),
-       CONVERT(varchar(10),annual_lv_start,23),
-       CONVERT(varchar(10),annual_lv_end,23),
-       remark
+	q := `
+SELECT ` + formCols + `
 FROM dbo.eval_form
+WHERE (@p3 = 1 OR archived_at IS NULL)
 ORDER BY id DESC
 OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY;`
-	rows, err := r.DB.QueryContext(ctx, q, offset, limit)
+	rows, err := r.DB.QueryContext(ctx, q, offset, limit, includeArchived)
 	if err != nil {
 		return nil, err
 	}
@@ -73,11 +102,8 @@ OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY;`
 
 	var out []Form
 	for rows.Next() {
-		var f Form
-		if err := rows.Scan(
-			&f.ID, &f.Code, &f.TitleTH, &f.TitleEN, &f.KPIWeight, &f.CompWeight, &f.TAWeight, &f.TotalWeight, &f.CalcMethod, &f.ScoreScheme,
-			&f.KPICfgStart, &f.KPICfgEnd, &f.EvalStart, &f.EvalEnd, &f.OtherLvStart, &f.OtherLvEnd, &f.AnnualLvStart, &f.AnnualLvEnd, &f.Remark,
-		); err != nil {
+		f, err := scanForm(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, f)
@@ -85,6 +111,244 @@ OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY;`
 	return out, rows.Err()
 }
 
+func (r *Repo) GetForm(ctx context.Context, formID int) (Form, bool, error) {
+	f, err := scanForm(r.DB.QueryRowContext(ctx, `SELECT `+formCols+` FROM dbo.eval_form WHERE id=@p1;`, formID))
+	if err == sql.ErrNoRows {
+		return Form{}, false, nil
+	}
+	if err != nil {
+		return Form{}, false, err
+	}
+	return f, true, nil
+}
+
+var (
+	ErrFormInUse     = errors.New("form has assignments: cannot delete")
+	ErrDuplicateCode = errors.New("form code already exists")
+)
+
+// isDuplicateKey: 2627 = unique constraint, 2601 = unique index
+func isDuplicateKey(err error) bool {
+	var me mssql.Error
+	if errors.As(err, &me) {
+		return me.Number == 2627 || me.Number == 2601
+	}
+	return false
+}
+
+// UpdateForm แก้เฉพาะฟิลด์ที่ส่งมา (วันที่ส่ง "" = ล้างค่า)
+func (r *Repo) UpdateForm(ctx context.Context, formID int, in UpdateFormInput) (Form, bool, error) {
+	f, ok, err := r.GetForm(ctx, formID)
+	if err != nil || !ok {
+		return Form{}, ok, err
+	}
+
+	setStr := func(dst *string, v *string) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	setInt := func(dst *int, v *int) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	setStr(&f.Code, in.Code)
+	setStr(&f.TitleTH, in.TitleTH)
+	setStr(&f.TitleEN, in.TitleEN)
+	setInt(&f.KPIWeight, in.KPIWeight)
+	setInt(&f.CompWeight, in.CompWeight)
+	setInt(&f.TAWeight, in.TAWeight)
+	setInt(&f.TotalWeight, in.TotalWeight)
+	setInt(&f.CalcMethod, in.CalcMethod)
+	setInt(&f.ScoreScheme, in.ScoreScheme)
+	setStr(&f.KPICfgStart, in.KPICfgStart)
+	setStr(&f.KPICfgEnd, in.KPICfgEnd)
+	setStr(&f.EvalStart, in.EvalStart)
+	setStr(&f.EvalEnd, in.EvalEnd)
+	setStr(&f.OtherLvStart, in.OtherLvStart)
+	setStr(&f.OtherLvEnd, in.OtherLvEnd)
+	setStr(&f.AnnualLvStart, in.AnnualLvStart)
+	setStr(&f.AnnualLvEnd, in.AnnualLvEnd)
+	setStr(&f.Remark, in.Remark)
+
+	if err := ValidateForm(CreateFormInput{
+		Code: f.Code, TitleTH: f.TitleTH, TitleEN: f.TitleEN,
+		KPIWeight: f.KPIWeight, CompWeight: f.CompWeight, TAWeight: f.TAWeight, TotalWeight: f.TotalWeight,
+		CalcMethod: f.CalcMethod, ScoreScheme: f.ScoreScheme,
+		KPICfgStart: f.KPICfgStart, KPICfgEnd: f.KPICfgEnd, EvalStart: f.EvalStart, EvalEnd: f.EvalEnd,
+		OtherLvStart: f.OtherLvStart, OtherLvEnd: f.OtherLvEnd, AnnualLvStart: f.AnnualLvStart, AnnualLvEnd: f.AnnualLvEnd,
+		Remark: f.Remark,
+	}); err != nil {
+		return Form{}, false, err
+	}
+
+	_, err = r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_form
+SET code=@p2, title_th=@p3, title_en=NULLIF(@p4,''),
+    kpi_weight=@p5, comp_weight=@p6, ta_weight=@p7, total_weight=@p8, calc_method=@p9, score_scheme=@p10,
+    kpi_cfg_start=NULLIF(@p11,''), kpi_cfg_end=NULLIF(@p12,''),
+    eval_start=NULLIF(@p13,''), eval_end=NULLIF(@p14,''),
+    other_lv_start=NULLIF(@p15,''), other_lv_end=NULLIF(@p16,''),
+    annual_lv_start=NULLIF(@p17,''), annual_lv_end=NULLIF(@p18,''),
+    remark=NULLIF(@p19,''),
+    updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`,
+		formID, f.Code, f.TitleTH, f.TitleEN,
+		f.KPIWeight, f.CompWeight, f.TAWeight, f.TotalWeight, f.CalcMethod, f.ScoreScheme,
+		f.KPICfgStart, f.KPICfgEnd, f.EvalStart, f.EvalEnd,
+		f.OtherLvStart, f.OtherLvEnd, f.AnnualLvStart, f.AnnualLvEnd, f.Remark,
+	)
+	if isDuplicateKey(err) {
+		return Form{}, false, ErrDuplicateCode
+	}
+	if err != nil {
+		return Form{}, false, err
+	}
+	return r.GetForm(ctx, formID)
+}
+
+// SetFormArchived เก็บถาวร / ยกเลิกเก็บถาวร
+func (r *Repo) SetFormArchived(ctx context.Context, formID int, archived bool) (Form, bool, error) {
+	res, err := r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_form
+SET archived_at = CASE WHEN @p2 = 1 THEN ISNULL(archived_at, SYSUTCDATETIME()) ELSE NULL END,
+    updated_at = SYSUTCDATETIME()
+WHERE id=@p1;`, formID, archived)
+	if err != nil {
+		return Form{}, false, err
+	}
+	if aff, _ := res.RowsAffected(); aff == 0 {
+		return Form{}, false, nil
+	}
+	return r.GetForm(ctx, formID)
+}
+
+// DeleteForm ลบได้เฉพาะฟอร์มที่ยังไม่มี assignment (ถ้ามีแล้วให้ archive แทน)
+func (r *Repo) DeleteForm(ctx context.Context, formID int) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var cnt int
+	if err = tx.QueryRowContext(ctx, `
+SELECT COUNT(1) FROM dbo.eval_assignment WITH (UPDLOCK, HOLDLOCK) WHERE form_id=@p1;`, formID).Scan(&cnt); err != nil {
+		return false, err
+	}
+	if cnt > 0 {
+		err = ErrFormInUse
+		return false, err
+	}
+
+	// grade band ไม่มี FK → ลบเอง, competency ลบตาม CASCADE
+	if _, err = tx.ExecContext(ctx, `DELETE FROM dbo.eval_grade_band WHERE form_id=@p1;`, formID); err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_form WHERE id=@p1;`, formID)
+	if err != nil {
+		return false, err
+	}
+	aff, _ := res.RowsAffected()
+	if aff == 0 {
+		_ = tx.Rollback()
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+// CloneForm คัดลอก eval_form + eval_competency + eval_grade_band (เฉพาะของฟอร์ม) เป็นรหัสใหม่
+func (r *Repo) CloneForm(ctx context.Context, srcID int, in CloneFormInput) (Form, bool, error) {
+	if in.ShiftYears == 0 {
+		in.ShiftYears = 1
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Form{}, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var newID int
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_form
+(code,title_th,title_en,kpi_weight,comp_weight,ta_weight,total_weight,calc_method,score_scheme,
+ kpi_cfg_start,kpi_cfg_end,eval_start,eval_end,other_lv_start,other_lv_end,annual_lv_start,annual_lv_end,remark)
+OUTPUT inserted.id
+SELECT @p2, ISNULL(NULLIF(@p3,''), title_th), ISNULL(NULLIF(@p4,''), title_en),
+       kpi_weight, comp_weight, ta_weight, total_weight, calc_method, score_scheme,
+       ISNULL(NULLIF(@p6,''),  DATEADD(year,@p5,kpi_cfg_start)),
+       ISNULL(NULLIF(@p7,''),  DATEADD(year,@p5,kpi_cfg_end)),
+       ISNULL(NULLIF(@p8,''),  DATEADD(year,@p5,eval_start)),
+       ISNULL(NULLIF(@p9,''),  DATEADD(year,@p5,eval_end)),
+       ISNULL(NULLIF(@p10,''), DATEADD(year,@p5,other_lv_start)),
+       ISNULL(NULLIF(@p11,''), DATEADD(year,@p5,other_lv_end)),
+       ISNULL(NULLIF(@p12,''), DATEADD(year,@p5,annual_lv_start)),
+       ISNULL(NULLIF(@p13,''), DATEADD(year,@p5,annual_lv_end)),
+       remark
+FROM dbo.eval_form WHERE id=@p1;`,
+		srcID, in.Code, in.TitleTH, in.TitleEN, in.ShiftYears,
+		in.KPICfgStart, in.KPICfgEnd, in.EvalStart, in.EvalEnd,
+		in.OtherLvStart, in.OtherLvEnd, in.AnnualLvStart, in.AnnualLvEnd,
+	).Scan(&newID)
+	if err == sql.ErrNoRows {
+		return Form{}, false, nil
+	}
+	if isDuplicateKey(err) {
+		err = ErrDuplicateCode
+		return Form{}, false, err
+	}
+	if err != nil {
+		return Form{}, false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_competency(form_id, idx, title, max_score, weight, full_total, expected_score, def_id)
+SELECT @p2, idx, title, max_score, weight, full_total, expected_score, def_id
+FROM dbo.eval_competency WHERE form_id=@p1
+ORDER BY idx, id;`, srcID, newID); err != nil {
+		return Form{}, false, err
+	}
+
+	// ระดับเฉพาะฟอร์ม: จับคู่หัวข้อเดิม/ใหม่ด้วย idx + title
+	if _, err = tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_competency_level(comp_id, score, label, indicators)
+SELECT nc.id, l.score, l.label, l.indicators
+FROM dbo.eval_competency_level l
+JOIN dbo.eval_competency oc ON oc.id = l.comp_id AND oc.form_id = @p1
+JOIN dbo.eval_competency nc ON nc.form_id = @p2 AND nc.idx = oc.idx AND nc.title = oc.title;`, srcID, newID); err != nil {
+		return Form{}, false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_question(form_id, idx, code, qtype, label_th, label_en, required, options, scale_min, scale_max)
+SELECT @p2, idx, code, qtype, label_th, label_en, required, options, scale_min, scale_max
+FROM dbo.eval_question WHERE form_id=@p1;`, srcID, newID); err != nil {
+		return Form{}, false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_grade_band(form_id, grade, min_pct, max_pct)
+SELECT @p2, grade, min_pct, max_pct
+FROM dbo.eval_grade_band WHERE form_id=@p1;`, srcID, newID); err != nil {
+		return Form{}, false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return Form{}, false, err
+	}
+	return r.GetForm(ctx, newID)
+}
+
 // สร้าง (หรือดึง) assignment ของ user กับฟอร์ม
 func (r *Repo) EnsureAssignment(ctx context.Context, formID, userID int, due string) (Assign, error) {
 	var a Assign
@@ -122,6 +386,11 @@ OUTPUT inserted.id, inserted.form_id, inserted.user_id, inserted.status,
 VALUES(@p1, @p2, 0, @p3);`
 	err = r.DB.QueryRowContext(ctx, ins, formID, userID, due).
 		Scan(&a.ID, &a.FormID, &a.UserID, &a.Status, &a.DueDate)
+	if err != nil {
+		return a, err
+	}
+	// แผนพัฒนาที่ยังไม่จบจากรอบก่อน
+	_, err = carryOverDevPlan(ctx, r.DB, a.ID)
 	return a, err
 }
 
@@ -139,12 +408,13 @@ func (r *Repo) AddMyKPIsBulk(ctx context.Context, assignmentID int, in []MyKPIIn
 
 	stmt, err := tx.PrepareContext(ctx, `
 INSERT INTO dbo.eval_kpi_user
-(assignment_id, idx, code, title, max_score, weight, expected_score, score, note, measure, criteria, unit)
+(assignment_id, idx, code, title, max_score, weight, expected_score, score, note, measure, criteria, unit, catalog_id, objective_id, scoring_rule, actual_value, score_override)
 OUTPUT inserted.id, inserted.assignment_id, inserted.idx,
        ISNULL(inserted.code,''), inserted.title, inserted.max_score, inserted.weight,
        inserted.expected_score, inserted.score, ISNULL(inserted.note,''),
-       ISNULL(inserted.measure,''), ISNULL(inserted.criteria,''), ISNULL(inserted.unit,'')
-VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8, NULLIF(@p9,''), NULLIF(@p10,''), NULLIF(@p11,''), NULLIF(@p12,''));`)
+       ISNULL(inserted.measure,''), ISNULL(inserted.criteria,''), ISNULL(inserted.unit,''), inserted.catalog_id, inserted.objective_id,
+       inserted.scoring_rule, CAST(inserted.actual_value AS float), inserted.score_override
+VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8, NULLIF(@p9,''), NULLIF(@p10,''), NULLIF(@p11,''), NULLIF(@p12,''), @p13, @p14, @p15, @p16, @p17);`)
 	if err != nil {
 		return nil, err
 	}
@@ -152,15 +422,18 @@ VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8, NULLIF(@p9,''), NULLIF(@p10,''), NULLIF(
 
 	var out []MyKPIItem
 	for _, it := range in {
+		applyKPIRule(&it)
 		var row MyKPIItem
 		if err = stmt.QueryRowContext(ctx,
 			assignmentID, it.Idx, it.Code, it.Title, it.MaxScore, it.Weight, it.ExpectedScore, it.Score,
-			it.Note, it.Measure, it.Criteria, it.Unit,
+			it.Note, it.Measure, it.Criteria, it.Unit, it.CatalogID, it.ObjectiveID,
+			ruleParam(it.ScoringRule), it.ActualValue, it.ScoreOverride,
 		).Scan(
 			&row.ID, &row.AssignmentID, &row.Idx,
 			&row.Code, &row.Title, &row.MaxScore, &row.Weight,
 			&row.ExpectedScore, &row.Score, &row.Note,
-			&row.Measure, &row.Criteria, &row.Unit,
+			&row.Measure, &row.Criteria, &row.Unit, &row.CatalogID, &row.ObjectiveID,
+			ruleColumn{&row.ScoringRule}, &row.ActualValue, &row.ScoreOverride,
 		); err != nil {
 			return nil, err
 		}
@@ -182,9 +455,18 @@ func (r *Repo) ListMyKPIsByForm(ctx context.Context, formID, userID int) ([]MyKP
 SELECT ku.id, ku.assignment_id, ku.idx,
        ISNULL(ku.code,''), ku.title, ku.max_score, ku.weight,
        ku.expected_score, ku.score, ISNULL(ku.note,''),
-       ISNULL(ku.measure,''), ISNULL(ku.criteria,''), ISNULL(ku.unit,'')
+       ISNULL(ku.measure,''), ISNULL(ku.criteria,''), ISNULL(ku.unit,''), ku.catalog_id, ku.objective_id,
+       ku.scoring_rule, CAST(ku.actual_value AS float), ku.score_override,
+       CAST(ku.final_score AS float), lc.d, lc.actual, lc.pct
 FROM dbo.eval_kpi_user ku
 JOIN dbo.eval_assignment a ON a.id = ku.assignment_id
+OUTER APPLY (
+  SELECT TOP(1) CONVERT(varchar(10), ci.checkin_date, 23) AS d,
+         CAST(ci.actual_value AS float) AS actual, CAST(ci.percent_complete AS float) AS pct
+  FROM dbo.eval_kpi_checkin ci
+  WHERE ci.assignment_id = ku.assignment_id AND ci.kpi_user_id = ku.id
+  ORDER BY ci.checkin_date DESC, ci.id DESC
+) lc
 WHERE a.form_id=@p1 AND a.user_id=@p2
 ORDER BY ku.idx, ku.id;`, formID, userID)
 	if err != nil {
@@ -194,15 +476,26 @@ ORDER BY ku.idx, ku.id;`, formID, userID)
 
 	var out []MyKPIItem
 	for rows.Next() {
-		var k MyKPIItem
+		var (
+			k      MyKPIItem
+			ciDate sql.NullString
+			latest KPICheckinLatest
+		)
 		if err := rows.Scan(
 			&k.ID, &k.AssignmentID, &k.Idx,
 			&k.Code, &k.Title, &k.MaxScore, &k.Weight,
 			&k.ExpectedScore, &k.Score, &k.Note,
-			&k.Measure, &k.Criteria, &k.Unit,
+			&k.Measure, &k.Criteria, &k.Unit, &k.CatalogID, &k.ObjectiveID,
+			ruleColumn{&k.ScoringRule}, &k.ActualValue, &k.ScoreOverride,
+			&k.FinalScore, &ciDate, &latest.ActualValue, &latest.PercentComplete,
 		); err != nil {
 			return nil, err
 		}
+		if ciDate.Valid {
+			latest.CheckinDate = ciDate.String
+			k.LatestCheckin = &latest
+			k.SuggestedScore = suggestedScore(k)
+		}
 		out = append(out, k)
 	}
 	return out, rows.Err()
@@ -226,7 +519,14 @@ func (r *Repo) ReplaceMyKPIs(ctx context.Context, formID, userID int, due string
 		return nil, err
 	}
 
-	// ลบชุดเดิมทั้งหมด
+	// ลบชุดเดิมทั้งหมด (คงลิงก์คลัง KPI / เป้าหมายของรายการที่ code เดิม)
+	if in, err = keepKPILinks(ctx, tx, a.ID, in); err != nil {
+		return nil, err
+	}
+	oldKeys, err := listKPIKeys(ctx, tx, a.ID)
+	if err != nil {
+		return nil, err
+	}
 	if _, err = tx.ExecContext(ctx, `DELETE FROM dbo.eval_kpi_user WHERE assignment_id=@p1;`, a.ID); err != nil {
 		return nil, err
 	}
@@ -234,12 +534,13 @@ func (r *Repo) ReplaceMyKPIs(ctx context.Context, formID, userID int, due string
 	// เตรียม insert ใหม่ทั้งหมด
 	stmt, err := tx.PrepareContext(ctx, `
 INSERT INTO dbo.eval_kpi_user
-(assignment_id, idx, code, title, max_score, weight, expected_score, score, note, measure, criteria, unit)
+(assignment_id, idx, code, title, max_score, weight, expected_score, score, note, measure, criteria, unit, catalog_id, objective_id, scoring_rule, actual_value, score_override)
 OUTPUT inserted.id, inserted.assignment_id, inserted.idx,
        ISNULL(inserted.code,''), inserted.title, inserted.max_score, inserted.weight,
        inserted.expected_score, inserted.score, ISNULL(inserted.note,''),
-       ISNULL(inserted.measure,''), ISNULL(inserted.criteria,''), ISNULL(inserted.unit,'')
-VALUES(@p1,@p2, NULLIF(@p3,''), @p4, @p5, @p6, @p7, @p8, NULLIF(@p9,''), NULLIF(@p10,''), NULLIF(@p11,''), NULLIF(@p12,''));`)
+       ISNULL(inserted.measure,''), ISNULL(inserted.criteria,''), ISNULL(inserted.unit,''), inserted.catalog_id, inserted.objective_id,
+       inserted.scoring_rule, CAST(inserted.actual_value AS float), inserted.score_override
+VALUES(@p1,@p2, NULLIF(@p3,''), @p4, @p5, @p6, @p7, @p8, NULLIF(@p9,''), NULLIF(@p10,''), NULLIF(@p11,''), NULLIF(@p12,''), @p13, @p14, @p15, @p16, @p17);`)
 	if err != nil {
 		return nil, err
 	}
@@ -247,21 +548,33 @@ VALUES(@p1,@p2, NULLIF(@p3,''), @p4, @p5, @p6, @p7, @p8, NULLIF(@p9,''), NULLIF(
 
 	var out []MyKPIItem
 	for _, it := range in {
+		applyKPIRule(&it)
 		var row MyKPIItem
 		if err = stmt.QueryRowContext(ctx,
 			a.ID, it.Idx, it.Code, it.Title, it.MaxScore, it.Weight, it.ExpectedScore, it.Score,
-			it.Note, it.Measure, it.Criteria, it.Unit,
+			it.Note, it.Measure, it.Criteria, it.Unit, it.CatalogID, it.ObjectiveID,
+			ruleParam(it.ScoringRule), it.ActualValue, it.ScoreOverride,
 		).Scan(
 			&row.ID, &row.AssignmentID, &row.Idx,
 			&row.Code, &row.Title, &row.MaxScore, &row.Weight,
 			&row.ExpectedScore, &row.Score, &row.Note,
-			&row.Measure, &row.Criteria, &row.Unit,
+			&row.Measure, &row.Criteria, &row.Unit, &row.CatalogID, &row.ObjectiveID,
+			ruleColumn{&row.ScoringRule}, &row.ActualValue, &row.ScoreOverride,
 		); err != nil {
 			return nil, err
 		}
 		out = append(out, row)
 	}
 
+	if err = relinkCheckins(ctx, tx, a.ID); err != nil {
+		return nil, err
+	}
+	if err = relinkStepScores(ctx, tx, a.ID, oldKeys); err != nil {
+		return nil, err
+	}
+	if err = applyFinalScores(ctx, tx, a.ID); err != nil {
+		return nil, err
+	}
 	_, err = tx.ExecContext(ctx, `UPDATE dbo.eval_kpi_user SET updated_at=SYSUTCDATETIME() WHERE assignment_id=@p1;`, a.ID)
 	if err != nil {
 		return nil, err
@@ -271,6 +584,89 @@ VALUES(@p1,@p2, NULLIF(@p3,''), @p4, @p5, @p6, @p7, @p8, NULLIF(@p9,''), NULLIF(
 	return out, err
 }
 
+// ruleParam เก็บ rule เป็น JSON (nil / manual = NULL)
+func ruleParam(r *KPIScoringRule) any {
+	if r == nil || r.Method == RuleManual || r.Method == "" {
+		return nil
+	}
+	b, _ := json.Marshal(r)
+	return string(b)
+}
+
+// ruleColumn อ่านคอลัมน์ scoring_rule (JSON) กลับเป็น *KPIScoringRule
+type ruleColumn struct{ dst **KPIScoringRule }
+
+func (c ruleColumn) Scan(src any) error {
+	*c.dst = nil
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("scoring_rule: unsupported type %T", src)
+	}
+	var r KPIScoringRule
+	if err := json.Unmarshal(b, &r); err != nil {
+		return fmt.Errorf("scoring_rule: %w", err)
+	}
+	*c.dst = &r
+	return nil
+}
+
+// keepKPILinks: ก่อน replace ทั้งชุด ให้รายการที่ไม่ได้ส่ง catalog_id / objective_id / scoring_rule มา
+// แต่ code ตรงกับของเดิม ยังใช้ค่าเดิม (FE เก่าไม่รู้จักฟิลด์เหล่านี้)
+func keepKPILinks(ctx context.Context, tx *sql.Tx, aid int, in []MyKPIInput) ([]MyKPIInput, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT code, catalog_id, objective_id, scoring_rule, CAST(actual_value AS float) FROM dbo.eval_kpi_user
+WHERE assignment_id=@p1 AND code IS NOT NULL
+  AND (catalog_id IS NOT NULL OR objective_id IS NOT NULL OR scoring_rule IS NOT NULL OR actual_value IS NOT NULL);`, aid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type link struct {
+		catalog, objective *int
+		rule               *KPIScoringRule
+		actual             *float64
+	}
+	links := map[string]link{}
+	for rows.Next() {
+		var code string
+		var l link
+		if err := rows.Scan(&code, &l.catalog, &l.objective, ruleColumn{&l.rule}, &l.actual); err != nil {
+			return nil, err
+		}
+		links[strings.ToLower(code)] = l
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := make([]MyKPIInput, len(in))
+	for i, it := range in {
+		if l, ok := links[strings.ToLower(strings.TrimSpace(it.Code))]; ok {
+			if it.CatalogID == nil {
+				it.CatalogID = l.catalog
+			}
+			if it.ObjectiveID == nil {
+				it.ObjectiveID = l.objective
+			}
+			if it.ScoringRule == nil {
+				it.ScoringRule = l.rule
+			}
+			if it.ActualValue == nil {
+				it.ActualValue = l.actual
+			}
+		}
+		out[i] = it
+	}
+	return out, nil
+}
+
 // Update KPI "รายการเดียว" ของผู้ใช้ในฟอร์ม (ยืนยันสิทธิ์ด้วย form_id+user_id)
 func (r *Repo) UpdateMyKPI(ctx context.Context, formID, userID, kpiID int, in MyKPIInput) (MyKPIItem, bool, error) {
 	const q = `
@@ -278,25 +674,56 @@ UPDATE ku
 SET ku.idx=@p4, ku.code=NULLIF(@p5,''), ku.title=@p6, ku.max_score=@p7, ku.weight=@p8,
     ku.expected_score=@p9, ku.score=@p10, ku.note=NULLIF(@p11,''),
     ku.measure=NULLIF(@p12,''), ku.criteria=NULLIF(@p13,''), ku.unit=NULLIF(@p14,''),
-    ku.updated_at=SYSUTCDATETIME()
+    ku.catalog_id=ISNULL(@p15, ku.catalog_id),
+    ku.objective_id=ISNULL(@p16, ku.objective_id),
+    ku.scoring_rule=@p17, ku.actual_value=@p18, ku.score_override=@p19, ku.updated_at=SYSUTCDATETIME()
 OUTPUT inserted.id, inserted.assignment_id, inserted.idx,
        ISNULL(inserted.code,''), inserted.title, inserted.max_score, inserted.weight,
        inserted.expected_score, inserted.score, ISNULL(inserted.note,''),
-       ISNULL(inserted.measure,''), ISNULL(inserted.criteria,''), ISNULL(inserted.unit,'')
+       ISNULL(inserted.measure,''), ISNULL(inserted.criteria,''), ISNULL(inserted.unit,''), inserted.catalog_id, inserted.objective_id,
+       inserted.scoring_rule, CAST(inserted.actual_value AS float), inserted.score_override
 FROM dbo.eval_kpi_user ku
 JOIN dbo.eval_assignment a ON a.id = ku.assignment_id
 WHERE ku.id=@p1 AND a.form_id=@p2 AND a.user_id=@p3;`
 
+	// ไม่ได้ส่ง rule / actual มา → ใช้ค่าเดิมก่อนคำนวณ score
+	if in.ScoringRule == nil || in.ActualValue == nil {
+		var (
+			rule   *KPIScoringRule
+			actual *float64
+		)
+		err := r.DB.QueryRowContext(ctx, `
+SELECT ku.scoring_rule, CAST(ku.actual_value AS float)
+FROM dbo.eval_kpi_user ku
+JOIN dbo.eval_assignment a ON a.id = ku.assignment_id
+WHERE ku.id=@p1 AND a.form_id=@p2 AND a.user_id=@p3;`, kpiID, formID, userID).Scan(ruleColumn{&rule}, &actual)
+		if err == sql.ErrNoRows {
+			return MyKPIItem{}, false, nil
+		}
+		if err != nil {
+			return MyKPIItem{}, false, err
+		}
+		if in.ScoringRule == nil {
+			in.ScoringRule = rule
+		}
+		if in.ActualValue == nil {
+			in.ActualValue = actual
+		}
+	}
+	applyKPIRule(&in)
+
 	var row MyKPIItem
 	err := r.DB.QueryRowContext(ctx, q,
 		kpiID, formID, userID,
 		in.Idx, in.Code, in.Title, in.MaxScore, in.Weight,
-		in.ExpectedScore, in.Score, in.Note, in.Measure, in.Criteria, in.Unit,
+		in.ExpectedScore, in.Score, in.Note, in.Measure, in.Criteria, in.Unit, in.CatalogID, in.ObjectiveID,
+		ruleParam(in.ScoringRule), in.ActualValue, in.ScoreOverride,
 	).Scan(
 		&row.ID, &row.AssignmentID, &row.Idx,
 		&row.Code, &row.Title, &row.MaxScore, &row.Weight,
 		&row.ExpectedScore, &row.Score, &row.Note,
-		&row.Measure, &row.Criteria, &row.Unit,
+		&row.Measure, &row.Criteria, &row.Unit, &row.CatalogID, &row.ObjectiveID,
+		ruleColumn{&row.ScoringRule}, &row.ActualValue, &row.ScoreOverride,
 	)
 	if err == sql.ErrNoRows {
 		return MyKPIItem{}, false, nil
@@ -304,9 +731,103 @@ WHERE ku.id=@p1 AND a.form_id=@p2 AND a.user_id=@p3;`
 	if err != nil {
 		return MyKPIItem{}, false, err
 	}
+	// check-in เดิมตามชื่อ/รหัสใหม่ของ KPI (ใช้ผูกใหม่ตอน replace)
+	if _, err := r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_kpi_checkin SET kpi_code=NULLIF(@p3,''), kpi_title=@p4
+WHERE assignment_id=@p1 AND kpi_user_id=@p2;`, row.AssignmentID, row.ID, row.Code, row.Title); err != nil {
+		return MyKPIItem{}, false, err
+	}
 	return row, true, nil
 }
 
+// relinkCheckins หลัง replace KPI ทั้งชุด: ผูก check-in กับแถวใหม่ด้วย code (หรือ title ถ้าไม่มี code)
+// check-in ที่ไม่เจอ KPI แล้วยังเก็บไว้เป็นประวัติ
+func relinkCheckins(ctx context.Context, tx *sql.Tx, aid int) error {
+	_, err := tx.ExecContext(ctx, `
+UPDATE ci SET ci.kpi_user_id = m.id
+FROM dbo.eval_kpi_checkin ci
+CROSS APPLY (
+  SELECT TOP(1) ku.id FROM dbo.eval_kpi_user ku
+  WHERE ku.assignment_id = ci.assignment_id
+    AND ((ci.kpi_code IS NOT NULL AND ku.code = ci.kpi_code)
+      OR (ci.kpi_code IS NULL AND ku.code IS NULL AND ku.title = ci.kpi_title))
+  ORDER BY ku.idx, ku.id
+) m
+WHERE ci.assignment_id = @p1;`, aid)
+	return err
+}
+
+// kpiKey id ของ KPI กับ key ที่ใช้ผูกใหม่หลัง replace ทั้งชุด (code หรือ title ถ้าไม่มี code)
+type kpiKey struct {
+	id  int
+	key string
+}
+
+func listKPIKeys(ctx context.Context, q querier, aid int) ([]kpiKey, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT id, ISNULL(code,''), title FROM dbo.eval_kpi_user
+WHERE assignment_id=@p1
+ORDER BY idx, id;`, aid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []kpiKey
+	for rows.Next() {
+		var (
+			k           kpiKey
+			code, title string
+		)
+		if err := rows.Scan(&k.id, &code, &title); err != nil {
+			return nil, err
+		}
+		k.key = "title:" + strings.ToLower(title)
+		if code != "" {
+			k.key = "code:" + strings.ToLower(code)
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// relinkStepScores หลัง replace KPI ทั้งชุด: ย้ายคะแนนทุกขั้น (self / หัวหน้า / ...) ไปที่แถวใหม่
+// ด้วย code/title เดียวกับ relinkCheckins; old = KPI ชุดเดิมก่อนลบ
+// KPI ที่ถูกเอาออก (หรือ key ซ้ำกัน) → ลบคะแนนของหัวข้อนั้นทิ้ง
+func relinkStepScores(ctx context.Context, tx *sql.Tx, aid int, old []kpiKey) error {
+	if len(old) == 0 {
+		return nil
+	}
+	cur, err := listKPIKeys(ctx, tx, aid)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]int, len(cur))
+	for _, k := range cur {
+		if _, ok := byKey[k.key]; !ok {
+			byKey[k.key] = k.id
+		}
+	}
+
+	const scope = `
+FROM dbo.eval_step_score ss
+JOIN dbo.eval_step st ON st.id = ss.step_id
+WHERE st.assignment_id=@p1 AND ss.kind=@p2 AND ss.item_id=@p3;`
+	used := map[int]bool{}
+	for _, o := range old {
+		newID, ok := byKey[o.key]
+		if ok && !used[newID] {
+			used[newID] = true
+			_, err = tx.ExecContext(ctx, `UPDATE ss SET ss.item_id=@p4`+scope, aid, stepKindKPI, o.id, newID)
+		} else {
+			_, err = tx.ExecContext(ctx, `DELETE ss`+scope, aid, stepKindKPI, o.id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Delete KPI "รายการเดียว" ของผู้ใช้ในฟอร์ม (ยืนยันสิทธิ์ด้วย form_id+user_id)
 func (r *Repo) DeleteMyKPI(ctx context.Context, formID, userID, kpiID int) (bool, error) {
 	res, err := r.DB.ExecContext(ctx, `
@@ -318,7 +839,18 @@ WHERE ku.id=@p1 AND a.form_id=@p2 AND a.user_id=@p3;`, kpiID, formID, userID)
 		return false, err
 	}
 	aff, _ := res.RowsAffected()
-	return aff > 0, nil
+	if aff == 0 {
+		return false, nil
+	}
+	// คะแนนรายขั้นของหัวข้อนี้ (ไม่มี FK เพราะ item_id ผูกได้หลายตาราง)
+	if _, err := r.DB.ExecContext(ctx, `
+DELETE ss FROM dbo.eval_step_score ss
+JOIN dbo.eval_step st ON st.id = ss.step_id
+JOIN dbo.eval_assignment a ON a.id = st.assignment_id
+WHERE ss.kind=@p4 AND ss.item_id=@p1 AND a.form_id=@p2 AND a.user_id=@p3;`, kpiID, formID, userID, stepKindKPI); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // เพิ่ม Competency หลายข้อ (append) ให้ฟอร์ม
@@ -334,10 +866,10 @@ func (r *Repo) AddCompsBulk(ctx context.Context, formID int, in []CompInput) ([]
 	}()
 
 	stmt, err := tx.PrepareContext(ctx, `
-INSERT INTO dbo.eval_competency(form_id, idx, title, max_score, weight, full_total, expected_score)
+INSERT INTO dbo.eval_competency(form_id, idx, title, max_score, weight, full_total, expected_score, def_id)
 OUTPUT inserted.id, inserted.form_id, inserted.idx, inserted.title,
-       inserted.max_score, inserted.weight, inserted.full_total, inserted.expected_score
-VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7);`)
+       inserted.max_score, inserted.weight, inserted.full_total, inserted.expected_score, inserted.def_id
+VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8);`)
 	if err != nil {
 		return nil, err
 	}
@@ -347,8 +879,11 @@ VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7);`)
 	for _, it := range in {
 		var row CompItem
 		if err = stmt.QueryRowContext(ctx,
-			formID, it.Idx, it.Title, it.MaxScore, it.Weight, it.FullTotal, it.ExpectedScore,
-		).Scan(&row.ID, &row.FormID, &row.Idx, &row.Title, &row.MaxScore, &row.Weight, &row.FullTotal, &row.ExpectedScore); err != nil {
+			formID, it.Idx, it.Title, it.MaxScore, it.Weight, it.FullTotal, it.ExpectedScore, it.DefID,
+		).Scan(&row.ID, &row.FormID, &row.Idx, &row.Title, &row.MaxScore, &row.Weight, &row.FullTotal, &row.ExpectedScore, &row.DefID); err != nil {
+			return nil, err
+		}
+		if err = insertLevels(ctx, tx, "dbo.eval_competency_level", "comp_id", row.ID, it.Levels); err != nil {
 			return nil, err
 		}
 		out = append(out, row)
@@ -358,14 +893,24 @@ VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7);`)
 	if err != nil {
 		return nil, err
 	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
 
-	return out, tx.Commit()
+	rubrics, err := r.loadRubrics(ctx, formID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Rubric = rubricOf(rubrics, out[i].ID)
+	}
+	return out, nil
 }
 
 // ดึง Competency ของฟอร์ม
 func (r *Repo) ListCompsByForm(ctx context.Context, formID int) ([]CompItem, error) {
 	rows, err := r.DB.QueryContext(ctx, `
-SELECT id, form_id, idx, title, max_score, weight, full_total, expected_score
+SELECT id, form_id, idx, title, max_score, weight, full_total, expected_score, def_id
 FROM dbo.eval_competency
 WHERE form_id=@p1
 ORDER BY idx, id;`, formID)
@@ -377,31 +922,43 @@ ORDER BY idx, id;`, formID)
 	var out []CompItem
 	for rows.Next() {
 		var c CompItem
-		if err := rows.Scan(&c.ID, &c.FormID, &c.Idx, &c.Title, &c.MaxScore, &c.Weight, &c.FullTotal, &c.ExpectedScore); err != nil {
+		if err := rows.Scan(&c.ID, &c.FormID, &c.Idx, &c.Title, &c.MaxScore, &c.Weight, &c.FullTotal, &c.ExpectedScore, &c.DefID); err != nil {
 			return nil, err
 		}
 		out = append(out, c)
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rubrics, err := r.loadRubrics(ctx, formID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Rubric = rubricOf(rubrics, out[i].ID)
+	}
+	return out, nil
 }
 
 var ErrAlreadySubmitted = errors.New("already submitted: cannot modify")
 
-func (r *Repo) SaveAll(ctx context.Context, formID, userID int, in SaveAllInput) (int, error) {
+// SaveAll บันทึกทุกแท็บ แล้วคืน summary ที่คำนวณจากข้อมูลชุดเดียวกัน (ใน transaction เดียว)
+func (r *Repo) SaveAll(ctx context.Context, formID, userID int, in SaveAllInput) (int, EvalSummary, error) {
 	// 1) assignment
 	a, err := r.EnsureAssignment(ctx, formID, userID, in.DueDate)
 	if err != nil {
-		return 0, err
+		return 0, EvalSummary{}, err
 	}
 
-	// ห้ามแก้ถ้า assignment ถูก lock แล้ว (status=2)
-	if a.Status == 2 {
-		return 0, ErrAlreadySubmitted
+	// ห้ามแก้ถ้า assignment ไม่ใช่ draft แล้ว (ส่งแล้ว / อยู่ระหว่างประเมิน / ปิดแล้ว)
+	if IsLocked(a.Status) {
+		return 0, EvalSummary{}, ErrAlreadySubmitted
 	}
 
 	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, err
+		return 0, EvalSummary{}, err
 	}
 	defer func() {
 		if err != nil {
@@ -410,40 +967,54 @@ func (r *Repo) SaveAll(ctx context.Context, formID, userID int, in SaveAllInput)
 	}()
 
 	/* ---- KPI (replace ทั้งชุด) ---- */
+	if in.KPIs, err = keepKPILinks(ctx, tx, a.ID, in.KPIs); err != nil {
+		return 0, EvalSummary{}, err
+	}
+	var oldKeys []kpiKey
+	if oldKeys, err = listKPIKeys(ctx, tx, a.ID); err != nil {
+		return 0, EvalSummary{}, err
+	}
 	if _, err = tx.ExecContext(ctx, `DELETE FROM dbo.eval_kpi_user WHERE assignment_id=@p1;`, a.ID); err != nil {
-		return 0, err
+		return 0, EvalSummary{}, err
 	}
 	if len(in.KPIs) > 0 {
 		stmtKPI, err2 := tx.PrepareContext(ctx, `
 INSERT INTO dbo.eval_kpi_user
-(assignment_id, idx, code, title, max_score, weight, expected_score, score, note, measure, criteria, unit)
-VALUES(@p1,@p2, NULLIF(@p3,''), @p4, @p5, @p6, @p7, @p8, NULLIF(@p9,''), NULLIF(@p10,''), NULLIF(@p11,''), NULLIF(@p12,''));`)
+(assignment_id, idx, code, title, max_score, weight, expected_score, score, note, measure, criteria, unit, catalog_id, objective_id, scoring_rule, actual_value, score_override)
+VALUES(@p1,@p2, NULLIF(@p3,''), @p4, @p5, @p6, @p7, @p8, NULLIF(@p9,''), NULLIF(@p10,''), NULLIF(@p11,''), NULLIF(@p12,''), @p13, @p14, @p15, @p16, @p17);`)
 		if err2 != nil {
-			return 0, err2
+			return 0, EvalSummary{}, err2
 		}
 		defer stmtKPI.Close()
 		for _, it := range in.KPIs {
-			if _, err = stmtKPI.ExecContext(ctx, a.ID, it.Idx, it.Code, it.Title, it.MaxScore, it.Weight, it.ExpectedScore, it.Score, it.Note, it.Measure, it.Criteria, it.Unit); err != nil {
-				return 0, err
+			applyKPIRule(&it)
+			if _, err = stmtKPI.ExecContext(ctx, a.ID, it.Idx, it.Code, it.Title, it.MaxScore, it.Weight, it.ExpectedScore, it.Score, it.Note, it.Measure, it.Criteria, it.Unit, it.CatalogID, it.ObjectiveID, ruleParam(it.ScoringRule), it.ActualValue, it.ScoreOverride); err != nil {
+				return 0, EvalSummary{}, err
 			}
 		}
 	}
+	if err = relinkCheckins(ctx, tx, a.ID); err != nil {
+		return 0, EvalSummary{}, err
+	}
+	if err = relinkStepScores(ctx, tx, a.ID, oldKeys); err != nil {
+		return 0, EvalSummary{}, err
+	}
 
 	/* ---- Competency scores (delete+insert) ---- */
 	if _, err = tx.ExecContext(ctx, `DELETE cs FROM dbo.eval_competency_score cs WHERE cs.assignment_id=@p1;`, a.ID); err != nil {
-		return 0, err
+		return 0, EvalSummary{}, err
 	}
 	if len(in.CompetencyScores) > 0 {
 		stmtC, err2 := tx.PrepareContext(ctx, `
 INSERT INTO dbo.eval_competency_score(assignment_id, comp_id, score, note)
 VALUES(@p1,@p2,@p3, NULLIF(@p4,''));`)
 		if err2 != nil {
-			return 0, err2
+			return 0, EvalSummary{}, err2
 		}
 		defer stmtC.Close()
 		for _, c := range in.CompetencyScores {
 			if _, err = stmtC.ExecContext(ctx, a.ID, c.CompID, c.Score, c.Note); err != nil {
-				return 0, err
+				return 0, EvalSummary{}, err
 			}
 		}
 	}
@@ -458,68 +1029,67 @@ WHEN NOT MATCHED THEN INSERT(assignment_id, full_score, score) VALUES(s.assignme
 		a.ID, in.TimeAttendance.FullScore, in.TimeAttendance.Score,
 	)
 	if err != nil {
-		return 0, err
+		return 0, EvalSummary{}, err
 	}
 
-	/* ---- Development Plan (replace all) ---- */
-	if _, err = tx.ExecContext(ctx, `DELETE FROM dbo.eval_dev_plan WHERE assignment_id=@p1;`, a.ID); err != nil {
-		return 0, err
-	}
-	if len(in.DevelopmentPlan) > 0 {
-		stmtD, err2 := tx.PrepareContext(ctx, `
-INSERT INTO dbo.eval_dev_plan(assignment_id, idx, content, priority, timing, remarks)
-VALUES(@p1,@p2,@p3,@p4, NULLIF(@p5,''), NULLIF(@p6,''));`)
-		if err2 != nil {
-			return 0, err2
-		}
-		defer stmtD.Close()
-		for _, d := range in.DevelopmentPlan {
-			if _, err = stmtD.ExecContext(ctx, a.ID, d.Idx, d.Content, d.Priority, d.Timing, d.Remarks); err != nil {
-				return 0, err
-			}
-		}
+	/* ---- Development Plan (คงสถานะของรายการเดิม) ---- */
+	if err = saveDevPlan(ctx, tx, a.ID, in.DevelopmentPlan); err != nil {
+		return 0, EvalSummary{}, err
 	}
 
-	/* ---- Additional (upsert) ---- */
-	_, err = tx.ExecContext(ctx, `
-MERGE dbo.eval_additional AS t
-USING (SELECT @p1 AS assignment_id, @p2 AS q1, @p3 AS q2, @p4 AS q3, @p5 AS q4, @p6 AS q5) s
-ON (t.assignment_id = s.assignment_id)
-WHEN MATCHED THEN UPDATE SET q1=s.q1, q2=s.q2, q3=s.q3, q4=s.q4, q5=s.q5, updated_at=SYSUTCDATETIME()
-WHEN NOT MATCHED THEN INSERT(assignment_id, q1, q2, q3, q4, q5) VALUES(s.assignment_id, s.q1, s.q2, s.q3, s.q4, s.q5);`,
-		a.ID, in.Additional.Q1, in.Additional.Q2, in.Additional.Q3, in.Additional.Q4, in.Additional.Q5,
-	)
-	if err != nil {
-		return 0, err
+	/* ---- Additional (replace ทั้งชุด) ---- */
+	if err = replaceAnswers(ctx, tx, formID, a.ID, in.Additional); err != nil {
+		return 0, EvalSummary{}, err
 	}
 
-	/* ---- อัปเดตสถานะ assignment ---- */
-	status := in.Status
-	if status < 0 || status > 2 {
-		status = 0
+	/* ---- คะแนนของขั้นที่ผู้บันทึกถืออยู่ → รวมเป็นคะแนนสุดท้าย ---- */
+	var stepID int
+	if stepID, err = activeStepOf(ctx, tx, a.ID, userID); err != nil {
+		return 0, EvalSummary{}, err
 	}
-	if _, err = tx.ExecContext(ctx,
-		`UPDATE dbo.eval_assignment SET status=@p2, updated_at=SYSUTCDATETIME() WHERE id=@p1;`,
-		a.ID, status,
-	); err != nil {
-		return 0, err
+	if stepID > 0 {
+		if err = saveStepScores(ctx, tx, a.ID, stepID); err != nil {
+			return 0, EvalSummary{}, err
+		}
+	}
+	// แถวสมรรถนะถูก delete+insert ด้านบน → คำนวณ final_score ใหม่เสมอ
+	if err = applyFinalScores(ctx, tx, a.ID); err != nil {
+		return 0, EvalSummary{}, err
 	}
 
-	// --- Hook: เดิน step-flow เฉพาะตอน submit (status=2) ---
-	if status == 2 {
-		if err = r.completeActiveStepAndOpenNext(ctx, tx, a.ID); err != nil {
-			return 0, err
+	/* ---- สถานะ: draft คงเดิม, status=2 → submitted แล้วเดิน step-flow ---- */
+	if in.Status == AssignSubmitted {
+		if err = transition(ctx, tx, a.ID, AssignSubmitted, userID, 0, ActionSubmit, ""); err != nil {
+			return 0, EvalSummary{}, err
+		}
+		if err = r.completeActiveStepAndOpenNext(ctx, tx, a.ID, userID); err != nil {
+			return 0, EvalSummary{}, err
 		}
+	} else if _, err = tx.ExecContext(ctx,
+		`UPDATE dbo.eval_assignment SET updated_at=SYSUTCDATETIME() WHERE id=@p1;`, a.ID,
+	); err != nil {
+		return 0, EvalSummary{}, err
+	}
+
+	/* ---- Summary (ใช้ Engine กับข้อมูลที่เพิ่งบันทึก) ---- */
+	score, err := loadScoreConfig(ctx, tx, formID)
+	if err != nil {
+		return 0, EvalSummary{}, err
 	}
+	if err = loadScoreItems(ctx, tx, a.ID, &score); err != nil {
+		return 0, EvalSummary{}, err
+	}
+	sum := r.summarize(ctx, tx, formID, a.ID, score)
 
 	if err = tx.Commit(); err != nil {
-		return 0, err
+		return 0, EvalSummary{}, err
 	}
-	return a.ID, nil
+	return a.ID, sum, nil
 }
 
 // completeActiveStepAndOpenNext: ปิด step ที่กำลัง active (1) เป็น 2 แล้วเปิดคนถัดไป (0→1)
-func (r *Repo) completeActiveStepAndOpenNext(ctx context.Context, tx *sql.Tx, assignmentID int) error {
+// ถ้าทุก step เสร็จแล้ว → assignment เป็น approved
+func (r *Repo) completeActiveStepAndOpenNext(ctx context.Context, tx *sql.Tx, assignmentID, actorID int) error {
 	// หา step ที่กำลัง active
 	var stepID, idx int
 	err := tx.QueryRowContext(ctx, `
@@ -546,19 +1116,161 @@ SET status=2, eval_date=CONVERT(date, SYSUTCDATETIME()), updated_at=SYSUTCDATETI
 WHERE id=@p1;`, stepID); err != nil {
 		return err
 	}
+	if err = logTransition(ctx, tx, assignmentID, stepID, ActionStepComplete, -1, -1, actorID, ""); err != nil {
+		return err
+	}
 
 	// เปิด idx ถัดไป (เฉพาะที่ยังเป็น 0)
-	_, err = tx.ExecContext(ctx, `
+	if _, err = tx.ExecContext(ctx, `
 UPDATE dbo.eval_step
 SET status=1, updated_at=SYSUTCDATETIME()
-WHERE assignment_id=@p1 AND idx=@p2 AND status=0;`, assignmentID, idx+1)
+WHERE assignment_id=@p1 AND idx=@p2 AND status=0;`, assignmentID, idx+1); err != nil {
+		return err
+	}
+
+	var pending int
+	if err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(1) FROM dbo.eval_step WHERE assignment_id=@p1 AND status<>2;`, assignmentID,
+	).Scan(&pending); err != nil {
+		return err
+	}
+	if pending == 0 {
+		return transition(ctx, tx, assignmentID, AssignApproved, actorID, 0, ActionApprove, "")
+	}
+	return nil
+}
+
+// querier: ใช้ได้ทั้ง *sql.DB และ *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// loadScoreConfig: น้ำหนัก/วิธีคำนวณของฟอร์ม
+func loadScoreConfig(ctx context.Context, q querier, formID int) (ScoreInput, error) {
+	var in ScoreInput
+	err := q.QueryRowContext(ctx,
+		`SELECT kpi_weight, comp_weight, ta_weight, calc_method, score_scheme FROM dbo.eval_form WHERE id=@p1;`,
+		formID,
+	).Scan(&in.KPIWeight, &in.CompWeight, &in.TAWeight, &in.CalcMethod, &in.ScoreScheme)
+	return in, err
+}
+
+// loadScoreItems: คะแนนที่บันทึกไว้ของ assignment (คะแนนสุดท้าย)
+func loadScoreItems(ctx context.Context, q querier, aid int, in *ScoreInput) error {
+	var err error
+	if in.KPIs, err = queryScoreItems(ctx, q, `
+SELECT CAST(ISNULL(final_score, score) AS float), CAST(max_score AS float), CAST(weight AS float)
+FROM dbo.eval_kpi_user WHERE assignment_id=@p1;`, aid); err != nil {
+		return err
+	}
+	if in.Comps, err = queryScoreItems(ctx, q, `
+SELECT CAST(ISNULL(cs.final_score, cs.score) AS float), CAST(c.max_score AS float), CAST(c.weight AS float)
+FROM dbo.eval_competency_score cs
+JOIN dbo.eval_competency c ON c.id = cs.comp_id
+WHERE cs.assignment_id=@p1;`, aid); err != nil {
+		return err
+	}
+	for i := range in.Comps {
+		in.Comps[i].Weight = compWeightFraction(in.Comps[i].Weight) * 100
+	}
+
+	err = q.QueryRowContext(ctx,
+		`SELECT CAST(ISNULL(final_score, score) AS float), CAST(full_score AS float) FROM dbo.eval_ta_score WHERE assignment_id=@p1;`, aid,
+	).Scan(&in.TAScore, &in.TAFull)
+	if err == sql.ErrNoRows {
+		return nil
+	}
 	return err
 }
 
+// queryScoreItems อ่านแถว (score, max_score, weight)
+func queryScoreItems(ctx context.Context, q querier, query string, args ...any) ([]ScoreItem, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ScoreItem
+	for rows.Next() {
+		var it ScoreItem
+		if err := rows.Scan(&it.Score, &it.MaxScore, &it.Weight); err != nil {
+			return nil, err
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// summarize คำนวณด้วย Engine แล้วหาเกรดจาก eval_grade_band
+func (r *Repo) summarize(ctx context.Context, q querier, formID, aid int, in ScoreInput) EvalSummary {
+	res := r.Engine.Compute(in)
+	out := EvalSummary{
+		FormID:      formID,
+		KPIWeight:   in.KPIWeight,
+		KPIPct:      res.KPIPct,
+		CompWeight:  in.CompWeight,
+		CompPct:     res.CompPct,
+		TAWeight:    in.TAWeight,
+		TAPct:       res.TAPct,
+		TotalPct:    res.TotalPct,
+		ScoreScheme: in.ScoreScheme,
+		TotalScore:  res.TotalScore,
+	}
+
+	// หาเกรด (ถ้ามี band)
+	out.Grade, out.GradeMin, out.GradeMax, _ = lookupGrade(ctx, q, formID, aid, "", out.TotalPct)
+
+	// เกรดหลัง calibration (เฉพาะ session ที่ล็อกแล้ว)
+	if aid > 0 {
+		_ = q.QueryRowContext(ctx, `
+SELECT ISNULL(ci.final_grade, ci.calc_grade)
+FROM dbo.eval_calibration_item ci
+JOIN dbo.eval_calibration c ON c.id = ci.calibration_id
+WHERE ci.assignment_id=@p1 AND c.status=1;`, aid).Scan(&out.CalibratedGrade)
+	}
+	return out
+}
+
+// lookupGrade หา band ตามลำดับ: เฉพาะฟอร์ม → บริษัท (ของ assignment หรือ companyID) → ค่าเริ่มต้น
+// ใช้ bandSet.pick ตัวเดียวกับ analytics
+func lookupGrade(ctx context.Context, q querier, formID, aid int, companyID string, pct float64) (string, float64, float64, string) {
+	bs, err := loadBandSet(ctx, q, formID)
+	if err != nil {
+		return "N/A", 0, 0, "none"
+	}
+	var companies []string
+	if companyID != "" {
+		companies = append(companies, companyID)
+	}
+	if aid > 0 {
+		rows, err := q.QueryContext(ctx, `
+SELECT CAST(uc.company_id AS nvarchar(36))
+FROM dbo.user_companies uc
+JOIN dbo.eval_assignment a ON a.user_id = uc.user_id
+WHERE a.id=@p1;`, aid)
+		if err != nil {
+			return "N/A", 0, 0, "none"
+		}
+		for rows.Next() {
+			var cid string
+			if err := rows.Scan(&cid); err == nil {
+				companies = append(companies, cid)
+			}
+		}
+		rows.Close()
+	}
+	b, scope, ok := bs.pick(companies, pct)
+	if !ok {
+		return "N/A", 0, 0, "none"
+	}
+	return b.Grade, b.MinPct, b.MaxPct, scope
+}
+
 // Summary หลังบันทึก
 func (r *Repo) ComputeSummary(ctx context.Context, formID, userID int) (EvalSummary, error) {
-	var out EvalSummary
-	out.FormID = formID
+	out := EvalSummary{FormID: formID}
 
 	// assignment id
 	var aid int
@@ -571,80 +1283,22 @@ func (r *Repo) ComputeSummary(ctx context.Context, formID, userID int) (EvalSumm
 		}
 		return out, err
 	}
+	return r.ComputeSummaryByAssignment(ctx, formID, aid)
+}
 
-	// weights ของฟอร์ม
-	if err := r.DB.QueryRowContext(ctx,
-		`SELECT kpi_weight, comp_weight, ta_weight FROM dbo.eval_form WHERE id=@p1;`,
-		formID,
-	).Scan(&out.KPIWeight, &out.CompWeight, &out.TAWeight); err != nil {
-		return out, err
-	}
-
-	// KPI %
-	if err := r.DB.QueryRowContext(ctx, `
-SELECT ISNULL(
-    (SUM(CASE WHEN ku.max_score>0 THEN (ku.score/ku.max_score) * ku.weight ELSE 0 END) 
-     / NULLIF(SUM(ku.weight),0)) * 100, 0)
-FROM dbo.eval_kpi_user ku 
-WHERE ku.assignment_id=@p1;`, aid).Scan(&out.KPIPct); err != nil {
-		return out, err
-	}
-
-	// Competency %
-	if err := r.DB.QueryRowContext(ctx, `
-SELECT ISNULL(SUM(
-    (CASE WHEN c.max_score>0 THEN (cs.score/c.max_score) ELSE 0 END) *
-    (CASE
-        WHEN c.weight IS NULL THEN 0
-        WHEN c.weight <= 1 THEN c.weight       -- เก็บเป็นอัตราส่วน 0..1
-        ELSE c.weight/100.0                    -- เก็บเป็นเปอร์เซ็นต์ 0..100
-    END)
-)*100, 0)
-FROM dbo.eval_competency_score cs
-JOIN dbo.eval_competency c ON c.id = cs.comp_id
-WHERE cs.assignment_id=@p1;`, aid).Scan(&out.CompPct); err != nil {
-		return out, err
-	}
-
-	// TA %
-	if err := r.DB.QueryRowContext(ctx, `
-SELECT ISNULL((CASE WHEN full_score>0 THEN (score/full_score)*100 ELSE 0 END), 0)
-FROM dbo.eval_ta_score WHERE assignment_id=@p1;`, aid).Scan(&out.TAPct); err != nil {
-		return out, err
-	}
-
-	// รวมถ่วงน้ำหนักระดับฟอร์ม
-	out.TotalPct = (out.KPIPct*float64(out.KPIWeight) +
-		out.CompPct*float64(out.CompWeight) +
-		out.TAPct*float64(out.TAWeight)) / 100.0
+func (r *Repo) ComputeSummaryByAssignment(ctx context.Context, formID, aid int) (EvalSummary, error) {
+	return r.computeSummary(ctx, r.DB, formID, aid)
+}
 
-	// หาเกรด (ถ้ามี band)
-	var g, has sql.NullString
-	var gmin, gmax sql.NullFloat64
-	err := r.DB.QueryRowContext(ctx, `
-SELECT TOP(1) grade, CAST(min_pct AS float), CAST(max_pct AS float), 'x'
-FROM dbo.eval_grade_band
-WHERE (form_id=@p1 OR form_id IS NULL)
-  AND @p2 BETWEEN min_pct AND max_pct
-ORDER BY CASE WHEN form_id=@p1 THEN 0 ELSE 1 END, min_pct DESC;`,
-		formID, out.TotalPct,
-	).Scan(&g, &gmin, &gmax, &has)
-	if err == nil {
-		if g.Valid {
-			out.Grade = g.String
-		}
-		if gmin.Valid {
-			out.GradeMin = gmin.Float64
-		}
-		if gmax.Valid {
-			out.GradeMax = gmax.Float64
-		}
+func (r *Repo) computeSummary(ctx context.Context, q querier, formID, aid int) (EvalSummary, error) {
+	in, err := loadScoreConfig(ctx, q, formID)
+	if err != nil {
+		return EvalSummary{FormID: formID}, err
 	}
-	if out.Grade == "" {
-		out.Grade = "N/A"
+	if err := loadScoreItems(ctx, q, aid, &in); err != nil {
+		return EvalSummary{FormID: formID}, err
 	}
-
-	return out, nil
+	return r.summarize(ctx, q, formID, aid, in), nil
 }
 
 // ก่อน: func (r *Repo) LoadMyFormData(ctx context.Context, formID, userID int) (LoadMyFormData, error)
@@ -653,15 +1307,23 @@ func (r *Repo) LoadMyFormData(ctx context.Context, formID, userID int) (LoadMyFo
 	out := LoadMyFormData{
 		KPIs:            make([]MyKPIItem, 0),
 		Competencies:    make([]MyCompWithScore, 0),
-		DevelopmentPlan: make([]DevPlanItemInput, 0),
-		TimeAttendance:  TAScoreInput{},    // 0,0
-		Additional:      AdditionalInput{}, // "" ทั้ง 5 ช่อง (ไม่มี omitempty)
+		DevelopmentPlan: make([]DevPlanItem, 0),
+		Steps:           make([]StepResult, 0),
+		TimeAttendance:  TAScoreInput{}, // 0,0
+		Additional:      make([]AdditionalAnswer, 0),
+	}
+
+	// คำถามเพิ่มเติมของฟอร์ม (ส่งกลับแม้ยังไม่เคยบันทึก)
+	questions, err := r.ListQuestions(ctx, formID)
+	if err != nil {
+		return out, err
 	}
+	out.Questions = questions
 
 	// หา assignment (ถ้าไม่เคยบันทึกมาก่อน จะไม่มีแถว)
 	var aid, status int
 	var due sql.NullString
-	err := r.DB.QueryRowContext(ctx, `
+	err = r.DB.QueryRowContext(ctx, `
         SELECT id, status, CONVERT(varchar(10),due_date,23)
         FROM dbo.eval_assignment
         WHERE form_id=@p1 AND user_id=@p2;`, formID, userID).
@@ -677,6 +1339,7 @@ func (r *Repo) LoadMyFormData(ctx context.Context, formID, userID int) (LoadMyFo
 
 	out.AssignmentID = aid
 	out.Status = status
+	out.StatusName = AssignStatusName(status)
 	if due.Valid {
 		out.DueDate = due.String
 	}
@@ -692,7 +1355,7 @@ func (r *Repo) LoadMyFormData(ctx context.Context, formID, userID int) (LoadMyFo
 	// Competency + คะแนน (LEFT JOIN)
 	rows, err := r.DB.QueryContext(ctx, `
 SELECT c.id, c.idx, c.title, c.max_score, c.weight, c.full_total, c.expected_score,
-       ISNULL(cs.score,0), ISNULL(cs.note,'')
+       ISNULL(cs.score,0), ISNULL(cs.note,''), CAST(cs.final_score AS float)
 FROM dbo.eval_competency c
 LEFT JOIN dbo.eval_competency_score cs
   ON cs.comp_id = c.id AND cs.assignment_id = @p2
@@ -704,7 +1367,7 @@ ORDER BY c.idx, c.id;`, formID, aid)
 	defer rows.Close()
 	for rows.Next() {
 		var m MyCompWithScore
-		if err := rows.Scan(&m.CompID, &m.Idx, &m.Title, &m.MaxScore, &m.Weight, &m.FullTotal, &m.ExpectedScore, &m.Score, &m.Note); err != nil {
+		if err := rows.Scan(&m.CompID, &m.Idx, &m.Title, &m.MaxScore, &m.Weight, &m.FullTotal, &m.ExpectedScore, &m.Score, &m.Note, &m.FinalScore); err != nil {
 			return out, err
 		}
 		out.Competencies = append(out.Competencies, m)
@@ -712,46 +1375,26 @@ ORDER BY c.idx, c.id;`, formID, aid)
 	if err := rows.Err(); err != nil {
 		return out, err
 	}
+	rubrics, err := r.loadRubrics(ctx, formID)
+	if err != nil {
+		return out, err
+	}
+	for i := range out.Competencies {
+		out.Competencies[i].Rubric = rubricOf(rubrics, out.Competencies[i].CompID)
+	}
 
 	// Time Attendance
-	_ = r.DB.QueryRowContext(ctx, `SELECT ISNULL(full_score,0), ISNULL(score,0) FROM dbo.eval_ta_score WHERE assignment_id=@p1;`, aid).
-		Scan(&out.TimeAttendance.FullScore, &out.TimeAttendance.Score)
+	_ = r.DB.QueryRowContext(ctx, `SELECT ISNULL(full_score,0), ISNULL(score,0), CAST(final_score AS float) FROM dbo.eval_ta_score WHERE assignment_id=@p1;`, aid).
+		Scan(&out.TimeAttendance.FullScore, &out.TimeAttendance.Score, &out.TAFinalScore)
 
 	// Dev plan
-	rows, err = r.DB.QueryContext(ctx, `
-SELECT idx, content, priority, CONVERT(varchar(10),timing,23), ISNULL(remarks,'')
-FROM dbo.eval_dev_plan WHERE assignment_id=@p1
-ORDER BY idx, id;`, aid)
-	if err != nil {
+	if out.DevelopmentPlan, err = r.ListDevPlan(ctx, aid, false); err != nil {
 		return out, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var d DevPlanItemInput
-		if err := rows.Scan(&d.Idx, &d.Content, &d.Priority, &d.Timing, &d.Remarks); err != nil {
-			return out, err
-		}
-		out.DevelopmentPlan = append(out.DevelopmentPlan, d)
-	}
 
 	// Additional
-	var q1, q2, q3, q4, q5 sql.NullString
-	_ = r.DB.QueryRowContext(ctx, `SELECT q1,q2,q3,q4,q5 FROM dbo.eval_additional WHERE assignment_id=@p1;`, aid).
-		Scan(&q1, &q2, &q3, &q4, &q5)
-	if q1.Valid {
-		out.Additional.Q1 = q1.String
-	}
-	if q2.Valid {
-		out.Additional.Q2 = q2.String
-	}
-	if q3.Valid {
-		out.Additional.Q3 = q3.String
-	}
-	if q4.Valid {
-		out.Additional.Q4 = q4.String
-	}
-	if q5.Valid {
-		out.Additional.Q5 = q5.String
+	if out.Additional, err = r.loadAnswers(ctx, aid); err != nil {
+		return out, err
 	}
 
 	// Summary
@@ -760,11 +1403,16 @@ ORDER BY idx, id;`, aid)
 		out.Summary = sum
 	}
 
+	// คะแนนแยกตามขั้น (เทียบ self / หัวหน้า / ...)
+	if err := r.loadStepScores(ctx, formID, &out); err != nil {
+		return out, err
+	}
+
 	return out, nil
 }
 
-// AddEvalSteps: คนแรก status=1, ที่เหลือ 0
-func (r *Repo) AddEvalSteps(ctx context.Context, assignmentID int, evaluators []int) ([]EvalStep, error) {
+// AddEvalSteps: คนแรก status=1, ที่เหลือ 0; weights (ถ้ามี) เรียงตาม evaluators
+func (r *Repo) AddEvalSteps(ctx context.Context, assignmentID int, evaluators []int, weights []float64) ([]EvalStep, error) {
 	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -775,15 +1423,26 @@ func (r *Repo) AddEvalSteps(ctx context.Context, assignmentID int, evaluators []
 		}
 	}()
 
+	out, err := insertSteps(ctx, tx, assignmentID, evaluators, weights)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	return out, err
+}
+
+// insertSteps เพิ่ม chain ของ eval_step (คนแรก active) ภายใน tx
+func insertSteps(ctx context.Context, tx *sql.Tx, assignmentID int, evaluators []int, weights []float64) ([]EvalStep, error) {
 	stmt, err := tx.PrepareContext(ctx, `
-INSERT INTO dbo.eval_step (assignment_id, idx, evaluator_id, status, created_at, updated_at)
+INSERT INTO dbo.eval_step (assignment_id, idx, evaluator_id, status, weight, created_at, updated_at)
 OUTPUT inserted.id,
        inserted.assignment_id,
        inserted.idx,
        inserted.evaluator_id,
        inserted.status,
-       CONVERT(varchar(10), inserted.eval_date, 23)
-VALUES (@p1, @p2, @p3, @p4, SYSUTCDATETIME(), SYSUTCDATETIME());
+       CONVERT(varchar(10), inserted.eval_date, 23),
+       CAST(inserted.weight AS float)
+VALUES (@p1, @p2, @p3, @p4, @p5, SYSUTCDATETIME(), SYSUTCDATETIME());
 `)
 	if err != nil {
 		return nil, err
@@ -797,9 +1456,14 @@ VALUES (@p1, @p2, @p3, @p4, SYSUTCDATETIME(), SYSUTCDATETIME());
 			st = 1 // คนแรก active เสมอ
 		}
 
+		var w any
+		if i < len(weights) {
+			w = weights[i]
+		}
+
 		var s EvalStep
-		if err = stmt.QueryRowContext(ctx, assignmentID, i+1, ev, st).
-			Scan(&s.ID, &s.AssignmentID, &s.Idx, &s.EvaluatorID, &s.Status, &s.EvalDate); err != nil {
+		if err = stmt.QueryRowContext(ctx, assignmentID, i+1, ev, st, w).
+			Scan(&s.ID, &s.AssignmentID, &s.Idx, &s.EvaluatorID, &s.Status, &s.EvalDate, &s.Weight); err != nil {
 			return nil, err
 		}
 
@@ -809,16 +1473,14 @@ VALUES (@p1, @p2, @p3, @p4, SYSUTCDATETIME(), SYSUTCDATETIME());
 
 		out = append(out, s)
 	}
-
-	err = tx.Commit()
-	return out, err
+	return out, nil
 }
 
 func (r *Repo) ListEvalSteps(ctx context.Context, formID, userID int) ([]EvalStep, error) {
 	const q = `
 SELECT es.id, es.assignment_id, es.idx,
        es.evaluator_id, u.name,
-       es.status, CONVERT(varchar(10), es.eval_date, 23)
+       es.status, CONVERT(varchar(10), es.eval_date, 23), CAST(es.weight AS float)
 FROM dbo.eval_step es
 JOIN dbo.eval_assignment a ON a.id = es.assignment_id
 JOIN dbo.users u ON u.id = es.evaluator_id
@@ -835,7 +1497,7 @@ ORDER BY es.idx;`
 		var s EvalStep
 		if err := rows.Scan(&s.ID, &s.AssignmentID, &s.Idx,
 			&s.EvaluatorID, &s.EvaluatorName,
-			&s.Status, &s.EvalDate); err != nil {
+			&s.Status, &s.EvalDate, &s.Weight); err != nil {
 			return nil, err
 		}
 		out = append(out, s)
@@ -843,8 +1505,9 @@ ORDER BY es.idx;`
 	return out, rows.Err()
 }
 
-// UpdateEvalStep: ถ้าเปลี่ยนเป็น 2=done → เปิด idx ถัดไปเป็น 1 (ถ้ายังไม่มี active)
-func (r *Repo) UpdateEvalStep(ctx context.Context, stepID int, status int, evalDate string) (EvalStep, bool, error) {
+// UpdateEvalStep: ผู้ประเมินของ step ปิดขั้นของตัวเอง (active → done) แล้วเปิดขั้นถัดไป
+// การย้อนกลับต้องใช้ ReturnToPreviousStep (มีเหตุผลประกอบ)
+func (r *Repo) UpdateEvalStep(ctx context.Context, stepID, actorID int, status int, evalDate string) (EvalStep, bool, error) {
 	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return EvalStep{}, false, err
@@ -855,65 +1518,4036 @@ func (r *Repo) UpdateEvalStep(ctx context.Context, stepID int, status int, evalD
 		}
 	}()
 
-	// lock แถวนี้ก่อน (กันชนกัน) และดึง assignment_id, idx
-	var assignmentID, idx int
+	// lock แถวนี้ก่อน (กันชนกัน)
+	var assignmentID, idx, evaluatorID, cur int
 	if err = tx.QueryRowContext(ctx, `
-SELECT assignment_id, idx
+SELECT assignment_id, idx, evaluator_id, status
 FROM dbo.eval_step WITH (UPDLOCK, ROWLOCK)
 WHERE id=@p1;
-`, stepID).Scan(&assignmentID, &idx); err != nil {
+`, stepID).Scan(&assignmentID, &idx, &evaluatorID, &cur); err != nil {
 		if err == sql.ErrNoRows {
+			err = nil
 			return EvalStep{}, false, nil
 		}
 		return EvalStep{}, false, err
 	}
+	if evaluatorID != actorID {
+		err = ErrNotEvaluator
+		return EvalStep{}, true, err
+	}
+	if status != StepDone || cur != StepActive {
+		err = fmt.Errorf("%w: a step can only move from active to done (use return to send back)", ErrIllegalTransition)
+		return EvalStep{}, true, err
+	}
+
+	// ขั้น self ปิดเอง = ส่งแบบประเมิน → ตรวจเต็มแบบเดียวกับ SaveAll status=2
+	if idx == 1 {
+		var formID, userID, aStatus int
+		if err = tx.QueryRowContext(ctx,
+			`SELECT form_id, user_id, status FROM dbo.eval_assignment WITH (UPDLOCK, ROWLOCK) WHERE id=@p1;`, assignmentID,
+		).Scan(&formID, &userID, &aStatus); err != nil {
+			return EvalStep{}, true, err
+		}
+		if aStatus == AssignDraft {
+			if err = r.validateSubmission(ctx, tx, formID, userID, assignmentID); err != nil {
+				return EvalStep{}, true, err
+			}
+			if err = transition(ctx, tx, assignmentID, AssignSubmitted, actorID, 0, ActionSubmit, ""); err != nil {
+				return EvalStep{}, true, err
+			}
+		}
+	}
+	if err = r.completeActiveStepAndOpenNext(ctx, tx, assignmentID, actorID); err != nil {
+		return EvalStep{}, true, err
+	}
+	if strings.TrimSpace(evalDate) != "" {
+		if _, err = tx.ExecContext(ctx, `UPDATE dbo.eval_step SET eval_date=@p2 WHERE id=@p1;`, stepID, evalDate); err != nil {
+			return EvalStep{}, true, err
+		}
+	}
+
+	s, err := getStep(ctx, tx, stepID)
+	if err != nil {
+		return EvalStep{}, true, err
+	}
+	if err = tx.Commit(); err != nil {
+		return EvalStep{}, true, err
+	}
+	return s, true, nil
+}
+
+// validateSubmission ตรวจข้อมูลที่บันทึกไว้ของ assignment ด้วย ValidateSaveAll แบบ strict
+// (ส่งผ่าน PUT step ต้องครบเท่ากับส่งผ่าน SaveAll)
+func (r *Repo) validateSubmission(ctx context.Context, q querier, formID, userID, aid int) error {
+	kpis, err := r.ListMyKPIsByForm(ctx, formID, userID)
+	if err != nil {
+		return err
+	}
+	comps, err := r.ListCompsByForm(ctx, formID)
+	if err != nil {
+		return err
+	}
+	questions, err := r.ListQuestions(ctx, formID)
+	if err != nil {
+		return err
+	}
+	in := SaveAllInput{Status: AssignSubmitted}
+	for _, k := range kpis {
+		in.KPIs = append(in.KPIs, SaveKPIInput{
+			Idx: k.Idx, Code: k.Code, Title: k.Title, MaxScore: k.MaxScore, Weight: k.Weight,
+			ExpectedScore: k.ExpectedScore, Score: k.Score, Note: k.Note,
+			Measure: k.Measure, Criteria: k.Criteria, Unit: k.Unit,
+		})
+	}
+
+	rows, err := q.QueryContext(ctx, `
+SELECT comp_id, CAST(score AS float), ISNULL(note,'') FROM dbo.eval_competency_score WHERE assignment_id=@p1;`, aid)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var cs CompScoreInput
+		if err := rows.Scan(&cs.CompID, &cs.Score, &cs.Note); err != nil {
+			rows.Close()
+			return err
+		}
+		in.CompetencyScores = append(in.CompetencyScores, cs)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	err = q.QueryRowContext(ctx, `
+SELECT CAST(full_score AS float), CAST(score AS float) FROM dbo.eval_ta_score WHERE assignment_id=@p1;`, aid).
+		Scan(&in.TimeAttendance.FullScore, &in.TimeAttendance.Score)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	plan, err := r.ListDevPlan(ctx, aid, false)
+	if err != nil {
+		return err
+	}
+	for _, d := range plan {
+		in.DevelopmentPlan = append(in.DevelopmentPlan, DevPlanItemInput{
+			ID: d.ID, Idx: d.Idx, Content: d.Content, Priority: d.Priority, Timing: d.Timing, Remarks: d.Remarks, CourseID: d.CourseID,
+		})
+	}
+	if in.Additional, err = r.loadAnswers(ctx, aid); err != nil {
+		return err
+	}
+	return ValidateSaveAll(in, comps, questions, true)
+}
 
-	// อัปเดตสถานะ + eval_date แล้ว OUTPUT ค่าที่ต้องการ
+func getStep(ctx context.Context, q querier, stepID int) (EvalStep, error) {
 	var s EvalStep
-	if err = tx.QueryRowContext(ctx, `
-UPDATE es
-SET es.status=@p2,
-    es.eval_date=NULLIF(@p3,''),
-    es.updated_at=SYSUTCDATETIME()
-OUTPUT inserted.id, inserted.assignment_id, inserted.idx,
-       inserted.evaluator_id, u.name,
-       inserted.status, CONVERT(varchar(10), inserted.eval_date, 23)
+	err := q.QueryRowContext(ctx, `
+SELECT es.id, es.assignment_id, es.idx, es.evaluator_id, u.name,
+       es.status, CONVERT(varchar(10), es.eval_date, 23), CAST(es.weight AS float)
 FROM dbo.eval_step es
 JOIN dbo.users u ON u.id = es.evaluator_id
-WHERE es.id=@p1;
-`, stepID, status, evalDate).
-		Scan(&s.ID, &s.AssignmentID, &s.Idx, &s.EvaluatorID, &s.EvaluatorName, &s.Status, &s.EvalDate); err != nil {
-		if err == sql.ErrNoRows {
-			return EvalStep{}, false, nil
+WHERE es.id=@p1;`, stepID).
+		Scan(&s.ID, &s.AssignmentID, &s.Idx, &s.EvaluatorID, &s.EvaluatorName, &s.Status, &s.EvalDate, &s.Weight)
+	return s, err
+}
+
+// ===== Per-step scores =====
+
+// ชนิดของแถวใน eval_step_score
+const (
+	stepKindKPI  = "kpi"
+	stepKindComp = "comp"
+	stepKindTA   = "ta"
+)
+
+// activeStepOf: step ที่กำลังทำ (status=1) ของ evaluator นี้ (0 = ไม่มี → ไม่แยกคะแนนตามขั้น)
+func activeStepOf(ctx context.Context, q querier, aid, evaluatorID int) (int, error) {
+	var id int
+	err := q.QueryRowContext(ctx, `
+SELECT TOP(1) id FROM dbo.eval_step
+WHERE assignment_id=@p1 AND evaluator_id=@p2 AND status=1
+ORDER BY idx;`, aid, evaluatorID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// saveStepScores คัดลอกคะแนนที่พนักงานเพิ่งบันทึกลงตารางหลัก (score) ไปเป็นคะแนนของ step นี้
+// ไม่แตะคะแนนของขั้นอื่น; คะแนนรวมทุกขั้นอยู่ใน final_score จึงไม่ปนกับคะแนนของขั้น
+func saveStepScores(ctx context.Context, tx *sql.Tx, aid, stepID int) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_step_score WHERE step_id=@p1;`, stepID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_step_score(step_id, kind, item_id, score, note)
+SELECT @p1, @p3, id, score, note FROM dbo.eval_kpi_user WHERE assignment_id=@p2
+UNION ALL
+SELECT @p1, @p4, comp_id, score, note FROM dbo.eval_competency_score WHERE assignment_id=@p2
+UNION ALL
+SELECT @p1, @p5, 0, score, NULL FROM dbo.eval_ta_score WHERE assignment_id=@p2;`,
+		stepID, aid, stepKindKPI, stepKindComp, stepKindTA)
+	return err
+}
+
+// applyFinalScores รวมคะแนนทุกขั้นด้วย CombineStepScores แล้วเขียนลง final_score ของตารางหลัก
+// (summary / เกรด / รายงาน อ่าน ISNULL(final_score, score); score ยังเป็นคะแนนที่พนักงานกรอก)
+func applyFinalScores(ctx context.Context, tx *sql.Tx, aid int) error {
+	type key struct {
+		kind string
+		id   int
+	}
+	rows, err := tx.QueryContext(ctx, `
+SELECT ss.kind, ss.item_id, st.idx, CAST(ISNULL(st.weight,0) AS float), CAST(ss.score AS float)
+FROM dbo.eval_step_score ss
+JOIN dbo.eval_step st ON st.id = ss.step_id
+WHERE st.assignment_id=@p1
+ORDER BY ss.kind, ss.item_id, st.idx;`, aid)
+	if err != nil {
+		return err
+	}
+	var order []key
+	scores := map[key][]StepScore{}
+	for rows.Next() {
+		var k key
+		var s StepScore
+		if err := rows.Scan(&k.kind, &k.id, &s.Idx, &s.Weight, &s.Score); err != nil {
+			rows.Close()
+			return err
 		}
-		return EvalStep{}, false, err
+		if _, ok := scores[k]; !ok {
+			order = append(order, k)
+		}
+		scores[k] = append(scores[k], s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	// ถ้าจบ → เปิดคนถัดไป (idx+1) เป็น 1 เฉพาะเมื่อไม่มี active อยู่แล้ว
-	if status == 2 {
-		var activeCnt int
-		if err = tx.QueryRowContext(ctx, `
-SELECT COUNT(1)
-FROM dbo.eval_step WITH (UPDLOCK, ROWLOCK)
-WHERE assignment_id=@p1 AND status=1;
-`, assignmentID).Scan(&activeCnt); err != nil {
-			return EvalStep{}, false, err
+	for _, k := range order {
+		final := CombineStepScores(scores[k])
+		switch k.kind {
+		case stepKindKPI:
+			_, err = tx.ExecContext(ctx,
+				`UPDATE dbo.eval_kpi_user SET final_score=@p3, updated_at=SYSUTCDATETIME() WHERE assignment_id=@p1 AND id=@p2;`,
+				aid, k.id, final)
+		case stepKindComp:
+			_, err = tx.ExecContext(ctx, `
+MERGE dbo.eval_competency_score AS t
+USING (SELECT @p1 AS assignment_id, @p2 AS comp_id, @p3 AS score) s
+ON (t.assignment_id = s.assignment_id AND t.comp_id = s.comp_id)
+WHEN MATCHED THEN UPDATE SET final_score=s.score, updated_at=SYSUTCDATETIME()
+WHEN NOT MATCHED THEN INSERT(assignment_id, comp_id, final_score) VALUES(s.assignment_id, s.comp_id, s.score);`,
+				aid, k.id, final)
+		case stepKindTA:
+			_, err = tx.ExecContext(ctx,
+				`UPDATE dbo.eval_ta_score SET final_score=@p2, updated_at=SYSUTCDATETIME() WHERE assignment_id=@p1;`,
+				aid, final)
+		}
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if activeCnt == 0 {
-			// เปิดเฉพาะแถวถัดไปที่ยังเป็น 0
-			if _, err = tx.ExecContext(ctx, `
-UPDATE dbo.eval_step
-SET status=1, updated_at=SYSUTCDATETIME()
-WHERE assignment_id=@p1 AND idx=@p2 AND status=0;
-`, assignmentID, idx+1); err != nil {
-				return EvalStep{}, false, err
+// loadStepScores เติม step_scores รายหัวข้อ และสรุปคะแนนของแต่ละขั้นลงใน out
+func (r *Repo) loadStepScores(ctx context.Context, formID int, out *LoadMyFormData) error {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT es.id, es.idx, es.evaluator_id, u.name, es.status, CAST(es.weight AS float)
+FROM dbo.eval_step es
+JOIN dbo.users u ON u.id = es.evaluator_id
+WHERE es.assignment_id=@p1
+ORDER BY es.idx;`, out.AssignmentID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var s StepResult
+		if err := rows.Scan(&s.StepID, &s.Idx, &s.EvaluatorID, &s.EvaluatorName, &s.Status, &s.Weight); err != nil {
+			rows.Close()
+			return err
+		}
+		out.Steps = append(out.Steps, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(out.Steps) == 0 {
+		return nil
+	}
+
+	kpiPos := make(map[int]int, len(out.KPIs))
+	for i, k := range out.KPIs {
+		kpiPos[k.ID] = i
+	}
+	compPos := make(map[int]int, len(out.Competencies))
+	for i, c := range out.Competencies {
+		compPos[c.CompID] = i
+	}
+	stepPos := make(map[int]int, len(out.Steps))
+	for i, s := range out.Steps {
+		stepPos[s.StepID] = i
+	}
+
+	rows, err = r.DB.QueryContext(ctx, `
+SELECT ss.step_id, st.idx, ss.kind, ss.item_id, CAST(ss.score AS float), ISNULL(ss.note,'')
+FROM dbo.eval_step_score ss
+JOIN dbo.eval_step st ON st.id = ss.step_id
+WHERE st.assignment_id=@p1
+ORDER BY st.idx, ss.kind, ss.item_id;`, out.AssignmentID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind string
+		var itemID int
+		var sc StepItemScore
+		if err := rows.Scan(&sc.StepID, &sc.StepIdx, &kind, &itemID, &sc.Score, &sc.Note); err != nil {
+			return err
+		}
+		switch kind {
+		case stepKindKPI:
+			if i, ok := kpiPos[itemID]; ok {
+				out.KPIs[i].StepScores = append(out.KPIs[i].StepScores, sc)
 			}
+		case stepKindComp:
+			if i, ok := compPos[itemID]; ok {
+				out.Competencies[i].StepScores = append(out.Competencies[i].StepScores, sc)
+			}
+		case stepKindTA:
+			out.Steps[stepPos[sc.StepID]].TAScore = sc.Score
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	if err = tx.Commit(); err != nil {
-		return EvalStep{}, false, err
+	// สรุปคะแนนของแต่ละขั้นด้วย Engine เดียวกับคะแนนสุดท้าย
+	cfg, err := loadScoreConfig(ctx, r.DB, formID)
+	if err != nil {
+		return err
 	}
-	return s, true, nil
+	for i := range out.Steps {
+		in := cfg
+		if in.KPIs, err = queryScoreItems(ctx, r.DB, `
+SELECT CAST(ss.score AS float), CAST(k.max_score AS float), CAST(k.weight AS float)
+FROM dbo.eval_step_score ss
+JOIN dbo.eval_kpi_user k ON k.id = ss.item_id
+WHERE ss.step_id=@p1 AND ss.kind=@p2;`, out.Steps[i].StepID, stepKindKPI); err != nil {
+			return err
+		}
+		if in.Comps, err = queryScoreItems(ctx, r.DB, `
+SELECT CAST(ss.score AS float), CAST(c.max_score AS float), CAST(c.weight AS float)
+FROM dbo.eval_step_score ss
+JOIN dbo.eval_competency c ON c.id = ss.item_id
+WHERE ss.step_id=@p1 AND ss.kind=@p2;`, out.Steps[i].StepID, stepKindComp); err != nil {
+			return err
+		}
+		for j := range in.Comps {
+			in.Comps[j].Weight = compWeightFraction(in.Comps[j].Weight) * 100
+		}
+		in.TAScore, in.TAFull = out.Steps[i].TAScore, out.TimeAttendance.FullScore
+		out.Steps[i].Summary = r.summarize(ctx, r.DB, formID, out.AssignmentID, in)
+	}
+	return nil
+}
+
+// ===== Evaluator inbox / ประเมินแทนผู้อื่น =====
+
+var (
+	ErrNotEvaluator  = errors.New("you are not an evaluator of this assignment")
+	ErrStepNotActive = errors.New("your evaluation step is not active")
+)
+
+// GetAssignment ดึง assignment ตาม id
+func (r *Repo) GetAssignment(ctx context.Context, aid int) (Assign, bool, error) {
+	var a Assign
+	err := r.DB.QueryRowContext(ctx, `
+SELECT id, form_id, user_id, status, ISNULL(CONVERT(varchar(10), due_date, 23),'')
+FROM dbo.eval_assignment WHERE id=@p1;`, aid).
+		Scan(&a.ID, &a.FormID, &a.UserID, &a.Status, &a.DueDate)
+	if err == sql.ErrNoRows {
+		return Assign{}, false, nil
+	}
+	if err != nil {
+		return Assign{}, false, err
+	}
+	return a, true, nil
+}
+
+// FindAssignment ดึง assignment ของ user กับฟอร์ม (ไม่สร้างใหม่)
+func (r *Repo) FindAssignment(ctx context.Context, formID, userID int) (Assign, bool, error) {
+	var a Assign
+	err := r.DB.QueryRowContext(ctx, `
+SELECT id, form_id, user_id, status, ISNULL(CONVERT(varchar(10), due_date, 23),'')
+FROM dbo.eval_assignment WHERE form_id=@p1 AND user_id=@p2;`, formID, userID).
+		Scan(&a.ID, &a.FormID, &a.UserID, &a.Status, &a.DueDate)
+	if err == sql.ErrNoRows {
+		return Assign{}, false, nil
+	}
+	if err != nil {
+		return Assign{}, false, err
+	}
+	return a, true, nil
+}
+
+// ListInbox: assignment ที่ฉันถือ step ที่ active อยู่
+func (r *Repo) ListInbox(ctx context.Context, evaluatorID int) ([]InboxItem, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT a.id, f.id, f.code, f.title_th, u.id, u.name, ISNULL(u.person_code,''),
+       es.id, es.idx,
+       ISNULL(CONVERT(varchar(10), a.due_date, 23),''), ISNULL(CONVERT(varchar(10), f.eval_end, 23),''),
+       a.status
+FROM dbo.eval_step es
+JOIN dbo.eval_assignment a ON a.id = es.assignment_id
+JOIN dbo.eval_form f ON f.id = a.form_id
+JOIN dbo.users u ON u.id = a.user_id
+WHERE es.evaluator_id=@p1 AND es.status=1 AND a.user_id <> @p1
+ORDER BY a.due_date, a.id;`, evaluatorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]InboxItem, 0)
+	for rows.Next() {
+		var it InboxItem
+		if err := rows.Scan(&it.AssignmentID, &it.FormID, &it.FormCode, &it.FormTitle,
+			&it.EmployeeID, &it.EmployeeName, &it.PersonCode,
+			&it.StepID, &it.StepIdx, &it.DueDate, &it.EvalEnd, &it.AssignmentStat); err != nil {
+			return nil, err
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// isEvaluatorOf: เป็นผู้ประเมินใน step ใดก็ได้ของ assignment
+func isEvaluatorOf(ctx context.Context, q querier, aid, evaluatorID int) (bool, error) {
+	var n int
+	err := q.QueryRowContext(ctx,
+		`SELECT COUNT(1) FROM dbo.eval_step WHERE assignment_id=@p1 AND evaluator_id=@p2;`,
+		aid, evaluatorID).Scan(&n)
+	return n > 0, err
+}
+
+// LoadAssignmentAsEvaluator โหลดทุกแท็บของ assignment ให้ผู้ประเมิน (ต้องมี step ของตัวเอง)
+func (r *Repo) LoadAssignmentAsEvaluator(ctx context.Context, aid, evaluatorID int) (LoadMyFormData, bool, error) {
+	a, ok, err := r.GetAssignment(ctx, aid)
+	if err != nil || !ok {
+		return LoadMyFormData{}, ok, err
+	}
+	isEval, err := isEvaluatorOf(ctx, r.DB, aid, evaluatorID)
+	if err != nil {
+		return LoadMyFormData{}, true, err
+	}
+	if !isEval {
+		return LoadMyFormData{}, true, ErrNotEvaluator
+	}
+	data, err := r.LoadMyFormData(ctx, a.FormID, a.UserID)
+	if err != nil {
+		return data, true, err
+	}
+	err = r.applyOwnStepScores(ctx, &data, evaluatorID)
+	return data, true, err
+}
+
+// applyOwnStepScores ให้ score ของแต่ละรายการเป็นคะแนนที่ evaluatorID ให้ไว้ในขั้นของตัวเอง
+// (ยังไม่ให้ = คงคะแนนของพนักงานไว้เป็นค่าตั้งต้น; คะแนนรวมอยู่ที่ final_score)
+func (r *Repo) applyOwnStepScores(ctx context.Context, data *LoadMyFormData, evaluatorID int) error {
+	own := map[int]bool{}
+	for _, s := range data.Steps {
+		if s.EvaluatorID == evaluatorID {
+			own[s.StepID] = true
+		}
+	}
+	// step_scores เรียงตาม idx → ขั้นหลังสุดของผู้ประเมินคนนี้ชนะ
+	pick := func(scores []StepItemScore, score *float64) {
+		for _, s := range scores {
+			if own[s.StepID] {
+				*score = s.Score
+			}
+		}
+	}
+	for i := range data.KPIs {
+		pick(data.KPIs[i].StepScores, &data.KPIs[i].Score)
+	}
+	for i := range data.Competencies {
+		pick(data.Competencies[i].StepScores, &data.Competencies[i].Score)
+	}
+
+	var ta float64
+	err := r.DB.QueryRowContext(ctx, `
+SELECT TOP(1) CAST(ss.score AS float)
+FROM dbo.eval_step_score ss
+JOIN dbo.eval_step st ON st.id = ss.step_id
+WHERE st.assignment_id=@p1 AND st.evaluator_id=@p2 AND ss.kind=@p3
+ORDER BY st.idx DESC;`, data.AssignmentID, evaluatorID, stepKindTA).Scan(&ta)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data.TimeAttendance.Score = ta
+	return nil
+}
+
+// SaveAsEvaluator บันทึกคะแนนของ step ที่ผู้ประเมินถืออยู่ แล้วรวมเป็นคะแนนสุดท้าย
+// status=2 → ปิด step นี้และเปิดขั้นถัดไป
+func (r *Repo) SaveAsEvaluator(ctx context.Context, a Assign, evaluatorID int, in EvaluatorSaveInput) (EvalSummary, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return EvalSummary{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var stepID int
+	err = tx.QueryRowContext(ctx, `
+SELECT TOP(1) id FROM dbo.eval_step WITH (UPDLOCK, ROWLOCK)
+WHERE assignment_id=@p1 AND evaluator_id=@p2 AND status=1
+ORDER BY idx;`, a.ID, evaluatorID).Scan(&stepID)
+	if err == sql.ErrNoRows {
+		var isEval bool
+		if isEval, err = isEvaluatorOf(ctx, tx, a.ID, evaluatorID); err != nil {
+			return EvalSummary{}, err
+		}
+		err = ErrNotEvaluator
+		if isEval {
+			err = ErrStepNotActive
+		}
+		return EvalSummary{}, err
+	}
+	if err != nil {
+		return EvalSummary{}, err
+	}
+
+	// เริ่มประเมินครั้งแรก → in_review
+	var aStatus int
+	if err = tx.QueryRowContext(ctx, `SELECT status FROM dbo.eval_assignment WITH (UPDLOCK, ROWLOCK) WHERE id=@p1;`, a.ID).Scan(&aStatus); err != nil {
+		return EvalSummary{}, err
+	}
+	if aStatus != AssignInReview {
+		if err = transition(ctx, tx, a.ID, AssignInReview, evaluatorID, stepID, ActionStartReview, ""); err != nil {
+			return EvalSummary{}, err
+		}
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+MERGE dbo.eval_step_score AS t
+USING (SELECT @p1 AS step_id, @p2 AS kind, @p3 AS item_id, @p4 AS score, NULLIF(@p5,'') AS note) s
+ON (t.step_id = s.step_id AND t.kind = s.kind AND t.item_id = s.item_id)
+WHEN MATCHED THEN UPDATE SET score=s.score, note=s.note, updated_at=SYSUTCDATETIME()
+WHEN NOT MATCHED THEN INSERT(step_id, kind, item_id, score, note) VALUES(s.step_id, s.kind, s.item_id, s.score, s.note);`)
+	if err != nil {
+		return EvalSummary{}, err
+	}
+	defer stmt.Close()
+
+	for _, it := range in.KPIScores {
+		if _, err = stmt.ExecContext(ctx, stepID, stepKindKPI, it.ID, it.Score, it.Note); err != nil {
+			return EvalSummary{}, err
+		}
+		if it.ActualValue != nil {
+			if _, err = tx.ExecContext(ctx, `
+UPDATE dbo.eval_kpi_user SET actual_value=@p3, score_override=@p4, updated_at=SYSUTCDATETIME()
+WHERE id=@p2 AND assignment_id=@p1;`, a.ID, it.ID, *it.ActualValue, it.ScoreOverride); err != nil {
+				return EvalSummary{}, err
+			}
+		}
+	}
+	for _, it := range in.CompetencyScores {
+		if _, err = stmt.ExecContext(ctx, stepID, stepKindComp, it.ID, it.Score, it.Note); err != nil {
+			return EvalSummary{}, err
+		}
+	}
+	if in.TAScore != nil {
+		if _, err = stmt.ExecContext(ctx, stepID, stepKindTA, 0, *in.TAScore, ""); err != nil {
+			return EvalSummary{}, err
+		}
+	}
+
+	if err = applyFinalScores(ctx, tx, a.ID); err != nil {
+		return EvalSummary{}, err
+	}
+	if in.Status == StepDone {
+		if err = r.completeActiveStepAndOpenNext(ctx, tx, a.ID, evaluatorID); err != nil {
+			return EvalSummary{}, err
+		}
+	}
+
+	score, err := loadScoreConfig(ctx, tx, a.FormID)
+	if err != nil {
+		return EvalSummary{}, err
+	}
+	if err = loadScoreItems(ctx, tx, a.ID, &score); err != nil {
+		return EvalSummary{}, err
+	}
+	sum := r.summarize(ctx, tx, a.FormID, a.ID, score)
+
+	if err = tx.Commit(); err != nil {
+		return EvalSummary{}, err
+	}
+	return sum, nil
+}
+
+// ===== Workflow transitions =====
+
+var ErrNotOwner = errors.New("only the evaluated employee can do this")
+
+// transition เปลี่ยนสถานะ assignment ตาม state machine แล้วบันทึก log
+// (to เท่ากับสถานะปัจจุบัน = บันทึก log อย่างเดียว)
+func transition(ctx context.Context, tx *sql.Tx, aid, to, actorID, stepID int, action, comment string) error {
+	var from int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT status FROM dbo.eval_assignment WITH (UPDLOCK, ROWLOCK) WHERE id=@p1;`, aid,
+	).Scan(&from); err != nil {
+		return err
+	}
+	if from != to {
+		if !CanTransition(from, to) {
+			return illegalTransition(from, to)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE dbo.eval_assignment SET status=@p2, updated_at=SYSUTCDATETIME() WHERE id=@p1;`, aid, to,
+		); err != nil {
+			return err
+		}
+	}
+	return logTransition(ctx, tx, aid, stepID, action, from, to, actorID, comment)
+}
+
+// logTransition: from/to < 0 = ใช้สถานะปัจจุบันของ assignment (เหตุการณ์ระดับ step)
+func logTransition(ctx context.Context, tx *sql.Tx, aid, stepID int, action string, from, to, actorID int, comment string) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_transition_log(assignment_id, step_id, action, from_status, to_status, actor_id, comment)
+SELECT a.id, NULLIF(@p2,0), @p3,
+       CASE WHEN @p4 < 0 THEN a.status ELSE @p4 END,
+       CASE WHEN @p5 < 0 THEN a.status ELSE @p5 END,
+       NULLIF(@p6,0), NULLIF(@p7,'')
+FROM dbo.eval_assignment a WHERE a.id=@p1;`,
+		aid, stepID, action, from, to, actorID, comment)
+	return err
+}
+
+// ReturnToPreviousStep ผู้ถือ step ที่ active ส่งกลับขั้นก่อนหน้า (ถ้าก่อนหน้าคือ self → draft)
+func (r *Repo) ReturnToPreviousStep(ctx context.Context, aid, actorID int, comment string) (EvalStep, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return EvalStep{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var stepID, idx, evaluatorID int
+	err = tx.QueryRowContext(ctx, `
+SELECT TOP(1) id, idx, evaluator_id
+FROM dbo.eval_step WITH (UPDLOCK, ROWLOCK)
+WHERE assignment_id=@p1 AND status=1
+ORDER BY idx;`, aid).Scan(&stepID, &idx, &evaluatorID)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("%w: no active step to return from", ErrIllegalTransition)
+		return EvalStep{}, err
+	}
+	if err != nil {
+		return EvalStep{}, err
+	}
+	if evaluatorID != actorID {
+		var isEval bool
+		if isEval, err = isEvaluatorOf(ctx, tx, aid, actorID); err != nil {
+			return EvalStep{}, err
+		}
+		err = ErrNotEvaluator
+		if isEval {
+			err = ErrStepNotActive
+		}
+		return EvalStep{}, err
+	}
+	if idx == 1 {
+		err = fmt.Errorf("%w: the first step has no previous step", ErrIllegalTransition)
+		return EvalStep{}, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+UPDATE dbo.eval_step SET status=0, eval_date=NULL, updated_at=SYSUTCDATETIME() WHERE id=@p1;
+UPDATE dbo.eval_step SET status=1, eval_date=NULL, updated_at=SYSUTCDATETIME()
+WHERE assignment_id=@p2 AND idx=@p3;`, stepID, aid, idx-1); err != nil {
+		return EvalStep{}, err
+	}
+
+	to := AssignInReview
+	if idx-1 == 1 {
+		to = AssignDraft
+	}
+	if err = transition(ctx, tx, aid, to, actorID, stepID, ActionReturn, comment); err != nil {
+		return EvalStep{}, err
+	}
+
+	var prevID int
+	if err = tx.QueryRowContext(ctx, `SELECT id FROM dbo.eval_step WHERE assignment_id=@p1 AND idx=@p2;`, aid, idx-1).Scan(&prevID); err != nil {
+		return EvalStep{}, err
+	}
+	prev, err := getStep(ctx, tx, prevID)
+	if err != nil {
+		return EvalStep{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return EvalStep{}, err
+	}
+	return prev, nil
+}
+
+// Acknowledge พนักงานรับทราบผลที่อนุมัติแล้ว
+func (r *Repo) Acknowledge(ctx context.Context, a Assign, actorID int, comment string) error {
+	if a.UserID != actorID {
+		return ErrNotOwner
+	}
+	return r.moveAssignment(ctx, a.ID, AssignAcknowledged, actorID, ActionAcknowledge, comment)
+}
+
+// Close ปิดรอบการประเมิน (HR)
+func (r *Repo) Close(ctx context.Context, aid, actorID int, comment string) error {
+	return r.moveAssignment(ctx, aid, AssignClosed, actorID, ActionClose, comment)
+}
+
+func (r *Repo) moveAssignment(ctx context.Context, aid, to, actorID int, action, comment string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var from int
+	if err = tx.QueryRowContext(ctx,
+		`SELECT status FROM dbo.eval_assignment WITH (UPDLOCK, ROWLOCK) WHERE id=@p1;`, aid,
+	).Scan(&from); err == nil && from == to {
+		err = illegalTransition(from, to) // ซ้ำ = ไม่อนุญาต
+	}
+	if err == nil {
+		err = transition(ctx, tx, aid, to, actorID, 0, action, comment)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// CanViewAssignment: พนักงานเจ้าของ หรือผู้ประเมินใน step ใดก็ได้
+func (r *Repo) CanViewAssignment(ctx context.Context, a Assign, userID int) (bool, error) {
+	if a.UserID == userID {
+		return true, nil
+	}
+	return isEvaluatorOf(ctx, r.DB, a.ID, userID)
+}
+
+func (r *Repo) ListTransitions(ctx context.Context, aid int) ([]TransitionLog, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT l.id, l.assignment_id, l.step_id, l.action, l.from_status, l.to_status,
+       l.actor_id, ISNULL(u.name,''), ISNULL(l.comment,''), l.created_at
+FROM dbo.eval_transition_log l
+LEFT JOIN dbo.users u ON u.id = l.actor_id
+WHERE l.assignment_id=@p1
+ORDER BY l.created_at, l.id;`, aid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]TransitionLog, 0)
+	for rows.Next() {
+		var t TransitionLog
+		var stepID, actorID sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.AssignmentID, &stepID, &t.Action, &t.FromStatus, &t.ToStatus,
+			&actorID, &t.ActorName, &t.Comment, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		if stepID.Valid {
+			v := int(stepID.Int64)
+			t.StepID = &v
+		}
+		if actorID.Valid {
+			v := int(actorID.Int64)
+			t.ActorID = &v
+		}
+		t.From = AssignStatusName(t.FromStatus)
+		t.To = AssignStatusName(t.ToStatus)
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// ===== PDF export =====
+
+var ErrNotFinished = errors.New("evaluation is not finished yet")
+
+// LoadPrintData รวมข้อมูลสำหรับพิมพ์ (เฉพาะ assignment ที่อนุมัติแล้วขึ้นไป)
+func (r *Repo) LoadPrintData(ctx context.Context, a Assign) (PrintData, error) {
+	if a.Status < AssignApproved {
+		return PrintData{}, ErrNotFinished
+	}
+	var out PrintData
+	form, ok, err := r.GetForm(ctx, a.FormID)
+	if err != nil {
+		return out, err
+	}
+	if !ok {
+		return out, sql.ErrNoRows
+	}
+	out.Form = form
+
+	e := &out.Employee
+	err = r.DB.QueryRowContext(ctx, `
+SELECT id, ISNULL(person_code,''), name, ISNULL(position,''), ISNULL(department,''),
+       ISNULL(CONVERT(varchar(10), start_date, 23),'')
+FROM dbo.users WHERE id=@p1;`, a.UserID).
+		Scan(&e.ID, &e.PersonCode, &e.Name, &e.Position, &e.Department, &e.StartDate)
+	if err != nil {
+		return out, err
+	}
+
+	if out.Data, err = r.LoadMyFormData(ctx, a.FormID, a.UserID); err != nil {
+		return out, err
+	}
+	out.Steps, err = r.ListEvalSteps(ctx, a.FormID, a.UserID)
+	return out, err
+}
+
+// ===== Bulk assignment =====
+
+type assignee struct {
+	ID   int
+	Name string
+}
+
+// selectAssignees: ผู้ใช้ที่ตรงทุกเงื่อนไขที่ส่งมา (บริษัท AND แผนก AND ตำแหน่ง) รวมกับรายชื่อที่ระบุ
+func (r *Repo) selectAssignees(ctx context.Context, in BulkAssignInput) ([]assignee, error) {
+	companies, _ := json.Marshal(in.CompanyIDs)
+	departments, _ := json.Marshal(in.Departments)
+	positions, _ := json.Marshal(in.Positions)
+	userIDs, _ := json.Marshal(in.UserIDs)
+
+	rows, err := r.DB.QueryContext(ctx, `
+DECLARE @hasFilter bit = CASE WHEN @p1 <> '[]' OR @p2 <> '[]' OR @p3 <> '[]' THEN 1 ELSE 0 END;
+SELECT u.id, u.name
+FROM dbo.users u
+WHERE u.id IN (SELECT TRY_CAST(value AS int) FROM OPENJSON(@p4))
+   OR (@hasFilter = 1
+       AND (@p1 = '[]' OR EXISTS (SELECT 1 FROM dbo.user_companies uc
+                                  WHERE uc.user_id = u.id
+                                    AND uc.company_id IN (SELECT TRY_CAST(value AS uniqueidentifier) FROM OPENJSON(@p1))))
+       AND (@p2 = '[]' OR u.department IN (SELECT value FROM OPENJSON(@p2)))
+       AND (@p3 = '[]' OR u.position   IN (SELECT value FROM OPENJSON(@p3))))
+ORDER BY u.id;`, string(nullToEmpty(companies)), string(nullToEmpty(departments)), string(nullToEmpty(positions)), string(nullToEmpty(userIDs)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []assignee
+	for rows.Next() {
+		var a assignee
+		if err := rows.Scan(&a.ID, &a.Name); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// json.Marshal(nil slice) = "null" → ใช้ "[]" แทน
+func nullToEmpty(b []byte) []byte {
+	if string(b) == "null" {
+		return []byte("[]")
+	}
+	return b
+}
+
+// managerChain: self + หัวหน้าตาม users.manager_id ขึ้นไป levels ชั้น (หยุดเมื่อไม่มีหัวหน้า/วนซ้ำ)
+func managerChain(ctx context.Context, q querier, userID, levels int) ([]int, error) {
+	chain := []int{userID}
+	seen := map[int]bool{userID: true}
+	cur := userID
+	for len(chain) <= levels {
+		var mgr sql.NullInt64
+		if err := q.QueryRowContext(ctx, `SELECT manager_id FROM dbo.users WHERE id=@p1;`, cur).Scan(&mgr); err != nil {
+			return nil, err
+		}
+		if !mgr.Valid || seen[int(mgr.Int64)] {
+			break
+		}
+		cur = int(mgr.Int64)
+		seen[cur] = true
+		chain = append(chain, cur)
+	}
+	return chain, nil
+}
+
+// BulkAssign มอบหมายฟอร์มให้ผู้ใช้ที่เลือก พร้อมสร้าง chain ของ eval_step
+// ผู้ที่มี assignment พร้อม chain อยู่แล้ว (uq_eval_assignment) จะได้ผล "exists" และไม่ถูกแก้ไข
+// assignment ที่ยังไม่มี step (สร้างจาก EnsureAssignment) จะถูกเติม chain ให้ → "steps_added"
+func (r *Repo) BulkAssign(ctx context.Context, formID int, in BulkAssignInput) ([]BulkAssignResult, error) {
+	users, err := r.selectAssignees(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	due := strings.TrimSpace(in.DueDate)
+	if due == "" {
+		due = today()
+	}
+
+	out := make([]BulkAssignResult, 0, len(users))
+	for _, u := range users {
+		res := BulkAssignResult{UserID: u.ID, Name: u.Name}
+		aid, steps, existed, weightsIgnored, err := r.assignOne(ctx, formID, u.ID, due, in.Levels, in.StepWeights)
+		switch {
+		case errors.Is(err, errAssignmentExists):
+			res.Result = BulkExists
+			res.AssignmentID = aid
+		case err != nil:
+			res.Result = BulkFailed
+			res.Error = err.Error()
+		default:
+			res.Result = BulkCreated
+			if existed {
+				res.Result = BulkStepsAdded
+			}
+			res.AssignmentID = aid
+			res.Steps = steps
+			if weightsIgnored {
+				res.Warning = fmt.Sprintf("manager chain has %d steps but %d step_weights were given: step weights not applied, the latest step's score is used", steps, len(in.StepWeights))
+			}
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+var errAssignmentExists = errors.New("assignment already exists")
+
+// assignOne สร้าง assignment + chain หรือเติม chain ให้ assignment เดิมที่ยังไม่มี step (existed=true)
+// weightsIgnored = chain สั้นกว่าจำนวนน้ำหนักที่ส่งมา จึงไม่ได้ตั้งน้ำหนักรายขั้น
+func (r *Repo) assignOne(ctx context.Context, formID, userID int, due string, levels int, weights []float64) (aid, steps int, existed, weightsIgnored bool, err error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, false, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var status int
+	err = tx.QueryRowContext(ctx, `
+SELECT id, status FROM dbo.eval_assignment WITH (UPDLOCK, HOLDLOCK)
+WHERE form_id=@p1 AND user_id=@p2;`, formID, userID).Scan(&aid, &status)
+	switch {
+	case err == nil:
+		var n int
+		if err = tx.QueryRowContext(ctx,
+			`SELECT COUNT(1) FROM dbo.eval_step WHERE assignment_id=@p1;`, aid).Scan(&n); err != nil {
+			return 0, 0, false, false, err
+		}
+		if n > 0 {
+			err = errAssignmentExists
+			return aid, 0, true, false, err
+		}
+		existed = true
+	case err == sql.ErrNoRows:
+		if err = tx.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_assignment(form_id, user_id, status, due_date)
+OUTPUT inserted.id
+VALUES(@p1, @p2, 0, @p3);`, formID, userID, due).Scan(&aid); err != nil {
+			return 0, 0, false, false, err
+		}
+	default:
+		return 0, 0, false, false, err
+	}
+
+	chain, err := managerChain(ctx, tx, userID, levels)
+	if err != nil {
+		return 0, 0, false, false, err
+	}
+	if len(weights) > 0 && len(weights) != len(chain) {
+		weights = nil // chain สั้นกว่าที่ตั้งไว้ (ไม่มีหัวหน้า) → ใช้คะแนนขั้นล่าสุด
+		weightsIgnored = true
+	}
+	if _, err = insertSteps(ctx, tx, aid, chain, weights); err != nil {
+		return 0, 0, false, false, err
+	}
+	switch {
+	case !existed || status == AssignDraft:
+	case status == AssignSubmitted || status == assignLegacySubmitted || status == AssignInReview:
+		// พนักงานส่งแบบประเมินตนเองไปแล้ว → ขั้น self เสร็จ เปิดขั้นถัดไป
+		if _, err = tx.ExecContext(ctx, `
+UPDATE dbo.eval_step
+SET status = CASE idx WHEN 1 THEN 2 ELSE 1 END, updated_at=SYSUTCDATETIME()
+WHERE assignment_id=@p1 AND idx IN (1, 2);`, aid); err != nil {
+			return 0, 0, false, false, err
+		}
+	default:
+		// อนุมัติ / รับทราบ / ปิดแล้ว → chain ถือว่าประเมินครบ ไม่เปิดขั้นใดอีก
+		if _, err = tx.ExecContext(ctx,
+			`UPDATE dbo.eval_step SET status=2, updated_at=SYSUTCDATETIME() WHERE assignment_id=@p1;`, aid); err != nil {
+			return 0, 0, false, false, err
+		}
+	}
+	if !existed { // assignment เดิมได้แผนพัฒนาตกค้างไปแล้วตอน EnsureAssignment
+		if _, err = carryOverDevPlan(ctx, tx, aid); err != nil {
+			return 0, 0, false, false, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, false, false, err
+	}
+	return aid, len(chain), existed, weightsIgnored, nil
+}
+
+// ===== Calibration =====
+
+var (
+	ErrCalibrationLocked = errors.New("calibration session is locked")
+	ErrWrongForm         = errors.New("assignment does not belong to the session's form")
+)
+
+// DeleteUserData ลบข้อมูลประเมินที่อ้างถึง user แบบไม่ cascade ก่อนลบ user (ผูกกับ user.Repo.BeforeDelete)
+// eval_calibration_item ชี้ assignment แบบไม่ cascade เพราะ eval_form มีสองเส้นทาง cascade มาถึง,
+// eval_enrolment.user_id ไม่ cascade เพราะ users มาถึงได้อีกทางผ่าน dev_plan_id
+func DeleteUserData(ctx context.Context, tx *sql.Tx, userID int) error {
+	if _, err := tx.ExecContext(ctx, `
+DELETE ci FROM dbo.eval_calibration_item ci
+JOIN dbo.eval_assignment a ON a.id = ci.assignment_id
+WHERE a.user_id=@p1;`, userID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_enrolment WHERE user_id=@p1;`, userID)
+	return err
+}
+
+func (r *Repo) CreateCalibration(ctx context.Context, actorID int, in CalibrationInput) (Calibration, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Calibration{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var id int
+	if err = tx.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_calibration(form_id, name, created_by)
+OUTPUT inserted.id
+VALUES(@p1, @p2, NULLIF(@p3,0));`, in.FormID, in.Name, actorID).Scan(&id); err != nil {
+		return Calibration{}, err
+	}
+	if err = replaceTargets(ctx, tx, id, in.Targets); err != nil {
+		return Calibration{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Calibration{}, err
+	}
+	c, _, err := r.getCalibration(ctx, id)
+	return c, err
+}
+
+func replaceTargets(ctx context.Context, tx *sql.Tx, id int, targets []CalibrationTarget) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_calibration_target WHERE calibration_id=@p1;`, id); err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO dbo.eval_calibration_target(calibration_id, grade, target_pct) VALUES(@p1, @p2, @p3);`,
+			id, strings.TrimSpace(t.Grade), t.TargetPct); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const calibrationCols = `c.id, c.form_id, c.name, c.status, c.locked_at, c.created_at`
+
+func scanCalibration(sc rowScanner) (Calibration, error) {
+	var c Calibration
+	var lockedAt sql.NullTime
+	if err := sc.Scan(&c.ID, &c.FormID, &c.Name, &c.Status, &lockedAt, &c.CreatedAt); err != nil {
+		return Calibration{}, err
+	}
+	if lockedAt.Valid {
+		c.LockedAt = &lockedAt.Time
+	}
+	c.Locked = c.Status == CalibrationLocked
+	c.Targets = make([]CalibrationTarget, 0)
+	return c, nil
+}
+
+func (r *Repo) ListCalibrations(ctx context.Context, formID int) ([]Calibration, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT `+calibrationCols+`
+FROM dbo.eval_calibration c
+WHERE (@p1 = 0 OR c.form_id = @p1)
+ORDER BY c.created_at DESC, c.id DESC;`, formID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Calibration, 0)
+	for rows.Next() {
+		c, err := scanCalibration(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// getCalibration: session + targets (ไม่รวมรายการ)
+func (r *Repo) getCalibration(ctx context.Context, id int) (Calibration, bool, error) {
+	c, err := scanCalibration(r.DB.QueryRowContext(ctx, `
+SELECT `+calibrationCols+` FROM dbo.eval_calibration c WHERE c.id=@p1;`, id))
+	if err == sql.ErrNoRows {
+		return Calibration{}, false, nil
+	}
+	if err != nil {
+		return Calibration{}, false, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT grade, CAST(target_pct AS float)
+FROM dbo.eval_calibration_target
+WHERE calibration_id=@p1
+ORDER BY grade;`, id)
+	if err != nil {
+		return Calibration{}, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t CalibrationTarget
+		if err := rows.Scan(&t.Grade, &t.TargetPct); err != nil {
+			return Calibration{}, false, err
+		}
+		c.Targets = append(c.Targets, t)
+	}
+	return c, true, rows.Err()
+}
+
+// GetCalibration อ่านอย่างเดียว: แสดงเกรดที่คำนวณไว้ล่าสุด
+// (คำนวณใหม่ตอนเพิ่มรายการ, POST /refresh และตอนล็อก)
+func (r *Repo) GetCalibration(ctx context.Context, id int) (CalibrationDetail, bool, error) {
+	c, ok, err := r.getCalibration(ctx, id)
+	if err != nil || !ok {
+		return CalibrationDetail{}, ok, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT ci.assignment_id, u.id, u.name, ISNULL(u.department,''),
+       CAST(ci.calc_pct AS float), ci.calc_grade, ISNULL(ci.final_grade,''), ISNULL(ci.reason,'')
+FROM dbo.eval_calibration_item ci
+JOIN dbo.eval_assignment a ON a.id = ci.assignment_id
+JOIN dbo.users u ON u.id = a.user_id
+WHERE ci.calibration_id=@p1
+ORDER BY ci.calc_pct DESC, u.name;`, id)
+	if err != nil {
+		return CalibrationDetail{}, true, err
+	}
+	defer rows.Close()
+
+	out := CalibrationDetail{Calibration: c, Items: make([]CalibrationItem, 0)}
+	for rows.Next() {
+		var it CalibrationItem
+		var final string
+		if err := rows.Scan(&it.AssignmentID, &it.UserID, &it.Name, &it.Department,
+			&it.CalcPct, &it.CalcGrade, &final, &it.Reason); err != nil {
+			return CalibrationDetail{}, true, err
+		}
+		it.FinalGrade = it.CalcGrade
+		if final != "" {
+			it.FinalGrade, it.Overridden = final, true
+		}
+		out.Items = append(out.Items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return CalibrationDetail{}, true, err
+	}
+	out.Distribution = gradeDistribution(out.Items, c.Targets)
+	return out, true, nil
+}
+
+// refreshCalibration คำนวณ calc_pct / calc_grade ของทุกรายการใหม่
+func (r *Repo) refreshCalibration(ctx context.Context, db dbtx, c Calibration) error {
+	rows, err := db.QueryContext(ctx,
+		`SELECT assignment_id FROM dbo.eval_calibration_item WHERE calibration_id=@p1;`, c.ID)
+	if err != nil {
+		return err
+	}
+	var aids []int
+	for rows.Next() {
+		var aid int
+		if err := rows.Scan(&aid); err != nil {
+			rows.Close()
+			return err
+		}
+		aids = append(aids, aid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, aid := range aids {
+		sum, err := r.computeSummary(ctx, db, c.FormID, aid)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, `
+UPDATE dbo.eval_calibration_item SET calc_pct=@p3, calc_grade=@p4
+WHERE calibration_id=@p1 AND assignment_id=@p2;`, c.ID, aid, sum.TotalPct, sum.Grade); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gradeDistribution นับเกรดสุดท้ายเทียบเป้า (เรียงตามเป้า แล้วตามด้วยเกรดที่ไม่มีเป้า)
+func gradeDistribution(items []CalibrationItem, targets []CalibrationTarget) []GradeDistribution {
+	counts := map[string]int{}
+	for _, it := range items {
+		counts[it.FinalGrade]++
+	}
+
+	out := make([]GradeDistribution, 0, len(targets)+len(counts))
+	seen := map[string]bool{}
+	add := func(grade string, target float64) {
+		d := GradeDistribution{Grade: grade, Count: counts[grade], TargetPct: target}
+		if len(items) > 0 {
+			d.Pct = round2(float64(d.Count) / float64(len(items)) * 100)
+		}
+		d.DiffPct = round2(d.Pct - d.TargetPct)
+		out = append(out, d)
+		seen[grade] = true
+	}
+	for _, t := range targets {
+		add(t.Grade, t.TargetPct)
+	}
+	var rest []string
+	for g := range counts {
+		if !seen[g] {
+			rest = append(rest, g)
+		}
+	}
+	sort.Strings(rest)
+	for _, g := range rest {
+		add(g, 0)
+	}
+	return out
+}
+
+// openCalibration ตรวจว่า session มีอยู่และยังไม่ล็อก (คืน form_id)
+// อ่านแบบ UPDLOCK: เรียกใน tx เดียวกับการเขียน เพื่อกันการล็อก session แทรกระหว่างตรวจกับเขียน
+func openCalibration(ctx context.Context, q querier, id int) (int, bool, error) {
+	var formID, status int
+	err := q.QueryRowContext(ctx,
+		`SELECT form_id, status FROM dbo.eval_calibration WITH (UPDLOCK, ROWLOCK) WHERE id=@p1;`, id).Scan(&formID, &status)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if status == CalibrationLocked {
+		return formID, true, ErrCalibrationLocked
+	}
+	return formID, true, nil
+}
+
+func (r *Repo) SetCalibrationTargets(ctx context.Context, id int, targets []CalibrationTarget) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, ok, err := openCalibration(ctx, tx, id); err != nil || !ok {
+		return ok, err
+	}
+	if err := replaceTargets(ctx, tx, id, targets); err != nil {
+		return true, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE dbo.eval_calibration SET updated_at=SYSUTCDATETIME() WHERE id=@p1;`, id); err != nil {
+		return true, err
+	}
+	return true, tx.Commit()
+}
+
+// AddCalibrationItems เพิ่ม assignment เข้า session (ที่อยู่ session อื่นแล้วจะถูกข้าม)
+// แล้วคำนวณเกรดของทุกรายการใหม่ใน tx เดียวกัน
+func (r *Repo) AddCalibrationItems(ctx context.Context, id int, aids []int) (added, skipped []int, ok bool, err error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	formID, ok, err := openCalibration(ctx, tx, id)
+	if err != nil || !ok {
+		return nil, nil, ok, err
+	}
+
+	added, skipped = make([]int, 0), make([]int, 0)
+	for _, aid := range aids {
+		var aFormID int
+		err := tx.QueryRowContext(ctx, `SELECT form_id FROM dbo.eval_assignment WHERE id=@p1;`, aid).Scan(&aFormID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, nil, true, err
+		}
+		if err == sql.ErrNoRows || aFormID != formID {
+			return nil, nil, true, fmt.Errorf("%w: %d", ErrWrongForm, aid)
+		}
+		res, err := tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_calibration_item(calibration_id, assignment_id)
+SELECT @p1, @p2
+WHERE NOT EXISTS (SELECT 1 FROM dbo.eval_calibration_item WITH (UPDLOCK, HOLDLOCK) WHERE assignment_id=@p2);`, id, aid)
+		if err != nil {
+			return nil, nil, true, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			skipped = append(skipped, aid)
+			continue
+		}
+		added = append(added, aid)
+	}
+	if err := r.refreshCalibration(ctx, tx, Calibration{ID: id, FormID: formID}); err != nil {
+		return nil, nil, true, err
+	}
+	return added, skipped, true, tx.Commit()
+}
+
+// RefreshCalibration คำนวณเกรดของทุกรายการใหม่ (session ที่ยังเปิดอยู่)
+func (r *Repo) RefreshCalibration(ctx context.Context, id int) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	formID, ok, err := openCalibration(ctx, tx, id)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err := r.refreshCalibration(ctx, tx, Calibration{ID: id, FormID: formID}); err != nil {
+		return true, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE dbo.eval_calibration SET updated_at=SYSUTCDATETIME() WHERE id=@p1;`, id); err != nil {
+		return true, err
+	}
+	return true, tx.Commit()
+}
+
+func (r *Repo) RemoveCalibrationItem(ctx context.Context, id, aid int) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, ok, err := openCalibration(ctx, tx, id); err != nil || !ok {
+		return ok, err
+	}
+	res, err := tx.ExecContext(ctx,
+		`DELETE FROM dbo.eval_calibration_item WHERE calibration_id=@p1 AND assignment_id=@p2;`, id, aid)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+// OverrideGrade HR ปรับเกรด (grade "" = ยกเลิก) — เกรดคำนวณยังเก็บไว้ใน calc_grade
+func (r *Repo) OverrideGrade(ctx context.Context, id, aid, actorID int, in CalibrationOverrideInput) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, ok, err := openCalibration(ctx, tx, id); err != nil || !ok {
+		return ok, err
+	}
+	res, err := tx.ExecContext(ctx, `
+UPDATE dbo.eval_calibration_item
+SET final_grade=NULLIF(@p3,''), reason=NULLIF(@p4,''),
+    overridden_by=NULLIF(@p5,0), overridden_at=SYSUTCDATETIME()
+WHERE calibration_id=@p1 AND assignment_id=@p2;`,
+		id, aid, strings.TrimSpace(in.Grade), strings.TrimSpace(in.Reason), actorID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// dbtx: *sql.DB หรือ *sql.Tx (อ่าน + เขียน)
+type dbtx interface {
+	querier
+	execer
+}
+
+// LockCalibration คำนวณเกรดครั้งสุดท้ายแล้วล็อก (ค่าหลังจากนี้ไม่เปลี่ยน)
+// คำนวณ + เปลี่ยนสถานะใน tx เดียว โดยล็อกแถว session (UPDLOCK) กันการล็อกซ้อน
+func (r *Repo) LockCalibration(ctx context.Context, id, actorID int) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	formID, ok, err := openCalibration(ctx, tx, id)
+	if err != nil || !ok {
+		return ok, err
+	}
+	if err := r.refreshCalibration(ctx, tx, Calibration{ID: id, FormID: formID}); err != nil {
+		return true, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE dbo.eval_calibration
+SET status=@p2, locked_by=NULLIF(@p3,0), locked_at=SYSUTCDATETIME(), updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`, id, CalibrationLocked, actorID); err != nil {
+		return true, err
+	}
+	return true, tx.Commit()
+}
+
+// ===== HR reports (คำนวณใน SQL ทั้งหมด) =====
+
+// reportBase: assignment ของฟอร์ม @p1 ที่ผ่านตัวกรอง @p2 department, @p3 position, @p4 company_id
+// @p5 = วันนี้ (ใช้หา overdue: เลย due_date และยังไม่ถึง approved)
+const reportBase = `
+FROM dbo.eval_assignment a
+JOIN dbo.users u ON u.id = a.user_id
+WHERE a.form_id=@p1
+  AND (@p2 = '' OR u.department = @p2)
+  AND (@p3 = '' OR u.position = @p3)
+  AND (@p4 = '' OR EXISTS (SELECT 1 FROM dbo.user_companies fc
+                           WHERE fc.user_id = u.id AND fc.company_id = TRY_CAST(@p4 AS uniqueidentifier)))`
+
+const overdueExpr = `CASE WHEN a.due_date < CAST(@p5 AS date) AND a.status IN (0,1,2,3) THEN 1 ELSE 0 END`
+
+func reportArgs(formID int, f ReportFilter, asOf string) []any {
+	return []any{formID, strings.TrimSpace(f.Department), strings.TrimSpace(f.Position), strings.TrimSpace(f.CompanyID), asOf}
+}
+
+// scoredBase: reportBase + @p6 สถานะขั้นต่ำ (analytics ไม่นับ draft เว้นแต่ขอ status=all)
+const scoredBase = reportBase + `
+  AND a.status >= @p6`
+
+func scoredArgs(formID int, f ReportFilter) []any {
+	return append(reportArgs(formID, f, today()), minReportStatus(f.Status))
+}
+
+func minReportStatus(s string) int {
+	switch s {
+	case ReportStatusAll:
+		return AssignDraft
+	case ReportStatusApproved:
+		return AssignApproved
+	}
+	return assignLegacySubmitted // submitted แบบเก่า (1) ขึ้นไป
+}
+
+func (r *Repo) ProgressReport(ctx context.Context, formID int, f ReportFilter) (ProgressReport, error) {
+	out := ProgressReport{FormID: formID, AsOf: today(), ByStatus: make([]StatusCount, 0), ByStep: make([]StepCount, 0)}
+	args := reportArgs(formID, f, out.AsOf)
+
+	if err := r.DB.QueryRowContext(ctx,
+		`SELECT COUNT(1), ISNULL(SUM(`+overdueExpr+`),0) `+reportBase+`;`, args...,
+	).Scan(&out.Total, &out.Overdue); err != nil {
+		return out, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT a.status, COUNT(1) `+reportBase+` GROUP BY a.status ORDER BY a.status;`, args...)
+	if err != nil {
+		return out, err
+	}
+	for rows.Next() {
+		var sc StatusCount
+		if err := rows.Scan(&sc.Status, &sc.Count); err != nil {
+			rows.Close()
+			return out, err
+		}
+		sc.Name = AssignStatusName(sc.Status)
+		out.ByStatus = append(out.ByStatus, sc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return out, err
+	}
+
+	rows, err = r.DB.QueryContext(ctx, `
+SELECT ISNULL(s.idx,0), COUNT(1)
+FROM (SELECT a.id `+reportBase+`) x
+OUTER APPLY (SELECT TOP(1) es.idx FROM dbo.eval_step es
+             WHERE es.assignment_id = x.id AND es.status = 1 ORDER BY es.idx) s
+GROUP BY ISNULL(s.idx,0)
+ORDER BY 1;`, args...)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sc StepCount
+		if err := rows.Scan(&sc.StepIdx, &sc.Count); err != nil {
+			return out, err
+		}
+		out.ByStep = append(out.ByStep, sc)
+	}
+	return out, rows.Err()
+}
+
+// Breakdown แยกตาม department หรือ company (ผู้ใช้หลายบริษัทนับในทุกบริษัท)
+func (r *Repo) Breakdown(ctx context.Context, formID int, by string, f ReportFilter) ([]BreakdownRow, error) {
+	var keyExpr, nameExpr, join string
+	switch by {
+	case "company":
+		keyExpr, nameExpr = "ISNULL(CAST(c.id AS nvarchar(36)),'')", "ISNULL(c.name,'')"
+		join = `
+LEFT JOIN dbo.user_companies uc ON uc.user_id = x.user_id
+LEFT JOIN dbo.companies c ON c.id = uc.company_id`
+	default:
+		keyExpr, nameExpr = "x.department", "x.department"
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT `+keyExpr+`, `+nameExpr+`,
+       COUNT(1),
+       SUM(CASE WHEN x.status = 0 THEN 1 ELSE 0 END),
+       SUM(CASE WHEN x.status IN (1,2) THEN 1 ELSE 0 END),
+       SUM(CASE WHEN x.status = 3 THEN 1 ELSE 0 END),
+       SUM(CASE WHEN x.status = 4 THEN 1 ELSE 0 END),
+       SUM(CASE WHEN x.status = 5 THEN 1 ELSE 0 END),
+       SUM(CASE WHEN x.status = 6 THEN 1 ELSE 0 END),
+       SUM(x.overdue)
+FROM (SELECT a.id, a.user_id, a.status, ISNULL(u.department,'') AS department, `+overdueExpr+` AS overdue
+      `+reportBase+`) x`+join+`
+GROUP BY `+keyExpr+`, `+nameExpr+`
+ORDER BY 2;`, reportArgs(formID, f, today())...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]BreakdownRow, 0)
+	for rows.Next() {
+		var b BreakdownRow
+		if err := rows.Scan(&b.Key, &b.Name, &b.Total, &b.Draft, &b.Submitted, &b.InReview,
+			&b.Approved, &b.Acknowledged, &b.Closed, &b.Overdue); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// ListOverdue รายการที่เลยกำหนด เรียงจากค้างนานสุด
+func (r *Repo) ListOverdue(ctx context.Context, formID int, f ReportFilter, limit, offset int) ([]OverdueItem, int, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	args := reportArgs(formID, f, today())
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx,
+		`SELECT ISNULL(SUM(`+overdueExpr+`),0) `+reportBase+`;`, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT x.id, x.user_id, x.name, x.department,
+       CONVERT(varchar(10), x.due_date, 23), DATEDIFF(day, x.due_date, CAST(@p5 AS date)),
+       x.status, ISNULL(s.idx,0), ISNULL(s.evaluator_name,'')
+FROM (SELECT a.id, a.user_id, u.name, ISNULL(u.department,'') AS department, a.due_date, a.status
+      `+reportBase+`
+        AND `+overdueExpr+` = 1) x
+OUTER APPLY (SELECT TOP(1) es.idx, eu.name AS evaluator_name
+             FROM dbo.eval_step es JOIN dbo.users eu ON eu.id = es.evaluator_id
+             WHERE es.assignment_id = x.id AND es.status = 1 ORDER BY es.idx) s
+ORDER BY x.due_date, x.id
+OFFSET @p6 ROWS FETCH NEXT @p7 ROWS ONLY;`, append(args, offset, limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]OverdueItem, 0)
+	for rows.Next() {
+		var it OverdueItem
+		if err := rows.Scan(&it.AssignmentID, &it.UserID, &it.Name, &it.Department,
+			&it.DueDate, &it.DaysOverdue, &it.Status, &it.StepIdx, &it.EvaluatorName); err != nil {
+			return nil, 0, err
+		}
+		it.StatusName = AssignStatusName(it.Status)
+		out = append(out, it)
+	}
+	return out, total, rows.Err()
+}
+
+// ===== Analytics =====
+
+// loadFormScores คำนวณทุก assignment ของฟอร์มด้วย Engine (โหลดข้อมูลแบบ batch ไม่กี่ query)
+func (r *Repo) loadFormScores(ctx context.Context, formID int, f ReportFilter) ([]scoredAssignment, error) {
+	cfg, err := loadScoreConfig(ctx, r.DB, formID)
+	if err != nil {
+		return nil, err
+	}
+	args := scoredArgs(formID, f)
+
+	// assignments
+	rows, err := r.DB.QueryContext(ctx, `SELECT a.id, a.user_id, ISNULL(u.department,'') `+scoredBase+` ORDER BY a.id;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var list []scoredAssignment
+	pos := map[int]int{}
+	for rows.Next() {
+		var sa scoredAssignment
+		if err := rows.Scan(&sa.AssignmentID, &sa.UserID, &sa.Department); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pos[sa.AssignmentID] = len(list)
+		list = append(list, sa)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	inputs := make([]ScoreInput, len(list))
+	for i := range inputs {
+		inputs[i] = cfg
+	}
+	scope := `SELECT a.id ` + scoredBase
+
+	// KPI / competency / TA
+	each := func(query string, fn func(aid int, it ScoreItem)) error {
+		rows, err := r.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var aid int
+			var it ScoreItem
+			if err := rows.Scan(&aid, &it.Score, &it.MaxScore, &it.Weight); err != nil {
+				return err
+			}
+			fn(aid, it)
+		}
+		return rows.Err()
+	}
+	if err := each(`
+SELECT k.assignment_id, CAST(ISNULL(k.final_score, k.score) AS float), CAST(k.max_score AS float), CAST(k.weight AS float)
+FROM dbo.eval_kpi_user k WHERE k.assignment_id IN (`+scope+`);`, func(aid int, it ScoreItem) {
+		inputs[pos[aid]].KPIs = append(inputs[pos[aid]].KPIs, it)
+	}); err != nil {
+		return nil, err
+	}
+	if err := each(`
+SELECT cs.assignment_id, CAST(ISNULL(cs.final_score, cs.score) AS float), CAST(c.max_score AS float), CAST(c.weight AS float)
+FROM dbo.eval_competency_score cs
+JOIN dbo.eval_competency c ON c.id = cs.comp_id
+WHERE cs.assignment_id IN (`+scope+`);`, func(aid int, it ScoreItem) {
+		it.Weight = compWeightFraction(it.Weight) * 100
+		inputs[pos[aid]].Comps = append(inputs[pos[aid]].Comps, it)
+	}); err != nil {
+		return nil, err
+	}
+	if err := each(`
+SELECT t.assignment_id, CAST(ISNULL(t.final_score, t.score) AS float), CAST(t.full_score AS float), 0.0
+FROM dbo.eval_ta_score t WHERE t.assignment_id IN (`+scope+`);`, func(aid int, it ScoreItem) {
+		inputs[pos[aid]].TAScore, inputs[pos[aid]].TAFull = it.Score, it.MaxScore
+	}); err != nil {
+		return nil, err
+	}
+
+	// บริษัทของพนักงาน + band ทั้งหมดที่เกี่ยวข้อง
+	companies, err := r.userCompanies(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := loadBandSet(ctx, r.DB, formID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range list {
+		list[i].Result = r.Engine.Compute(inputs[i])
+		list[i].Grade = bs.grade(companies[list[i].UserID], list[i].Result.TotalPct)
+	}
+	return list, nil
+}
+
+// userCompanies บริษัทของพนักงานใน assignment ที่ผ่าน filter (user_id → company ids)
+func (r *Repo) userCompanies(ctx context.Context, args []any) (map[int][]string, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT uc.user_id, CAST(uc.company_id AS nvarchar(36))
+FROM dbo.user_companies uc
+WHERE uc.user_id IN (SELECT a.user_id `+reportBase+`);`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	companies := map[int][]string{}
+	for rows.Next() {
+		var uid int
+		var cid string
+		if err := rows.Scan(&uid, &cid); err != nil {
+			return nil, err
+		}
+		companies[uid] = append(companies[uid], cid)
+	}
+	return companies, rows.Err()
+}
+
+// loadBandSet band ของฟอร์ม + บริษัท + ค่าเริ่มต้น สำหรับหาเกรดในหน่วยความจำ
+func loadBandSet(ctx context.Context, q querier, formID int) (bandSet, error) {
+	rows, err := q.QueryContext(ctx, `
+SELECT ISNULL(form_id,0), ISNULL(CAST(company_id AS nvarchar(36)),''), grade, CAST(min_pct AS float), CAST(max_pct AS float)
+FROM dbo.eval_grade_band
+WHERE form_id=@p1 OR form_id IS NULL;`, formID)
+	if err != nil {
+		return bandSet{}, err
+	}
+	defer rows.Close()
+
+	var bands []bandRow
+	for rows.Next() {
+		var b bandRow
+		if err := rows.Scan(&b.FormID, &b.CompanyID, &b.Grade, &b.MinPct, &b.MaxPct); err != nil {
+			return bandSet{}, err
+		}
+		bands = append(bands, b)
+	}
+	return newBandSet(formID, bands), rows.Err()
+}
+
+// ScoreAnalytics ค่าเฉลี่ยรายส่วน + histogram ของ total_pct
+func (r *Repo) ScoreAnalytics(ctx context.Context, formID int, f ReportFilter, bucket float64) (ScoreAnalytics, error) {
+	rows, err := r.loadFormScores(ctx, formID, f)
+	if err != nil {
+		return ScoreAnalytics{}, err
+	}
+	return ScoreAnalytics{
+		FormID:    formID,
+		Count:     len(rows),
+		Averages:  averages(rows),
+		Histogram: histogram(rows, bucket),
+	}, nil
+}
+
+func (r *Repo) GradesByDepartment(ctx context.Context, formID int, f ReportFilter) ([]DepartmentGrades, error) {
+	rows, err := r.loadFormScores(ctx, formID, f)
+	if err != nil {
+		return nil, err
+	}
+	return gradesByDepartment(rows), nil
+}
+
+// CompetencyGaps คะแนนเฉลี่ยของแต่ละ competency เทียบ expected_score
+func (r *Repo) CompetencyGaps(ctx context.Context, formID int, f ReportFilter) ([]CompetencyGap, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT c.id, c.title, CAST(c.max_score AS float), CAST(c.expected_score AS float),
+       CAST(ISNULL(AVG(CAST(ISNULL(cs.final_score, cs.score) AS float)),0) AS float), COUNT(cs.score)
+FROM dbo.eval_competency c
+LEFT JOIN dbo.eval_competency_score cs
+  ON cs.comp_id = c.id AND cs.assignment_id IN (SELECT a.id `+scoredBase+`)
+WHERE c.form_id=@p1
+GROUP BY c.id, c.idx, c.title, c.max_score, c.expected_score
+ORDER BY c.idx, c.id;`, scoredArgs(formID, f)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]CompetencyGap, 0)
+	for rows.Next() {
+		var g CompetencyGap
+		if err := rows.Scan(&g.CompID, &g.Title, &g.MaxScore, &g.ExpectedScore, &g.AvgScore, &g.Scored); err != nil {
+			return nil, err
+		}
+		g.AvgScore = round2(g.AvgScore)
+		g.Gap = round2(g.AvgScore - g.ExpectedScore)
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// ===== XLSX export =====
+
+const exportScope = `SELECT a.id ` + reportBase
+
+// ExportFormXLSX เขียนผลทุก assignment ของฟอร์มเป็น xlsx ลง w แบบ stream
+// ชีต 1 สรุปต่อคน, ชีต 2 KPI รายบรรทัด, ชีต 3 competency รายบรรทัด
+func (r *Repo) ExportFormXLSX(ctx context.Context, formID int, f ReportFilter, w io.Writer) error {
+	cfg, err := loadScoreConfig(ctx, r.DB, formID)
+	if err != nil {
+		return err
+	}
+	args := reportArgs(formID, f, today())
+	companies, err := r.userCompanies(ctx, args)
+	if err != nil {
+		return err
+	}
+	bs, err := loadBandSet(ctx, r.DB, formID)
+	if err != nil {
+		return err
+	}
+	var maxSteps int
+	if err := r.DB.QueryRowContext(ctx, `
+SELECT ISNULL(MAX(n),0) FROM (
+  SELECT COUNT(1) AS n FROM dbo.eval_step
+  WHERE assignment_id IN (`+exportScope+`)
+  GROUP BY assignment_id
+) x;`, args...).Scan(&maxSteps); err != nil {
+		return err
+	}
+
+	// cursor ของรายการย่อย เรียงตาม assignment_id เหมือน query หลัก
+	open := func(query string) (*sql.Rows, error) { return r.DB.QueryContext(ctx, query, args...) }
+	kpiRows, err := open(`
+SELECT k.assignment_id, CAST(ISNULL(k.final_score, k.score) AS float), CAST(k.max_score AS float), CAST(k.weight AS float)
+FROM dbo.eval_kpi_user k WHERE k.assignment_id IN (` + exportScope + `)
+ORDER BY k.assignment_id;`)
+	if err != nil {
+		return err
+	}
+	defer kpiRows.Close()
+	compRows, err := open(`
+SELECT cs.assignment_id, CAST(ISNULL(cs.final_score, cs.score) AS float), CAST(c.max_score AS float), CAST(c.weight AS float)
+FROM dbo.eval_competency_score cs
+JOIN dbo.eval_competency c ON c.id = cs.comp_id
+WHERE cs.assignment_id IN (` + exportScope + `)
+ORDER BY cs.assignment_id;`)
+	if err != nil {
+		return err
+	}
+	defer compRows.Close()
+	taRows, err := open(`
+SELECT t.assignment_id, CAST(ISNULL(t.final_score, t.score) AS float), CAST(t.full_score AS float), 0.0
+FROM dbo.eval_ta_score t WHERE t.assignment_id IN (` + exportScope + `)
+ORDER BY t.assignment_id;`)
+	if err != nil {
+		return err
+	}
+	defer taRows.Close()
+	stepRows, err := open(`
+SELECT es.assignment_id, es.idx, u.name, es.status, ISNULL(CONVERT(varchar(10), es.eval_date, 23),'')
+FROM dbo.eval_step es
+JOIN dbo.users u ON u.id = es.evaluator_id
+WHERE es.assignment_id IN (` + exportScope + `)
+ORDER BY es.assignment_id, es.idx;`)
+	if err != nil {
+		return err
+	}
+	defer stepRows.Close()
+
+	kpis := newGroupCursor(kpiRows, scanScoreItem)
+	comps := newGroupCursor(compRows, scanScoreItem)
+	tas := newGroupCursor(taRows, scanScoreItem)
+	steps := newGroupCursor(stepRows, scanExportStep)
+
+	rows, err := open(`
+SELECT a.id, a.user_id, ISNULL(u.person_code,''), u.name, ISNULL(u.position,''), ISNULL(u.department,''),
+       a.status, ISNULL(CONVERT(varchar(10), a.due_date, 23),''),
+       ISNULL((SELECT TOP(1) ISNULL(ci.final_grade, ci.calc_grade)
+               FROM dbo.eval_calibration_item ci
+               JOIN dbo.eval_calibration c ON c.id = ci.calibration_id
+               WHERE ci.assignment_id = a.id AND c.status = 1),'')
+` + reportBase + `
+ORDER BY a.id;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	xw := xlsx.NewWriter(w)
+	if err := xw.StartSheet("สรุป", summaryHeader(maxSteps)...); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			aid, uid, status                            int
+			code, name, position, dept, due, calibrated string
+		)
+		if err := rows.Scan(&aid, &uid, &code, &name, &position, &dept, &status, &due, &calibrated); err != nil {
+			return err
+		}
+		in := cfg
+		in.KPIs = kpis.take(aid)
+		in.Comps = comps.take(aid)
+		for i := range in.Comps {
+			in.Comps[i].Weight = compWeightFraction(in.Comps[i].Weight) * 100
+		}
+		if ta := tas.take(aid); len(ta) > 0 {
+			in.TAScore, in.TAFull = ta[0].Score, ta[0].MaxScore
+		}
+		res := r.Engine.Compute(in)
+
+		cells := []any{aid, code, name, position, dept, AssignStatusName(status), due,
+			res.KPIPct, res.CompPct, res.TAPct, res.TotalPct, res.TotalScore,
+			bs.grade(companies[uid], res.TotalPct), calibrated}
+		st := steps.take(aid)
+		for i := 0; i < maxSteps; i++ {
+			if i < len(st) {
+				cells = append(cells, st[i].Evaluator, stepStatusNames[st[i].Status], st[i].Date)
+			} else {
+				cells = append(cells, nil, nil, nil)
+			}
+		}
+		if err := xw.WriteRow(cells...); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, err := range []error{kpis.err, comps.err, tas.err, steps.err} {
+		if err != nil {
+			return err
+		}
+	}
+	rows.Close()
+
+	// KPI รายบรรทัด
+	if err := xw.StartSheet("KPI", kpiDetailHeader...); err != nil {
+		return err
+	}
+	detail, err := open(`
+SELECT k.assignment_id, ISNULL(u.person_code,''), u.name, k.idx, ISNULL(k.code,''), k.title,
+       ISNULL(k.measure,''), ISNULL(k.criteria,''), ISNULL(k.unit,''), CAST(k.weight AS float),
+       CAST(k.max_score AS float), CAST(k.expected_score AS float), CAST(ISNULL(k.final_score, k.score) AS float), ISNULL(k.note,'')
+FROM dbo.eval_kpi_user k
+JOIN dbo.eval_assignment a ON a.id = k.assignment_id
+JOIN dbo.users u ON u.id = a.user_id
+WHERE k.assignment_id IN (` + exportScope + `)
+ORDER BY k.assignment_id, k.idx, k.id;`)
+	if err != nil {
+		return err
+	}
+	if err := streamRows(xw, detail); err != nil {
+		return err
+	}
+
+	// competency รายบรรทัด (ทุกหัวข้อของฟอร์ม ว่าง = ยังไม่ให้คะแนน)
+	if err := xw.StartSheet("Competency", compDetailHeader...); err != nil {
+		return err
+	}
+	detail, err = open(`
+SELECT x.id, x.person_code, x.name, c.idx, c.title,
+       CAST(CASE WHEN c.weight <= 1 THEN c.weight * 100 ELSE c.weight END AS float),
+       CAST(c.max_score AS float), CAST(c.expected_score AS float), CAST(ISNULL(cs.final_score, cs.score) AS float), ISNULL(cs.note,'')
+FROM (SELECT a.id, ISNULL(u.person_code,'') AS person_code, u.name ` + reportBase + `) x
+JOIN dbo.eval_competency c ON c.form_id = @p1
+LEFT JOIN dbo.eval_competency_score cs ON cs.assignment_id = x.id AND cs.comp_id = c.id
+ORDER BY x.id, c.idx, c.id;`)
+	if err != nil {
+		return err
+	}
+	if err := streamRows(xw, detail); err != nil {
+		return err
+	}
+	return xw.Close()
+}
+
+// ===== KPI library / templates =====
+
+const kpiCatalogCols = `id, CAST(company_id AS nvarchar(36)), department, code, title,
+       ISNULL(measure,''), ISNULL(criteria,''), ISNULL(unit,''), CAST(default_max_score AS float), active`
+
+func scanKPICatalog(sc rowScanner) (KPICatalogItem, error) {
+	var (
+		k        KPICatalogItem
+		cid, dep sql.NullString
+	)
+	err := sc.Scan(&k.ID, &cid, &dep, &k.Code, &k.Title, &k.Measure, &k.Criteria, &k.Unit, &k.DefaultMaxScore, &k.Active)
+	if cid.Valid {
+		k.CompanyID = &cid.String
+	}
+	if dep.Valid {
+		k.Department = &dep.String
+	}
+	return k, err
+}
+
+func (r *Repo) queryKPICatalog(ctx context.Context, where string, args ...any) ([]KPICatalogItem, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+kpiCatalogCols+` FROM dbo.eval_kpi_catalog k WHERE `+where+` ORDER BY code, id;`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]KPICatalogItem, 0)
+	for rows.Next() {
+		k, err := scanKPICatalog(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// ListKPICatalog: filter บริษัท/แผนก รวมรายการกลาง (null) ด้วย
+func (r *Repo) ListKPICatalog(ctx context.Context, f KPICatalogFilter) ([]KPICatalogItem, error) {
+	return r.queryKPICatalog(ctx, `
+    (@p1 = '' OR k.company_id IS NULL OR k.company_id = TRY_CAST(@p1 AS uniqueidentifier))
+AND (@p2 = '' OR k.department IS NULL OR k.department = @p2)
+AND (@p3 = '' OR k.code LIKE '%' + @p3 + '%' OR k.title LIKE '%' + @p3 + '%')
+AND (@p4 = 1 OR k.active = 1)`,
+		strings.TrimSpace(f.CompanyID), strings.TrimSpace(f.Department), strings.TrimSpace(f.Q), f.IncludeInactive)
+}
+
+// ListKPICatalogForUser รายการที่พนักงานเลือกได้ (บริษัทและแผนกของตัวเอง)
+func (r *Repo) ListKPICatalogForUser(ctx context.Context, userID int) ([]KPICatalogItem, error) {
+	return r.queryKPICatalog(ctx, `
+    k.active = 1
+AND (k.company_id IS NULL OR k.company_id IN (SELECT company_id FROM dbo.user_companies WHERE user_id = @p1))
+AND (k.department IS NULL OR k.department = (SELECT department FROM dbo.users WHERE id = @p1))`, userID)
+}
+
+func (r *Repo) CreateKPICatalog(ctx context.Context, in KPICatalogInput) (KPICatalogItem, error) {
+	var id int
+	err := r.DB.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_kpi_catalog(company_id, department, code, title, measure, criteria, unit, default_max_score)
+OUTPUT inserted.id
+VALUES(TRY_CAST(@p1 AS uniqueidentifier), NULLIF(@p2,''), @p3, @p4, NULLIF(@p5,''), NULLIF(@p6,''), NULLIF(@p7,''), @p8);`,
+		in.CompanyID, in.Department, strings.TrimSpace(in.Code), in.Title, in.Measure, in.Criteria, in.Unit, in.DefaultMaxScore).Scan(&id)
+	if isDuplicateKey(err) {
+		return KPICatalogItem{}, ErrDuplicateCode
+	}
+	if err != nil {
+		return KPICatalogItem{}, err
+	}
+	k, _, err := r.getKPICatalog(ctx, id)
+	return k, err
+}
+
+func (r *Repo) UpdateKPICatalog(ctx context.Context, id int, in KPICatalogInput) (KPICatalogItem, bool, error) {
+	_, err := r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_kpi_catalog
+SET company_id=TRY_CAST(@p2 AS uniqueidentifier), department=NULLIF(@p3,''), code=@p4, title=@p5,
+    measure=NULLIF(@p6,''), criteria=NULLIF(@p7,''), unit=NULLIF(@p8,''), default_max_score=@p9,
+    updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`,
+		id, in.CompanyID, in.Department, strings.TrimSpace(in.Code), in.Title, in.Measure, in.Criteria, in.Unit, in.DefaultMaxScore)
+	if isDuplicateKey(err) {
+		return KPICatalogItem{}, true, ErrDuplicateCode
+	}
+	if err != nil {
+		return KPICatalogItem{}, false, err
+	}
+	return r.getKPICatalog(ctx, id)
+}
+
+func (r *Repo) getKPICatalog(ctx context.Context, id int) (KPICatalogItem, bool, error) {
+	k, err := scanKPICatalog(r.DB.QueryRowContext(ctx, `SELECT `+kpiCatalogCols+` FROM dbo.eval_kpi_catalog WHERE id=@p1;`, id))
+	if err == sql.ErrNoRows {
+		return KPICatalogItem{}, false, nil
+	}
+	return k, err == nil, err
+}
+
+// SetKPICatalogActive ปิด/เปิดใช้งาน (ไม่ลบจริง เพราะ KPI ของพนักงานยังอ้างอยู่)
+func (r *Repo) SetKPICatalogActive(ctx context.Context, id int, active bool) (bool, error) {
+	res, err := r.DB.ExecContext(ctx,
+		`UPDATE dbo.eval_kpi_catalog SET active=@p2, updated_at=SYSUTCDATETIME() WHERE id=@p1;`, id, active)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (r *Repo) CreateKPITemplate(ctx context.Context, in KPITemplateInput) (KPITemplate, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return KPITemplate{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var id int
+	if err = tx.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_kpi_template(company_id, position, name)
+OUTPUT inserted.id
+VALUES(TRY_CAST(@p1 AS uniqueidentifier), @p2, @p3);`, in.CompanyID, strings.TrimSpace(in.Position), in.Name).Scan(&id); err != nil {
+		return KPITemplate{}, err
+	}
+	if err = replaceTemplateItems(ctx, tx, id, in.Items); err != nil {
+		return KPITemplate{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return KPITemplate{}, err
+	}
+	t, _, err := r.GetKPITemplate(ctx, id)
+	return t, err
+}
+
+// UpdateKPITemplate แทนที่หัว template และรายการทั้งชุด
+func (r *Repo) UpdateKPITemplate(ctx context.Context, id int, in KPITemplateInput) (KPITemplate, bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return KPITemplate{}, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE dbo.eval_kpi_template
+SET company_id=TRY_CAST(@p2 AS uniqueidentifier), position=@p3, name=@p4, updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`, id, in.CompanyID, strings.TrimSpace(in.Position), in.Name)
+	if err != nil {
+		return KPITemplate{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		_ = tx.Rollback()
+		return KPITemplate{}, false, nil
+	}
+	if err = replaceTemplateItems(ctx, tx, id, in.Items); err != nil {
+		return KPITemplate{}, true, err
+	}
+	if err = tx.Commit(); err != nil {
+		return KPITemplate{}, true, err
+	}
+	return r.GetKPITemplate(ctx, id)
+}
+
+func replaceTemplateItems(ctx context.Context, tx *sql.Tx, id int, items []KPITemplateItemInput) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_kpi_template_item WHERE template_id=@p1;`, id); err != nil {
+		return err
+	}
+	for i, it := range items {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_kpi_template_item(template_id, catalog_id, idx, weight, max_score, expected_score)
+VALUES(@p1, @p2, @p3, @p4, @p5, @p6);`, id, it.CatalogID, i+1, it.Weight, it.MaxScore, it.ExpectedScore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repo) DeleteKPITemplate(ctx context.Context, id int) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM dbo.eval_kpi_template WHERE id=@p1;`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func scanKPITemplate(sc rowScanner) (KPITemplate, error) {
+	var (
+		t   KPITemplate
+		cid sql.NullString
+	)
+	err := sc.Scan(&t.ID, &cid, &t.Position, &t.Name)
+	if cid.Valid {
+		t.CompanyID = &cid.String
+	}
+	return t, err
+}
+
+// ListKPITemplates filter ตำแหน่ง / บริษัท (ไม่รวมรายการ)
+func (r *Repo) ListKPITemplates(ctx context.Context, position, companyID string) ([]KPITemplate, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT id, CAST(company_id AS nvarchar(36)), position, name
+FROM dbo.eval_kpi_template
+WHERE (@p1 = '' OR position = @p1)
+  AND (@p2 = '' OR company_id IS NULL OR company_id = TRY_CAST(@p2 AS uniqueidentifier))
+ORDER BY position, name, id;`, strings.TrimSpace(position), strings.TrimSpace(companyID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]KPITemplate, 0)
+	for rows.Next() {
+		t, err := scanKPITemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) GetKPITemplate(ctx context.Context, id int) (KPITemplate, bool, error) {
+	t, err := scanKPITemplate(r.DB.QueryRowContext(ctx,
+		`SELECT id, CAST(company_id AS nvarchar(36)), position, name FROM dbo.eval_kpi_template WHERE id=@p1;`, id))
+	if err == sql.ErrNoRows {
+		return KPITemplate{}, false, nil
+	}
+	if err != nil {
+		return KPITemplate{}, false, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT ti.id, ti.catalog_id, ti.idx, ti.weight, CAST(ti.max_score AS float), CAST(ti.expected_score AS float),
+       c.code, c.title, c.active
+FROM dbo.eval_kpi_template_item ti
+JOIN dbo.eval_kpi_catalog c ON c.id = ti.catalog_id
+WHERE ti.template_id=@p1
+ORDER BY ti.idx, ti.id;`, id)
+	if err != nil {
+		return t, true, err
+	}
+	defer rows.Close()
+	t.Items = make([]KPITemplateItem, 0)
+	for rows.Next() {
+		var it KPITemplateItem
+		if err := rows.Scan(&it.ID, &it.CatalogID, &it.Idx, &it.Weight, &it.MaxScore, &it.ExpectedScore,
+			&it.Code, &it.Title, &it.Active); err != nil {
+			return t, true, err
+		}
+		t.Items = append(t.Items, it)
+	}
+	return t, true, rows.Err()
+}
+
+// TemplateForUser หา template ตามตำแหน่งของพนักงาน (ของบริษัทตัวเองก่อน แล้วค่อยแบบกลาง)
+func (r *Repo) TemplateForUser(ctx context.Context, userID int) (int, bool, error) {
+	var id int
+	err := r.DB.QueryRowContext(ctx, `
+SELECT TOP(1) t.id
+FROM dbo.eval_kpi_template t
+JOIN dbo.users u ON u.id = @p1 AND u.position = t.position
+WHERE t.company_id IS NULL
+   OR t.company_id IN (SELECT company_id FROM dbo.user_companies WHERE user_id = @p1)
+ORDER BY CASE WHEN t.company_id IS NULL THEN 1 ELSE 0 END, t.id;`, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// TemplateKPIs แปลงรายการ template เป็น KPI ของพนักงาน (ข้ามรายการคลังที่ปิดใช้งาน)
+func (r *Repo) TemplateKPIs(ctx context.Context, templateID int) ([]MyKPIInput, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT ti.idx, c.id, c.code, c.title, ISNULL(c.measure,''), ISNULL(c.criteria,''), ISNULL(c.unit,''),
+       CAST(ISNULL(ti.max_score, c.default_max_score) AS float), ti.weight,
+       CAST(ISNULL(ti.expected_score, 0) AS float)
+FROM dbo.eval_kpi_template_item ti
+JOIN dbo.eval_kpi_catalog c ON c.id = ti.catalog_id
+WHERE ti.template_id=@p1 AND c.active = 1
+ORDER BY ti.idx, ti.id;`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []MyKPIInput
+	for rows.Next() {
+		var (
+			k   MyKPIInput
+			cid int
+		)
+		if err := rows.Scan(&k.Idx, &cid, &k.Code, &k.Title, &k.Measure, &k.Criteria, &k.Unit,
+			&k.MaxScore, &k.Weight, &k.ExpectedScore); err != nil {
+			return nil, err
+		}
+		k.CatalogID = &cid
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// KPICatalogUsage รายการคลังที่ใช้ในฟอร์ม + จำนวน KPI ที่ไม่ได้อ้างคลัง
+func (r *Repo) KPICatalogUsage(ctx context.Context, formID int, f ReportFilter) ([]KPICatalogUsage, int, error) {
+	args := reportArgs(formID, f, today())
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT c.id, c.code, c.title, COUNT(DISTINCT k.assignment_id),
+       AVG(CAST(k.weight AS float)),
+       ISNULL(AVG(CASE WHEN k.max_score > 0 THEN CAST(ISNULL(k.final_score, k.score) AS float) * 100 / CAST(k.max_score AS float) END), 0)
+FROM dbo.eval_kpi_user k
+JOIN dbo.eval_kpi_catalog c ON c.id = k.catalog_id
+WHERE k.assignment_id IN (SELECT a.id `+reportBase+`)
+GROUP BY c.id, c.code, c.title
+ORDER BY c.code, c.id;`, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]KPICatalogUsage, 0)
+	for rows.Next() {
+		var u KPICatalogUsage
+		if err := rows.Scan(&u.CatalogID, &u.Code, &u.Title, &u.Assignments, &u.AvgWeight, &u.AvgScorePct); err != nil {
+			return nil, 0, err
+		}
+		u.AvgWeight = round2(u.AvgWeight)
+		u.AvgScorePct = round2(u.AvgScorePct)
+		out = append(out, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var unlinked int
+	err = r.DB.QueryRowContext(ctx, `
+SELECT COUNT(1) FROM dbo.eval_kpi_user k
+WHERE k.catalog_id IS NULL AND k.assignment_id IN (SELECT a.id `+reportBase+`);`, args...).Scan(&unlinked)
+	return out, unlinked, err
+}
+
+// ===== Goal cascading =====
+
+var ErrObjectiveHasChildren = errors.New("objective has child objectives")
+
+const objectiveCols = `id, form_id, parent_id, level, CAST(company_id AS nvarchar(36)), department,
+       ISNULL(code,''), title, ISNULL(description,''), owner_user_id, weight, idx`
+
+func scanObjective(sc rowScanner) (Objective, error) {
+	var (
+		o        Objective
+		cid, dep sql.NullString
+	)
+	err := sc.Scan(&o.ID, &o.FormID, &o.ParentID, &o.Level, &cid, &dep,
+		&o.Code, &o.Title, &o.Description, &o.OwnerUserID, &o.Weight, &o.Idx)
+	if cid.Valid {
+		o.CompanyID = &cid.String
+	}
+	if dep.Valid {
+		o.Department = &dep.String
+	}
+	return o, err
+}
+
+func (r *Repo) ListObjectives(ctx context.Context, formID int) ([]Objective, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT `+objectiveCols+` FROM dbo.eval_objective WHERE form_id=@p1 ORDER BY idx, id;`, formID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Objective, 0)
+	for rows.Next() {
+		o, err := scanObjective(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) GetObjective(ctx context.Context, id int) (Objective, bool, error) {
+	o, err := scanObjective(r.DB.QueryRowContext(ctx,
+		`SELECT `+objectiveCols+` FROM dbo.eval_objective WHERE id=@p1;`, id))
+	if err == sql.ErrNoRows {
+		return Objective{}, false, nil
+	}
+	return o, err == nil, err
+}
+
+func objectiveWeight(in ObjectiveInput) int {
+	if in.Weight == nil {
+		return 100
+	}
+	return *in.Weight
+}
+
+func (r *Repo) CreateObjective(ctx context.Context, formID int, in ObjectiveInput) (Objective, error) {
+	var id int
+	if err := r.DB.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_objective(form_id, parent_id, level, company_id, department, code, title, description, owner_user_id, weight, idx)
+OUTPUT inserted.id
+VALUES(@p1, @p2, @p3, TRY_CAST(@p4 AS uniqueidentifier), NULLIF(@p5,''), NULLIF(@p6,''), @p7, NULLIF(@p8,''), @p9, @p10, @p11);`,
+		formID, in.ParentID, in.Level, in.CompanyID, in.Department, strings.TrimSpace(in.Code), in.Title,
+		in.Description, in.OwnerUserID, objectiveWeight(in), in.Idx).Scan(&id); err != nil {
+		return Objective{}, err
+	}
+	o, _, err := r.GetObjective(ctx, id)
+	return o, err
+}
+
+func (r *Repo) UpdateObjective(ctx context.Context, id int, in ObjectiveInput) (Objective, bool, error) {
+	res, err := r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_objective
+SET parent_id=@p2, level=@p3, company_id=TRY_CAST(@p4 AS uniqueidentifier), department=NULLIF(@p5,''),
+    code=NULLIF(@p6,''), title=@p7, description=NULLIF(@p8,''), owner_user_id=@p9, weight=@p10, idx=@p11,
+    updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`,
+		id, in.ParentID, in.Level, in.CompanyID, in.Department, strings.TrimSpace(in.Code), in.Title,
+		in.Description, in.OwnerUserID, objectiveWeight(in), in.Idx)
+	if err != nil {
+		return Objective{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Objective{}, false, nil
+	}
+	return r.GetObjective(ctx, id)
+}
+
+// DeleteObjective ลบได้เมื่อไม่มีเป้าหมายย่อย; KPI ที่ผูกอยู่จะถูกปลดลิงก์
+func (r *Repo) DeleteObjective(ctx context.Context, id int) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var children int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(1) FROM dbo.eval_objective WHERE parent_id=@p1;`, id).Scan(&children); err != nil {
+		return false, err
+	}
+	if children > 0 {
+		return true, ErrObjectiveHasChildren
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE dbo.eval_kpi_user SET objective_id=NULL, updated_at=SYSUTCDATETIME() WHERE objective_id=@p1;`, id); err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_objective WHERE id=@p1;`, id)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+// CheckObjectiveLinks: objective_id ของ KPI ต้องเป็นเป้าหมายในฟอร์มเดียวกัน
+func (r *Repo) CheckObjectiveLinks(ctx context.Context, formID int, field string, items []MyKPIInput) error {
+	var ids []int
+	for _, it := range items {
+		if it.ObjectiveID != nil {
+			ids = append(ids, *it.ObjectiveID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	objs, err := r.ListObjectives(ctx, formID)
+	if err != nil {
+		return err
+	}
+	valid := make(map[int]bool, len(objs))
+	for _, o := range objs {
+		valid[o.ID] = true
+	}
+	var ve ValidationError
+	for i, it := range items {
+		if it.ObjectiveID != nil && !valid[*it.ObjectiveID] {
+			ve.add(fmt.Sprintf("%s[%d].objective_id", field, i), "not_found", "objective %d is not in this form", *it.ObjectiveID)
+		}
+	}
+	return ve.errOrNil()
+}
+
+// LinkKPIObjective ผูก / ปลด (nil) KPI หนึ่งรายการของ assignment กับเป้าหมาย
+func (r *Repo) LinkKPIObjective(ctx context.Context, aid, kpiID int, objectiveID *int) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_kpi_user SET objective_id=@p3, updated_at=SYSUTCDATETIME()
+WHERE id=@p2 AND assignment_id=@p1;`, aid, kpiID, objectiveID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ObjectiveCascade ต้นไม้เป้าหมายของฟอร์ม พร้อม KPI ที่ผูกและคะแนนรวมจากลูก (root = 0 → ทั้งหมด)
+func (r *Repo) ObjectiveCascade(ctx context.Context, formID, root int) ([]ObjectiveNode, error) {
+	objs, err := r.ListObjectives(ctx, formID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT ku.objective_id, ku.id, ku.assignment_id, a.user_id, u.name, ISNULL(ku.code,''), ku.title, ku.weight,
+       CAST(ISNULL(ku.final_score, ku.score) AS float), CAST(ku.max_score AS float), lc.pct
+FROM dbo.eval_kpi_user ku
+JOIN dbo.eval_assignment a ON a.id = ku.assignment_id
+JOIN dbo.users u ON u.id = a.user_id
+JOIN dbo.eval_objective o ON o.id = ku.objective_id
+OUTER APPLY (
+  SELECT TOP(1) CAST(ci.percent_complete AS float) AS pct
+  FROM dbo.eval_kpi_checkin ci
+  WHERE ci.assignment_id = ku.assignment_id AND ci.kpi_user_id = ku.id AND ci.percent_complete IS NOT NULL
+  ORDER BY ci.checkin_date DESC, ci.id DESC
+) lc
+WHERE o.form_id=@p1
+ORDER BY u.name, ku.idx, ku.id;`, formID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var kpis []cascadeKPI
+	for rows.Next() {
+		var (
+			k               cascadeKPI
+			score, maxScore float64
+		)
+		if err := rows.Scan(&k.ObjectiveID, &k.KPI.KPIID, &k.KPI.AssignmentID, &k.KPI.UserID, &k.KPI.UserName,
+			&k.KPI.Code, &k.KPI.Title, &k.KPI.Weight, &score, &maxScore, &k.KPI.ProgressPct); err != nil {
+			return nil, err
+		}
+		k.KPI.ScorePct = kpiScorePct(score, maxScore)
+		kpis = append(kpis, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buildCascade(objs, kpis, root), nil
+}
+
+// ===== KPI check-ins =====
+
+// suggestedScore ค่าเริ่มต้นของ score จาก check-in ล่าสุด:
+// มี scoring rule + actual → คำนวณตาม rule, ไม่งั้นใช้ % ความสำเร็จ (null = ไม่มีข้อมูล)
+func suggestedScore(k MyKPIItem) *float64 {
+	lc := k.LatestCheckin
+	if lc == nil || k.MaxScore <= 0 {
+		return nil
+	}
+	if k.ScoringRule != nil && lc.ActualValue != nil {
+		v := k.ScoringRule.Score(*lc.ActualValue, k.MaxScore)
+		return &v
+	}
+	if lc.PercentComplete == nil {
+		return nil
+	}
+	v := round2(min(*lc.PercentComplete, 100) / 100 * k.MaxScore)
+	return &v
+}
+
+const checkinCols = `ci.id, ci.assignment_id, ci.kpi_user_id, ISNULL(ci.kpi_code,''), ci.kpi_title,
+       CONVERT(varchar(10), ci.checkin_date, 23), CAST(ci.actual_value AS float), CAST(ci.percent_complete AS float),
+       ISNULL(ci.comment,''), ci.author_id, ISNULL(u.name,''), ci.author_role, ci.created_at`
+
+func scanCheckin(sc rowScanner) (KPICheckin, error) {
+	var k KPICheckin
+	err := sc.Scan(&k.ID, &k.AssignmentID, &k.KPIID, &k.KPICode, &k.KPITitle, &k.CheckinDate,
+		&k.ActualValue, &k.PercentComplete, &k.Comment, &k.AuthorID, &k.AuthorName, &k.AuthorRole, &k.CreatedAt)
+	return k, err
+}
+
+// AddKPICheckin บันทึก check-in ของ KPI ใน assignment (found = false ถ้า KPI ไม่อยู่ใน assignment นี้)
+func (r *Repo) AddKPICheckin(ctx context.Context, aid, kpiID, authorID int, role string, in KPICheckinInput) (KPICheckin, bool, error) {
+	date := strings.TrimSpace(in.CheckinDate)
+	if date == "" {
+		date = today()
+	}
+	var id int
+	err := r.DB.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_kpi_checkin
+(assignment_id, kpi_user_id, kpi_code, kpi_title, checkin_date, actual_value, percent_complete, comment, author_id, author_role)
+OUTPUT inserted.id
+SELECT ku.assignment_id, ku.id, ku.code, ku.title, @p3, @p4, @p5, NULLIF(@p6,''), @p7, @p8
+FROM dbo.eval_kpi_user ku
+WHERE ku.id=@p2 AND ku.assignment_id=@p1;`,
+		aid, kpiID, date, in.ActualValue, in.PercentComplete, strings.TrimSpace(in.Comment), authorID, role).Scan(&id)
+	if err == sql.ErrNoRows {
+		return KPICheckin{}, false, nil
+	}
+	if err != nil {
+		return KPICheckin{}, false, err
+	}
+	return r.GetKPICheckin(ctx, aid, id)
+}
+
+func (r *Repo) GetKPICheckin(ctx context.Context, aid, id int) (KPICheckin, bool, error) {
+	k, err := scanCheckin(r.DB.QueryRowContext(ctx, `
+SELECT `+checkinCols+`
+FROM dbo.eval_kpi_checkin ci
+LEFT JOIN dbo.users u ON u.id = ci.author_id
+WHERE ci.id=@p2 AND ci.assignment_id=@p1;`, aid, id))
+	if err == sql.ErrNoRows {
+		return KPICheckin{}, false, nil
+	}
+	return k, err == nil, err
+}
+
+// ListKPICheckins timeline ของ assignment (kpiID = 0 → ทุก KPI) เรียงจากเก่าไปใหม่
+func (r *Repo) ListKPICheckins(ctx context.Context, aid, kpiID int) ([]KPICheckin, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT `+checkinCols+`
+FROM dbo.eval_kpi_checkin ci
+LEFT JOIN dbo.users u ON u.id = ci.author_id
+WHERE ci.assignment_id=@p1 AND (@p2 = 0 OR ci.kpi_user_id=@p2)
+ORDER BY ci.checkin_date, ci.id;`, aid, kpiID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]KPICheckin, 0)
+	for rows.Next() {
+		k, err := scanCheckin(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) DeleteKPICheckin(ctx context.Context, aid, id int) (bool, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM dbo.eval_kpi_checkin WHERE id=@p2 AND assignment_id=@p1;`, aid, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ===== Development plan tracking =====
+
+var (
+	ErrDevPlanSignedOff   = errors.New("development plan item is signed off")
+	ErrDevPlanNotFinished = errors.New("development plan item is not done or cancelled")
+	ErrDevPlanKept        = errors.New("development plan item is signed off, carried over or already started and cannot be removed")
+)
+
+const devPlanCols = `
+dp.id, dp.assignment_id, dp.idx, dp.content, dp.priority, CONVERT(varchar(10),dp.timing,23), ISNULL(dp.remarks,''),
+dp.status, CONVERT(varchar(10),dp.completed_on,23), dp.signed_off_by, ISNULL(u.name,''), dp.signed_off_at, dp.carried_from_id,
+dp.course_id, ISNULL(c.title,''), CAST(c.hours AS float)`
+
+const devPlanFrom = `
+FROM dbo.eval_dev_plan dp
+LEFT JOIN dbo.users u ON u.id = dp.signed_off_by
+LEFT JOIN dbo.eval_course c ON c.id = dp.course_id`
+
+func scanDevPlan(s rowScanner) (DevPlanItem, error) {
+	var (
+		d               DevPlanItem
+		timing, doneOn  sql.NullString
+		signedBy, carry sql.NullInt64
+		signedAt        sql.NullTime
+		hours           sql.NullFloat64
+	)
+	if err := s.Scan(&d.ID, &d.AssignmentID, &d.Idx, &d.Content, &d.Priority, &timing, &d.Remarks,
+		&d.Status, &doneOn, &signedBy, &d.SignedOffName, &signedAt, &carry,
+		&d.CourseID, &d.CourseTitle, &hours); err != nil {
+		return d, err
+	}
+	if hours.Valid {
+		d.CourseHours = &hours.Float64
+	}
+	d.Timing = timing.String
+	d.CompletedOn = doneOn.String
+	if signedBy.Valid {
+		v := int(signedBy.Int64)
+		d.SignedOffBy = &v
+	}
+	if signedAt.Valid {
+		d.SignedOffAt = &signedAt.Time
+	}
+	if carry.Valid {
+		v := int(carry.Int64)
+		d.CarriedFromID = &v
+	}
+	return d, nil
+}
+
+// ListDevPlan รายการแผนพัฒนาของ assignment (withNotes = แนบบันทึกความคืบหน้า)
+func (r *Repo) ListDevPlan(ctx context.Context, aid int, withNotes bool) ([]DevPlanItem, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+devPlanCols+devPlanFrom+`
+WHERE dp.assignment_id=@p1
+ORDER BY dp.idx, dp.id;`, aid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]DevPlanItem, 0)
+	for rows.Next() {
+		d, err := scanDevPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !withNotes || len(out) == 0 {
+		return out, nil
+	}
+
+	notes, err := r.listDevPlanNotes(ctx, aid, 0)
+	if err != nil {
+		return nil, err
+	}
+	byPlan := map[int][]DevPlanNote{}
+	for _, n := range notes {
+		byPlan[n.PlanID] = append(byPlan[n.PlanID], n)
+	}
+	for i := range out {
+		out[i].Notes = byPlan[out[i].ID]
+		if out[i].Notes == nil {
+			out[i].Notes = []DevPlanNote{}
+		}
+	}
+	return out, nil
+}
+
+func (r *Repo) GetDevPlanItem(ctx context.Context, aid, id int) (DevPlanItem, bool, error) {
+	d, err := scanDevPlan(r.DB.QueryRowContext(ctx, `SELECT `+devPlanCols+devPlanFrom+`
+WHERE dp.id=@p2 AND dp.assignment_id=@p1;`, aid, id))
+	if err == sql.ErrNoRows {
+		return DevPlanItem{}, false, nil
+	}
+	if err != nil {
+		return DevPlanItem{}, false, err
+	}
+	if d.Notes, err = r.listDevPlanNotes(ctx, aid, id); err != nil {
+		return d, true, err
+	}
+	return d, true, nil
+}
+
+// listDevPlanNotes planID = 0 → ทุกรายการของ assignment
+func (r *Repo) listDevPlanNotes(ctx context.Context, aid, planID int) ([]DevPlanNote, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT n.id, n.plan_id, n.note, n.author_id, ISNULL(u.name,''), n.author_role, n.created_at
+FROM dbo.eval_dev_plan_note n
+JOIN dbo.eval_dev_plan dp ON dp.id = n.plan_id
+LEFT JOIN dbo.users u ON u.id = n.author_id
+WHERE dp.assignment_id=@p1 AND (@p2 = 0 OR n.plan_id=@p2)
+ORDER BY n.created_at, n.id;`, aid, planID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]DevPlanNote, 0)
+	for rows.Next() {
+		var (
+			n      DevPlanNote
+			author sql.NullInt64
+		)
+		if err := rows.Scan(&n.ID, &n.PlanID, &n.Note, &author, &n.AuthorName, &n.AuthorRole, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		if author.Valid {
+			v := int(author.Int64)
+			n.AuthorID = &v
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// saveDevPlan บันทึกแผนจาก SaveAll: จับคู่รายการเดิมด้วย id (หรือเนื้อหาเดียวกัน)
+// เพื่อคงสถานะ/บันทึกความคืบหน้า, รายการที่ไม่ได้ส่งมาถูกลบ
+// ยกเว้นรายการที่ต้องเก็บไว้ (sign-off / ยกมา-ยกไป / เลย planned แล้ว) → ErrDevPlanKept
+func saveDevPlan(ctx context.Context, tx *sql.Tx, aid int, items []DevPlanItemInput) error {
+	// kept = sign-off แล้ว / ยกมาจากหรือยกไปรอบอื่น / เริ่มดำเนินการแล้ว → ลบไม่ได้
+	rows, err := tx.QueryContext(ctx, `
+SELECT dp.id, dp.content,
+       CAST(CASE WHEN dp.signed_off_by IS NOT NULL OR dp.carried_from_id IS NOT NULL OR dp.status <> @p2
+                   OR EXISTS (SELECT 1 FROM dbo.eval_dev_plan n WHERE n.carried_from_id = dp.id)
+            THEN 1 ELSE 0 END AS bit)
+FROM dbo.eval_dev_plan dp
+WHERE dp.assignment_id=@p1
+ORDER BY dp.idx, dp.id;`, aid, DevPlanPlanned)
+	if err != nil {
+		return err
+	}
+	existing := map[int]string{}
+	kept := map[int]bool{}
+	order := []int{}
+	for rows.Next() {
+		var (
+			id      int
+			content string
+			keep    bool
+		)
+		if err := rows.Scan(&id, &content, &keep); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[id] = strings.TrimSpace(content)
+		kept[id] = keep
+		order = append(order, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	used := map[int]bool{}
+	match := func(d DevPlanItemInput) int {
+		if _, ok := existing[d.ID]; ok && !used[d.ID] {
+			return d.ID
+		}
+		for _, id := range order {
+			if !used[id] && existing[id] == strings.TrimSpace(d.Content) {
+				return id
+			}
+		}
+		return 0
+	}
+
+	for _, d := range items {
+		if id := match(d); id > 0 {
+			used[id] = true
+			if _, err := tx.ExecContext(ctx, `
+UPDATE dbo.eval_dev_plan
+SET idx=@p2, content=@p3, priority=@p4, timing=NULLIF(@p5,''), remarks=NULLIF(@p6,''), course_id=@p7, updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`, id, d.Idx, d.Content, d.Priority, d.Timing, d.Remarks, d.CourseID); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_dev_plan(assignment_id, idx, content, priority, timing, remarks, course_id)
+VALUES(@p1,@p2,@p3,@p4, NULLIF(@p5,''), NULLIF(@p6,''), @p7);`, aid, d.Idx, d.Content, d.Priority, d.Timing, d.Remarks, d.CourseID); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range order {
+		if used[id] {
+			continue
+		}
+		if kept[id] {
+			return fmt.Errorf("%w: item %d", ErrDevPlanKept, id)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_dev_plan WHERE id=@p1;`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDevPlanStatus เปลี่ยนสถานะ (ทำได้นอกช่วงประเมิน); รายการที่ sign-off แล้วแก้ไม่ได้
+func (r *Repo) SetDevPlanStatus(ctx context.Context, aid, id, authorID int, role string, in DevPlanStatusInput) (DevPlanItem, bool, error) {
+	cur, found, err := r.GetDevPlanItem(ctx, aid, id)
+	if err != nil || !found {
+		return DevPlanItem{}, found, err
+	}
+	if cur.SignedOffBy != nil {
+		return DevPlanItem{}, true, ErrDevPlanSignedOff
+	}
+
+	doneOn := ""
+	if in.Status == DevPlanDone {
+		doneOn = strings.TrimSpace(in.CompletedOn)
+		if doneOn == "" {
+			doneOn = today()
+		}
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return DevPlanItem{}, true, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `
+UPDATE dbo.eval_dev_plan
+SET status=@p2, completed_on=NULLIF(@p3,''), updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`, id, in.Status, doneOn); err != nil {
+		return DevPlanItem{}, true, err
+	}
+	if note := strings.TrimSpace(in.Note); note != "" {
+		if err = insertDevPlanNote(ctx, tx, id, authorID, role, note); err != nil {
+			return DevPlanItem{}, true, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return DevPlanItem{}, true, err
+	}
+	return r.GetDevPlanItem(ctx, aid, id)
+}
+
+func insertDevPlanNote(ctx context.Context, tx *sql.Tx, planID, authorID int, role, note string) error {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_dev_plan_note(plan_id, note, author_id, author_role) VALUES(@p1, @p2, @p3, @p4);`,
+		planID, note, authorID, role)
+	return err
+}
+
+func (r *Repo) AddDevPlanNote(ctx context.Context, aid, id, authorID int, role, note string) (DevPlanItem, bool, error) {
+	res, err := r.DB.ExecContext(ctx, `
+INSERT INTO dbo.eval_dev_plan_note(plan_id, note, author_id, author_role)
+SELECT dp.id, @p3, @p4, @p5 FROM dbo.eval_dev_plan dp WHERE dp.id=@p2 AND dp.assignment_id=@p1;`,
+		aid, id, strings.TrimSpace(note), authorID, role)
+	if err != nil {
+		return DevPlanItem{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return DevPlanItem{}, false, nil
+	}
+	return r.GetDevPlanItem(ctx, aid, id)
+}
+
+// SignOffDevPlan หัวหน้ารับรองรายการที่จบแล้ว (undo = ยกเลิกการรับรอง)
+func (r *Repo) SignOffDevPlan(ctx context.Context, aid, id, managerID int, undo bool) (DevPlanItem, bool, error) {
+	cur, found, err := r.GetDevPlanItem(ctx, aid, id)
+	if err != nil || !found {
+		return DevPlanItem{}, found, err
+	}
+	if undo {
+		_, err = r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_dev_plan SET signed_off_by=NULL, signed_off_at=NULL, updated_at=SYSUTCDATETIME() WHERE id=@p1;`, id)
+	} else {
+		if devPlanOpen(cur.Status) {
+			return DevPlanItem{}, true, ErrDevPlanNotFinished
+		}
+		_, err = r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_dev_plan SET signed_off_by=@p2, signed_off_at=SYSUTCDATETIME(), updated_at=SYSUTCDATETIME() WHERE id=@p1;`, id, managerID)
+	}
+	if err != nil {
+		return DevPlanItem{}, true, err
+	}
+	return r.GetDevPlanItem(ctx, aid, id)
+}
+
+// carryOverDevPlan ยกรายการที่ยังไม่จบ (planned / in_progress) จาก assignment รอบก่อนของพนักงานคนเดียวกัน
+// รอบก่อน = ฟอร์มที่ eval_start ก่อนหน้า (ไม่มีวันที่ใช้ลำดับ id ของฟอร์ม); รายการที่เคยยกไปแล้วไม่ยกซ้ำ
+func carryOverDevPlan(ctx context.Context, ex execer, aid int) (int, error) {
+	res, err := ex.ExecContext(ctx, `
+;WITH prev AS (
+  SELECT TOP 1 p.id
+  FROM dbo.eval_assignment cur
+  JOIN dbo.eval_form cf ON cf.id = cur.form_id
+  JOIN dbo.eval_assignment p ON p.user_id = cur.user_id AND p.id <> cur.id
+  JOIN dbo.eval_form pf ON pf.id = p.form_id
+  WHERE cur.id = @p1
+    AND 1 = CASE WHEN pf.eval_start IS NOT NULL AND cf.eval_start IS NOT NULL
+                 THEN CASE WHEN pf.eval_start < cf.eval_start THEN 1 ELSE 0 END
+                 ELSE CASE WHEN pf.id < cf.id THEN 1 ELSE 0 END END
+  ORDER BY pf.eval_start DESC, pf.id DESC
+)
+INSERT INTO dbo.eval_dev_plan(assignment_id, idx, content, priority, timing, remarks, status, carried_from_id, course_id)
+SELECT @p1,
+       ISNULL((SELECT MAX(idx) FROM dbo.eval_dev_plan WHERE assignment_id = @p1), 0)
+         + ROW_NUMBER() OVER (ORDER BY dp.idx, dp.id),
+       dp.content, dp.priority, dp.timing, dp.remarks, dp.status, dp.id, dp.course_id
+FROM dbo.eval_dev_plan dp
+JOIN prev ON prev.id = dp.assignment_id
+WHERE dp.status IN ('planned', 'in_progress')
+  AND NOT EXISTS (SELECT 1 FROM dbo.eval_dev_plan x WHERE x.carried_from_id = dp.id);`, aid)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// CarryOverDevPlan ยกรายการค้างจากรอบก่อนเข้า assignment นี้ (เรียกซ้ำได้ ไม่ซ้ำรายการ)
+func (r *Repo) CarryOverDevPlan(ctx context.Context, aid int) (int, error) {
+	return carryOverDevPlan(ctx, r.DB, aid)
+}
+
+// ===== Additional questions =====
+
+var ErrQuestionAnswered = errors.New("question already has answers")
+
+func optionsParam(opts []QuestionOption) any {
+	if len(opts) == 0 {
+		return nil
+	}
+	for i := range opts {
+		opts[i].Value = strings.TrimSpace(opts[i].Value)
+	}
+	b, _ := json.Marshal(opts)
+	return string(b)
+}
+
+const questionCols = `id, form_id, idx, code, qtype, label_th, ISNULL(label_en,''), required, options, scale_min, scale_max`
+
+func scanQuestion(s rowScanner) (Question, error) {
+	var (
+		q        Question
+		opts     sql.NullString
+		min, max sql.NullInt64
+	)
+	if err := s.Scan(&q.ID, &q.FormID, &q.Idx, &q.Code, &q.Type, &q.LabelTH, &q.LabelEN, &q.Required, &opts, &min, &max); err != nil {
+		return q, err
+	}
+	q.Options = []QuestionOption{}
+	if opts.Valid && opts.String != "" {
+		if err := json.Unmarshal([]byte(opts.String), &q.Options); err != nil {
+			return q, fmt.Errorf("question %d options: %w", q.ID, err)
+		}
+	}
+	if min.Valid {
+		v := int(min.Int64)
+		q.ScaleMin = &v
+	}
+	if max.Valid {
+		v := int(max.Int64)
+		q.ScaleMax = &v
+	}
+	return q, nil
+}
+
+func (r *Repo) ListQuestions(ctx context.Context, formID int) ([]Question, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT `+questionCols+` FROM dbo.eval_question WHERE form_id=@p1 ORDER BY idx, id;`, formID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Question, 0)
+	for rows.Next() {
+		q, err := scanQuestion(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) GetQuestion(ctx context.Context, id int) (Question, bool, error) {
+	q, err := scanQuestion(r.DB.QueryRowContext(ctx,
+		`SELECT `+questionCols+` FROM dbo.eval_question WHERE id=@p1;`, id))
+	if err == sql.ErrNoRows {
+		return Question{}, false, nil
+	}
+	if err != nil {
+		return Question{}, false, err
+	}
+	return q, true, nil
+}
+
+func (r *Repo) CreateQuestion(ctx context.Context, formID int, in QuestionInput) (Question, error) {
+	var id int
+	err := r.DB.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_question(form_id, idx, code, qtype, label_th, label_en, required, options, scale_min, scale_max)
+OUTPUT inserted.id
+VALUES(@p1, @p2, @p3, @p4, @p5, NULLIF(@p6,''), @p7, @p8, @p9, @p10);`,
+		formID, in.Idx, strings.TrimSpace(in.Code), in.Type, in.LabelTH, in.LabelEN, in.Required,
+		optionsParam(in.Options), in.ScaleMin, in.ScaleMax).Scan(&id)
+	if isDuplicateKey(err) {
+		return Question{}, ErrDuplicateCode
+	}
+	if err != nil {
+		return Question{}, err
+	}
+	q, _, err := r.GetQuestion(ctx, id)
+	return q, err
+}
+
+// UpdateQuestion ห้ามเปลี่ยนชนิดคำถามเมื่อมีคนตอบแล้ว (คำตอบเดิมจะอ่านไม่ได้)
+func (r *Repo) UpdateQuestion(ctx context.Context, id int, in QuestionInput) (Question, bool, error) {
+	cur, found, err := r.GetQuestion(ctx, id)
+	if err != nil || !found {
+		return Question{}, found, err
+	}
+	if cur.Type != in.Type {
+		n, err := r.countAnswers(ctx, id)
+		if err != nil {
+			return Question{}, true, err
+		}
+		if n > 0 {
+			return Question{}, true, ErrQuestionAnswered
+		}
+	}
+
+	_, err = r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_question
+SET idx=@p2, code=@p3, qtype=@p4, label_th=@p5, label_en=NULLIF(@p6,''), required=@p7,
+    options=@p8, scale_min=@p9, scale_max=@p10, updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`, id, in.Idx, strings.TrimSpace(in.Code), in.Type, in.LabelTH, in.LabelEN, in.Required,
+		optionsParam(in.Options), in.ScaleMin, in.ScaleMax)
+	if isDuplicateKey(err) {
+		return Question{}, true, ErrDuplicateCode
+	}
+	if err != nil {
+		return Question{}, true, err
+	}
+	return r.GetQuestion(ctx, id)
+}
+
+// DeleteQuestion ลบได้เฉพาะคำถามที่ยังไม่มีคำตอบ
+func (r *Repo) DeleteQuestion(ctx context.Context, id int) (bool, error) {
+	n, err := r.countAnswers(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, ErrQuestionAnswered
+	}
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM dbo.eval_question WHERE id=@p1;`, id)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := res.RowsAffected()
+	return affected > 0, nil
+}
+
+func (r *Repo) countAnswers(ctx context.Context, questionID int) (int, error) {
+	var n int
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT COUNT(1) FROM dbo.eval_answer WHERE question_id=@p1;`, questionID).Scan(&n)
+	return n, err
+}
+
+// replaceAnswers แทนที่คำตอบทั้งชุดของ assignment (อ้างคำถามด้วย question_id หรือ code)
+func replaceAnswers(ctx context.Context, tx *sql.Tx, formID, assignmentID int, answers []AdditionalAnswer) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_answer WHERE assignment_id=@p1;`, assignmentID); err != nil {
+		return err
+	}
+	for _, a := range answers {
+		if answerEmpty(a) {
+			continue
+		}
+		var text any
+		switch {
+		case len(a.Values) > 0:
+			b, _ := json.Marshal(a.Values)
+			text = string(b)
+		case a.Value != "":
+			text = a.Value
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO dbo.eval_answer(assignment_id, question_id, value_text, value_num)
+SELECT @p1, q.id, @p5, @p6
+FROM dbo.eval_question q
+WHERE q.form_id=@p2 AND ((@p3 > 0 AND q.id=@p3) OR (@p3 = 0 AND q.code=@p4));`,
+			assignmentID, formID, a.QuestionID, a.Code, text, a.Rating); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAnswers คำตอบของ assignment เรียงตามลำดับคำถาม
+func (r *Repo) loadAnswers(ctx context.Context, assignmentID int) ([]AdditionalAnswer, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT q.id, q.code, q.qtype, an.value_text, CAST(an.value_num AS float)
+FROM dbo.eval_answer an
+JOIN dbo.eval_question q ON q.id = an.question_id
+WHERE an.assignment_id=@p1
+ORDER BY q.idx, q.id;`, assignmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]AdditionalAnswer, 0)
+	for rows.Next() {
+		var (
+			a     AdditionalAnswer
+			qtype string
+			text  sql.NullString
+			num   sql.NullFloat64
+		)
+		if err := rows.Scan(&a.QuestionID, &a.Code, &qtype, &text, &num); err != nil {
+			return nil, err
+		}
+		switch {
+		case qtype == QuestionMultiChoice && text.Valid:
+			if err := json.Unmarshal([]byte(text.String), &a.Values); err != nil {
+				a.Values = []string{text.String}
+			}
+		case text.Valid:
+			a.Value = text.String
+		}
+		if num.Valid {
+			v := num.Float64
+			a.Rating = &v
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// ===== Competency rubric =====
+
+func indicatorsParam(ind []string) any {
+	if len(ind) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(ind)
+	return string(b)
+}
+
+// insertLevels เขียนระดับคะแนนลงตาราง level (ของฟอร์มหรือพจนานุกรม)
+func insertLevels(ctx context.Context, tx *sql.Tx, table, ownerCol string, ownerID int, levels []CompLevel) error {
+	for _, l := range levels {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO `+table+`(`+ownerCol+`, score, label, indicators) VALUES(@p1, @p2, @p3, @p4);`,
+			ownerID, l.Score, strings.TrimSpace(l.Label), indicatorsParam(l.Indicators)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanLevels อ่านแถว (owner_id, score, label, indicators) เป็น owner → ระดับ (เรียงตาม score)
+func scanLevels(rows *sql.Rows) (map[int][]CompLevel, error) {
+	defer rows.Close()
+	out := map[int][]CompLevel{}
+	for rows.Next() {
+		var (
+			owner int
+			l     CompLevel
+			ind   sql.NullString
+		)
+		if err := rows.Scan(&owner, &l.Score, &l.Label, &ind); err != nil {
+			return nil, err
+		}
+		l.Indicators = []string{}
+		if ind.Valid && ind.String != "" {
+			if err := json.Unmarshal([]byte(ind.String), &l.Indicators); err != nil {
+				return nil, fmt.Errorf("competency level indicators: %w", err)
+			}
+		}
+		out[owner] = append(out[owner], l)
+	}
+	return out, rows.Err()
+}
+
+// loadRubrics ระดับของทุกหัวข้อในฟอร์ม: ของฟอร์มเองก่อน ถ้าไม่มีใช้ของพจนานุกรม
+func (r *Repo) loadRubrics(ctx context.Context, formID int) (map[int][]CompLevel, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT c.id, CAST(l.score AS float), l.label, l.indicators
+FROM dbo.eval_competency c
+JOIN dbo.eval_competency_level l ON l.comp_id = c.id
+WHERE c.form_id=@p1
+UNION ALL
+SELECT c.id, CAST(dl.score AS float), dl.label, dl.indicators
+FROM dbo.eval_competency c
+JOIN dbo.eval_competency_def_level dl ON dl.def_id = c.def_id
+WHERE c.form_id=@p1
+  AND NOT EXISTS (SELECT 1 FROM dbo.eval_competency_level l WHERE l.comp_id = c.id)
+ORDER BY 1, 2;`, formID)
+	if err != nil {
+		return nil, err
+	}
+	return scanLevels(rows)
+}
+
+func rubricOf(m map[int][]CompLevel, id int) []CompLevel {
+	if l, ok := m[id]; ok {
+		return l
+	}
+	return []CompLevel{}
+}
+
+// ReplaceCompLevels แทนที่ระดับเฉพาะฟอร์มของหัวข้อ (ว่าง = ใช้ของพจนานุกรม)
+func (r *Repo) ReplaceCompLevels(ctx context.Context, formID, compID int, levels []CompLevel) (bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var n int
+	if err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(1) FROM dbo.eval_competency WHERE id=@p1 AND form_id=@p2;`, compID, formID).Scan(&n); err != nil {
+		return false, err
+	}
+	if n == 0 {
+		_ = tx.Rollback()
+		return false, nil
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM dbo.eval_competency_level WHERE comp_id=@p1;`, compID); err != nil {
+		return false, err
+	}
+	if err = insertLevels(ctx, tx, "dbo.eval_competency_level", "comp_id", compID, levels); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// --- พจนานุกรมสมรรถนะ ---
+
+func (r *Repo) ListCompetencyDefs(ctx context.Context, q string, includeInactive bool) ([]CompetencyDef, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT id, code, title, ISNULL(title_en,''), ISNULL(description,''), active
+FROM dbo.eval_competency_def
+WHERE (@p1 = '' OR code LIKE '%' + @p1 + '%' OR title LIKE '%' + @p1 + '%')
+  AND (@p2 = 1 OR active = 1)
+ORDER BY code, id;`, strings.TrimSpace(q), includeInactive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]CompetencyDef, 0)
+	for rows.Next() {
+		var d CompetencyDef
+		if err := rows.Scan(&d.ID, &d.Code, &d.Title, &d.TitleEN, &d.Description, &d.Active); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lrows, err := r.DB.QueryContext(ctx, `
+SELECT def_id, CAST(score AS float), label, indicators FROM dbo.eval_competency_def_level ORDER BY def_id, score;`)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := scanLevels(lrows)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Levels = rubricOf(levels, out[i].ID)
+	}
+	return out, nil
+}
+
+func (r *Repo) GetCompetencyDef(ctx context.Context, id int) (CompetencyDef, bool, error) {
+	var d CompetencyDef
+	err := r.DB.QueryRowContext(ctx, `
+SELECT id, code, title, ISNULL(title_en,''), ISNULL(description,''), active
+FROM dbo.eval_competency_def WHERE id=@p1;`, id).Scan(&d.ID, &d.Code, &d.Title, &d.TitleEN, &d.Description, &d.Active)
+	if err == sql.ErrNoRows {
+		return CompetencyDef{}, false, nil
+	}
+	if err != nil {
+		return CompetencyDef{}, false, err
+	}
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT def_id, CAST(score AS float), label, indicators FROM dbo.eval_competency_def_level WHERE def_id=@p1 ORDER BY score;`, id)
+	if err != nil {
+		return d, true, err
+	}
+	levels, err := scanLevels(rows)
+	d.Levels = rubricOf(levels, id)
+	return d, true, err
+}
+
+func (r *Repo) CreateCompetencyDef(ctx context.Context, in CompetencyDefInput) (CompetencyDef, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return CompetencyDef{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_competency_def(code, title, title_en, description)
+OUTPUT inserted.id
+VALUES(@p1, @p2, NULLIF(@p3,''), NULLIF(@p4,''));`,
+		strings.TrimSpace(in.Code), in.Title, in.TitleEN, in.Description).Scan(&id)
+	if isDuplicateKey(err) {
+		err = ErrDuplicateCode
+	}
+	if err != nil {
+		return CompetencyDef{}, err
+	}
+	if err = insertLevels(ctx, tx, "dbo.eval_competency_def_level", "def_id", id, in.Levels); err != nil {
+		return CompetencyDef{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return CompetencyDef{}, err
+	}
+	d, _, err := r.GetCompetencyDef(ctx, id)
+	return d, err
+}
+
+// UpdateCompetencyDef แก้หัวข้อและแทนที่ระดับทั้งชุด (ทุกฟอร์มที่อ้างถึงเห็นทันที)
+func (r *Repo) UpdateCompetencyDef(ctx context.Context, id int, in CompetencyDefInput) (CompetencyDef, bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return CompetencyDef{}, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE dbo.eval_competency_def
+SET code=@p2, title=@p3, title_en=NULLIF(@p4,''), description=NULLIF(@p5,''), updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`, id, strings.TrimSpace(in.Code), in.Title, in.TitleEN, in.Description)
+	if isDuplicateKey(err) {
+		err = ErrDuplicateCode
+	}
+	if err != nil {
+		return CompetencyDef{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		_ = tx.Rollback()
+		return CompetencyDef{}, false, nil
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM dbo.eval_competency_def_level WHERE def_id=@p1;`, id); err != nil {
+		return CompetencyDef{}, true, err
+	}
+	if err = insertLevels(ctx, tx, "dbo.eval_competency_def_level", "def_id", id, in.Levels); err != nil {
+		return CompetencyDef{}, true, err
+	}
+	if err = tx.Commit(); err != nil {
+		return CompetencyDef{}, true, err
+	}
+	return r.GetCompetencyDef(ctx, id)
+}
+
+// SetCompetencyDefActive ปิด/เปิดใช้งาน (ฟอร์มที่อ้างอยู่แล้วยังเห็นระดับเดิม)
+func (r *Repo) SetCompetencyDefActive(ctx context.Context, id int, active bool) (bool, error) {
+	res, err := r.DB.ExecContext(ctx,
+		`UPDATE dbo.eval_competency_def SET active=@p2, updated_at=SYSUTCDATETIME() WHERE id=@p1;`, id, active)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ===== Grade bands =====
+
+// bandScope: WHERE ของชุด band ตามขอบเขต (form / company / global)
+const bandScope = `
+((@p1 IS NOT NULL AND form_id = @p1)
+ OR (@p1 IS NULL AND @p2 IS NOT NULL AND form_id IS NULL AND company_id = TRY_CAST(@p2 AS uniqueidentifier))
+ OR (@p1 IS NULL AND @p2 IS NULL AND form_id IS NULL AND company_id IS NULL))`
+
+func (r *Repo) ListGradeBands(ctx context.Context, formID *int, companyID *string) ([]GradeBand, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT id, form_id, CAST(company_id AS nvarchar(36)), grade, CAST(min_pct AS float), CAST(max_pct AS float)
+FROM dbo.eval_grade_band
+WHERE `+bandScope+`
+ORDER BY min_pct DESC;`, formID, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]GradeBand, 0)
+	for rows.Next() {
+		var (
+			b   GradeBand
+			fid sql.NullInt64
+			cid sql.NullString
+		)
+		if err := rows.Scan(&b.ID, &fid, &cid, &b.Grade, &b.MinPct, &b.MaxPct); err != nil {
+			return nil, err
+		}
+		if fid.Valid {
+			v := int(fid.Int64)
+			b.FormID = &v
+		}
+		if cid.Valid {
+			b.CompanyID = &cid.String
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// ReplaceGradeBands แทนที่ทั้งชุดของขอบเขตนั้น (ตรวจช่วงก่อนด้วย ValidateBands)
+func (r *Repo) ReplaceGradeBands(ctx context.Context, in GradeBandSetInput) ([]GradeBand, error) {
+	if err := ValidateBands(in.Bands); err != nil {
+		return nil, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM dbo.eval_grade_band WHERE `+bandScope+`;`, in.FormID, in.CompanyID); err != nil {
+		return nil, err
+	}
+
+	var companyID any
+	if in.FormID == nil && in.CompanyID != nil {
+		companyID = *in.CompanyID
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+INSERT INTO dbo.eval_grade_band(form_id, company_id, grade, min_pct, max_pct)
+VALUES(@p1, TRY_CAST(@p2 AS uniqueidentifier), @p3, @p4, @p5);`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for _, b := range in.Bands {
+		if _, err = stmt.ExecContext(ctx, in.FormID, companyID, b.Grade, b.MinPct, b.MaxPct); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return r.ListGradeBands(ctx, in.FormID, in.CompanyID)
+}
+
+func (r *Repo) DeleteGradeBands(ctx context.Context, formID *int, companyID *string) (int64, error) {
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM dbo.eval_grade_band WHERE `+bandScope+`;`, formID, companyID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PreviewGrade: เปอร์เซ็นต์นี้จะได้เกรดอะไร (ใช้ลำดับเดียวกับ ComputeSummary)
+func (r *Repo) PreviewGrade(ctx context.Context, formID int, companyID string, pct float64) GradePreview {
+	g, gmin, gmax, scope := lookupGrade(ctx, r.DB, formID, 0, companyID, pct)
+	return GradePreview{Pct: pct, Scope: scope, Grade: g, MinPct: gmin, MaxPct: gmax}
+}
+
+// ===== Period windows =====
+
+// WindowError เขียนข้อมูลนอกช่วงเวลาที่ฟอร์มกำหนด
+type WindowError struct {
+	Window string // kpi_cfg / eval
+	Closed bool   // true = เลยกำหนดแล้ว, false = ยังไม่เปิด
+	Start  string
+	End    string
+}
+
+func (e *WindowError) Error() string {
+	if e.Closed {
+		return fmt.Sprintf("%s window closed on %s", e.Window, e.End)
+	}
+	return fmt.Sprintf("%s window opens on %s", e.Window, e.Start)
+}
+
+// Code เช่น KPI_CFG_CLOSED / EVAL_NOT_OPEN
+func (e *WindowError) Code() string {
+	if e.Closed {
+		return strings.ToUpper(e.Window) + "_CLOSED"
+	}
+	return strings.ToUpper(e.Window) + "_NOT_OPEN"
+}
+
+// วันนี้ตามเวลาไทย (yyyy-mm-dd)
+func today() string {
+	loc, err := time.LoadLocation("Asia/Bangkok")
+	if err != nil {
+		loc = time.FixedZone("ICT", 7*3600)
+	}
+	return time.Now().In(loc).Format("2006-01-02")
+}
+
+// CheckWindow ตรวจว่าวันนี้อยู่ในช่วง window ของฟอร์ม (รวมวันที่ HR ขยายให้ assignment นี้)
+// ช่วงที่ไม่ได้ตั้งค่า (NULL) ถือว่าเปิดตลอด
+func (r *Repo) CheckWindow(ctx context.Context, formID, userID int, window string) error {
+	startCol, endCol := "kpi_cfg_start", "kpi_cfg_end"
+	if window == WindowEval {
+		startCol, endCol = "eval_start", "eval_end"
+	}
+
+	var start, end, ext sql.NullString
+	err := r.DB.QueryRowContext(ctx, `
+SELECT CONVERT(varchar(10), f.`+startCol+`, 23),
+       CONVERT(varchar(10), f.`+endCol+`, 23),
+       CONVERT(varchar(10), x.extended_until, 23)
+FROM dbo.eval_form f
+LEFT JOIN dbo.eval_assignment a ON a.form_id = f.id AND a.user_id = @p2
+LEFT JOIN dbo.eval_window_extension x ON x.assignment_id = a.id AND x.window_name = @p3
+WHERE f.id = @p1;`, formID, userID, window).Scan(&start, &end, &ext)
+	if err == sql.ErrNoRows {
+		return nil // ไม่มีฟอร์ม → ให้ขั้นตอนถัดไปจัดการเอง
+	}
+	if err != nil {
+		return err
+	}
+
+	now := today()
+	if start.Valid && now < start.String {
+		return &WindowError{Window: window, Start: start.String, End: end.String}
+	}
+	if end.Valid && now > end.String && !(ext.Valid && now <= ext.String) {
+		closedOn := end.String
+		if ext.Valid && ext.String > closedOn {
+			closedOn = ext.String
+		}
+		return &WindowError{Window: window, Closed: true, Start: start.String, End: closedOn}
+	}
+	return nil
+}
+
+// GrantExtension สร้าง/แก้วันขยายของ assignment (หนึ่งแถวต่อ window)
+func (r *Repo) GrantExtension(ctx context.Context, assignmentID, grantedBy int, in WindowExtensionInput) (WindowExtension, bool, error) {
+	var x WindowExtension
+	err := r.DB.QueryRowContext(ctx, `
+MERGE dbo.eval_window_extension AS t
+USING (SELECT a.id AS assignment_id, @p2 AS window_name, CAST(@p3 AS date) AS extended_until,
+              NULLIF(@p4,'') AS reason, @p5 AS granted_by
+       FROM dbo.eval_assignment a WHERE a.id = @p1) AS s
+ON (t.assignment_id = s.assignment_id AND t.window_name = s.window_name)
+WHEN MATCHED THEN UPDATE SET extended_until=s.extended_until, reason=s.reason, granted_by=s.granted_by, updated_at=SYSUTCDATETIME()
+WHEN NOT MATCHED THEN INSERT(assignment_id, window_name, extended_until, reason, granted_by)
+  VALUES(s.assignment_id, s.window_name, s.extended_until, s.reason, s.granted_by)
+OUTPUT inserted.id, inserted.assignment_id, inserted.window_name,
+       CONVERT(varchar(10), inserted.extended_until, 23), ISNULL(inserted.reason,''), ISNULL(inserted.granted_by,0);`,
+		assignmentID, in.Window, in.ExtendedUntil, in.Reason, grantedBy,
+	).Scan(&x.ID, &x.AssignmentID, &x.Window, &x.ExtendedUntil, &x.Reason, &x.GrantedBy)
+	if err == sql.ErrNoRows {
+		return WindowExtension{}, false, nil
+	}
+	if err != nil {
+		return WindowExtension{}, false, err
+	}
+	return x, true, nil
+}
+
+func (r *Repo) ListExtensions(ctx context.Context, assignmentID int) ([]WindowExtension, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT id, assignment_id, window_name, CONVERT(varchar(10), extended_until, 23), ISNULL(reason,''), ISNULL(granted_by,0)
+FROM dbo.eval_window_extension
+WHERE assignment_id=@p1
+ORDER BY window_name;`, assignmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]WindowExtension, 0)
+	for rows.Next() {
+		var x WindowExtension
+		if err := rows.Scan(&x.ID, &x.AssignmentID, &x.Window, &x.ExtendedUntil, &x.Reason, &x.GrantedBy); err != nil {
+			return nil, err
+		}
+		out = append(out, x)
+	}
+	return out, rows.Err()
+}
+
+// ===== Training catalogue =====
+
+var ErrCourseInactive = errors.New("course is not active")
+
+const courseCols = `c.id, c.code, c.title, ISNULL(c.provider,''), CAST(c.hours AS float), ISNULL(c.description,''), c.active`
+
+// ListCourses filter: q (code/title/provider), competency (def_id), include_inactive
+func (r *Repo) ListCourses(ctx context.Context, f CourseFilter) ([]Course, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT `+courseCols+`
+FROM dbo.eval_course c
+WHERE (@p1 = '' OR c.code LIKE '%' + @p1 + '%' OR c.title LIKE '%' + @p1 + '%' OR c.provider LIKE '%' + @p1 + '%')
+  AND (@p2 = 0 OR EXISTS (SELECT 1 FROM dbo.eval_course_competency cc WHERE cc.course_id = c.id AND cc.def_id = @p2))
+  AND (@p3 = 1 OR c.active = 1)
+ORDER BY c.code, c.id;`, strings.TrimSpace(f.Q), f.CompetencyID, f.IncludeInactive)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Course, 0)
+	for rows.Next() {
+		var c Course
+		if err := rows.Scan(&c.ID, &c.Code, &c.Title, &c.Provider, &c.Hours, &c.Description, &c.Active); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tags, err := r.courseTags(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Competencies = tags[out[i].ID]
+		if out[i].Competencies == nil {
+			out[i].Competencies = []CourseCompetency{}
+		}
+	}
+	return out, nil
+}
+
+// courseTags courseID = 0 → ทุกหลักสูตร
+func (r *Repo) courseTags(ctx context.Context, courseID int) (map[int][]CourseCompetency, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT cc.course_id, d.id, d.code, d.title
+FROM dbo.eval_course_competency cc
+JOIN dbo.eval_competency_def d ON d.id = cc.def_id
+WHERE @p1 = 0 OR cc.course_id = @p1
+ORDER BY cc.course_id, d.code;`, courseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[int][]CourseCompetency{}
+	for rows.Next() {
+		var (
+			cid int
+			t   CourseCompetency
+		)
+		if err := rows.Scan(&cid, &t.DefID, &t.Code, &t.Title); err != nil {
+			return nil, err
+		}
+		out[cid] = append(out[cid], t)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) GetCourse(ctx context.Context, id int) (Course, bool, error) {
+	var c Course
+	err := r.DB.QueryRowContext(ctx, `SELECT `+courseCols+` FROM dbo.eval_course c WHERE c.id=@p1;`, id).
+		Scan(&c.ID, &c.Code, &c.Title, &c.Provider, &c.Hours, &c.Description, &c.Active)
+	if err == sql.ErrNoRows {
+		return Course{}, false, nil
+	}
+	if err != nil {
+		return Course{}, false, err
+	}
+	tags, err := r.courseTags(ctx, id)
+	c.Competencies = tags[id]
+	if c.Competencies == nil {
+		c.Competencies = []CourseCompetency{}
+	}
+	return c, true, err
+}
+
+// CheckCourseCompetencies tag ต้องมีอยู่ในพจนานุกรมสมรรถนะ
+func (r *Repo) CheckCourseCompetencies(ctx context.Context, ids []int) error {
+	var ve ValidationError
+	for i, id := range ids {
+		_, found, err := r.GetCompetencyDef(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			ve.add(fmt.Sprintf("competency_ids[%d]", i), "not_found", "competency definition %d not found", id)
+		}
+	}
+	return ve.errOrNil()
+}
+
+func replaceCourseTags(ctx context.Context, tx *sql.Tx, courseID int, ids []int) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM dbo.eval_course_competency WHERE course_id=@p1;`, courseID); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO dbo.eval_course_competency(course_id, def_id) VALUES(@p1, @p2);`, courseID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repo) CreateCourse(ctx context.Context, in CourseInput) (Course, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Course{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_course(code, title, provider, hours, description)
+OUTPUT inserted.id
+VALUES(@p1, @p2, NULLIF(@p3,''), @p4, NULLIF(@p5,''));`,
+		strings.TrimSpace(in.Code), in.Title, in.Provider, in.Hours, in.Description).Scan(&id)
+	if isDuplicateKey(err) {
+		err = ErrDuplicateCode
+	}
+	if err != nil {
+		return Course{}, err
+	}
+	if err = replaceCourseTags(ctx, tx, id, in.CompetencyIDs); err != nil {
+		return Course{}, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Course{}, err
+	}
+	c, _, err := r.GetCourse(ctx, id)
+	return c, err
+}
+
+func (r *Repo) UpdateCourse(ctx context.Context, id int, in CourseInput) (Course, bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Course{}, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE dbo.eval_course
+SET code=@p2, title=@p3, provider=NULLIF(@p4,''), hours=@p5, description=NULLIF(@p6,''), updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`, id, strings.TrimSpace(in.Code), in.Title, in.Provider, in.Hours, in.Description)
+	if isDuplicateKey(err) {
+		err = ErrDuplicateCode
+	}
+	if err != nil {
+		return Course{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		_ = tx.Rollback()
+		return Course{}, false, nil
+	}
+	if err = replaceCourseTags(ctx, tx, id, in.CompetencyIDs); err != nil {
+		return Course{}, true, err
+	}
+	if err = tx.Commit(); err != nil {
+		return Course{}, true, err
+	}
+	return r.GetCourse(ctx, id)
+}
+
+// SetCourseActive ปิด/เปิดใช้งาน (แผนพัฒนาและการลงทะเบียนเดิมไม่กระทบ)
+func (r *Repo) SetCourseActive(ctx context.Context, id int, active bool) (bool, error) {
+	res, err := r.DB.ExecContext(ctx,
+		`UPDATE dbo.eval_course SET active=@p2, updated_at=SYSUTCDATETIME() WHERE id=@p1;`, id, active)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// CheckDevPlanCourses หลักสูตรที่อ้างในแผนพัฒนาต้องมีอยู่ในคลัง
+func (r *Repo) CheckDevPlanCourses(ctx context.Context, field string, items []DevPlanItemInput) error {
+	var ve ValidationError
+	for i, d := range items {
+		if d.CourseID == nil {
+			continue
+		}
+		_, found, err := r.GetCourse(ctx, *d.CourseID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			ve.add(fmt.Sprintf("%s[%d].course_id", field, i), "not_found", "course %d not found", *d.CourseID)
+		}
+	}
+	return ve.errOrNil()
+}
+
+// --- การลงทะเบียนอบรม ---
+
+const enrolmentCols = `e.id, e.course_id, c.code, c.title, CAST(c.hours AS float), e.user_id, u.name, e.status,
+       CONVERT(varchar(10), e.enrolled_on, 23), CONVERT(varchar(10), e.completed_on, 23),
+       e.cert_number, e.cert_issuer, CONVERT(varchar(10), e.cert_issued_on, 23), CONVERT(varchar(10), e.cert_expires_on, 23), e.cert_url,
+       e.dev_plan_id, e.created_at`
+
+const enrolmentFrom = `
+FROM dbo.eval_enrolment e
+JOIN dbo.eval_course c ON c.id = e.course_id
+JOIN dbo.users u ON u.id = e.user_id`
+
+func scanEnrolment(s rowScanner) (Enrolment, error) {
+	var (
+		e                                            Enrolment
+		doneOn                                       sql.NullString
+		certNo, certIssuer, certOn, certExp, certURL sql.NullString
+	)
+	if err := s.Scan(&e.ID, &e.CourseID, &e.CourseCode, &e.CourseTitle, &e.Hours, &e.UserID, &e.UserName, &e.Status,
+		&e.EnrolledOn, &doneOn, &certNo, &certIssuer, &certOn, &certExp, &certURL, &e.DevPlanID, &e.CreatedAt); err != nil {
+		return e, err
+	}
+	e.CompletedOn = doneOn.String
+	if certNo.Valid || certIssuer.Valid || certOn.Valid || certURL.Valid {
+		e.Certificate = &Certificate{
+			Number: certNo.String, Issuer: certIssuer.String, IssuedOn: certOn.String, ExpiresOn: certExp.String, URL: certURL.String,
+		}
+	}
+	return e, nil
+}
+
+func (r *Repo) ListEnrolments(ctx context.Context, f EnrolmentFilter) ([]Enrolment, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+enrolmentCols+enrolmentFrom+`
+WHERE (@p1 = 0 OR e.user_id = @p1)
+  AND (@p2 = 0 OR e.course_id = @p2)
+  AND (@p3 = '' OR e.status = @p3)
+ORDER BY e.enrolled_on DESC, e.id DESC;`, f.UserID, f.CourseID, strings.TrimSpace(f.Status))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Enrolment, 0)
+	for rows.Next() {
+		e, err := scanEnrolment(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) GetEnrolment(ctx context.Context, id int) (Enrolment, bool, error) {
+	e, err := scanEnrolment(r.DB.QueryRowContext(ctx, `SELECT `+enrolmentCols+enrolmentFrom+` WHERE e.id=@p1;`, id))
+	if err == sql.ErrNoRows {
+		return Enrolment{}, false, nil
+	}
+	if err != nil {
+		return Enrolment{}, false, err
+	}
+	return e, true, nil
+}
+
+// CreateEnrolment ลงทะเบียนหลักสูตรที่เปิดใช้งาน; dev_plan_id ต้องเป็นแผนของพนักงานคนเดียวกัน
+func (r *Repo) CreateEnrolment(ctx context.Context, in EnrolmentInput) (Enrolment, error) {
+	c, found, err := r.GetCourse(ctx, in.CourseID)
+	if err != nil {
+		return Enrolment{}, err
+	}
+	if !found {
+		var ve ValidationError
+		ve.add("course_id", "not_found", "course %d not found", in.CourseID)
+		return Enrolment{}, &ve
+	}
+	if !c.Active {
+		return Enrolment{}, ErrCourseInactive
+	}
+	if in.DevPlanID != nil {
+		var n int
+		if err := r.DB.QueryRowContext(ctx, `
+SELECT COUNT(1) FROM dbo.eval_dev_plan dp
+JOIN dbo.eval_assignment a ON a.id = dp.assignment_id
+WHERE dp.id=@p1 AND a.user_id=@p2;`, *in.DevPlanID, in.UserID).Scan(&n); err != nil {
+			return Enrolment{}, err
+		}
+		if n == 0 {
+			var ve ValidationError
+			ve.add("dev_plan_id", "not_found", "development plan item %d does not belong to this employee", *in.DevPlanID)
+			return Enrolment{}, &ve
+		}
+	}
+
+	on := strings.TrimSpace(in.EnrolledOn)
+	if on == "" {
+		on = today()
+	}
+	var id int
+	if err := r.DB.QueryRowContext(ctx, `
+INSERT INTO dbo.eval_enrolment(course_id, user_id, enrolled_on, dev_plan_id)
+OUTPUT inserted.id
+VALUES(@p1, @p2, @p3, @p4);`, in.CourseID, in.UserID, on, in.DevPlanID).Scan(&id); err != nil {
+		return Enrolment{}, err
+	}
+	e, _, err := r.GetEnrolment(ctx, id)
+	return e, err
+}
+
+// UpdateEnrolment สถานะ + ใบประกาศ; ไม่ใช่ completed = ล้างวันที่จบและใบประกาศ
+func (r *Repo) UpdateEnrolment(ctx context.Context, id int, in EnrolmentUpdateInput) (Enrolment, bool, error) {
+	doneOn := ""
+	cert := Certificate{}
+	if in.Status == EnrolCompleted {
+		doneOn = strings.TrimSpace(in.CompletedOn)
+		if doneOn == "" {
+			doneOn = today()
+		}
+		if in.Certificate != nil {
+			cert = *in.Certificate
+		}
+	}
+	res, err := r.DB.ExecContext(ctx, `
+UPDATE dbo.eval_enrolment
+SET status=@p2, completed_on=NULLIF(@p3,''),
+    cert_number=NULLIF(@p4,''), cert_issuer=NULLIF(@p5,''), cert_issued_on=NULLIF(@p6,''),
+    cert_expires_on=NULLIF(@p7,''), cert_url=NULLIF(@p8,''), updated_at=SYSUTCDATETIME()
+WHERE id=@p1;`, id, in.Status, doneOn,
+		strings.TrimSpace(cert.Number), strings.TrimSpace(cert.Issuer), cert.IssuedOn, cert.ExpiresOn, strings.TrimSpace(cert.URL))
+	if err != nil {
+		return Enrolment{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return Enrolment{}, false, nil
+	}
+	return r.GetEnrolment(ctx, id)
+}
+
+// TrainingHours ชั่วโมงอบรมตามแผนพัฒนา (ไม่นับรายการที่ยกเลิก) เทียบกับที่พนักงานอบรมจบแล้วในหลักสูตรเดียวกัน
+func (r *Repo) TrainingHours(ctx context.Context, formID int, f ReportFilter) ([]TrainingHoursRow, error) {
+	rows, err := r.DB.QueryContext(ctx, `
+SELECT x.department, COUNT(DISTINCT x.user_id), COUNT(dp.id),
+       ISNULL(SUM(CAST(c.hours AS float)), 0),
+       ISNULL(SUM(CASE WHEN done.ok = 1 THEN 1 ELSE 0 END), 0),
+       ISNULL(SUM(CASE WHEN done.ok = 1 THEN CAST(c.hours AS float) END), 0)
+FROM (SELECT a.id, a.user_id, ISNULL(u.department,'') AS department `+reportBase+`) x
+LEFT JOIN dbo.eval_dev_plan dp ON dp.assignment_id = x.id AND dp.course_id IS NOT NULL AND dp.status <> N'cancelled'
+LEFT JOIN dbo.eval_course c ON c.id = dp.course_id
+OUTER APPLY (SELECT TOP 1 1 AS ok FROM dbo.eval_enrolment e
+             WHERE e.user_id = x.user_id AND e.course_id = dp.course_id AND e.status = 'completed') done
+GROUP BY x.department
+ORDER BY x.department;`, reportArgs(formID, f, today())...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]TrainingHoursRow, 0)
+	for rows.Next() {
+		var t TrainingHoursRow
+		if err := rows.Scan(&t.Department, &t.Employees, &t.PlannedItems, &t.PlannedHours, &t.CompletedItems, &t.CompletedHours); err != nil {
+			return nil, err
+		}
+		t.PlannedHours = round2(t.PlannedHours)
+		t.CompletedHours = round2(t.CompletedHours)
+		if t.PlannedHours > 0 {
+			t.CompletionPct = round2(t.CompletedHours * 100 / t.PlannedHours)
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
 }
@@ -0,0 +1,297 @@
+package eval
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ===== Scoring engine (pure Go, ไม่แตะ DB) =====
+
+// calc_method ของ eval_form
+const (
+	CalcWeightedAvg = 1 // ถ่วงน้ำหนักแล้วหารด้วยน้ำหนักรวม (ค่าเดิม)
+	CalcCappedSum   = 2 // รวมคะแนนถ่วงน้ำหนักตรงๆ แล้วตัดที่ 100
+	CalcSimpleAvg   = 3 // เฉลี่ยธรรมดา ไม่ใช้น้ำหนักรายข้อ
+)
+
+// score_scheme ของ eval_form
+const (
+	Scheme0to5   = 1
+	Scheme0to100 = 2
+)
+
+// schemeMax คะแนนเต็มของรูปแบบการให้คะแนน (ใช้เมื่อรายการไม่มี max_score)
+func schemeMax(scheme int) float64 {
+	if scheme == Scheme0to100 {
+		return 100
+	}
+	return 5
+}
+
+type RoundMode int
+
+const (
+	RoundHalfUp   RoundMode = iota // 2.345 → 2.35
+	RoundHalfEven                  // banker's rounding
+	RoundDown                      // ตัดทิ้ง
+)
+
+type Rounding struct {
+	Places int
+	Mode   RoundMode
+}
+
+func (r Rounding) apply(v float64) float64 {
+	if r.Places < 0 {
+		return v
+	}
+	p := math.Pow(10, float64(r.Places))
+	x := scaleDecimal(v, r.Places)
+	switch r.Mode {
+	case RoundHalfEven:
+		return math.RoundToEven(x) / p
+	case RoundDown:
+		return math.Trunc(x) / p
+	default:
+		return math.Round(x) / p
+	}
+}
+
+// scaleDecimal คูณ v ด้วย 10^places บนตัวเลขฐานสิบที่สั้นที่สุดของ v
+// (2.345*100 แบบ float = 234.49999… แต่ "2.345e2" = 234.5 พอดี)
+func scaleDecimal(v float64, places int) float64 {
+	x, err := strconv.ParseFloat(strconv.FormatFloat(v, 'g', -1, 64)+"e"+strconv.Itoa(places), 64)
+	if err != nil { // NaN / Inf
+		return v * math.Pow(10, float64(places))
+	}
+	return x
+}
+
+// ScoreItem หนึ่งรายการ (KPI / competency) — Weight เป็นเปอร์เซ็นต์ 0..100
+type ScoreItem struct {
+	Score    float64
+	MaxScore float64
+	Weight   float64
+}
+
+type ScoreInput struct {
+	CalcMethod  int
+	ScoreScheme int
+	KPIWeight   int
+	CompWeight  int
+	TAWeight    int
+	KPIs        []ScoreItem
+	Comps       []ScoreItem
+	TAScore     float64
+	TAFull      float64
+}
+
+type ScoreResult struct {
+	KPIPct     float64
+	CompPct    float64
+	TAPct      float64
+	TotalPct   float64
+	TotalScore float64 // TotalPct แปลงเป็นสเกลของ score_scheme (เช่น 0-5)
+}
+
+// Strategy คำนวณเปอร์เซ็นต์ (0..100) ของหนึ่งส่วนจากรายการที่ normalise แล้ว
+type Strategy interface {
+	SectionPct(items []ScoreItem, scheme int) float64
+}
+
+type Engine struct {
+	Rounding   Rounding
+	Strategies map[int]Strategy
+}
+
+// NewEngine: strategy ตาม calc_method; calc_method ที่ไม่รู้จักใช้ weighted average
+func NewEngine(rounding Rounding) *Engine {
+	return &Engine{
+		Rounding: rounding,
+		Strategies: map[int]Strategy{
+			CalcWeightedAvg: weightedAvg{},
+			CalcCappedSum:   cappedSum{},
+			CalcSimpleAvg:   simpleAvg{},
+		},
+	}
+}
+
+func DefaultEngine() *Engine { return NewEngine(Rounding{Places: 2, Mode: RoundHalfUp}) }
+
+func (e *Engine) strategy(method int) Strategy {
+	if s, ok := e.Strategies[method]; ok {
+		return s
+	}
+	return e.Strategies[CalcWeightedAvg]
+}
+
+func (e *Engine) Compute(in ScoreInput) ScoreResult {
+	s := e.strategy(in.CalcMethod)
+
+	var out ScoreResult
+	out.KPIPct = e.Rounding.apply(s.SectionPct(in.KPIs, in.ScoreScheme))
+	out.CompPct = e.Rounding.apply(s.SectionPct(in.Comps, in.ScoreScheme))
+	out.TAPct = e.Rounding.apply(100 * ratio(ScoreItem{Score: in.TAScore, MaxScore: in.TAFull}, in.ScoreScheme))
+
+	total := (out.KPIPct*float64(in.KPIWeight) +
+		out.CompPct*float64(in.CompWeight) +
+		out.TAPct*float64(in.TAWeight)) / 100.0
+	if in.CalcMethod == CalcCappedSum {
+		total = math.Min(total, 100)
+	}
+	out.TotalPct = e.Rounding.apply(total)
+	out.TotalScore = e.Rounding.apply(out.TotalPct / 100 * schemeMax(in.ScoreScheme))
+	return out
+}
+
+// ratio: score/max ตัดให้อยู่ใน 0..1 (ถ้าไม่มี max_score ใช้คะแนนเต็มของ scheme)
+func ratio(it ScoreItem, scheme int) float64 {
+	max := it.MaxScore
+	if max <= 0 {
+		max = schemeMax(scheme)
+	}
+	r := it.Score / max
+	return math.Max(0, math.Min(1, r))
+}
+
+type weightedAvg struct{}
+
+func (weightedAvg) SectionPct(items []ScoreItem, scheme int) float64 {
+	var sum, wsum float64
+	for _, it := range items {
+		sum += ratio(it, scheme) * it.Weight
+		wsum += it.Weight
+	}
+	if wsum == 0 {
+		return 0
+	}
+	return sum / wsum * 100
+}
+
+type cappedSum struct{}
+
+func (cappedSum) SectionPct(items []ScoreItem, scheme int) float64 {
+	var sum float64
+	for _, it := range items {
+		sum += ratio(it, scheme) * it.Weight
+	}
+	return math.Min(sum, 100)
+}
+
+type simpleAvg struct{}
+
+func (simpleAvg) SectionPct(items []ScoreItem, scheme int) float64 {
+	if len(items) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, it := range items {
+		sum += ratio(it, scheme)
+	}
+	return sum / float64(len(items)) * 100
+}
+
+// StepScore คะแนนของหนึ่งรายการจากหนึ่งขั้นการประเมิน
+type StepScore struct {
+	Idx    int
+	Weight float64
+	Score  float64
+}
+
+// CombineStepScores รวมคะแนนหลายขั้นเป็นคะแนนสุดท้าย:
+// ถ่วงด้วยน้ำหนักของขั้น (เฉลี่ยเฉพาะขั้นที่ให้คะแนนแล้ว); ถ้าไม่ได้ตั้งน้ำหนักเลย ใช้ขั้นล่าสุด
+func CombineStepScores(scores []StepScore) float64 {
+	var sum, wsum float64
+	latest := -1
+	for i, s := range scores {
+		sum += s.Score * s.Weight
+		wsum += s.Weight
+		if latest < 0 || s.Idx > scores[latest].Idx {
+			latest = i
+		}
+	}
+	if wsum > 0 {
+		return sum / wsum
+	}
+	if latest < 0 {
+		return 0
+	}
+	return scores[latest].Score
+}
+
+// ===== KPI scoring rule (ผลจริง → คะแนน) =====
+
+const (
+	RuleManual = "manual" // ไม่คำนวณอัตโนมัติ (ใช้ล้าง rule เดิม)
+	RuleLinear = "linear" // เทียบสัดส่วนระหว่าง baseline (0 คะแนน) กับ target (คะแนนเต็ม)
+	RuleBands  = "bands"  // ช่วงเกณฑ์ → คะแนน
+
+	DirHigher = "higher" // ยิ่งมากยิ่งดี
+	DirLower  = "lower"  // ยิ่งน้อยยิ่งดี
+)
+
+// KPIScoreBand higher: actual >= threshold ได้ score; lower: actual <= threshold ได้ score
+type KPIScoreBand struct {
+	Threshold float64 `json:"threshold"`
+	Score     float64 `json:"score"`
+}
+
+type KPIScoringRule struct {
+	Method    string         `json:"method"`
+	Direction string         `json:"direction"`
+	Target    float64        `json:"target"`
+	Baseline  *float64       `json:"baseline,omitempty"` // linear: higher ไม่ระบุ = 0, lower ต้องระบุ
+	Bands     []KPIScoreBand `json:"bands,omitempty"`
+}
+
+// Score คะแนนจากผลจริง ตัดให้อยู่ใน [0, maxScore]
+func (r KPIScoringRule) Score(actual, maxScore float64) float64 {
+	var s float64
+	switch r.Method {
+	case RuleLinear:
+		base := 0.0
+		if r.Baseline != nil {
+			base = *r.Baseline
+		}
+		span := r.Target - base
+		if r.Direction == DirLower {
+			span = base - r.Target
+		}
+		switch {
+		case span == 0 && r.Direction == DirLower && actual <= r.Target,
+			span == 0 && r.Direction != DirLower && actual >= r.Target:
+			s = maxScore
+		case span == 0:
+			s = 0
+		case r.Direction == DirLower:
+			s = (base - actual) / span * maxScore
+		default:
+			s = (actual - base) / span * maxScore
+		}
+	case RuleBands:
+		bands := append([]KPIScoreBand(nil), r.Bands...)
+		sort.Slice(bands, func(i, j int) bool { return bands[i].Threshold < bands[j].Threshold })
+		if r.Direction == DirLower {
+			for i := len(bands) - 1; i >= 0; i-- {
+				if actual <= bands[i].Threshold {
+					s = bands[i].Score
+				}
+			}
+		} else {
+			for _, b := range bands {
+				if actual >= b.Threshold {
+					s = b.Score
+				}
+			}
+		}
+	}
+	return round2(math.Max(0, math.Min(maxScore, s)))
+}
+
+// applyKPIRule คำนวณ score จาก actual_value เมื่อมี rule และไม่ได้ override
+func applyKPIRule(it *MyKPIInput) {
+	if it.ScoringRule != nil && it.ScoringRule.Method != RuleManual && it.ActualValue != nil && !it.ScoreOverride {
+		it.Score = it.ScoringRule.Score(*it.ActualValue, it.MaxScore)
+	}
+}
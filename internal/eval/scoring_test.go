@@ -0,0 +1,214 @@
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestEngineCompute(t *testing.T) {
+	mixedKPIs := []ScoreItem{{Score: 4, MaxScore: 5, Weight: 60}, {Score: 3, MaxScore: 5, Weight: 40}}
+	fullComp := []ScoreItem{{Score: 5, MaxScore: 5, Weight: 100}}
+
+	tests := []struct {
+		name string
+		in   ScoreInput
+		want ScoreResult
+	}{
+		{
+			name: "weighted average",
+			in: ScoreInput{CalcMethod: CalcWeightedAvg, ScoreScheme: Scheme0to5, KPIWeight: 70, CompWeight: 30,
+				KPIs: mixedKPIs, Comps: fullComp},
+			want: ScoreResult{KPIPct: 72, CompPct: 100, TotalPct: 80.4, TotalScore: 4.02},
+		},
+		{
+			name: "unknown method falls back to weighted average",
+			in: ScoreInput{CalcMethod: 99, ScoreScheme: Scheme0to5, KPIWeight: 70, CompWeight: 30,
+				KPIs: mixedKPIs, Comps: fullComp},
+			want: ScoreResult{KPIPct: 72, CompPct: 100, TotalPct: 80.4, TotalScore: 4.02},
+		},
+		{
+			name: "simple average ignores item weights",
+			in: ScoreInput{CalcMethod: CalcSimpleAvg, ScoreScheme: Scheme0to5, KPIWeight: 70, CompWeight: 30,
+				KPIs: mixedKPIs, Comps: fullComp},
+			want: ScoreResult{KPIPct: 70, CompPct: 100, TotalPct: 79, TotalScore: 3.95},
+		},
+		{
+			name: "capped sum stops at 100",
+			in: ScoreInput{CalcMethod: CalcCappedSum, ScoreScheme: Scheme0to5, KPIWeight: 70, CompWeight: 30,
+				KPIs:  []ScoreItem{{Score: 5, MaxScore: 5, Weight: 80}, {Score: 5, MaxScore: 5, Weight: 40}},
+				Comps: []ScoreItem{{Score: 0, MaxScore: 5, Weight: 100}}},
+			want: ScoreResult{KPIPct: 100, CompPct: 0, TotalPct: 70, TotalScore: 3.5},
+		},
+		{
+			name: "weighted average with zero weights",
+			in: ScoreInput{CalcMethod: CalcWeightedAvg, ScoreScheme: Scheme0to5, KPIWeight: 100,
+				KPIs: []ScoreItem{{Score: 5, MaxScore: 5}, {Score: 4, MaxScore: 5}}},
+			want: ScoreResult{},
+		},
+		{
+			name: "section weights all zero",
+			in: ScoreInput{CalcMethod: CalcWeightedAvg, ScoreScheme: Scheme0to5,
+				KPIs: mixedKPIs, Comps: fullComp},
+			want: ScoreResult{KPIPct: 72, CompPct: 100},
+		},
+		{
+			name: "0-100 scheme without max score",
+			in: ScoreInput{CalcMethod: CalcWeightedAvg, ScoreScheme: Scheme0to100, KPIWeight: 100,
+				KPIs: []ScoreItem{{Score: 80, Weight: 100}}},
+			want: ScoreResult{KPIPct: 80, TotalPct: 80, TotalScore: 80},
+		},
+		{
+			name: "score above max is clipped",
+			in: ScoreInput{CalcMethod: CalcWeightedAvg, ScoreScheme: Scheme0to5, KPIWeight: 100,
+				KPIs: []ScoreItem{{Score: 6, MaxScore: 5, Weight: 100}}},
+			want: ScoreResult{KPIPct: 100, TotalPct: 100, TotalScore: 5},
+		},
+		{
+			name: "time attendance section",
+			in:   ScoreInput{CalcMethod: CalcWeightedAvg, ScoreScheme: Scheme0to5, TAWeight: 100, TAScore: 18, TAFull: 20},
+			want: ScoreResult{TAPct: 90, TotalPct: 90, TotalScore: 4.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultEngine().Compute(tt.in)
+			if !approx(got.KPIPct, tt.want.KPIPct) || !approx(got.CompPct, tt.want.CompPct) ||
+				!approx(got.TAPct, tt.want.TAPct) || !approx(got.TotalPct, tt.want.TotalPct) ||
+				!approx(got.TotalScore, tt.want.TotalScore) {
+				t.Errorf("Compute() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompWeightFraction(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{0, 0},
+		{0.7, 0.7},
+		{1, 1}, // 1 = สัดส่วนเต็ม ไม่ใช่ 1%
+		{70, 0.7},
+		{100, 1},
+		{1.5, 0.015},
+	}
+	for _, tt := range tests {
+		if got := compWeightFraction(tt.in); !approx(got, tt.want) {
+			t.Errorf("compWeightFraction(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRounding(t *testing.T) {
+	// 2.345 / 1.005 แทนด้วย float ไม่ได้พอดี (ต่ำกว่าค่าจริงเล็กน้อย) ต้องยังปัดตามเลขฐานสิบ
+	tests := []struct {
+		name string
+		r    Rounding
+		in   float64
+		want float64
+	}{
+		{"half up", Rounding{2, RoundHalfUp}, 2.125, 2.13},
+		{"half up 2.345", Rounding{2, RoundHalfUp}, 2.345, 2.35},
+		{"half up 1.005", Rounding{2, RoundHalfUp}, 1.005, 1.01},
+		{"half even 2.345", Rounding{2, RoundHalfEven}, 2.345, 2.34},
+		{"half even 2.355", Rounding{2, RoundHalfEven}, 2.355, 2.36},
+		{"down 1.005", Rounding{2, RoundDown}, 1.005, 1},
+		{"half up large value", Rounding{2, RoundHalfUp}, 12345.675, 12345.68},
+		{"half up 2.375", Rounding{2, RoundHalfUp}, 2.375, 2.38},
+		{"half even rounds down to even", Rounding{2, RoundHalfEven}, 2.125, 2.12},
+		{"half even rounds up to even", Rounding{2, RoundHalfEven}, 2.375, 2.38},
+		{"down truncates", Rounding{2, RoundDown}, 2.379, 2.37},
+		{"down truncates negative toward zero", Rounding{2, RoundDown}, -2.379, -2.37},
+		{"zero places half up", Rounding{0, RoundHalfUp}, 2.5, 3},
+		{"zero places half even", Rounding{0, RoundHalfEven}, 2.5, 2},
+		{"zero places down", Rounding{0, RoundDown}, 2.99, 2},
+		{"negative places leaves value", Rounding{-1, RoundHalfUp}, 2.12345, 2.12345},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.apply(tt.in); !approx(got, tt.want) {
+				t.Errorf("apply(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKPIScoringRule(t *testing.T) {
+	fptr := func(v float64) *float64 { return &v }
+	higherBands := KPIScoringRule{Method: RuleBands, Direction: DirHigher,
+		Bands: []KPIScoreBand{{Threshold: 100, Score: 5}, {Threshold: 60, Score: 1}, {Threshold: 80, Score: 3}}}
+	lowerBands := KPIScoringRule{Method: RuleBands, Direction: DirLower,
+		Bands: []KPIScoreBand{{Threshold: 2, Score: 5}, {Threshold: 10, Score: 1}, {Threshold: 5, Score: 3}}}
+
+	tests := []struct {
+		name   string
+		rule   KPIScoringRule
+		actual float64
+		want   float64
+	}{
+		// linear, higher is better (baseline ไม่ระบุ = 0)
+		{"linear higher half", KPIScoringRule{Method: RuleLinear, Direction: DirHigher, Target: 100}, 50, 2.5},
+		{"linear higher at target", KPIScoringRule{Method: RuleLinear, Direction: DirHigher, Target: 100}, 100, 5},
+		{"linear higher above target capped", KPIScoringRule{Method: RuleLinear, Direction: DirHigher, Target: 100}, 120, 5},
+		{"linear higher below zero floored", KPIScoringRule{Method: RuleLinear, Direction: DirHigher, Target: 100}, -10, 0},
+		{"linear higher at baseline", KPIScoringRule{Method: RuleLinear, Direction: DirHigher, Target: 100, Baseline: fptr(50)}, 50, 0},
+		{"linear higher with baseline", KPIScoringRule{Method: RuleLinear, Direction: DirHigher, Target: 100, Baseline: fptr(50)}, 75, 2.5},
+		// linear, lower is better
+		{"linear lower half", KPIScoringRule{Method: RuleLinear, Direction: DirLower, Target: 2, Baseline: fptr(10)}, 6, 2.5},
+		{"linear lower at target", KPIScoringRule{Method: RuleLinear, Direction: DirLower, Target: 2, Baseline: fptr(10)}, 2, 5},
+		{"linear lower beyond target capped", KPIScoringRule{Method: RuleLinear, Direction: DirLower, Target: 2, Baseline: fptr(10)}, 1, 5},
+		{"linear lower worse than baseline", KPIScoringRule{Method: RuleLinear, Direction: DirLower, Target: 2, Baseline: fptr(10)}, 12, 0},
+		// linear, baseline = target
+		{"linear zero span higher met", KPIScoringRule{Method: RuleLinear, Direction: DirHigher, Target: 10, Baseline: fptr(10)}, 10, 5},
+		{"linear zero span higher missed", KPIScoringRule{Method: RuleLinear, Direction: DirHigher, Target: 10, Baseline: fptr(10)}, 9.99, 0},
+		{"linear zero span lower met", KPIScoringRule{Method: RuleLinear, Direction: DirLower, Target: 10, Baseline: fptr(10)}, 10, 5},
+		{"linear zero span lower missed", KPIScoringRule{Method: RuleLinear, Direction: DirLower, Target: 10, Baseline: fptr(10)}, 10.01, 0},
+		// bands, higher is better (ส่งมาไม่เรียง)
+		{"bands higher below lowest", higherBands, 59.99, 0},
+		{"bands higher at lowest boundary", higherBands, 60, 1},
+		{"bands higher just below middle", higherBands, 79.99, 1},
+		{"bands higher at middle boundary", higherBands, 80, 3},
+		{"bands higher at top boundary", higherBands, 100, 5},
+		{"bands higher above top", higherBands, 150, 5},
+		// bands, lower is better
+		{"bands lower above highest", lowerBands, 10.01, 0},
+		{"bands lower at highest boundary", lowerBands, 10, 1},
+		{"bands lower between", lowerBands, 6, 1},
+		{"bands lower at middle boundary", lowerBands, 5, 3},
+		{"bands lower at best boundary", lowerBands, 2, 5},
+		{"bands lower below best", lowerBands, 0, 5},
+		{"bands score capped at max", KPIScoringRule{Method: RuleBands, Bands: []KPIScoreBand{{Threshold: 0, Score: 10}}}, 1, 5},
+		{"manual scores nothing", KPIScoringRule{Method: RuleManual}, 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Score(tt.actual, 5); !approx(got, tt.want) {
+				t.Errorf("Score(%v) = %v, want %v", tt.actual, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCombineStepScores(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []StepScore
+		want   float64
+	}{
+		{"no steps scored", nil, 0},
+		{"no weights uses latest step", []StepScore{{Idx: 2, Score: 3}, {Idx: 1, Score: 4}}, 3},
+		{"weighted steps", []StepScore{{Idx: 1, Weight: 30, Score: 4}, {Idx: 2, Weight: 70, Score: 2}}, 2.6},
+		{"missing manager step averages scored steps only", []StepScore{{Idx: 1, Weight: 30, Score: 4}}, 4},
+		{"missing self step", []StepScore{{Idx: 2, Weight: 70, Score: 2}}, 2},
+		{"zero-weight step is ignored", []StepScore{{Idx: 1, Score: 5}, {Idx: 2, Weight: 50, Score: 3}}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CombineStepScores(tt.scores); !approx(got, tt.want) {
+				t.Errorf("CombineStepScores() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
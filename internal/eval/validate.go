@@ -0,0 +1,995 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldError ข้อผิดพลาดรายฟิลด์ (field เป็น path แบบ kpis[0].score)
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError รวม FieldError ทั้งหมด (handler ตอบ 422)
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return "validation failed"
+	}
+	return fmt.Sprintf("validation failed: %s %s", e.Fields[0].Field, e.Fields[0].Message)
+}
+
+func (e *ValidationError) add(field, code, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+// errOrNil คืน nil ถ้าไม่มีข้อผิดพลาด (กัน typed-nil)
+func (e *ValidationError) errOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+const weightEpsilon = 0.01
+
+func nearlyEqual(a, b float64) bool { return math.Abs(a-b) <= weightEpsilon }
+
+// compWeightFraction: CompItem.Weight อาจส่งมาเป็น 0.70 หรือ 70 → แปลงเป็นสัดส่วน 0..1
+func compWeightFraction(w float64) float64 {
+	if w <= 1 {
+		return w
+	}
+	return w / 100.0
+}
+
+// ValidateForm: น้ำหนักแต่ละส่วนรวมกันต้องเท่ากับ total_weight และช่วงวันที่ต้องไม่กลับด้าน
+func ValidateForm(in CreateFormInput) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Code) == "" {
+		ve.add("code", "required", "is required")
+	}
+	if strings.TrimSpace(in.TitleTH) == "" {
+		ve.add("title_th", "required", "is required")
+	}
+	weights := []struct {
+		field string
+		w     int
+	}{{"kpi_weight", in.KPIWeight}, {"comp_weight", in.CompWeight}, {"ta_weight", in.TAWeight}}
+	for _, x := range weights {
+		if x.w < 0 || x.w > 100 {
+			ve.add(x.field, "out_of_range", "must be between 0 and 100")
+		}
+	}
+	if sum := in.KPIWeight + in.CompWeight + in.TAWeight; sum != in.TotalWeight {
+		ve.add("total_weight", "weight_sum_mismatch",
+			"kpi_weight + comp_weight + ta_weight = %d, expected %d", sum, in.TotalWeight)
+	}
+
+	periods := []struct{ start, end, sv, ev string }{
+		{"kpi_cfg_start", "kpi_cfg_end", in.KPICfgStart, in.KPICfgEnd},
+		{"eval_start", "eval_end", in.EvalStart, in.EvalEnd},
+		{"other_lv_start", "other_lv_end", in.OtherLvStart, in.OtherLvEnd},
+		{"annual_lv_start", "annual_lv_end", in.AnnualLvStart, in.AnnualLvEnd},
+	}
+	for _, p := range periods {
+		s, okS := parseDate(&ve, p.start, p.sv)
+		e, okE := parseDate(&ve, p.end, p.ev)
+		if okS && okE && e.Before(s) {
+			ve.add(p.end, "invalid_range", "must not be before %s", p.start)
+		}
+	}
+	return ve.errOrNil()
+}
+
+func parseDate(ve *ValidationError, field, v string) (time.Time, bool) {
+	if strings.TrimSpace(v) == "" {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		ve.add(field, "invalid_date", "must be yyyy-mm-dd")
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// validateKPIItems ตรวจแต่ละรายการ + ผลรวมน้ำหนัก
+// strict (submit) = รวมต้องเท่ากับ 100, relaxed (draft) = ไม่เกิน 100
+// existingWeight = น้ำหนักของ KPI เดิมที่ยังอยู่ (ใช้กับการ append)
+func validateKPIItems(ve *ValidationError, prefix string, items []MyKPIInput, existingWeight int, strict bool) {
+	sum := existingWeight
+	for i, it := range items {
+		f := fmt.Sprintf("%s[%d]", prefix, i)
+		if strings.TrimSpace(it.Title) == "" {
+			ve.add(f+".title", "required", "is required")
+		}
+		if it.MaxScore <= 0 {
+			ve.add(f+".max_score", "out_of_range", "must be greater than 0")
+		}
+		if it.Weight < 0 || it.Weight > 100 {
+			ve.add(f+".weight", "out_of_range", "must be between 0 and 100")
+		}
+		if it.Score < 0 || (it.MaxScore > 0 && it.Score > it.MaxScore) {
+			ve.add(f+".score", "out_of_range", "must be between 0 and max_score (%.2f)", it.MaxScore)
+		}
+		if it.ExpectedScore < 0 || (it.MaxScore > 0 && it.ExpectedScore > it.MaxScore) {
+			ve.add(f+".expected_score", "out_of_range", "must be between 0 and max_score (%.2f)", it.MaxScore)
+		}
+		if it.ScoringRule != nil {
+			validateScoringRule(ve, f+".scoring_rule", *it.ScoringRule, it.MaxScore)
+		}
+		sum += it.Weight
+	}
+
+	switch {
+	case strict && sum != 100:
+		ve.add(prefix, "weight_sum_mismatch", "KPI weights add up to %d, expected 100", sum)
+	case !strict && sum > 100:
+		ve.add(prefix, "weight_sum_exceeded", "KPI weights add up to %d, must not exceed 100", sum)
+	}
+}
+
+// ValidateKPIs ใช้กับ AddMyKPIsBulk / ReplaceMyKPIs (บันทึกร่างเสมอ → relaxed)
+func ValidateKPIs(items []MyKPIInput, existingWeight int) error {
+	var ve ValidationError
+	validateKPIItems(&ve, "items", items, existingWeight, false)
+	return ve.errOrNil()
+}
+
+// validateCompWeights: ทุกข้อต้องใช้สเกลเดียวกัน (0..1 หรือ 0..100) และรวมไม่เกิน 100%
+// strict = รวมต้องเท่ากับ 100% พอดี
+func validateCompWeights(ve *ValidationError, field string, weights []float64, strict bool) {
+	if len(weights) == 0 {
+		return
+	}
+	fractions, percents := 0, 0
+	sum := 0.0
+	for _, w := range weights {
+		switch {
+		case w < 0:
+			ve.add(field, "out_of_range", "competency weight must not be negative")
+			return
+		case w == 0:
+		case w <= 1:
+			fractions++
+		default:
+			percents++
+		}
+		sum += compWeightFraction(w)
+	}
+	if fractions > 0 && percents > 0 {
+		ve.add(field, "inconsistent_weight_scale",
+			"competency weights mix fractions (0-1) and percentages (0-100)")
+		return
+	}
+	switch {
+	case strict && !nearlyEqual(sum, 1):
+		ve.add(field, "weight_sum_mismatch", "competency weights add up to %.2f%%, expected 100%%", sum*100)
+	case sum > 1+weightEpsilon:
+		ve.add(field, "weight_sum_exceeded", "competency weights add up to %.2f%%, must not exceed 100%%", sum*100)
+	}
+}
+
+// ValidateComps ใช้กับ AddCompsBulk: ตรวจรายการใหม่ + สเกลน้ำหนักรวมกับของเดิมในฟอร์ม
+func ValidateComps(items []CompInput, existing []CompItem) error {
+	var ve ValidationError
+	weights := make([]float64, 0, len(items)+len(existing))
+	for _, c := range existing {
+		weights = append(weights, c.Weight)
+	}
+	for i, it := range items {
+		f := fmt.Sprintf("items[%d]", i)
+		if strings.TrimSpace(it.Title) == "" {
+			ve.add(f+".title", "required", "is required")
+		}
+		if it.MaxScore <= 0 {
+			ve.add(f+".max_score", "out_of_range", "must be greater than 0")
+		}
+		if it.ExpectedScore < 0 || (it.MaxScore > 0 && it.ExpectedScore > it.MaxScore) {
+			ve.add(f+".expected_score", "out_of_range", "must be between 0 and max_score (%.2f)", it.MaxScore)
+		}
+		validateCompLevels(&ve, f+".levels", it.Levels, it.MaxScore)
+		weights = append(weights, it.Weight)
+	}
+	validateCompWeights(&ve, "items", weights, false)
+	return ve.errOrNil()
+}
+
+// ValidateSaveAll: strict เมื่อ submit (status=2) — KPI รวม 100, ให้คะแนน competency ครบทุกข้อ, ตอบคำถามที่ required
+func ValidateSaveAll(in SaveAllInput, comps []CompItem, questions []Question, strict bool) error {
+	var ve ValidationError
+
+	validateKPIItems(&ve, "kpis", in.KPIs, 0, strict)
+
+	byID := make(map[int]CompItem, len(comps))
+	weights := make([]float64, 0, len(comps))
+	for _, c := range comps {
+		byID[c.ID] = c
+		weights = append(weights, c.Weight)
+	}
+	scored := make(map[int]bool, len(in.CompetencyScores))
+	for i, cs := range in.CompetencyScores {
+		f := fmt.Sprintf("competency_scores[%d]", i)
+		c, ok := byID[cs.CompID]
+		if !ok {
+			ve.add(f+".comp_id", "unknown_competency", "competency %d does not belong to this form", cs.CompID)
+			continue
+		}
+		if scored[cs.CompID] {
+			ve.add(f+".comp_id", "duplicate", "competency %d is scored more than once", cs.CompID)
+		}
+		scored[cs.CompID] = true
+		if cs.Score < 0 || cs.Score > c.MaxScore {
+			ve.add(f+".score", "out_of_range", "must be between 0 and max_score (%.2f)", c.MaxScore)
+		}
+	}
+	validateCompWeights(&ve, "competencies", weights, strict)
+
+	ta := in.TimeAttendance
+	if ta.FullScore < 0 {
+		ve.add("time_attendance.full_score", "out_of_range", "must not be negative")
+	}
+	if ta.Score < 0 || ta.Score > ta.FullScore {
+		ve.add("time_attendance.score", "out_of_range", "must be between 0 and full_score (%.2f)", ta.FullScore)
+	}
+
+	for i, d := range in.DevelopmentPlan {
+		f := fmt.Sprintf("development_plan[%d]", i)
+		if strict && strings.TrimSpace(d.Content) == "" {
+			ve.add(f+".content", "required", "is required")
+		}
+		if d.Timing != "" {
+			parseDate(&ve, f+".timing", d.Timing)
+		}
+	}
+
+	validateAnswers(&ve, "additional", in.Additional, questions, strict)
+
+	if strict {
+		for _, c := range comps {
+			if !scored[c.ID] {
+				ve.add("competency_scores", "missing_score", "competency %d (%s) is not scored", c.ID, c.Title)
+			}
+		}
+	}
+	return ve.errOrNil()
+}
+
+// bandStep ความละเอียดของ min_pct/max_pct (DECIMAL(5,2))
+const bandStep = 0.01
+
+// ValidateBands: ช่วงต้องครอบคลุม 0-100 ต่อเนื่องกัน (band ถัดไปเริ่มที่ max ก่อนหน้า + 0.01) และไม่ซ้อนกัน
+func ValidateBands(bands []GradeBandInput) error {
+	var ve ValidationError
+	if len(bands) == 0 {
+		ve.add("bands", "required", "at least one band is required")
+		return ve.errOrNil()
+	}
+
+	idx := make([]int, len(bands))
+	seen := make(map[string]bool, len(bands))
+	for i, b := range bands {
+		idx[i] = i
+		f := fmt.Sprintf("bands[%d]", i)
+		if strings.TrimSpace(b.Grade) == "" {
+			ve.add(f+".grade", "required", "is required")
+		} else if seen[strings.ToUpper(b.Grade)] {
+			ve.add(f+".grade", "duplicate", "grade %q appears more than once", b.Grade)
+		}
+		seen[strings.ToUpper(b.Grade)] = true
+		if b.MinPct < 0 || b.MaxPct > 100 {
+			ve.add(f, "out_of_range", "range must be within 0-100")
+		}
+		if b.MinPct > b.MaxPct {
+			ve.add(f, "invalid_range", "min_pct must not exceed max_pct")
+		}
+	}
+	if len(ve.Fields) > 0 {
+		return ve.errOrNil()
+	}
+
+	sort.Slice(idx, func(a, b int) bool { return bands[idx[a]].MinPct < bands[idx[b]].MinPct })
+
+	if first := bands[idx[0]]; !nearlyZero(first.MinPct) {
+		ve.add(fmt.Sprintf("bands[%d].min_pct", idx[0]), "gap", "lowest band must start at 0 (got %.2f)", first.MinPct)
+	}
+	for k := 1; k < len(idx); k++ {
+		prev, cur := bands[idx[k-1]], bands[idx[k]]
+		f := fmt.Sprintf("bands[%d].min_pct", idx[k])
+		switch d := round2(cur.MinPct - prev.MaxPct); {
+		case d <= 0:
+			ve.add(f, "overlap", "%s (%.2f-%.2f) overlaps %s (%.2f-%.2f)",
+				cur.Grade, cur.MinPct, cur.MaxPct, prev.Grade, prev.MinPct, prev.MaxPct)
+		case d > bandStep:
+			ve.add(f, "gap", "gap between %s (max %.2f) and %s (min %.2f)",
+				prev.Grade, prev.MaxPct, cur.Grade, cur.MinPct)
+		}
+	}
+	if last := bands[idx[len(idx)-1]]; round2(last.MaxPct) != 100 {
+		ve.add(fmt.Sprintf("bands[%d].max_pct", idx[len(idx)-1]), "gap", "highest band must end at 100 (got %.2f)", last.MaxPct)
+	}
+	return ve.errOrNil()
+}
+
+func round2(v float64) float64 { return Rounding{2, RoundHalfUp}.apply(v) }
+
+func nearlyZero(v float64) bool { return round2(v) == 0 }
+
+// ValidateExtension ตรวจคำขอขยายช่วงเวลา
+func ValidateExtension(in WindowExtensionInput) error {
+	var ve ValidationError
+	if in.Window != WindowKPICfg && in.Window != WindowEval {
+		ve.add("window", "invalid_value", "must be %q or %q", WindowKPICfg, WindowEval)
+	}
+	if strings.TrimSpace(in.ExtendedUntil) == "" {
+		ve.add("extended_until", "required", "is required")
+	} else {
+		parseDate(&ve, "extended_until", in.ExtendedUntil)
+	}
+	if len(in.Reason) > 500 {
+		ve.add("reason", "too_long", "must be at most 500 characters")
+	}
+	return ve.errOrNil()
+}
+
+// ValidateStepWeights: น้ำหนักรายขั้น (ไม่ส่งเลยได้) ต้องครบทุกขั้น อยู่ใน 0..100 และรวม 100
+func ValidateStepWeights(weights []float64, steps int) error {
+	var ve ValidationError
+	if len(weights) == 0 {
+		return nil
+	}
+	if len(weights) != steps {
+		ve.add("weights", "count_mismatch", "must have %d entries (self first), got %d", steps, len(weights))
+		return ve.errOrNil()
+	}
+	var sum float64
+	for i, w := range weights {
+		if w < 0 || w > 100 {
+			ve.add(fmt.Sprintf("weights[%d]", i), "out_of_range", "must be between 0 and 100")
+		}
+		sum += w
+	}
+	if len(ve.Fields) == 0 && !nearlyEqual(sum, 100) {
+		ve.add("weights", "weight_sum_mismatch", "must sum to 100, got %.2f", sum)
+	}
+	return ve.errOrNil()
+}
+
+// ValidateEvaluatorSave ตรวจว่าหัวข้ออยู่ใน assignment และคะแนนอยู่ในช่วง
+func ValidateEvaluatorSave(in EvaluatorSaveInput, kpis []MyKPIItem, comps []CompItem, taFull float64) error {
+	var ve ValidationError
+
+	kpiIDs := make([]int, 0, len(kpis))
+	kpiMax := make(map[int]float64, len(kpis))
+	for _, k := range kpis {
+		kpiIDs = append(kpiIDs, k.ID)
+		kpiMax[k.ID] = k.MaxScore
+	}
+	compIDs := make([]int, 0, len(comps))
+	compMax := make(map[int]float64, len(comps))
+	for _, c := range comps {
+		compIDs = append(compIDs, c.ID)
+		compMax[c.ID] = c.MaxScore
+	}
+
+	check := func(field, what string, items []ItemScoreInput, ids []int, max map[int]float64) {
+		seen := make(map[int]bool, len(items))
+		for i, it := range items {
+			f := fmt.Sprintf("%s[%d]", field, i)
+			m, ok := max[it.ID]
+			if !ok {
+				ve.add(f+".id", "unknown_"+what, "%s %d does not belong to this assignment", what, it.ID)
+				continue
+			}
+			if seen[it.ID] {
+				ve.add(f+".id", "duplicate", "%s %d is scored more than once", what, it.ID)
+			}
+			seen[it.ID] = true
+			if it.Score < 0 || it.Score > m {
+				ve.add(f+".score", "out_of_range", "must be between 0 and max_score (%.2f)", m)
+			}
+		}
+		if in.Status == AssignSubmitted {
+			for _, id := range ids {
+				if !seen[id] {
+					ve.add(field, "missing_score", "%s %d is not scored", what, id)
+				}
+			}
+		}
+	}
+	check("kpi_scores", "kpi", in.KPIScores, kpiIDs, kpiMax)
+	check("competency_scores", "competency", in.CompetencyScores, compIDs, compMax)
+
+	if in.TAScore != nil && (*in.TAScore < 0 || *in.TAScore > taFull) {
+		ve.add("ta_score", "out_of_range", "must be between 0 and full_score (%.2f)", taFull)
+	}
+	return ve.errOrNil()
+}
+
+// ValidateTransition: comment บังคับเมื่อส่งกลับ
+func ValidateTransition(in TransitionInput, commentRequired bool) error {
+	var ve ValidationError
+	c := strings.TrimSpace(in.Comment)
+	if commentRequired && c == "" {
+		ve.add("comment", "required", "is required when returning to the previous step")
+	}
+	if len(c) > 1000 {
+		ve.add("comment", "too_long", "must be at most 1000 characters")
+	}
+	return ve.errOrNil()
+}
+
+// maxChainLevels จำนวนชั้นหัวหน้าสูงสุดใน chain
+const maxChainLevels = 5
+
+// ValidateBulkAssign: ต้องมีเงื่อนไขเลือกผู้ใช้อย่างน้อยหนึ่งอย่าง
+func ValidateBulkAssign(in BulkAssignInput) error {
+	var ve ValidationError
+	if len(in.CompanyIDs)+len(in.Departments)+len(in.Positions)+len(in.UserIDs) == 0 {
+		ve.add("user_ids", "required", "select users by company_ids, departments, positions or user_ids")
+	}
+	if in.DueDate != "" {
+		parseDate(&ve, "due_date", in.DueDate)
+	}
+	if in.Levels < 1 || in.Levels > maxChainLevels {
+		ve.add("levels", "out_of_range", "must be between 1 and %d", maxChainLevels)
+	}
+	if len(in.StepWeights) > 0 {
+		if err := ValidateStepWeights(in.StepWeights, in.Levels+1); err != nil {
+			for _, f := range err.(*ValidationError).Fields {
+				f.Field = "step_" + f.Field
+				ve.Fields = append(ve.Fields, f)
+			}
+		}
+	}
+	return ve.errOrNil()
+}
+
+// ValidateCalibration: ต้องมีชื่อ/ฟอร์ม และเป้าหมายเกรด (ถ้ามี) รวม 100%
+func ValidateCalibration(in CalibrationInput) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Name) == "" {
+		ve.add("name", "required", "is required")
+	}
+	if in.FormID <= 0 {
+		ve.add("form_id", "required", "is required")
+	}
+	validateTargets(&ve, in.Targets)
+	return ve.errOrNil()
+}
+
+func ValidateTargets(targets []CalibrationTarget) error {
+	var ve ValidationError
+	validateTargets(&ve, targets)
+	return ve.errOrNil()
+}
+
+func validateTargets(ve *ValidationError, targets []CalibrationTarget) {
+	if len(targets) == 0 {
+		return
+	}
+	seen := map[string]bool{}
+	var sum float64
+	for i, t := range targets {
+		f := fmt.Sprintf("targets[%d]", i)
+		g := strings.TrimSpace(t.Grade)
+		switch {
+		case g == "":
+			ve.add(f+".grade", "required", "is required")
+		case len(g) > 10:
+			ve.add(f+".grade", "too_long", "must be at most 10 characters")
+		case seen[g]:
+			ve.add(f+".grade", "duplicate", "grade %q appears more than once", g)
+		}
+		seen[g] = true
+		if t.TargetPct < 0 || t.TargetPct > 100 {
+			ve.add(f+".target_pct", "out_of_range", "must be between 0 and 100")
+		}
+		sum += t.TargetPct
+	}
+	if !nearlyEqual(sum, 100) {
+		ve.add("targets", "weight_sum_mismatch", "target_pct must sum to 100, got %.2f", sum)
+	}
+}
+
+// ValidateOverride: ปรับเกรดต้องมีเหตุผลเสมอ
+func ValidateOverride(in CalibrationOverrideInput) error {
+	var ve ValidationError
+	g := strings.TrimSpace(in.Grade)
+	if len(g) > 10 {
+		ve.add("grade", "too_long", "must be at most 10 characters")
+	}
+	if g != "" && strings.TrimSpace(in.Reason) == "" {
+		ve.add("reason", "required", "is required when overriding a grade")
+	}
+	if len(in.Reason) > 1000 {
+		ve.add("reason", "too_long", "must be at most 1000 characters")
+	}
+	return ve.errOrNil()
+}
+
+// ===== KPI library =====
+
+func ValidateKPICatalog(in KPICatalogInput) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Code) == "" {
+		ve.add("code", "required", "is required")
+	} else if len(in.Code) > 50 {
+		ve.add("code", "too_long", "must be at most 50 characters")
+	}
+	if strings.TrimSpace(in.Title) == "" {
+		ve.add("title", "required", "is required")
+	}
+	if in.DefaultMaxScore <= 0 {
+		ve.add("default_max_score", "out_of_range", "must be greater than 0")
+	}
+	return ve.errOrNil()
+}
+
+// ValidateKPITemplate: รายการคลังห้ามซ้ำ และน้ำหนักรวมไม่เกิน 100
+func ValidateKPITemplate(in KPITemplateInput) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Position) == "" {
+		ve.add("position", "required", "is required")
+	}
+	if strings.TrimSpace(in.Name) == "" {
+		ve.add("name", "required", "is required")
+	}
+	if len(in.Items) == 0 {
+		ve.add("items", "required", "at least one KPI is required")
+	}
+	seen := map[int]bool{}
+	sum := 0
+	for i, it := range in.Items {
+		f := fmt.Sprintf("items[%d]", i)
+		switch {
+		case it.CatalogID <= 0:
+			ve.add(f+".catalog_id", "required", "is required")
+		case seen[it.CatalogID]:
+			ve.add(f+".catalog_id", "duplicate", "catalog %d is listed more than once", it.CatalogID)
+		}
+		seen[it.CatalogID] = true
+		if it.Weight < 0 || it.Weight > 100 {
+			ve.add(f+".weight", "out_of_range", "must be between 0 and 100")
+		}
+		if it.MaxScore != nil && *it.MaxScore <= 0 {
+			ve.add(f+".max_score", "out_of_range", "must be greater than 0")
+		}
+		if it.ExpectedScore != nil && (*it.ExpectedScore < 0 || (it.MaxScore != nil && *it.ExpectedScore > *it.MaxScore)) {
+			ve.add(f+".expected_score", "out_of_range", "must be between 0 and max_score")
+		}
+		sum += it.Weight
+	}
+	if sum > 100 {
+		ve.add("items", "weight_sum_exceeded", "KPI weights add up to %d, must not exceed 100", sum)
+	}
+	return ve.errOrNil()
+}
+
+// ===== Goal cascading =====
+
+var objectiveLevels = map[string]int{ObjectiveCompany: 1, ObjectiveDepartment: 2, ObjectiveTeam: 3}
+
+// ValidateObjective: all = เป้าหมายทั้งหมดของฟอร์ม, selfID = 0 ตอนสร้างใหม่
+// parent ต้องอยู่ฟอร์มเดียวกัน ระดับไม่สูงกว่า parent และห้ามวนกลับมาที่ตัวเอง
+func ValidateObjective(in ObjectiveInput, all []Objective, selfID int) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Title) == "" {
+		ve.add("title", "required", "is required")
+	}
+	if len(in.Code) > 50 {
+		ve.add("code", "too_long", "must be at most 50 characters")
+	}
+	lv, ok := objectiveLevels[in.Level]
+	if !ok {
+		ve.add("level", "invalid", "must be company, department or team")
+	}
+	if in.Weight != nil && (*in.Weight < 0 || *in.Weight > 100) {
+		ve.add("weight", "out_of_range", "must be between 0 and 100")
+	}
+	if in.ParentID != nil {
+		byID := make(map[int]Objective, len(all))
+		for _, o := range all {
+			byID[o.ID] = o
+		}
+		parent, found := byID[*in.ParentID]
+		switch {
+		case !found:
+			ve.add("parent_id", "not_found", "objective %d is not in this form", *in.ParentID)
+		case ok && objectiveLevels[parent.Level] > lv:
+			ve.add("level", "above_parent", "cannot be above parent level %s", parent.Level)
+		default:
+			for p, seen := &parent, 0; p != nil && seen <= len(all); seen++ {
+				if selfID != 0 && p.ID == selfID {
+					ve.add("parent_id", "cycle", "would make the objective its own ancestor")
+					break
+				}
+				if p.ParentID == nil {
+					break
+				}
+				next, ok := byID[*p.ParentID]
+				if !ok {
+					break
+				}
+				p = &next
+			}
+		}
+	}
+	return ve.errOrNil()
+}
+
+// ===== KPI check-ins =====
+
+func ValidateKPICheckin(in KPICheckinInput) error {
+	var ve ValidationError
+	if d, ok := parseDate(&ve, "checkin_date", in.CheckinDate); ok && d.Format("2006-01-02") > today() {
+		ve.add("checkin_date", "in_future", "must not be in the future")
+	}
+	if in.ActualValue == nil && in.PercentComplete == nil && strings.TrimSpace(in.Comment) == "" {
+		ve.add("actual_value", "required", "actual_value, percent_complete or comment is required")
+	}
+	if in.PercentComplete != nil && (*in.PercentComplete < 0 || *in.PercentComplete > 100) {
+		ve.add("percent_complete", "out_of_range", "must be between 0 and 100")
+	}
+	if len(in.Comment) > 1000 {
+		ve.add("comment", "too_long", "must be at most 1000 characters")
+	}
+	return ve.errOrNil()
+}
+
+// ===== KPI scoring rules =====
+
+func validateScoringRule(ve *ValidationError, field string, r KPIScoringRule, maxScore float64) {
+	switch r.Method {
+	case RuleManual:
+		return
+	case RuleLinear, RuleBands:
+	default:
+		ve.add(field+".method", "invalid", "must be manual, linear or bands")
+		return
+	}
+	if r.Direction != DirHigher && r.Direction != DirLower {
+		ve.add(field+".direction", "invalid", "must be higher or lower")
+	}
+	if r.Method == RuleLinear {
+		switch {
+		case r.Direction == DirLower && r.Baseline == nil:
+			ve.add(field+".baseline", "required", "is required when lower is better")
+		case r.Direction == DirLower && *r.Baseline <= r.Target:
+			ve.add(field+".baseline", "out_of_range", "must be greater than target when lower is better")
+		case r.Direction == DirHigher && r.Baseline != nil && *r.Baseline >= r.Target:
+			ve.add(field+".baseline", "out_of_range", "must be less than target when higher is better")
+		}
+		return
+	}
+	if len(r.Bands) == 0 {
+		ve.add(field+".bands", "required", "at least one band is required")
+	}
+	seen := map[float64]bool{}
+	for i, b := range r.Bands {
+		f := fmt.Sprintf("%s.bands[%d]", field, i)
+		if seen[b.Threshold] {
+			ve.add(f+".threshold", "duplicate", "threshold %.4g is listed more than once", b.Threshold)
+		}
+		seen[b.Threshold] = true
+		if b.Score < 0 || (maxScore > 0 && b.Score > maxScore) {
+			ve.add(f+".score", "out_of_range", "must be between 0 and max_score (%.2f)", maxScore)
+		}
+	}
+}
+
+// ===== Competency rubric =====
+
+// validateCompLevels: maxScore <= 0 = ไม่จำกัดคะแนนสูงสุด (พจนานุกรม)
+func validateCompLevels(ve *ValidationError, field string, levels []CompLevel, maxScore float64) {
+	seen := map[float64]bool{}
+	for i, l := range levels {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if l.Score < 0 || (maxScore > 0 && l.Score > maxScore) {
+			ve.add(f+".score", "out_of_range", "must be between 0 and max_score (%.2f)", maxScore)
+		}
+		if seen[l.Score] {
+			ve.add(f+".score", "duplicate", "score %.2f is described more than once", l.Score)
+		}
+		seen[l.Score] = true
+		if strings.TrimSpace(l.Label) == "" {
+			ve.add(f+".label", "required", "is required")
+		} else if len([]rune(l.Label)) > 100 {
+			ve.add(f+".label", "too_long", "must be at most 100 characters")
+		}
+		for j, ind := range l.Indicators {
+			if len([]rune(ind)) > 500 {
+				ve.add(fmt.Sprintf("%s.indicators[%d]", f, j), "too_long", "must be at most 500 characters")
+			}
+		}
+	}
+}
+
+func ValidateCompetencyDef(in CompetencyDefInput) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Code) == "" {
+		ve.add("code", "required", "is required")
+	} else if len(in.Code) > 50 {
+		ve.add("code", "too_long", "must be at most 50 characters")
+	}
+	if strings.TrimSpace(in.Title) == "" {
+		ve.add("title", "required", "is required")
+	}
+	validateCompLevels(&ve, "levels", in.Levels, 0)
+	return ve.errOrNil()
+}
+
+func ValidateCompLevels(levels []CompLevel, maxScore float64) error {
+	var ve ValidationError
+	validateCompLevels(&ve, "levels", levels, maxScore)
+	return ve.errOrNil()
+}
+
+// ความยาวสูงสุดของคำตอบแบบข้อความ
+const (
+	maxTextAnswer     = 500
+	maxLongTextAnswer = 4000
+)
+
+func ValidateQuestion(in QuestionInput) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Code) == "" {
+		ve.add("code", "required", "is required")
+	} else if len(in.Code) > 50 {
+		ve.add("code", "too_long", "must be at most 50 characters")
+	}
+	if strings.TrimSpace(in.LabelTH) == "" {
+		ve.add("label_th", "required", "is required")
+	} else if len([]rune(in.LabelTH)) > 500 {
+		ve.add("label_th", "too_long", "must be at most 500 characters")
+	}
+	if len([]rune(in.LabelEN)) > 500 {
+		ve.add("label_en", "too_long", "must be at most 500 characters")
+	}
+
+	switch in.Type {
+	case QuestionText, QuestionLongText, QuestionRating:
+		if len(in.Options) > 0 {
+			ve.add("options", "not_allowed", "only choice questions have options")
+		}
+	case QuestionSingleChoice, QuestionMultiChoice:
+		if len(in.Options) < 2 {
+			ve.add("options", "too_few", "must have at least 2 options")
+		}
+		seen := map[string]bool{}
+		for i, o := range in.Options {
+			f := fmt.Sprintf("options[%d]", i)
+			v := strings.TrimSpace(o.Value)
+			if v == "" {
+				ve.add(f+".value", "required", "is required")
+			} else if seen[v] {
+				ve.add(f+".value", "duplicate", "option %q is listed more than once", v)
+			}
+			seen[v] = true
+			if strings.TrimSpace(o.LabelTH) == "" {
+				ve.add(f+".label_th", "required", "is required")
+			}
+		}
+	default:
+		ve.add("type", "invalid", "must be text, long_text, single_choice, multi_choice or rating")
+	}
+
+	if in.Type == QuestionRating {
+		if in.ScaleMin == nil || in.ScaleMax == nil {
+			ve.add("scale_max", "required", "scale_min and scale_max are required for rating")
+		} else if *in.ScaleMin >= *in.ScaleMax || *in.ScaleMin < 0 || *in.ScaleMax > 100 {
+			ve.add("scale_max", "invalid_range", "must satisfy 0 <= scale_min < scale_max <= 100")
+		}
+	} else if in.ScaleMin != nil || in.ScaleMax != nil {
+		ve.add("scale_max", "not_allowed", "only rating questions have a scale")
+	}
+	return ve.errOrNil()
+}
+
+// answerEmpty ไม่มีคำตอบ (ไม่บันทึก / นับว่ายังไม่ตอบ)
+func answerEmpty(a AdditionalAnswer) bool {
+	return strings.TrimSpace(a.Value) == "" && len(a.Values) == 0 && a.Rating == nil
+}
+
+// validateAnswers ตรวจคำตอบตามชนิดคำถาม; strict (submit) = ข้อที่ required ต้องตอบ
+func validateAnswers(ve *ValidationError, prefix string, answers []AdditionalAnswer, questions []Question, strict bool) {
+	byID := make(map[int]Question, len(questions))
+	byCode := make(map[string]Question, len(questions))
+	for _, q := range questions {
+		byID[q.ID] = q
+		byCode[q.Code] = q
+	}
+
+	answered := map[int]bool{}
+	for i, a := range answers {
+		f := fmt.Sprintf("%s[%d]", prefix, i)
+		q, ok := byID[a.QuestionID]
+		if a.QuestionID == 0 {
+			q, ok = byCode[a.Code]
+		}
+		if !ok {
+			ve.add(f+".question_id", "unknown_question", "question does not belong to this form")
+			continue
+		}
+		if answered[q.ID] {
+			ve.add(f+".question_id", "duplicate", "question %s is answered more than once", q.Code)
+		}
+		if answerEmpty(a) {
+			continue
+		}
+		answered[q.ID] = true
+
+		if q.Type != QuestionMultiChoice && len(a.Values) > 0 {
+			ve.add(f+".values", "not_allowed", "only multi_choice answers use values")
+		}
+		if q.Type != QuestionRating && a.Rating != nil {
+			ve.add(f+".rating", "not_allowed", "only rating answers use rating")
+		}
+		if (q.Type == QuestionMultiChoice || q.Type == QuestionRating) && a.Value != "" {
+			ve.add(f+".value", "not_allowed", "use values or rating for %s questions", q.Type)
+		}
+
+		switch q.Type {
+		case QuestionText:
+			if len([]rune(a.Value)) > maxTextAnswer {
+				ve.add(f+".value", "too_long", "must be at most %d characters", maxTextAnswer)
+			}
+		case QuestionLongText:
+			if len([]rune(a.Value)) > maxLongTextAnswer {
+				ve.add(f+".value", "too_long", "must be at most %d characters", maxLongTextAnswer)
+			}
+		case QuestionSingleChoice:
+			if a.Value != "" && !hasOption(q, a.Value) {
+				ve.add(f+".value", "invalid_option", "%q is not an option of question %s", a.Value, q.Code)
+			}
+		case QuestionMultiChoice:
+			seen := map[string]bool{}
+			for j, v := range a.Values {
+				if !hasOption(q, v) {
+					ve.add(fmt.Sprintf("%s.values[%d]", f, j), "invalid_option", "%q is not an option of question %s", v, q.Code)
+				} else if seen[v] {
+					ve.add(fmt.Sprintf("%s.values[%d]", f, j), "duplicate", "%q is selected more than once", v)
+				}
+				seen[v] = true
+			}
+		case QuestionRating:
+			if a.Rating != nil && q.ScaleMin != nil && q.ScaleMax != nil &&
+				(*a.Rating < float64(*q.ScaleMin) || *a.Rating > float64(*q.ScaleMax)) {
+				ve.add(f+".rating", "out_of_range", "must be between %d and %d", *q.ScaleMin, *q.ScaleMax)
+			}
+		}
+	}
+
+	if strict {
+		for _, q := range questions {
+			if q.Required && !answered[q.ID] {
+				ve.add(prefix, "missing_answer", "question %s (%s) is required", q.Code, q.LabelTH)
+			}
+		}
+	}
+}
+
+func hasOption(q Question, v string) bool {
+	for _, o := range q.Options {
+		if o.Value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ===== Development plan tracking =====
+
+func ValidateDevPlanStatus(in DevPlanStatusInput) error {
+	var ve ValidationError
+	switch in.Status {
+	case DevPlanPlanned, DevPlanInProgress, DevPlanDone, DevPlanCancelled:
+	default:
+		ve.add("status", "invalid", "must be planned, in_progress, done or cancelled")
+	}
+	if in.CompletedOn != "" {
+		if in.Status != DevPlanDone {
+			ve.add("completed_on", "not_allowed", "only done items have a completion date")
+		} else if d, ok := parseDate(&ve, "completed_on", in.CompletedOn); ok && d.Format("2006-01-02") > today() {
+			ve.add("completed_on", "in_future", "must not be in the future")
+		}
+	}
+	if len([]rune(in.Note)) > 1000 {
+		ve.add("note", "too_long", "must be at most 1000 characters")
+	}
+	return ve.errOrNil()
+}
+
+func ValidateDevPlanNote(in DevPlanNoteInput) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Note) == "" {
+		ve.add("note", "required", "is required")
+	} else if len([]rune(in.Note)) > 1000 {
+		ve.add("note", "too_long", "must be at most 1000 characters")
+	}
+	return ve.errOrNil()
+}
+
+// ===== Training catalogue =====
+
+func ValidateCourse(in CourseInput) error {
+	var ve ValidationError
+	if strings.TrimSpace(in.Code) == "" {
+		ve.add("code", "required", "is required")
+	} else if len(in.Code) > 50 {
+		ve.add("code", "too_long", "must be at most 50 characters")
+	}
+	if strings.TrimSpace(in.Title) == "" {
+		ve.add("title", "required", "is required")
+	} else if len([]rune(in.Title)) > 300 {
+		ve.add("title", "too_long", "must be at most 300 characters")
+	}
+	if len([]rune(in.Provider)) > 200 {
+		ve.add("provider", "too_long", "must be at most 200 characters")
+	}
+	if in.Hours <= 0 || in.Hours > 1000 {
+		ve.add("hours", "out_of_range", "must be greater than 0 and at most 1000")
+	}
+	seen := map[int]bool{}
+	for i, id := range in.CompetencyIDs {
+		if seen[id] {
+			ve.add(fmt.Sprintf("competency_ids[%d]", i), "duplicate", "competency %d is tagged more than once", id)
+		}
+		seen[id] = true
+	}
+	return ve.errOrNil()
+}
+
+func ValidateEnrolment(in EnrolmentInput) error {
+	var ve ValidationError
+	if in.CourseID <= 0 {
+		ve.add("course_id", "required", "is required")
+	}
+	parseDate(&ve, "enrolled_on", in.EnrolledOn)
+	return ve.errOrNil()
+}
+
+func ValidateEnrolmentUpdate(in EnrolmentUpdateInput) error {
+	var ve ValidationError
+	switch in.Status {
+	case EnrolEnrolled, EnrolInProgress, EnrolCompleted, EnrolCancelled:
+	default:
+		ve.add("status", "invalid", "must be enrolled, in_progress, completed or cancelled")
+	}
+	if in.CompletedOn != "" {
+		if in.Status != EnrolCompleted {
+			ve.add("completed_on", "not_allowed", "only completed enrolments have a completion date")
+		} else if d, ok := parseDate(&ve, "completed_on", in.CompletedOn); ok && d.Format("2006-01-02") > today() {
+			ve.add("completed_on", "in_future", "must not be in the future")
+		}
+	}
+	if cert := in.Certificate; cert != nil {
+		if in.Status != EnrolCompleted {
+			ve.add("certificate", "not_allowed", "only completed enrolments have a certificate")
+		}
+		if len([]rune(cert.Number)) > 100 {
+			ve.add("certificate.number", "too_long", "must be at most 100 characters")
+		}
+		if len([]rune(cert.Issuer)) > 200 {
+			ve.add("certificate.issuer", "too_long", "must be at most 200 characters")
+		}
+		if len(cert.URL) > 500 {
+			ve.add("certificate.url", "too_long", "must be at most 500 characters")
+		}
+		issued, okI := parseDate(&ve, "certificate.issued_on", cert.IssuedOn)
+		expires, okE := parseDate(&ve, "certificate.expires_on", cert.ExpiresOn)
+		if okI && okE && expires.Before(issued) {
+			ve.add("certificate.expires_on", "invalid_range", "must not be before issued_on")
+		}
+	}
+	return ve.errOrNil()
+}
@@ -0,0 +1,85 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ===== Workflow state machine (assignment / step) =====
+
+// สถานะ eval_assignment.status (2 = submitted คงค่าเดิมไว้ เพราะ FE ส่ง status=2 ตอนกดส่ง)
+const (
+	AssignDraft        = 0
+	AssignSubmitted    = 2
+	AssignInReview     = 3
+	AssignApproved     = 4
+	AssignAcknowledged = 5
+	AssignClosed       = 6
+
+	assignLegacySubmitted = 1 // ค่าเก่า ถือว่าเท่ากับ submitted
+)
+
+// สถานะ eval_step.status
+const (
+	StepWaiting = 0
+	StepActive  = 1
+	StepDone    = 2
+)
+
+// action ที่บันทึกใน eval_transition_log
+const (
+	ActionSubmit       = "submit"
+	ActionStartReview  = "start_review"
+	ActionStepComplete = "step_complete"
+	ActionReturn       = "return"
+	ActionApprove      = "approve"
+	ActionAcknowledge  = "acknowledge"
+	ActionClose        = "close"
+)
+
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+var assignStatusNames = map[int]string{
+	AssignDraft:           "draft",
+	assignLegacySubmitted: "submitted",
+	AssignSubmitted:       "submitted",
+	AssignInReview:        "in_review",
+	AssignApproved:        "approved",
+	AssignAcknowledged:    "acknowledged",
+	AssignClosed:          "closed",
+}
+
+// AssignStatusName ชื่อสถานะสำหรับแสดงผล
+func AssignStatusName(status int) string {
+	if n, ok := assignStatusNames[status]; ok {
+		return n
+	}
+	return fmt.Sprintf("unknown(%d)", status)
+}
+
+// assignTransitions: from → สถานะที่ไปต่อได้ (ส่งกลับขั้น self = กลับเป็น draft)
+var assignTransitions = map[int][]int{
+	AssignDraft:           {AssignSubmitted},
+	assignLegacySubmitted: {AssignInReview, AssignApproved, AssignDraft},
+	AssignSubmitted:       {AssignInReview, AssignApproved, AssignDraft},
+	AssignInReview:        {AssignApproved, AssignDraft},
+	AssignApproved:        {AssignAcknowledged, AssignClosed},
+	AssignAcknowledged:    {AssignClosed},
+}
+
+// CanTransition ตรวจว่าเปลี่ยนสถานะ assignment from → to ได้หรือไม่
+func CanTransition(from, to int) bool {
+	for _, s := range assignTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+func illegalTransition(from, to int) error {
+	return fmt.Errorf("%w: %s → %s", ErrIllegalTransition, AssignStatusName(from), AssignStatusName(to))
+}
+
+// IsLocked: พนักงานแก้ฟอร์มตัวเองได้เฉพาะตอน draft
+func IsLocked(status int) bool { return status != AssignDraft }
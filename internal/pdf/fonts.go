@@ -0,0 +1,54 @@
+package pdf
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ฟอนต์ไทยที่ฝังตอน build: วางไฟล์ .ttf (เช่น THSarabunNew.ttf) ไว้ใน fonts/
+//
+//go:embed fonts
+var fontFS embed.FS
+
+var ErrNoFont = errors.New("no embedded font: add a Thai .ttf file to internal/pdf/fonts")
+
+var (
+	defaultOnce sync.Once
+	defaultFont *Font
+	defaultErr  error
+)
+
+// DefaultFont ฟอนต์ .ttf ตัวแรก (เรียงตามชื่อ) ใน fonts/ — parse ครั้งเดียว
+func DefaultFont() (*Font, error) {
+	defaultOnce.Do(func() {
+		defaultFont, defaultErr = loadEmbedded()
+	})
+	return defaultFont, defaultErr
+}
+
+func loadEmbedded() (*Font, error) {
+	entries, err := fs.ReadDir(fontFS, "fonts")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.EqualFold(path.Ext(e.Name()), ".ttf") {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, ErrNoFont
+	}
+	sort.Strings(names)
+	data, err := fontFS.ReadFile(path.Join("fonts", names[0]))
+	if err != nil {
+		return nil, err
+	}
+	return ParseTTF(data)
+}
@@ -0,0 +1,304 @@
+// Package pdf เขียนไฟล์ PDF แบบเรียบง่ายด้วย Go ล้วน (ข้อความ UTF-8 + เส้น/กรอบ)
+// ฟอนต์ TrueType ฝังเป็น CIDFontType2 / Identity-H จึงแสดงภาษาไทยได้
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ขนาด A4 (point)
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+type Document struct {
+	font  *Font
+	size  float64
+	pages []*bytes.Buffer
+	cur   *bytes.Buffer
+	used  map[uint16]rune // glyph ที่ใช้ → สำหรับ W และ ToUnicode
+
+	W, H float64
+}
+
+// New เอกสาร A4 แนวตั้ง (ยังไม่มีหน้า ต้องเรียก AddPage)
+func New(font *Font) *Document {
+	return &Document{font: font, size: 12, used: map[uint16]rune{}, W: A4Width, H: A4Height}
+}
+
+func (d *Document) AddPage() {
+	d.cur = &bytes.Buffer{}
+	d.pages = append(d.pages, d.cur)
+}
+
+func (d *Document) PageCount() int { return len(d.pages) }
+
+// SetPage กลับไปเขียนต่อบนหน้าที่ i (เริ่ม 0) เช่น ใส่เลขหน้าตอนท้าย
+func (d *Document) SetPage(i int) { d.cur = d.pages[i] }
+
+func (d *Document) SetFontSize(size float64) { d.size = size }
+
+func (d *Document) FontSize() float64 { return d.size }
+
+// TextWidth ความกว้างข้อความที่ขนาดฟอนต์ปัจจุบัน
+func (d *Document) TextWidth(s string) float64 { return d.font.Width(s, d.size) }
+
+// Text วางข้อความโดย (x, y) = จุดเริ่ม baseline วัดจากมุมซ้ายบน
+// เครื่องหมายที่ต้องเลื่อน (ดู layout) ใช้ตัวเลขใน TJ เลื่อนซ้าย/ขวา และ Ts ยกขึ้น/ลง
+func (d *Document) Text(x, y float64, s string) {
+	if s == "" {
+		return
+	}
+	var tj strings.Builder
+	rise := 0
+	tj.WriteString("[")
+	for _, p := range d.font.layout(s) {
+		if _, ok := d.used[p.g]; !ok {
+			d.used[p.g] = p.r
+		}
+		if p.dy != rise {
+			fmt.Fprintf(&tj, "] TJ %s Ts [", num(float64(p.dy)*d.size/float64(d.font.unitsPerEm)))
+			rise = p.dy
+		}
+		if p.dx != 0 {
+			fmt.Fprintf(&tj, "%d", -d.font.scaled(p.dx))
+		}
+		fmt.Fprintf(&tj, "<%04X>", p.g)
+		if p.dx != 0 {
+			fmt.Fprintf(&tj, "%d", d.font.scaled(p.dx))
+		}
+	}
+	tj.WriteString("] TJ")
+	if rise != 0 {
+		tj.WriteString(" 0 Ts") // Ts อยู่ใน graphics state ต่อข้าม BT/ET
+	}
+	fmt.Fprintf(d.cur, "BT /F1 %s Tf %s %s Td %s ET\n", num(d.size), num(x), num(d.H-y), tj.String())
+}
+
+// TextRight วางข้อความชิดขวาที่ตำแหน่ง x
+func (d *Document) TextRight(x, y float64, s string) { d.Text(x-d.TextWidth(s), y, s) }
+
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.cur, "%s %s m %s %s l S\n", num(x1), num(d.H-y1), num(x2), num(d.H-y2))
+}
+
+// Rect กรอบสี่เหลี่ยม (x, y = มุมซ้ายบน); gray >= 0 = ระบายสีเทา (0 ดำ .. 1 ขาว) ก่อนตีเส้น
+func (d *Document) Rect(x, y, w, h, gray float64) {
+	if gray >= 0 {
+		fmt.Fprintf(d.cur, "q %s g %s %s %s %s re f Q\n", num(gray), num(x), num(d.H-y-h), num(w), num(h))
+	}
+	fmt.Fprintf(d.cur, "%s %s %s %s re S\n", num(x), num(d.H-y-h), num(w), num(h))
+}
+
+// Wrap ตัดข้อความเป็นบรรทัดไม่เกิน width (เว้นวรรคก่อน ถ้าไม่มีตัดตามตัวอักษร
+// โดยไม่แยกสระ/วรรณยุกต์ออกจากพยัญชนะ) — ภาษาไทยไม่มีช่องว่างระหว่างคำ
+func (d *Document) Wrap(s string, width float64) []string {
+	var out []string
+	for _, para := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		out = append(out, d.wrapLine(para, width)...)
+	}
+	return out
+}
+
+func (d *Document) wrapLine(s string, width float64) []string {
+	rs := []rune(s)
+	var out []string
+	for len(rs) > 0 {
+		if d.TextWidth(string(rs)) <= width {
+			out = append(out, string(rs))
+			break
+		}
+		cut, space := 0, -1
+		for i := 1; i <= len(rs); i++ {
+			if d.TextWidth(string(rs[:i])) > width {
+				break
+			}
+			if i < len(rs) && unicode.Is(unicode.Mn, rs[i]) {
+				continue // ห้ามตัดก่อนสระบน/ล่าง/วรรณยุกต์
+			}
+			cut = i
+			if unicode.IsSpace(rs[i-1]) {
+				space = i
+			}
+		}
+		if space > 0 {
+			cut = space
+		}
+		if cut == 0 {
+			cut = 1 // กว้างไม่พอแม้ตัวเดียว
+			for cut < len(rs) && unicode.Is(unicode.Mn, rs[cut]) {
+				cut++
+			}
+		}
+		out = append(out, strings.TrimRightFunc(string(rs[:cut]), unicode.IsSpace))
+		rs = []rune(strings.TrimLeftFunc(string(rs[cut:]), unicode.IsSpace))
+	}
+	if len(out) == 0 {
+		out = []string{""}
+	}
+	return out
+}
+
+// WriteTo เขียนเอกสารทั้งหมด (object 1 catalog, 2 pages, 3-7 font, ต่อด้วย page/content คู่ละหน้า)
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	offsets := []int64{0}
+	obj := func(body func()) {
+		offsets = append(offsets, cw.n)
+		fmt.Fprintf(cw, "%d 0 obj\n", len(offsets)-1)
+		body()
+		fmt.Fprint(cw, "\nendobj\n")
+	}
+	stream := func(dict string, data []byte) {
+		fmt.Fprintf(cw, "<< %s /Length %d >>\nstream\n", dict, len(data))
+		cw.Write(data)
+		fmt.Fprint(cw, "\nendstream")
+	}
+	pageID := func(i int) int { return 8 + 2*i }
+
+	fmt.Fprint(cw, "%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	obj(func() { fmt.Fprint(cw, "<< /Type /Catalog /Pages 2 0 R >>") })
+	obj(func() {
+		kids := make([]string, len(d.pages))
+		for i := range d.pages {
+			kids[i] = fmt.Sprintf("%d 0 R", pageID(i))
+		}
+		fmt.Fprintf(cw, "<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages))
+	})
+
+	f := d.font
+	obj(func() {
+		fmt.Fprint(cw, "<< /Type /Font /Subtype /Type0 /BaseFont /EmbeddedTTF /Encoding /Identity-H /DescendantFonts [4 0 R] /ToUnicode 7 0 R >>")
+	})
+	obj(func() {
+		fmt.Fprintf(cw, "<< /Type /Font /Subtype /CIDFontType2 /BaseFont /EmbeddedTTF /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor 5 0 R /CIDToGIDMap /Identity /DW 1000 /W [%s] >>", d.widths())
+	})
+	obj(func() {
+		fmt.Fprintf(cw, "<< /Type /FontDescriptor /FontName /EmbeddedTTF /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 6 0 R >>",
+			f.scaled(f.xMin), f.scaled(f.yMin), f.scaled(f.xMax), f.scaled(f.yMax),
+			f.scaled(f.ascent), f.scaled(f.descent), f.scaled(f.ascent))
+	})
+	obj(func() { stream(fmt.Sprintf("/Filter /FlateDecode /Length1 %d", f.length), f.compressed) })
+	obj(func() { stream("", d.toUnicode()) })
+
+	for i, p := range d.pages {
+		obj(func() {
+			fmt.Fprintf(cw, "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				num(d.W), num(d.H), pageID(i)+1)
+		})
+		obj(func() { stream("/Filter /FlateDecode", deflate(p.Bytes())) })
+	}
+
+	xref := cw.n
+	fmt.Fprintf(cw, "xref\n0 %d\n0000000000 65535 f \n", len(offsets))
+	for _, off := range offsets[1:] {
+		fmt.Fprintf(cw, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(cw, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets), xref)
+
+	if err := cw.w.(*bufio.Writer).Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+func (d *Document) sortedGlyphs() []int {
+	gs := make([]int, 0, len(d.used))
+	for g := range d.used {
+		gs = append(gs, int(g))
+	}
+	sort.Ints(gs)
+	return gs
+}
+
+// widths: รูปแบบ gid [w] ต่อ glyph ที่ใช้
+func (d *Document) widths() string {
+	var b strings.Builder
+	for _, g := range d.sortedGlyphs() {
+		adv := 0
+		if g < len(d.font.advances) {
+			adv = int(d.font.advances[g])
+		}
+		fmt.Fprintf(&b, "%d [%d] ", g, d.font.scaled(adv))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// toUnicode CMap ให้ copy/ค้นหาข้อความใน PDF ได้
+func (d *Document) toUnicode() []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	gs := d.sortedGlyphs()
+	for len(gs) > 0 {
+		n := min(len(gs), 100) // bfchar ได้ไม่เกิน 100 รายการต่อบล็อก
+		fmt.Fprintf(&b, "%d beginbfchar\n", n)
+		for _, g := range gs[:n] {
+			fmt.Fprintf(&b, "<%04X> <", g)
+			for _, u := range utf16Units(d.used[uint16(g)]) {
+				fmt.Fprintf(&b, "%04X", u)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+		gs = gs[n:]
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	return b.Bytes()
+}
+
+func utf16Units(r rune) []uint16 {
+	if r < 0x10000 {
+		return []uint16{uint16(r)}
+	}
+	r -= 0x10000
+	return []uint16{uint16(0xD800 + (r >> 10)), uint16(0xDC00 + (r & 0x3FF))}
+}
+
+func deflate(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// num ตัวเลขทศนิยม 2 ตำแหน่งแบบไม่มีศูนย์ท้าย
+func num(v float64) string {
+	s := fmt.Sprintf("%.2f", v)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,287 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+const thaiSample = "ผลการประเมินผลการปฏิบัติงาน ปี ๒๕๖๙"
+
+// outline ของ glyph ในฟอนต์ทดสอบ (สี่เหลี่ยม, หน่วยฟอนต์ 1000/em, advance 500 ทุกตัว)
+// เครื่องหมายวางเทียบจุดหลังพยัญชนะ (x ติดลบ) ที่ตำแหน่งต่ำแบบฟอนต์ที่อาศัย GPOS
+var testOutlines = map[rune][]glyphBox{
+	'ก': {{50, 0, 450, 500}},
+	'ท': {{50, 0, 450, 500}},
+	'น': {{50, 0, 450, 500}},
+	'ป': {{50, 0, 450, 500}, {380, 0, 450, 750}},  // หางสูงด้านขวา
+	'ฎ': {{50, 0, 450, 500}, {380, -250, 450, 0}}, // เชิงด้านล่าง
+	'ำ': {{-300, 560, -200, 660}, {50, 0, 400, 500}},
+	'ิ': {{-400, 550, -100, 650}},
+	'ี': {{-400, 550, -100, 650}},
+	'่': {{-150, 550, -50, 650}},
+	'้': {{-150, 550, -50, 650}},
+	'ุ': {{-250, -150, -150, -50}},
+}
+
+// rectGlyph simple glyph จากสี่เหลี่ยม (contour ละ 4 จุด on-curve, พิกัด 2 byte)
+func rectGlyph(rects []glyphBox) []byte {
+	be := binary.BigEndian
+	bb := rects[0]
+	for _, r := range rects[1:] {
+		bb = glyphBox{min(bb.xMin, r.xMin), min(bb.yMin, r.yMin), max(bb.xMax, r.xMax), max(bb.yMax, r.yMax)}
+	}
+	put := func(b []byte, v int) []byte { return be.AppendUint16(b, uint16(int16(v))) }
+	g := put(nil, len(rects))
+	for _, v := range []int{bb.xMin, bb.yMin, bb.xMax, bb.yMax} {
+		g = put(g, v)
+	}
+	for i := range rects {
+		g = put(g, 4*i+3)
+	}
+	g = put(g, 0) // instructionLength
+	var xs, ys []int
+	for _, r := range rects {
+		xs = append(xs, r.xMin, r.xMin, r.xMax, r.xMax)
+		ys = append(ys, r.yMin, r.yMax, r.yMax, r.yMin)
+	}
+	for range xs {
+		g = append(g, 0x01)
+	}
+	for _, c := range [][]int{xs, ys} {
+		prev := 0
+		for _, v := range c {
+			g = put(g, v-prev)
+			prev = v
+		}
+	}
+	for len(g)%4 != 0 {
+		g = append(g, 0)
+	}
+	return g
+}
+
+// thaiTestFont ฟอนต์ TrueType ขนาดเล็กที่สร้างในเทสต์: U+0E01–U+0E5B → glyph 1..91
+// (มี outline เฉพาะตัวใน testOutlines)
+func thaiTestFont(t *testing.T) *Font {
+	t.Helper()
+	const (
+		first, last = 0x0E01, 0x0E5B
+		numGlyphs   = last - first + 2
+		unitsPerEm  = 1000
+	)
+	be := binary.BigEndian
+
+	head := make([]byte, 54)
+	be.PutUint32(head[0:], 0x00010000)
+	be.PutUint16(head[18:], unitsPerEm)
+	be.PutUint16(head[40:], 1000) // xMax
+	be.PutUint16(head[42:], 800)  // yMax
+	be.PutUint16(head[50:], 1)    // indexToLocFormat: loca แบบ 32 บิต
+
+	hhea := make([]byte, 36)
+	be.PutUint32(hhea[0:], 0x00010000)
+	be.PutUint16(hhea[4:], 800)
+	be.PutUint16(hhea[6:], uint16(0xFFFF-200+1)) // descent -200
+	be.PutUint16(hhea[34:], numGlyphs)
+
+	maxp := make([]byte, 6)
+	be.PutUint32(maxp[0:], 0x00005000)
+	be.PutUint16(maxp[4:], numGlyphs)
+
+	hmtx := make([]byte, 4*numGlyphs)
+	for g := 0; g < numGlyphs; g++ {
+		be.PutUint16(hmtx[4*g:], 500)
+	}
+
+	// cmap (3,1) format 4: ช่วงไทย + ช่วงปิดท้าย 0xFFFF
+	sub := make([]byte, 16+4*2*2)
+	be.PutUint16(sub[0:], 4)
+	be.PutUint16(sub[2:], uint16(len(sub)))
+	be.PutUint16(sub[6:], 2*2) // segCountX2
+	be.PutUint16(sub[14:], last)
+	be.PutUint16(sub[16:], 0xFFFF)
+	be.PutUint16(sub[20:], first)
+	be.PutUint16(sub[22:], 0xFFFF)
+	be.PutUint16(sub[24:], 0x10000+1-first) // idDelta = 1-first (mod 65536)
+	be.PutUint16(sub[26:], 1)
+	cmap := make([]byte, 12, 12+len(sub))
+	be.PutUint16(cmap[2:], 1)
+	be.PutUint16(cmap[4:], 3)
+	be.PutUint16(cmap[6:], 1)
+	be.PutUint32(cmap[8:], 12)
+	cmap = append(cmap, sub...)
+
+	var glyf []byte
+	loca := make([]byte, 0, 4*(numGlyphs+1))
+	for g := 0; g < numGlyphs; g++ {
+		loca = be.AppendUint32(loca, uint32(len(glyf)))
+		if rects, ok := testOutlines[rune(first+g-1)]; ok && g > 0 {
+			glyf = append(glyf, rectGlyph(rects)...)
+		}
+	}
+	loca = be.AppendUint32(loca, uint32(len(glyf)))
+
+	tables := []struct {
+		tag  string
+		data []byte
+	}{{"cmap", cmap}, {"glyf", glyf}, {"head", head}, {"hhea", hhea}, {"hmtx", hmtx}, {"loca", loca}, {"maxp", maxp}}
+
+	dir := make([]byte, 12+16*len(tables))
+	be.PutUint32(dir[0:], 0x00010000)
+	be.PutUint16(dir[4:], uint16(len(tables)))
+	body := []byte{}
+	for i, tb := range tables {
+		p := 12 + 16*i
+		copy(dir[p:], tb.tag)
+		be.PutUint32(dir[p+8:], uint32(len(dir)+len(body)))
+		be.PutUint32(dir[p+12:], uint32(len(tb.data)))
+		body = append(body, tb.data...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	f, err := ParseTTF(append(dir, body...))
+	if err != nil {
+		t.Fatalf("ParseTTF: %v", err)
+	}
+	return f
+}
+
+// renderThai วางข้อความไทยหนึ่งหน้า แล้วคืน PDF ทั้งไฟล์
+func renderThai(t *testing.T, f *Font) []byte {
+	t.Helper()
+	doc := New(f)
+	doc.AddPage()
+	doc.SetFontSize(16)
+	for i, line := range doc.Wrap(thaiSample, 120) {
+		doc.Text(40, 60+float64(i)*20, line)
+	}
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func assertThaiPDF(t *testing.T, f *Font, out []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("output is not a complete PDF")
+	}
+	for _, want := range []string{"/FontFile2 6 0 R", "/Encoding /Identity-H", "/Count 1"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("PDF missing %q", want)
+		}
+	}
+	// ทุกตัวอักษรไทยต้องมี glyph และอยู่ใน ToUnicode (ค้นหา/คัดลอกข้อความได้)
+	for _, r := range thaiSample {
+		if r == ' ' {
+			continue
+		}
+		g := f.glyph(r)
+		if g == 0 {
+			t.Errorf("no glyph for %q (U+%04X)", r, r)
+			continue
+		}
+		entry := fmt.Sprintf("<%04X> <%04X>", g, r)
+		if !bytes.Contains(out, []byte(entry)) {
+			t.Errorf("ToUnicode missing %s for %q", entry, r)
+		}
+	}
+}
+
+func TestRenderThaiText(t *testing.T) {
+	f := thaiTestFont(t)
+	assertThaiPDF(t, f, renderThai(t, f))
+}
+
+func TestWrapKeepsCombiningMarks(t *testing.T) {
+	doc := New(thaiTestFont(t))
+	doc.SetFontSize(10)
+	lines := doc.Wrap("ที่นี่มีน้ำ", 12) // กว้างพอแค่ตัวละ 2 glyph
+	if len(lines) < 2 {
+		t.Fatalf("expected text to wrap, got %q", lines)
+	}
+	for _, l := range lines {
+		if unicode.Is(unicode.Mn, []rune(l)[0]) {
+			t.Errorf("line %q starts with a combining mark", l)
+		}
+	}
+	if got := strings.Join(lines, ""); got != "ที่นี่มีน้ำ" {
+		t.Errorf("wrapped text lost characters: %q", got)
+	}
+}
+
+func TestLayoutPositionsThaiMarks(t *testing.T) {
+	f := thaiTestFont(t)
+	type shift struct {
+		r      rune
+		dx, dy int
+	}
+	tests := []struct {
+		text string
+		want []shift // เฉพาะ glyph ที่ต้องเลื่อน
+	}{
+		{"กา", nil},
+		{"ที่", []shift{{'่', 0, 120}}},                  // วรรณยุกต์พ้นสระ ี: 650 + 20 - 550
+		{"ก่", nil},                                      // ไม่มีสระบน → ตำแหน่งปกติ
+		{"ป่", []shift{{'่', -90, 0}}},                   // ขอบขวา 500-50=450 → หาง 380 - 20
+		{"ปี่", []shift{{'ี', -40, 0}, {'่', -90, 120}}}, // ทั้งเลื่อนซ้ายและยกขึ้น
+		{"น้ำ", []shift{{'้', 0, 130}}},                  // พ้นนิคหิตของ ำ: 660 + 20 - 550
+		{"ฎุ", []shift{{'ุ', 0, -220}}},                  // ใต้เชิง: -250 - 20 - (-50)
+		{"กุ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got []shift
+			for _, p := range f.layout(tt.text) {
+				if p.dx != 0 || p.dy != 0 {
+					got = append(got, shift{p.r, p.dx, p.dy})
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("layout(%q) shifts = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextEmitsMarkOffsets(t *testing.T) {
+	f := thaiTestFont(t)
+	doc := New(f)
+	doc.AddPage()
+	doc.SetFontSize(10)
+	doc.Text(0, 0, "ปี่")
+	got := doc.cur.String()
+	// ่ : เลื่อนซ้าย 90/1000 em, ยก 120 หน่วย = 1.2pt ที่ขนาด 10 แล้วคืนค่า rise
+	want := fmt.Sprintf("[<%04X>40<%04X>-40] TJ 1.2 Ts [90<%04X>-90] TJ 0 Ts", f.glyph('ป'), f.glyph('ี'), f.glyph('่'))
+	if !strings.Contains(got, want) {
+		t.Errorf("content = %q, want it to contain %q", got, want)
+	}
+}
+
+// ฟอนต์จริงที่ฝังใน binary (Docker build ดึง Sarabun ให้ถ้ายังไม่มีใน fonts/)
+func TestDefaultFontRendersThai(t *testing.T) {
+	f, err := DefaultFont()
+	if errors.Is(err, ErrNoFont) {
+		t.Skip("no .ttf in internal/pdf/fonts (see fonts/README.md)")
+	}
+	if err != nil {
+		t.Fatalf("DefaultFont: %v", err)
+	}
+	assertThaiPDF(t, f, renderThai(t, f))
+
+	// วรรณยุกต์หลังสระบนต้องไม่ทับสระ
+	vowel, tone := f.thai['ี'], f.thai['่']
+	for _, p := range f.layout("ที่") {
+		if p.r == '่' && tone.yMin+p.dy < vowel.yMax {
+			t.Errorf("tone mark overlaps the upper vowel: tone yMin %d + %d < vowel yMax %d", tone.yMin, p.dy, vowel.yMax)
+		}
+	}
+}
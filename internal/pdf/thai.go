@@ -0,0 +1,80 @@
+package pdf
+
+import "unicode"
+
+// จัดตำแหน่งสระบน/วรรณยุกต์ของไทยเองจากกรอบ glyph (ไม่ได้อ่าน GPOS ของฟอนต์)
+//   - วรรณยุกต์ / ทัณฑฆาต ที่ตามสระบน (ที่ ปี่) หรือนำหน้า ำ (น้ำ) → ยกขึ้นพ้นสระ
+//   - สระบน/วรรณยุกต์บนพยัญชนะหางสูง ป ฝ ฟ ฬ → เลื่อนซ้ายพ้นหาง
+//   - สระล่างใต้ ฎ ฏ → เลื่อนลงพ้นเชิง
+// ฟอนต์ที่ไม่มี loca/glyf หรือไม่มีกรอบของตัวที่เกี่ยวข้อง = วางตามตำแหน่งปกติของฟอนต์
+
+var (
+	thaiTall  = []rune{'ป', 'ฝ', 'ฟ', 'ฬ'}
+	thaiAbove = map[rune]bool{'ั': true, 'ิ': true, 'ี': true, 'ึ': true, 'ื': true, '็': true, 'ํ': true}
+	thaiTone  = map[rune]bool{'่': true, '้': true, '๊': true, '๋': true, '์': true}
+	thaiBelow = map[rune]bool{'ุ': true, 'ู': true, 'ฺ': true}
+	thaiDeep  = map[rune]bool{'ฎ': true, 'ฏ': true}
+)
+
+// placedGlyph glyph หนึ่งตัวพร้อมระยะเลื่อนจากตำแหน่งปกติ (หน่วยฟอนต์; dx ลบ = ซ้าย, dy บวก = ขึ้น)
+type placedGlyph struct {
+	r      rune
+	g      uint16
+	dx, dy int
+}
+
+// layout แปลงข้อความเป็น glyph ที่จัดตำแหน่งเครื่องหมายแล้ว (ข้ามอักขระควบคุมที่ไม่มี glyph)
+func (f *Font) layout(s string) []placedGlyph {
+	rs := []rune(s)
+	out := make([]placedGlyph, 0, len(rs))
+	gap := f.unitsPerEm / 50
+
+	var (
+		base     rune
+		baseAdv  int
+		aboveTop int // ยอดของสระบนใน cluster นี้ (หลังเลื่อน), 0 = ยังไม่มี
+	)
+	for i, r := range rs {
+		g := f.glyph(r)
+		if g == 0 && !unicode.IsPrint(r) {
+			continue
+		}
+		p := placedGlyph{r: r, g: g}
+		box, hasBox := f.thai[r]
+
+		switch {
+		case hasBox && (thaiAbove[r] || thaiTone[r]):
+			if left, ok := f.ascLeft[base]; ok {
+				// ขอบขวาของเครื่องหมาย (เทียบจุดเริ่มของพยัญชนะ) ต้องอยู่ซ้ายของหาง
+				p.dx = min(0, left-gap-(baseAdv+box.xMax))
+			}
+			if thaiTone[r] {
+				top := aboveTop
+				if i+1 < len(rs) && rs[i+1] == 'ำ' {
+					if am, ok := f.thai['ำ']; ok {
+						top = max(top, am.yMax)
+					}
+				}
+				if top > 0 {
+					p.dy = max(0, top+gap-box.yMin)
+				}
+			} else {
+				aboveTop = max(aboveTop, box.yMax+p.dy)
+			}
+		case hasBox && thaiBelow[r] && thaiDeep[base]:
+			if b, ok := f.thai[base]; ok {
+				p.dy = min(0, b.yMin-gap-box.yMax)
+			}
+		case unicode.Is(unicode.Mn, r):
+			// เครื่องหมายอื่น → ตำแหน่งปกติ
+		default:
+			base, aboveTop = r, 0
+			baseAdv = 0
+			if int(g) < len(f.advances) {
+				baseAdv = int(f.advances[g])
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,314 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Font ฟอนต์ TrueType (glyf) ที่ฝังทั้งไฟล์ลง PDF แบบ CID / Identity-H
+type Font struct {
+	unitsPerEm             int
+	ascent, descent        int
+	xMin, yMin, xMax, yMax int
+	glyphs                 map[rune]uint16
+	advances               []uint16
+	thai                   map[rune]glyphBox // กรอบ glyph ของตัวอักษรไทย (จัดตำแหน่งสระบน/วรรณยุกต์)
+	ascLeft                map[rune]int      // ขอบซ้ายของหางที่สูงกว่าพยัญชนะปกติ (ป ฝ ฟ ฬ)
+	compressed             []byte // ไฟล์ฟอนต์บีบอัดแล้ว (FlateDecode)
+	length                 int
+}
+
+var ErrUnsupportedFont = errors.New("unsupported font: need TrueType outlines (glyf)")
+
+type ttfTable struct{ off, len int }
+
+// ParseTTF อ่านเฉพาะตารางที่ต้องใช้: head, hhea, maxp, hmtx, cmap (+ loca/glyf ของตัวอักษรไทย)
+func ParseTTF(data []byte) (*Font, error) {
+	if len(data) < 12 {
+		return nil, ErrUnsupportedFont
+	}
+	if v := binary.BigEndian.Uint32(data); v != 0x00010000 && v != 0x74727565 { // 'true'
+		return nil, ErrUnsupportedFont
+	}
+	n := int(binary.BigEndian.Uint16(data[4:]))
+	tables := map[string]ttfTable{}
+	for i := 0; i < n; i++ {
+		p := 12 + 16*i
+		if p+16 > len(data) {
+			return nil, ErrUnsupportedFont
+		}
+		off := int(binary.BigEndian.Uint32(data[p+8:]))
+		ln := int(binary.BigEndian.Uint32(data[p+12:]))
+		if off < 0 || ln < 0 || off+ln > len(data) {
+			return nil, fmt.Errorf("font table %q out of range", data[p:p+4])
+		}
+		tables[string(data[p:p+4])] = ttfTable{off, ln}
+	}
+	for _, t := range []string{"head", "hhea", "maxp", "hmtx", "cmap", "glyf"} {
+		if _, ok := tables[t]; !ok {
+			return nil, fmt.Errorf("%w: missing %s table", ErrUnsupportedFont, t)
+		}
+	}
+
+	u16 := func(p int) int { return int(binary.BigEndian.Uint16(data[p:])) }
+	i16 := func(p int) int { return int(int16(binary.BigEndian.Uint16(data[p:]))) }
+
+	f := &Font{glyphs: map[rune]uint16{}, length: len(data)}
+	head := tables["head"].off
+	f.unitsPerEm = u16(head + 18)
+	if f.unitsPerEm == 0 {
+		return nil, ErrUnsupportedFont
+	}
+	f.xMin, f.yMin, f.xMax, f.yMax = i16(head+36), i16(head+38), i16(head+40), i16(head+42)
+
+	hhea := tables["hhea"].off
+	f.ascent, f.descent = i16(hhea+4), i16(hhea+6)
+	numHM := u16(hhea + 34)
+	numGlyphs := u16(tables["maxp"].off + 4)
+
+	hmtx := tables["hmtx"]
+	if numHM == 0 || numHM*4 > hmtx.len {
+		return nil, errors.New("font hmtx table too short")
+	}
+	f.advances = make([]uint16, numGlyphs)
+	for g := 0; g < numGlyphs; g++ {
+		if g < numHM {
+			f.advances[g] = uint16(u16(hmtx.off + 4*g))
+		} else {
+			f.advances[g] = f.advances[numHM-1]
+		}
+	}
+
+	if err := f.parseCmap(data, tables["cmap"].off); err != nil {
+		return nil, err
+	}
+	if loca, ok := tables["loca"]; ok {
+		f.parseThaiGlyphs(data, loca, tables["glyf"], i16(head+50) == 1, numGlyphs)
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	f.compressed = buf.Bytes()
+	return f, nil
+}
+
+// parseCmap ใช้ subtable format 12 (3,10) ถ้ามี ไม่งั้น format 4 (3,1)/(0,x)
+func (f *Font) parseCmap(data []byte, base int) error {
+	u16 := func(p int) int { return int(binary.BigEndian.Uint16(data[p:])) }
+	u32 := func(p int) int { return int(binary.BigEndian.Uint32(data[p:])) }
+
+	var fmt4, fmt12 int = -1, -1
+	for i := 0; i < u16(base+2); i++ {
+		rec := base + 4 + 8*i
+		pid, eid, off := u16(rec), u16(rec+2), base+u32(rec+4)
+		if off+4 > len(data) {
+			continue
+		}
+		switch u16(off) {
+		case 12:
+			if (pid == 3 && eid == 10) || pid == 0 {
+				fmt12 = off
+			}
+		case 4:
+			if (pid == 3 && eid == 1) || pid == 0 {
+				fmt4 = off
+			}
+		}
+	}
+
+	switch {
+	case fmt12 >= 0:
+		groups := u32(fmt12 + 12)
+		for i := 0; i < groups; i++ {
+			g := fmt12 + 16 + 12*i
+			start, end, gid := u32(g), u32(g+4), u32(g+8)
+			for c := start; c <= end && c <= 0x10FFFF; c++ {
+				f.glyphs[rune(c)] = uint16(gid + c - start)
+			}
+		}
+	case fmt4 >= 0:
+		segs := u16(fmt4+6) / 2
+		ends := fmt4 + 14
+		starts := ends + 2*segs + 2
+		deltas := starts + 2*segs
+		ranges := deltas + 2*segs
+		for s := 0; s < segs; s++ {
+			start, end := u16(starts+2*s), u16(ends+2*s)
+			delta, ro := u16(deltas+2*s), u16(ranges+2*s)
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				var gid int
+				if ro == 0 {
+					gid = (c + delta) & 0xFFFF
+				} else {
+					p := ranges + 2*s + ro + 2*(c-start)
+					if p+2 > len(data) {
+						break
+					}
+					if gid = u16(p); gid != 0 {
+						gid = (gid + delta) & 0xFFFF
+					}
+				}
+				if gid != 0 {
+					f.glyphs[rune(c)] = uint16(gid)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("%w: no unicode cmap", ErrUnsupportedFont)
+	}
+	return nil
+}
+
+// glyphBox กรอบของ glyph (หน่วยฟอนต์, เทียบจุดเริ่มของ glyph)
+type glyphBox struct{ xMin, yMin, xMax, yMax int }
+
+// parseThaiGlyphs อ่านกรอบ glyph ของช่วงไทย (U+0E00–U+0E7F) จาก loca/glyf
+// และขอบซ้ายของหางพยัญชนะสูง = จุดซ้ายสุดของ outline ที่สูงกว่ายอดของ ก
+func (f *Font) parseThaiGlyphs(data []byte, loca, glyf ttfTable, long bool, numGlyphs int) {
+	span := func(g int) (int, int, bool) {
+		if g >= numGlyphs {
+			return 0, 0, false
+		}
+		var a, b int
+		if long {
+			if loca.len < 4*(g+2) {
+				return 0, 0, false
+			}
+			a = int(binary.BigEndian.Uint32(data[loca.off+4*g:]))
+			b = int(binary.BigEndian.Uint32(data[loca.off+4*g+4:]))
+		} else {
+			if loca.len < 2*(g+2) {
+				return 0, 0, false
+			}
+			a = 2 * int(binary.BigEndian.Uint16(data[loca.off+2*g:]))
+			b = 2 * int(binary.BigEndian.Uint16(data[loca.off+2*g+2:]))
+		}
+		if b-a < 10 || b > glyf.len {
+			return 0, 0, false // glyph ว่าง (เช่น ช่องว่าง)
+		}
+		return glyf.off + a, glyf.off + b, true
+	}
+
+	f.thai = map[rune]glyphBox{}
+	for r := rune(0x0E00); r <= 0x0E7F; r++ {
+		g, ok := f.glyphs[r]
+		if !ok {
+			continue
+		}
+		a, _, ok := span(int(g))
+		if !ok {
+			continue
+		}
+		i16 := func(p int) int { return int(int16(binary.BigEndian.Uint16(data[p:]))) }
+		f.thai[r] = glyphBox{i16(a + 2), i16(a + 4), i16(a + 6), i16(a + 8)}
+	}
+
+	short, ok := f.thai['ก']
+	if !ok {
+		return
+	}
+	f.ascLeft = map[rune]int{}
+	for _, r := range thaiTall {
+		a, b, ok := span(int(f.glyphs[r]))
+		if !ok {
+			continue
+		}
+		left, found := 0, false
+		for _, pt := range simpleGlyphPoints(data[a:b]) {
+			if pt[1] > short.yMax && (!found || pt[0] < left) {
+				left, found = pt[0], true
+			}
+		}
+		if found {
+			f.ascLeft[r] = left
+		}
+	}
+}
+
+// simpleGlyphPoints จุดของ outline แบบ simple glyph (composite / ข้อมูลเสีย = nil)
+func simpleGlyphPoints(g []byte) [][2]int {
+	u16 := func(p int) int { return int(binary.BigEndian.Uint16(g[p:])) }
+	n := int(int16(u16(0)))
+	if n <= 0 || len(g) < 10+2*n+2 {
+		return nil
+	}
+	count := u16(10+2*(n-1)) + 1
+	p := 10 + 2*n
+	p += 2 + u16(p) // ข้าม instructions
+	flags := make([]byte, 0, count)
+	for len(flags) < count {
+		if p >= len(g) {
+			return nil
+		}
+		fl := g[p]
+		p++
+		flags = append(flags, fl)
+		if fl&0x08 != 0 { // repeat
+			if p >= len(g) {
+				return nil
+			}
+			for k := 0; k < int(g[p]) && len(flags) < count; k++ {
+				flags = append(flags, fl)
+			}
+			p++
+		}
+	}
+	coords := func(short, same byte) []int {
+		out := make([]int, count)
+		v := 0
+		for i, fl := range flags {
+			switch {
+			case fl&short != 0:
+				if p >= len(g) {
+					return nil
+				}
+				d := int(g[p])
+				p++
+				if fl&same == 0 {
+					d = -d
+				}
+				v += d
+			case fl&same == 0:
+				if p+2 > len(g) {
+					return nil
+				}
+				v += int(int16(u16(p)))
+				p += 2
+			}
+			out[i] = v
+		}
+		return out
+	}
+	xs := coords(0x02, 0x10)
+	ys := coords(0x04, 0x20)
+	if xs == nil || ys == nil {
+		return nil
+	}
+	pts := make([][2]int, count)
+	for i := range pts {
+		pts[i] = [2]int{xs[i], ys[i]}
+	}
+	return pts
+}
+
+// glyph คืน glyph id ของตัวอักษร (0 = .notdef)
+func (f *Font) glyph(r rune) uint16 { return f.glyphs[r] }
+
+// scaled แปลงหน่วยฟอนต์เป็นหน่วย 1/1000 em ของ PDF
+func (f *Font) scaled(v int) int { return v * 1000 / f.unitsPerEm }
+
+// Width ความกว้างของข้อความ (point) ที่ขนาด size
+func (f *Font) Width(s string, size float64) float64 {
+	w := 0
+	for _, r := range s {
+		if g := int(f.glyph(r)); g < len(f.advances) {
+			w += int(f.advances[g])
+		}
+	}
+	return float64(w) * size / float64(f.unitsPerEm)
+}
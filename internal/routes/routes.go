@@ -6,6 +6,7 @@ import (
 
 	"go-sqlserver-demo/internal/auth"
 	"go-sqlserver-demo/internal/eval"
+	"go-sqlserver-demo/internal/scim"
 	"go-sqlserver-demo/internal/timeattendance"
 	"go-sqlserver-demo/internal/user"
 
@@ -18,13 +19,16 @@ type Options struct {
 	JWTSecret string
 	JWTIssuer string
 	JWTTTL    time.Duration
+	SCIMToken string
+
+	ScoreDecimals int // จำนวนทศนิยมของคะแนนประเมิน
 }
 
 func Register(app *fiber.App, opt Options) {
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
+		AllowMethods: "GET,POST,PUT,PATCH,DELETE,OPTIONS",
 		AllowHeaders: "Authorization, Content-Type",
 	}))
 
@@ -35,7 +39,8 @@ func Register(app *fiber.App, opt Options) {
 
 	repo := user.NewRepo(opt.DB)
 	uh := user.NewHandler(repo)
-	uh.RegisterRoutes(api.Group("/users")) // auth.JWTMiddleware(opt.JWTSecret)
+	uh.HasRole = auth.HasRole
+	uh.RegisterRoutes(api.Group("/users", auth.OptionalJWTMiddleware(opt.JWTSecret))) // auth.JWTMiddleware(opt.JWTSecret)
 
 	ah := auth.NewAuthHandler(opt.DB, opt.JWTSecret, opt.JWTIssuer, opt.JWTTTL)
 	ag := api.Group("/auth")
@@ -47,7 +52,15 @@ func Register(app *fiber.App, opt Options) {
 	taH.RegisterRoutes(api.Group("/users", auth.JWTMiddleware(opt.JWTSecret)))
 
 	evRepo := eval.NewRepo(opt.DB)
+	evRepo.Engine = eval.NewEngine(eval.Rounding{Places: opt.ScoreDecimals, Mode: eval.RoundHalfUp})
 	evH := eval.NewHandler(evRepo)
 	evH.RegisterRoutes(api.Group("/eval", auth.JWTMiddleware(opt.JWTSecret)))
+	repo.BeforeDelete = append(repo.BeforeDelete, eval.DeleteUserData)
+
+	// SCIM 2.0 สำหรับ HRIS (ใช้ service token แยกจาก JWT ของผู้ใช้)
+	scimRepo := scim.NewRepo(opt.DB)
+	scimRepo.Users = repo // ลบผู้ใช้ผ่าน SCIM ต้องผ่าน BeforeDelete เดียวกัน
+	scimH := scim.NewHandler(scimRepo)
+	scimH.RegisterRoutes(app.Group("/scim/v2", scim.BearerAuth(opt.SCIMToken)))
 
 }
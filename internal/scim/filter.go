@@ -0,0 +1,153 @@
+package scim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// userFilterAttrs / groupFilterAttrs: attribute ของ SCIM (ตัวเล็ก) → คอลัมน์ SQL
+var userFilterAttrs = map[string]string{
+	"id":                             "u.id",
+	"username":                       "u.person_code",
+	"displayname":                    "u.name",
+	"name.formatted":                 "u.name",
+	"title":                          "u.position",
+	"usertype":                       "u.role",
+	"emails":                         "u.email",
+	"emails.value":                   "u.email",
+	"employeenumber":                 "u.person_code",
+	"department":                     "u.department",
+	"manager.value":                  "u.manager_id",
+	"meta.lastmodified":              "u.updated_at",
+	"meta.created":                   "u.created_at",
+	enterpriseAttr("employeenumber"): "u.person_code",
+	enterpriseAttr("department"):     "u.department",
+	enterpriseAttr("manager.value"):  "u.manager_id",
+	enterpriseAttr("manager"):        "u.manager_id",
+}
+
+var groupFilterAttrs = map[string]string{
+	"id":            "c.id",
+	"displayname":   "c.name",
+	"externalid":    "c.code",
+	"members.value": "EXISTS_MEMBER",
+	"members":       "EXISTS_MEMBER",
+}
+
+func enterpriseAttr(a string) string { return strings.ToLower(SchemaEnterprise) + ":" + a }
+
+// parseFilter แปลง filter แบบง่าย `attr op "value" [and ...]` เป็น WHERE + args
+// รองรับ op: eq ne co sw ew pr (ไม่รองรับ or / วงเล็บ)
+func parseFilter(filter string, attrs map[string]string, firstParam int) (string, []any, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return "1=1", nil, nil
+	}
+
+	var (
+		conds []string
+		args  []any
+		p     = firstParam
+		s     = filter
+	)
+	for {
+		s = strings.TrimSpace(s)
+		attr, rest := nextToken(s)
+		op, rest := nextToken(rest)
+		col, ok := attrs[strings.ToLower(attr)]
+		if attr == "" || !ok {
+			return "", nil, fmt.Errorf("%w: unsupported attribute %q", ErrInvalidFilter, attr)
+		}
+		op = strings.ToLower(op)
+
+		var cond string
+		if op == "pr" {
+			cond = fmt.Sprintf("(%s IS NOT NULL AND CAST(%s AS nvarchar(400)) <> '')", col, col)
+		} else {
+			val, r2, err := nextValue(rest)
+			if err != nil {
+				return "", nil, err
+			}
+			rest = r2
+
+			var expr string
+			switch op {
+			case "eq":
+				expr, args = "= @p%d", append(args, val)
+			case "ne":
+				expr, args = "<> @p%d", append(args, val)
+			case "co":
+				expr, args = "LIKE @p%d", append(args, "%"+escapeLike(val)+"%")
+			case "sw":
+				expr, args = "LIKE @p%d", append(args, escapeLike(val)+"%")
+			case "ew":
+				expr, args = "LIKE @p%d", append(args, "%"+escapeLike(val))
+			default:
+				return "", nil, fmt.Errorf("%w: unsupported operator %q", ErrInvalidFilter, op)
+			}
+			expr = fmt.Sprintf(expr, p)
+			p++
+
+			if col == "EXISTS_MEMBER" {
+				cond = "EXISTS (SELECT 1 FROM dbo.user_companies m WHERE m.company_id=c.id AND CAST(m.user_id AS nvarchar(20)) " + expr + ")"
+			} else {
+				cond = fmt.Sprintf("CAST(%s AS nvarchar(400)) %s", col, expr)
+			}
+		}
+		conds = append(conds, cond)
+
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			break
+		}
+		kw, r3 := nextToken(rest)
+		if strings.ToLower(kw) != "and" {
+			return "", nil, fmt.Errorf("%w: expected 'and' but got %q", ErrInvalidFilter, kw)
+		}
+		s = r3
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+func nextToken(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// nextValue อ่านค่า "quoted" (รองรับ \") หรือ true/false/null/ตัวเลข
+func nextValue(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", "", fmt.Errorf("%w: missing value", ErrInvalidFilter)
+	}
+	if s[0] != '"' {
+		v, rest := nextToken(s)
+		return v, rest, nil
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:], nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`[`, `[[]`, `%`, `[%]`, `_`, `[_]`)
+	return r.Replace(s)
+}
@@ -0,0 +1,569 @@
+package scim
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-sqlserver-demo/internal/user"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const contentType = "application/scim+json"
+
+type Handler struct{ Repo *Repo }
+
+func NewHandler(r *Repo) *Handler { return &Handler{Repo: r} }
+
+func (h *Handler) RegisterRoutes(r fiber.Router) {
+	r.Get("/ServiceProviderConfig", h.serviceProviderConfig)
+
+	r.Get("/Users", h.listUsers)
+	r.Get("/Users/:id", h.getUser)
+	r.Post("/Users", h.createUser)
+	r.Put("/Users/:id", h.replaceUser)
+	r.Patch("/Users/:id", h.patchUser)
+	r.Delete("/Users/:id", h.deleteUser)
+
+	r.Get("/Groups", h.listGroups)
+	r.Get("/Groups/:id", h.getGroup)
+	r.Post("/Groups", h.createGroup)
+	r.Put("/Groups/:id", h.replaceGroup)
+	r.Patch("/Groups/:id", h.patchGroup)
+	r.Delete("/Groups/:id", h.deleteGroup)
+}
+
+// BearerAuth ตรวจ service token ของ HRIS (ถ้าไม่ได้ตั้งค่า token จะปิด endpoint ทั้งหมด)
+func BearerAuth(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		h := c.Get("Authorization")
+		got := strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+		if token == "" || !strings.HasPrefix(h, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return scimError(c, 401, "", "invalid service token")
+		}
+		return c.Next()
+	}
+}
+
+func scimError(c *fiber.Ctx, status int, scimType, detail string) error {
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Status(status).JSON(Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+// fromErr แปลง error ของ repo เป็น response ตาม RFC 7644
+func fromErr(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return scimError(c, 404, "", err.Error())
+	case errors.Is(err, ErrConflict):
+		return scimError(c, 409, "uniqueness", err.Error())
+	case errors.Is(err, ErrInvalidFilter):
+		return scimError(c, 400, "invalidFilter", err.Error())
+	case errors.Is(err, ErrBadTarget):
+		return scimError(c, 400, "invalidPath", err.Error())
+	case errors.Is(err, ErrBadValue):
+		return scimError(c, 400, "invalidValue", err.Error())
+	}
+	return scimError(c, 500, "", err.Error())
+}
+
+func send(c *fiber.Ctx, status int, etag string, body any) error {
+	c.Set(fiber.HeaderContentType, contentType)
+	if etag != "" {
+		c.Set(fiber.HeaderETag, etag)
+	}
+	return c.Status(status).JSON(body)
+}
+
+// etagOf ใช้ updated_at ละเอียดระดับ 100ns (DATETIME2(7)) กันสองการแก้ไขในวินาทีเดียวได้ ETag ซ้ำ
+func etagOf(t time.Time) string { return fmt.Sprintf(`W/"%d"`, t.UTC().UnixNano()) }
+
+// checkIfMatch: ถ้ามี If-Match แต่ไม่ตรงกับ version ปัจจุบัน → 412
+func checkIfMatch(c *fiber.Ctx, current string) bool {
+	want := c.Get(fiber.HeaderIfMatch)
+	if want == "" || want == "*" {
+		return true
+	}
+	for _, v := range strings.Split(want, ",") {
+		if strings.TrimSpace(v) == current {
+			return true
+		}
+	}
+	return false
+}
+
+// startIndex (เริ่มที่ 1) และ count ตาม RFC 7644 §3.4.2.4
+func paging(c *fiber.Ctx) (int, int) {
+	start, _ := strconv.Atoi(c.Query("startIndex", "1"))
+	count, _ := strconv.Atoi(c.Query("count", "100"))
+	if start < 1 {
+		start = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > 200 {
+		count = 200
+	}
+	return start, count
+}
+
+func (h *Handler) serviceProviderConfig(c *fiber.Ctx) error {
+	return send(c, 200, "", fiber.Map{
+		"schemas":        []string{SchemaSPConfig},
+		"patch":          fiber.Map{"supported": true},
+		"bulk":           fiber.Map{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         fiber.Map{"supported": true, "maxResults": 200},
+		"changePassword": fiber.Map{"supported": true},
+		"sort":           fiber.Map{"supported": false},
+		"etag":           fiber.Map{"supported": true},
+		"authenticationSchemes": []fiber.Map{{
+			"type": "oauthbearertoken", "name": "Bearer Token", "description": "service token",
+		}},
+	})
+}
+
+// ===== Users =====
+
+func (h *Handler) userLocation(c *fiber.Ctx, id int) string {
+	return c.BaseURL() + "/scim/v2/Users/" + strconv.Itoa(id)
+}
+
+func (h *Handler) toSCIMUser(c *fiber.Ctx, u user.User) User {
+	out := User{
+		Schemas:     []string{SchemaUser, SchemaEnterprise},
+		ID:          strconv.Itoa(u.ID),
+		UserName:    u.PersonCode,
+		Name:        &Name{Formatted: u.Name},
+		DisplayName: u.Name,
+		Title:       u.Position,
+		UserType:    u.Role,
+		Active:      &u.Active,
+		Enterprise: &EnterpriseUser{
+			EmployeeNumber: u.PersonCode,
+			Department:     u.Department,
+		},
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      u.CreatedAt.UTC().Format(time.RFC3339),
+			LastModified: u.UpdatedAt.UTC().Format(time.RFC3339),
+			Location:     h.userLocation(c, u.ID),
+			Version:      etagOf(u.UpdatedAt),
+		},
+	}
+	if u.Email != "" {
+		out.Emails = []Email{{Value: u.Email, Type: "work", Primary: true}}
+	}
+	if u.ManagerID != nil {
+		out.Enterprise.Manager = &Ref{
+			Value: strconv.Itoa(*u.ManagerID),
+			Ref:   h.userLocation(c, *u.ManagerID),
+		}
+	}
+	for _, cp := range u.Company {
+		out.Groups = append(out.Groups, Ref{
+			Value:   cp.ID,
+			Display: cp.Name,
+			Ref:     c.BaseURL() + "/scim/v2/Groups/" + cp.ID,
+		})
+	}
+	return out
+}
+
+func primaryEmail(es []Email) string {
+	for _, e := range es {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(es) > 0 {
+		return es[0].Value
+	}
+	return ""
+}
+
+// requireEmail: users.email เป็น NOT NULL UNIQUE → ไม่มีอีเมลตอบ 400 invalidValue แทนการบันทึกค่าว่าง
+func requireEmail(email string) error {
+	if strings.TrimSpace(email) == "" {
+		return fmt.Errorf("%w: emails: a primary email is required", ErrBadValue)
+	}
+	return nil
+}
+
+func managerID(ref *Ref) (*int, error) {
+	if ref == nil || ref.Value == "" {
+		return nil, nil
+	}
+	id, err := strconv.Atoi(ref.Value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: manager.value must be a user id", ErrBadValue)
+	}
+	return &id, nil
+}
+
+func displayName(in User) string {
+	if in.DisplayName != "" {
+		return in.DisplayName
+	}
+	if in.Name != nil {
+		if in.Name.Formatted != "" {
+			return in.Name.Formatted
+		}
+		return strings.TrimSpace(in.Name.GivenName + " " + in.Name.FamilyName)
+	}
+	return in.UserName
+}
+
+func parseUser(c *fiber.Ctx) (User, error) {
+	var in User
+	if err := json.Unmarshal(c.Body(), &in); err != nil {
+		return User{}, fmt.Errorf("%w: invalid json", ErrBadValue)
+	}
+	if in.UserName == "" && in.Enterprise != nil {
+		in.UserName = in.Enterprise.EmployeeNumber
+	}
+	return in, nil
+}
+
+func (h *Handler) listUsers(c *fiber.Ctx) error {
+	start, count := paging(c)
+	us, total, err := h.Repo.ListUsers(c.Context(), c.Query("filter"), start, count)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	res := make([]User, 0, len(us))
+	for _, u := range us {
+		res = append(res, h.toSCIMUser(c, u))
+	}
+	return send(c, 200, "", ListResponse{
+		Schemas:      []string{SchemaList},
+		TotalResults: total,
+		StartIndex:   start,
+		ItemsPerPage: len(res),
+		Resources:    res,
+	})
+}
+
+func (h *Handler) getUser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return scimError(c, 404, "", ErrNotFound.Error())
+	}
+	u, err := h.Repo.GetUser(c.Context(), id)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	out := h.toSCIMUser(c, u)
+	return send(c, 200, out.Meta.Version, out)
+}
+
+func (h *Handler) createUser(c *fiber.Ctx) error {
+	in, err := parseUser(c)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	mgr, err := managerID(enterpriseManager(in))
+	if err != nil {
+		return fromErr(c, err)
+	}
+
+	cin := user.CreateUserInput{
+		Name:       displayName(in),
+		Email:      primaryEmail(in.Emails),
+		Role:       in.UserType,
+		Password:   in.Password,
+		PersonCode: in.UserName,
+		Position:   in.Title,
+		ManagerID:  mgr,
+		Active:     in.Active,
+	}
+	if in.Enterprise != nil {
+		cin.Department = in.Enterprise.Department
+	}
+	if err := requireEmail(cin.Email); err != nil {
+		return fromErr(c, err)
+	}
+
+	u, err := h.Repo.CreateUser(c.Context(), cin)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	out := h.toSCIMUser(c, u)
+	c.Location(out.Meta.Location)
+	return send(c, 201, out.Meta.Version, out)
+}
+
+func enterpriseManager(in User) *Ref {
+	if in.Enterprise == nil {
+		return nil
+	}
+	return in.Enterprise.Manager
+}
+
+func (h *Handler) replaceUser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return scimError(c, 404, "", ErrNotFound.Error())
+	}
+	cur, err := h.Repo.GetUser(c.Context(), id)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	if !checkIfMatch(c, etagOf(cur.UpdatedAt)) {
+		return scimError(c, 412, "", "version mismatch")
+	}
+
+	in, err := parseUser(c)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	mgr, err := managerID(enterpriseManager(in))
+	if err != nil {
+		return fromErr(c, err)
+	}
+
+	// PUT = แทนที่ทั้งก้อน: ฟิลด์ที่ไม่ส่งมาถือว่าว่าง
+	name, email, title, role := displayName(in), primaryEmail(in.Emails), in.Title, in.UserType
+	if err := requireEmail(email); err != nil {
+		return fromErr(c, err)
+	}
+	dept := ""
+	if in.Enterprise != nil {
+		dept = in.Enterprise.Department
+	}
+	clearMgr := 0
+	if mgr == nil {
+		mgr = &clearMgr
+	}
+	upd := user.UpdateUserInput{
+		Name:       &name,
+		Email:      &email,
+		Role:       &role,
+		PersonCode: &in.UserName,
+		Position:   &title,
+		Department: &dept,
+		ManagerID:  mgr,
+		Active:     in.Active,
+	}
+	if in.Password != "" {
+		upd.Password = &in.Password
+	}
+
+	u, err := h.Repo.UpdateUser(c.Context(), id, upd)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	out := h.toSCIMUser(c, u)
+	return send(c, 200, out.Meta.Version, out)
+}
+
+func (h *Handler) patchUser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return scimError(c, 404, "", ErrNotFound.Error())
+	}
+	cur, err := h.Repo.GetUser(c.Context(), id)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	if !checkIfMatch(c, etagOf(cur.UpdatedAt)) {
+		return scimError(c, 412, "", "version mismatch")
+	}
+
+	var req PatchRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil || len(req.Operations) == 0 {
+		return scimError(c, 400, "invalidSyntax", "invalid PatchOp body")
+	}
+
+	var upd user.UpdateUserInput
+	for _, op := range req.Operations {
+		if err := applyUserOp(&upd, op); err != nil {
+			return fromErr(c, err)
+		}
+	}
+
+	u, err := h.Repo.UpdateUser(c.Context(), id, upd)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	out := h.toSCIMUser(c, u)
+	return send(c, 200, out.Meta.Version, out)
+}
+
+func (h *Handler) deleteUser(c *fiber.Ctx) error {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return scimError(c, 404, "", ErrNotFound.Error())
+	}
+	cur, err := h.Repo.GetUser(c.Context(), id)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	if !checkIfMatch(c, etagOf(cur.UpdatedAt)) {
+		return scimError(c, 412, "", "version mismatch")
+	}
+	if err := h.Repo.DeleteUser(c.Context(), id); err != nil {
+		return fromErr(c, err)
+	}
+	return c.SendStatus(204)
+}
+
+// ===== Groups =====
+
+func (h *Handler) toSCIMGroup(c *fiber.Ctx, g company) Group {
+	out := Group{
+		Schemas:     []string{SchemaGroup},
+		ID:          g.ID,
+		DisplayName: g.Name,
+		ExternalID:  g.Code,
+		Meta: &Meta{
+			ResourceType: "Group",
+			LastModified: g.UpdatedAt.UTC().Format(time.RFC3339),
+			Location:     c.BaseURL() + "/scim/v2/Groups/" + g.ID,
+			Version:      etagOf(g.UpdatedAt),
+		},
+	}
+	for _, m := range g.Members {
+		m.Ref = c.BaseURL() + "/scim/v2/Users/" + m.Value
+		out.Members = append(out.Members, m)
+	}
+	return out
+}
+
+func memberIDs(refs []Ref) ([]int, error) {
+	out := make([]int, 0, len(refs))
+	for _, m := range refs {
+		id, err := strconv.Atoi(m.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: member value must be a user id", ErrBadValue)
+		}
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+func (h *Handler) listGroups(c *fiber.Ctx) error {
+	start, count := paging(c)
+	gs, total, err := h.Repo.ListGroups(c.Context(), c.Query("filter"), start, count)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	res := make([]Group, 0, len(gs))
+	for _, g := range gs {
+		res = append(res, h.toSCIMGroup(c, g))
+	}
+	return send(c, 200, "", ListResponse{
+		Schemas:      []string{SchemaList},
+		TotalResults: total,
+		StartIndex:   start,
+		ItemsPerPage: len(res),
+		Resources:    res,
+	})
+}
+
+func (h *Handler) getGroup(c *fiber.Ctx) error {
+	g, err := h.Repo.GetGroup(c.Context(), c.Params("id"))
+	if err != nil {
+		return fromErr(c, err)
+	}
+	out := h.toSCIMGroup(c, g)
+	return send(c, 200, out.Meta.Version, out)
+}
+
+func (h *Handler) createGroup(c *fiber.Ctx) error {
+	var in Group
+	if err := json.Unmarshal(c.Body(), &in); err != nil {
+		return scimError(c, 400, "invalidSyntax", "invalid json")
+	}
+	members, err := memberIDs(in.Members)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	g, err := h.Repo.CreateGroup(c.Context(), in.DisplayName, in.ExternalID, members)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	out := h.toSCIMGroup(c, g)
+	c.Location(out.Meta.Location)
+	return send(c, 201, out.Meta.Version, out)
+}
+
+func (h *Handler) replaceGroup(c *fiber.Ctx) error {
+	cur, err := h.Repo.GetGroup(c.Context(), c.Params("id"))
+	if err != nil {
+		return fromErr(c, err)
+	}
+	if !checkIfMatch(c, etagOf(cur.UpdatedAt)) {
+		return scimError(c, 412, "", "version mismatch")
+	}
+
+	var in Group
+	if err := json.Unmarshal(c.Body(), &in); err != nil {
+		return scimError(c, 400, "invalidSyntax", "invalid json")
+	}
+	members, err := memberIDs(in.Members)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	g, err := h.Repo.ReplaceGroup(c.Context(), cur.ID, in.DisplayName, in.ExternalID, &members)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	out := h.toSCIMGroup(c, g)
+	return send(c, 200, out.Meta.Version, out)
+}
+
+func (h *Handler) patchGroup(c *fiber.Ctx) error {
+	cur, err := h.Repo.GetGroup(c.Context(), c.Params("id"))
+	if err != nil {
+		return fromErr(c, err)
+	}
+	if !checkIfMatch(c, etagOf(cur.UpdatedAt)) {
+		return scimError(c, 412, "", "version mismatch")
+	}
+
+	var req PatchRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil || len(req.Operations) == 0 {
+		return scimError(c, 400, "invalidSyntax", "invalid PatchOp body")
+	}
+
+	members, _ := memberIDs(cur.Members)
+	name, code := "", ""
+	for _, op := range req.Operations {
+		if members, name, code, err = applyGroupOp(members, name, code, op); err != nil {
+			return fromErr(c, err)
+		}
+	}
+
+	g, err := h.Repo.ReplaceGroup(c.Context(), cur.ID, name, code, &members)
+	if err != nil {
+		return fromErr(c, err)
+	}
+	out := h.toSCIMGroup(c, g)
+	return send(c, 200, out.Meta.Version, out)
+}
+
+func (h *Handler) deleteGroup(c *fiber.Ctx) error {
+	cur, err := h.Repo.GetGroup(c.Context(), c.Params("id"))
+	if err != nil {
+		return fromErr(c, err)
+	}
+	if !checkIfMatch(c, etagOf(cur.UpdatedAt)) {
+		return scimError(c, 412, "", "version mismatch")
+	}
+	if err := h.Repo.DeleteGroup(c.Context(), cur.ID); err != nil {
+		return fromErr(c, err)
+	}
+	return c.SendStatus(204)
+}
@@ -0,0 +1,100 @@
+package scim
+
+import "encoding/json"
+
+const (
+	SchemaUser       = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaEnterprise = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	SchemaGroup      = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaList       = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp    = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError      = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaSPConfig   = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"` // ETag
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Ref อ้างอิง resource อื่น (members ของ group / groups ของ user / manager)
+type Ref struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// EnterpriseUser: employeeNumber → person_code, department → department, manager → manager_id
+type EnterpriseUser struct {
+	EmployeeNumber string `json:"employeeNumber,omitempty"`
+	Department     string `json:"department,omitempty"`
+	Manager        *Ref   `json:"manager,omitempty"`
+}
+
+// User: userName → person_code (ใช้ login), displayName/name.formatted → name, title → position, userType → role
+type User struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	UserName    string          `json:"userName"`
+	Name        *Name           `json:"name,omitempty"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Title       string          `json:"title,omitempty"`
+	UserType    string          `json:"userType,omitempty"`
+	Emails      []Email         `json:"emails,omitempty"`
+	Password    string          `json:"password,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Groups      []Ref           `json:"groups,omitempty"`
+	Enterprise  *EnterpriseUser `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Meta        *Meta           `json:"meta,omitempty"`
+}
+
+// Group = บริษัท: displayName → name, externalId → code
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	Members     []Ref    `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    any      `json:"Resources"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
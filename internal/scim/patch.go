@@ -0,0 +1,298 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go-sqlserver-demo/internal/user"
+)
+
+// stringValue อ่านค่าที่เป็น "x" หรือ {"value":"x"} หรือ [{"value":"x"}]
+func stringValue(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var ref Ref
+	if err := json.Unmarshal(raw, &ref); err == nil && ref.Value != "" {
+		return ref.Value, nil
+	}
+	var refs []Ref
+	if err := json.Unmarshal(raw, &refs); err == nil {
+		for _, r := range refs {
+			if r.Value != "" {
+				return r.Value, nil
+			}
+		}
+		return "", nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n.String(), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrBadValue, string(raw))
+}
+
+// boolValue อ่านค่า true/false ทั้งแบบ boolean และ string ("False" ที่ Entra ID ส่งมา)
+func boolValue(raw json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b, nil
+	}
+	s, err := stringValue(raw)
+	if err != nil {
+		return false, err
+	}
+	v, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return false, fmt.Errorf("%w: active must be a boolean", ErrBadValue)
+	}
+	return v, nil
+}
+
+// applyUserOp แปลง PatchOp หนึ่งรายการเป็นฟิลด์ของ user.UpdateUserInput
+func applyUserOp(upd *user.UpdateUserInput, op PatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return fmt.Errorf("%w: unsupported op %q", ErrBadValue, op.Op)
+	}
+
+	// ไม่มี path → value เป็น object ของหลาย attribute
+	if op.Path == "" {
+		if kind == "remove" {
+			return fmt.Errorf("%w: remove requires a path", ErrBadTarget)
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &obj); err != nil {
+			return fmt.Errorf("%w: value must be an object", ErrBadValue)
+		}
+		for k, v := range obj {
+			if strings.EqualFold(k, SchemaEnterprise) {
+				var ext map[string]json.RawMessage
+				if err := json.Unmarshal(v, &ext); err != nil {
+					return fmt.Errorf("%w: enterprise extension must be an object", ErrBadValue)
+				}
+				for ek, ev := range ext {
+					if err := setUserAttr(upd, SchemaEnterprise+":"+ek, ev, false); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := setUserAttr(upd, k, v, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return setUserAttr(upd, op.Path, op.Value, kind == "remove")
+}
+
+func setUserAttr(upd *user.UpdateUserInput, path string, raw json.RawMessage, remove bool) error {
+	p := strings.ToLower(path)
+	if strings.HasPrefix(p, "urn:ietf:params:scim:schemas:core:2.0:user:") {
+		p = strings.TrimPrefix(p, "urn:ietf:params:scim:schemas:core:2.0:user:")
+	}
+
+	// name เป็น object ได้ ({"formatted": ...})
+	if p == "name" && !remove {
+		var n Name
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return fmt.Errorf("%w: name must be an object", ErrBadValue)
+		}
+		v := displayName(User{Name: &n})
+		upd.Name = &v
+		return nil
+	}
+	// active=false → ปิดบัญชี (remove ถือว่าปิด)
+	if p == "active" {
+		v := false
+		if !remove {
+			var err error
+			if v, err = boolValue(raw); err != nil {
+				return err
+			}
+		}
+		upd.Active = &v
+		return nil
+	}
+	// manager เป็น object ได้ ({"value": "12"})
+	if p == enterpriseAttr("manager") || p == enterpriseAttr("manager.value") {
+		if remove {
+			zero := 0
+			upd.ManagerID = &zero
+			return nil
+		}
+		v, err := stringValue(raw)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			zero := 0
+			upd.ManagerID = &zero
+			return nil
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%w: manager must be a user id", ErrBadValue)
+		}
+		upd.ManagerID = &id
+		return nil
+	}
+
+	v := ""
+	if !remove {
+		var err error
+		if v, err = stringValue(raw); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case p == "username" || p == enterpriseAttr("employeenumber"):
+		if v == "" {
+			return fmt.Errorf("%w: userName cannot be empty", ErrBadValue)
+		}
+		upd.PersonCode = &v
+	case p == "displayname" || p == "name.formatted":
+		upd.Name = &v
+	case p == "title":
+		upd.Position = &v
+	case p == "usertype":
+		upd.Role = &v
+	case p == "password":
+		upd.Password = &v
+	case strings.HasPrefix(p, "emails"):
+		if err := requireEmail(v); err != nil {
+			return err
+		}
+		upd.Email = &v
+	case p == enterpriseAttr("department"):
+		upd.Department = &v
+	case p == "externalid", p == "schemas", p == "groups", strings.HasPrefix(p, "name."):
+		// ยังไม่มีคอลัมน์รองรับ → ข้าม (groups แก้ผ่าน /Groups ตาม RFC)
+	default:
+		return fmt.Errorf("%w: %s", ErrBadTarget, path)
+	}
+	return nil
+}
+
+// applyGroupOp: รองรับ displayName, externalId, members และ members[value eq "x"]
+func applyGroupOp(members []int, name, code string, op PatchOperation) ([]int, string, string, error) {
+	kind := strings.ToLower(op.Op)
+	p := strings.ToLower(strings.TrimSpace(op.Path))
+
+	if p == "" {
+		if kind == "remove" {
+			return nil, "", "", fmt.Errorf("%w: remove requires a path", ErrBadTarget)
+		}
+		var in Group
+		if err := json.Unmarshal(op.Value, &in); err != nil {
+			return nil, "", "", fmt.Errorf("%w: value must be an object", ErrBadValue)
+		}
+		if in.DisplayName != "" {
+			name = in.DisplayName
+		}
+		if in.ExternalID != "" {
+			code = in.ExternalID
+		}
+		if in.Members != nil {
+			ids, err := memberIDs(in.Members)
+			if err != nil {
+				return nil, "", "", err
+			}
+			if kind == "replace" {
+				members = ids
+			} else {
+				members = union(members, ids)
+			}
+		}
+		return members, name, code, nil
+	}
+
+	switch {
+	case p == "displayname":
+		v, err := stringValue(op.Value)
+		if err != nil {
+			return nil, "", "", err
+		}
+		name = v
+	case p == "externalid":
+		v, err := stringValue(op.Value)
+		if err != nil {
+			return nil, "", "", err
+		}
+		code = v
+	case p == "members":
+		var refs []Ref
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &refs); err != nil {
+				return nil, "", "", fmt.Errorf("%w: members must be an array", ErrBadValue)
+			}
+		}
+		ids, err := memberIDs(refs)
+		if err != nil {
+			return nil, "", "", err
+		}
+		switch kind {
+		case "add":
+			members = union(members, ids)
+		case "replace":
+			members = ids
+		case "remove":
+			if len(ids) == 0 {
+				members = nil
+			} else {
+				members = minus(members, ids)
+			}
+		}
+	case strings.HasPrefix(p, "members[") && kind == "remove":
+		// members[value eq "12"]
+		_, args, err := parseFilter(strings.TrimSuffix(op.Path[len("members["):], "]"),
+			map[string]string{"value": "value"}, 1)
+		if err != nil || len(args) != 1 {
+			return nil, "", "", fmt.Errorf("%w: %s", ErrBadTarget, op.Path)
+		}
+		id, err := strconv.Atoi(fmt.Sprint(args[0]))
+		if err != nil {
+			return nil, "", "", fmt.Errorf("%w: %s", ErrBadTarget, op.Path)
+		}
+		members = minus(members, []int{id})
+	default:
+		return nil, "", "", fmt.Errorf("%w: %s", ErrBadTarget, op.Path)
+	}
+	return members, name, code, nil
+}
+
+func union(a, b []int) []int {
+	seen := make(map[int]bool, len(a))
+	for _, v := range a {
+		seen[v] = true
+	}
+	for _, v := range b {
+		if !seen[v] {
+			a = append(a, v)
+			seen[v] = true
+		}
+	}
+	return a
+}
+
+func minus(a, b []int) []int {
+	drop := make(map[int]bool, len(b))
+	for _, v := range b {
+		drop[v] = true
+	}
+	out := make([]int, 0, len(a))
+	for _, v := range a {
+		if !drop[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
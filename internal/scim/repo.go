@@ -0,0 +1,421 @@
+package scim
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-sqlserver-demo/internal/user"
+)
+
+var (
+	ErrNotFound  = errors.New("resource not found")
+	ErrConflict  = errors.New("resource already exists")
+	ErrBadValue  = errors.New("invalid value")
+	ErrBadTarget = errors.New("invalid patch path")
+)
+
+// Repo: ผู้ใช้ทำผ่าน user.Repo (ให้ประวัติการแก้ไขครบ), บริษัท (group) ทำตรงที่นี่
+type Repo struct {
+	DB    *sql.DB
+	Users *user.Repo
+}
+
+func NewRepo(db *sql.DB) *Repo { return &Repo{DB: db, Users: user.NewRepo(db)} }
+
+// querier คือ *sql.DB หรือ *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// ===== Users =====
+
+func (r *Repo) ListUsers(ctx context.Context, filter string, startIndex, count int) ([]user.User, int, error) {
+	where, args, err := parseFilter(filter, userFilterAttrs, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, `SELECT COUNT(1) FROM dbo.users u WHERE `+where+`;`, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if count == 0 {
+		return []user.User{}, total, nil
+	}
+
+	n := len(args)
+	q := fmt.Sprintf(`
+SELECT u.id FROM dbo.users u
+WHERE %s
+ORDER BY u.id
+OFFSET @p%d ROWS FETCH NEXT @p%d ROWS ONLY;`, where, n+1, n+2)
+	rows, err := r.DB.QueryContext(ctx, q, append(args, startIndex-1, count)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	us, err := r.Users.ListByIDs(ctx, ids)
+	return us, total, err
+}
+
+func (r *Repo) GetUser(ctx context.Context, id int) (user.User, error) {
+	u, err := r.Users.GetByID(ctx, id)
+	if err == sql.ErrNoRows {
+		return user.User{}, ErrNotFound
+	}
+	return u, err
+}
+
+func (r *Repo) userIDByPersonCode(ctx context.Context, code string) (int, error) {
+	var id int
+	err := r.DB.QueryRowContext(ctx, `SELECT id FROM dbo.users WHERE person_code=@p1;`, code).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+func (r *Repo) CreateUser(ctx context.Context, in user.CreateUserInput) (user.User, error) {
+	if strings.TrimSpace(in.PersonCode) == "" {
+		return user.User{}, fmt.Errorf("%w: userName is required", ErrBadValue)
+	}
+	if id, err := r.userIDByPersonCode(ctx, in.PersonCode); err != nil {
+		return user.User{}, err
+	} else if id != 0 {
+		return user.User{}, ErrConflict
+	}
+	return r.Users.Create(ctx, 0, in)
+}
+
+func (r *Repo) UpdateUser(ctx context.Context, id int, in user.UpdateUserInput) (user.User, error) {
+	if in.PersonCode != nil {
+		other, err := r.userIDByPersonCode(ctx, *in.PersonCode)
+		if err != nil {
+			return user.User{}, err
+		}
+		if other != 0 && other != id {
+			return user.User{}, ErrConflict
+		}
+	}
+	u, err := r.Users.Update(ctx, 0, id, in)
+	if err == sql.ErrNoRows {
+		return user.User{}, ErrNotFound
+	}
+	return u, err
+}
+
+func (r *Repo) DeleteUser(ctx context.Context, id int) error {
+	if err := r.Users.Delete(ctx, 0, id); err != nil {
+		if errors.Is(err, user.ErrNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// ===== Groups (companies) =====
+
+type company struct {
+	ID        string
+	Code      string
+	Name      string
+	UpdatedAt time.Time
+	Members   []Ref
+}
+
+func (r *Repo) ListGroups(ctx context.Context, filter string, startIndex, count int) ([]company, int, error) {
+	where, args, err := parseFilter(filter, groupFilterAttrs, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, `SELECT COUNT(1) FROM dbo.companies c WHERE `+where+`;`, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	out := make([]company, 0)
+	if count == 0 {
+		return out, total, nil
+	}
+
+	n := len(args)
+	q := fmt.Sprintf(`
+SELECT CAST(c.id AS nvarchar(36)), c.code, c.name, c.updated_at
+FROM dbo.companies c
+WHERE %s
+ORDER BY c.code, c.id
+OFFSET @p%d ROWS FETCH NEXT @p%d ROWS ONLY;`, where, n+1, n+2)
+	rows, err := r.DB.QueryContext(ctx, q, append(args, startIndex-1, count)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g company
+		if err := rows.Scan(&g.ID, &g.Code, &g.Name, &g.UpdatedAt); err != nil {
+			return nil, 0, err
+		}
+		out = append(out, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// สมาชิกของทั้งหน้าในคิวรีเดียว (กัน N+1)
+	ids := make([]string, 0, len(out))
+	for _, g := range out {
+		ids = append(ids, g.ID)
+	}
+	byGroup, err := loadMembers(ctx, r.DB, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range out {
+		out[i].Members = byGroup[out[i].ID]
+	}
+	return out, total, nil
+}
+
+func (r *Repo) GetGroup(ctx context.Context, id string) (company, error) {
+	var g company
+	err := r.DB.QueryRowContext(ctx, `
+SELECT CAST(id AS nvarchar(36)), code, name, updated_at
+FROM dbo.companies WHERE CAST(id AS nvarchar(36))=@p1;`, id).
+		Scan(&g.ID, &g.Code, &g.Name, &g.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return company{}, ErrNotFound
+	}
+	if err != nil {
+		return company{}, err
+	}
+	byGroup, err := loadMembers(ctx, r.DB, []string{g.ID})
+	g.Members = byGroup[g.ID]
+	return g, err
+}
+
+// loadMembers ดึงสมาชิกของหลายบริษัทในคิวรีเดียว คืนค่าเป็น map[company_id][]Ref
+func loadMembers(ctx context.Context, db querier, companyIDs []string) (map[string][]Ref, error) {
+	out := make(map[string][]Ref, len(companyIDs))
+	if len(companyIDs) == 0 {
+		return out, nil
+	}
+	rows, err := db.QueryContext(ctx, `
+SELECT CAST(uc.company_id AS nvarchar(36)), u.id, u.name
+FROM dbo.user_companies uc
+JOIN dbo.users u ON u.id = uc.user_id
+WHERE uc.company_id IN (SELECT TRY_CAST(value AS uniqueidentifier) FROM STRING_SPLIT(@p1, ','))
+ORDER BY uc.company_id, u.id;`, strings.Join(companyIDs, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid string
+			id  int
+			m   Ref
+		)
+		if err := rows.Scan(&cid, &id, &m.Display); err != nil {
+			return nil, err
+		}
+		m.Value = strconv.Itoa(id)
+		out[cid] = append(out[cid], m)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repo) CreateGroup(ctx context.Context, name, code string, members []int) (company, error) {
+	if strings.TrimSpace(name) == "" {
+		return company{}, fmt.Errorf("%w: displayName is required", ErrBadValue)
+	}
+	if code == "" {
+		code = name
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return company{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(1) FROM dbo.companies WITH (UPDLOCK, HOLDLOCK) WHERE code=@p1;`, code).Scan(&exists); err != nil {
+		return company{}, err
+	}
+	if exists > 0 {
+		return company{}, ErrConflict
+	}
+
+	var id string
+	if err := tx.QueryRowContext(ctx, `
+INSERT INTO dbo.companies(code, name, updated_at)
+OUTPUT CAST(inserted.id AS nvarchar(36))
+VALUES(@p1, @p2, SYSUTCDATETIME());`, code, name).Scan(&id); err != nil {
+		return company{}, err
+	}
+	if err := r.setMembers(ctx, tx, id, members); err != nil {
+		return company{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return company{}, err
+	}
+	return r.GetGroup(ctx, id)
+}
+
+// ReplaceGroup แทนที่ชื่อ/รหัส และ (ถ้า members != nil) รายชื่อสมาชิกทั้งหมด
+func (r *Repo) ReplaceGroup(ctx context.Context, id, name, code string, members *[]int) (company, error) {
+	g, err := r.GetGroup(ctx, id)
+	if err != nil {
+		return company{}, err
+	}
+	if name == "" {
+		name = g.Name
+	}
+	if code == "" {
+		code = g.Code
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return company{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+UPDATE dbo.companies SET name=@p2, code=@p3, updated_at=SYSUTCDATETIME()
+WHERE CAST(id AS nvarchar(36))=@p1;`, g.ID, name, code); err != nil {
+		return company{}, err
+	}
+	if members != nil {
+		if err := r.setMembers(ctx, tx, g.ID, *members); err != nil {
+			return company{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return company{}, err
+	}
+	return r.GetGroup(ctx, g.ID)
+}
+
+func (r *Repo) DeleteGroup(ctx context.Context, id string) error {
+	g, err := r.GetGroup(ctx, id)
+	if err != nil {
+		return err
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// ถอดสมาชิกผ่าน user.Repo ก่อน เพื่อให้มีประวัติ
+	if err := r.setMembers(ctx, tx, g.ID, nil); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM dbo.companies WHERE CAST(id AS nvarchar(36))=@p1;`, g.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setMembers ทำให้สมาชิกของบริษัทเท่ากับ userIDs (ภายใน tx ของผู้เรียก)
+func (r *Repo) setMembers(ctx context.Context, tx *sql.Tx, companyID string, userIDs []int) error {
+	byGroup, err := loadMembers(ctx, tx, []string{companyID})
+	if err != nil {
+		return err
+	}
+	current := byGroup[companyID]
+	want := make(map[int]bool, len(userIDs))
+	for _, id := range userIDs {
+		want[id] = true
+	}
+	have := make(map[int]bool, len(current))
+	for _, m := range current {
+		id, _ := strconv.Atoi(m.Value)
+		have[id] = true
+	}
+
+	var add, remove []int
+	for id := range want {
+		if !have[id] {
+			add = append(add, id)
+		}
+	}
+	for id := range have {
+		if !want[id] {
+			remove = append(remove, id)
+		}
+	}
+	return r.changeMembers(ctx, tx, companyID, add, remove)
+}
+
+// changeMembers เพิ่ม/ถอดสมาชิกทีละคนผ่าน user.Repo.UpdateTx (บันทึกประวัติ + updated_at ของ user)
+// ทั้งหมดอยู่ใน tx เดียว: คนใดคนหนึ่งล้มเหลว → ไม่มีใครถูกเปลี่ยน
+func (r *Repo) changeMembers(ctx context.Context, tx *sql.Tx, companyID string, add, remove []int) error {
+	apply := func(uid int, join bool) error {
+		u, err := r.Users.GetByIDTx(ctx, tx, uid)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: member %d does not exist", ErrBadValue, uid)
+		}
+		if err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(u.Company)+1)
+		found := false
+		for _, c := range u.Company {
+			if strings.EqualFold(c.ID, companyID) {
+				found = true
+				if !join {
+					continue
+				}
+			}
+			ids = append(ids, c.ID)
+		}
+		if join == found {
+			return nil
+		}
+		if join {
+			ids = append(ids, companyID)
+		}
+		_, err = r.Users.UpdateTx(ctx, tx, 0, uid, user.UpdateUserInput{CompanyIDs: &ids})
+		return err
+	}
+
+	for _, uid := range add {
+		if err := apply(uid, true); err != nil {
+			return err
+		}
+	}
+	for _, uid := range remove {
+		if err := apply(uid, false); err != nil {
+			return err
+		}
+	}
+	if len(add) > 0 || len(remove) > 0 {
+		_, err := tx.ExecContext(ctx, `UPDATE dbo.companies SET updated_at=SYSUTCDATETIME() WHERE CAST(id AS nvarchar(36))=@p1;`, companyID)
+		return err
+	}
+	return nil
+}
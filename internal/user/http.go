@@ -4,10 +4,14 @@ import (
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 type Handler struct {
 	Repo *Repo
+
+	// HasRole ตรวจ role ของผู้เรียก (auth.HasRole; user import auth ไม่ได้เพราะ auth ใช้ user)
+	HasRole func(c *fiber.Ctx, roles ...string) bool
 }
 
 func NewHandler(r *Repo) *Handler { return &Handler{Repo: r} }
@@ -18,6 +22,20 @@ func (h *Handler) RegisterRoutes(r fiber.Router) {
 	r.Post("/", h.create)
 	r.Patch("/:id", h.update)
 	r.Delete("/:id", h.remove)
+	r.Get("/:id/history", h.history)
+}
+
+// actorID ดึง user id ของผู้ทำรายการจาก JWT (0 = ไม่ทราบ)
+func actorID(c *fiber.Ctx) int {
+	claims, ok := c.Locals("user").(jwt.MapClaims)
+	if !ok {
+		return 0
+	}
+	sub, ok := claims["sub"].(float64)
+	if !ok {
+		return 0
+	}
+	return int(sub)
 }
 
 func (h *Handler) list(c *fiber.Ctx) error {
@@ -49,7 +67,7 @@ func (h *Handler) create(c *fiber.Ctx) error {
 	if err := c.BodyParser(&in); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
 	}
-	u, err := h.Repo.Create(c.Context(), in)
+	u, err := h.Repo.Create(c.Context(), actorID(c), in)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -62,7 +80,7 @@ func (h *Handler) update(c *fiber.Ctx) error {
 	if err := c.BodyParser(&in); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
 	}
-	u, err := h.Repo.Update(c.Context(), id, in)
+	u, err := h.Repo.Update(c.Context(), actorID(c), id, in)
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
 	}
@@ -71,8 +89,28 @@ func (h *Handler) update(c *fiber.Ctx) error {
 
 func (h *Handler) remove(c *fiber.Ctx) error {
 	id, _ := strconv.Atoi(c.Params("id"))
-	if err := h.Repo.Delete(c.Context(), id); err != nil {
+	if err := h.Repo.Delete(c.Context(), actorID(c), id); err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
 	}
 	return c.SendStatus(204)
 }
+
+// history ต้องมี token: ดูได้เฉพาะของตัวเอง หรือ hr/admin ดูได้ทุกคน
+func (h *Handler) history(c *fiber.Ctx) error {
+	id, _ := strconv.Atoi(c.Params("id"))
+	uid := actorID(c)
+	if uid == 0 {
+		return c.Status(401).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	if uid != id && (h.HasRole == nil || !h.HasRole(c, "hr", "admin")) {
+		return c.Status(403).JSON(fiber.Map{"error": "forbidden"})
+	}
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+
+	out, err := h.Repo.ListHistory(c.Context(), id, limit, offset)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"data": out})
+}
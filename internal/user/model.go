@@ -26,6 +26,8 @@ type User struct {
 	StartDate   string    `json:"start_date"`
 	ConfirmDate string    `json:"confirm_date"`
 	YearsOfWork int       `json:"years_of_work"`
+	ManagerID   *int      `json:"manager_id"`
+	Active      bool      `json:"active"`
 }
 
 type CreateUserInput struct {
@@ -40,6 +42,8 @@ type CreateUserInput struct {
 	StartDate   string   `json:"start_date"`
 	ConfirmDate string   `json:"confirm_date"`
 	CompanyIDs  []string `json:"company_ids"`
+	ManagerID   *int     `json:"manager_id,omitempty"`
+	Active      *bool    `json:"active,omitempty"` // nil = true
 }
 
 type UpdateUserInput struct {
@@ -54,4 +58,28 @@ type UpdateUserInput struct {
 	StartDate   *string   `json:"start_date,omitempty"`
 	ConfirmDate *string   `json:"confirm_date,omitempty"`
 	CompanyIDs  *[]string `json:"company_ids,omitempty"`
+	ManagerID   *int      `json:"manager_id,omitempty"` // 0 = ล้างหัวหน้า
+	Active      *bool     `json:"active,omitempty"`     // false = ปิดบัญชี (login ไม่ได้)
+}
+
+// ประวัติการเปลี่ยนแปลงข้อมูลผู้ใช้
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+type FieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+type HistoryEntry struct {
+	ID        int64                  `json:"id"`
+	UserID    int                    `json:"user_id"`
+	ActorID   *int                   `json:"actor_id"`
+	ActorName string                 `json:"actor_name,omitempty"`
+	Action    string                 `json:"action"`
+	Changes   map[string]FieldChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
 }
@@ -3,18 +3,32 @@ package user
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrNotFound = errors.New("not found")
+
 type Repo struct {
 	DB *sql.DB
+	// BeforeDelete ให้โมดูลอื่นลบข้อมูลที่อ้างถึง user แบบไม่ cascade (รันใน tx เดียวกับการลบ)
+	BeforeDelete []func(ctx context.Context, tx *sql.Tx, userID int) error
 }
 
 func NewRepo(db *sql.DB) *Repo { return &Repo{DB: db} }
 
+// querier คือ *sql.DB หรือ *sql.Tx (ให้อ่าน/เขียนในธุรกรรมเดียวกับการบันทึกประวัติได้)
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 func hash(pw string) ([]byte, error) {
 	if strings.TrimSpace(pw) == "" {
 		return nil, nil
@@ -22,24 +36,35 @@ func hash(pw string) ([]byte, error) {
 	return bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
 }
 
-func (r *Repo) Create(ctx context.Context, in CreateUserInput) (User, error) {
+func (r *Repo) Create(ctx context.Context, actorID int, in CreateUserInput) (User, error) {
 	pwHash, err := hash(in.Password)
 	if err != nil {
 		return User{}, err
 	}
 
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
 	const q = `
 INSERT INTO dbo.users
-(name, email, role, password_hash, person_code, position, department, url_image, start_date, confirm_date, years_of_work, created_at, updated_at)
+(name, email, role, password_hash, person_code, position, department, url_image, start_date, confirm_date, manager_id, active, years_of_work, created_at, updated_at)
 OUTPUT inserted.id, inserted.name, inserted.email, inserted.role, inserted.person_code, inserted.position, inserted.department, inserted.url_image, inserted.start_date, inserted.confirm_date, inserted.years_of_work, inserted.created_at, inserted.updated_at
-VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9,@p10,0,SYSUTCDATETIME(),SYSUTCDATETIME());
+VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9,@p10,@p11,@p12,0,SYSUTCDATETIME(),SYSUTCDATETIME());
 `
 
+	active := true
+	if in.Active != nil {
+		active = *in.Active
+	}
+
 	var u User
-	err = r.DB.QueryRowContext(ctx, q,
+	err = tx.QueryRowContext(ctx, q,
 		in.Name, in.Email, in.Role, pwHash,
 		in.PersonCode, in.Position, in.Department,
-		in.UrlImage, in.StartDate, in.ConfirmDate,
+		in.UrlImage, in.StartDate, in.ConfirmDate, in.ManagerID, active,
 	).Scan(&u.ID, &u.Name, &u.Email, &u.Role,
 		&u.PersonCode, &u.Position, &u.Department,
 		&u.UrlImage, &u.StartDate, &u.ConfirmDate,
@@ -51,52 +76,60 @@ VALUES(@p1,@p2,@p3,@p4,@p5,@p6,@p7,@p8,@p9,@p10,0,SYSUTCDATETIME(),SYSUTCDATETIM
 
 	if len(in.CompanyIDs) > 0 {
 		for _, cid := range in.CompanyIDs {
-			_, _ = r.DB.ExecContext(ctx,
+			_, _ = tx.ExecContext(ctx,
 				"INSERT INTO dbo.user_companies(user_id, company_id) VALUES(@p1,@p2);",
 				u.ID, cid,
 			)
 		}
 	}
 
-	return r.GetByID(ctx, u.ID)
+	after, err := getByID(ctx, tx, u.ID)
+	if err != nil {
+		return User{}, err
+	}
+	if err := recordHistory(ctx, tx, u.ID, actorID, ActionCreate, diffUser(User{}, after, false)); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return after, nil
 }
 
 func (r *Repo) GetByID(ctx context.Context, id int) (User, error) {
+	return getByID(ctx, r.DB, id)
+}
+
+// GetByIDTx อ่านผู้ใช้ภายในธุรกรรม (เห็นการแก้ไขที่ยังไม่ commit ของ tx นั้น)
+func (r *Repo) GetByIDTx(ctx context.Context, tx *sql.Tx, id int) (User, error) {
+	return getByID(ctx, tx, id)
+}
+
+func getByID(ctx context.Context, db querier, id int) (User, error) {
 	const q = `
 SELECT id, name, email, role, person_code, position, department, url_image,
-       start_date, confirm_date, years_of_work, created_at, updated_at
+       start_date, confirm_date, years_of_work, created_at, updated_at, manager_id, active
 FROM dbo.users WHERE id=@p1;
 `
 	var u User
-	err := r.DB.QueryRowContext(ctx, q, id).Scan(
+	var mgr sql.NullInt64
+	err := db.QueryRowContext(ctx, q, id).Scan(
 		&u.ID, &u.Name, &u.Email, &u.Role,
 		&u.PersonCode, &u.Position, &u.Department,
 		&u.UrlImage, &u.StartDate, &u.ConfirmDate,
-		&u.YearsOfWork, &u.CreatedAt, &u.UpdatedAt,
+		&u.YearsOfWork, &u.CreatedAt, &u.UpdatedAt, &mgr, &u.Active,
 	)
 	if err != nil {
 		return User{}, err
 	}
+	u.ManagerID = nullIntPtr(mgr)
 
 	// companies
-	const qc = `
-SELECT c.id, c.code, c.name, c.image
-FROM dbo.companies c
-JOIN dbo.user_companies uc ON uc.company_id=c.id
-WHERE uc.user_id=@p1;
-`
-	rows, err := r.DB.QueryContext(ctx, qc, id)
+	byUser, err := loadCompanies(ctx, db, []int{id})
 	if err != nil {
 		return u, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var c Company
-		if err := rows.Scan(&c.ID, &c.Code, &c.Name, &c.Image); err != nil {
-			return u, err
-		}
-		u.Company = append(u.Company, c)
-	}
+	u.Company = byUser[id]
 	return u, nil
 }
 
@@ -110,7 +143,7 @@ func (r *Repo) List(ctx context.Context, limit, offset int) ([]User, error) {
 
 	const q = `
 SELECT id, name, email, role, person_code, position, department, url_image,
-       start_date, confirm_date, years_of_work, created_at, updated_at
+       start_date, confirm_date, years_of_work, created_at, updated_at, manager_id, active
 FROM dbo.users
 ORDER BY id DESC
 OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY;
@@ -121,28 +154,83 @@ OFFSET @p1 ROWS FETCH NEXT @p2 ROWS ONLY;
 	}
 	defer rows.Close()
 
+	return r.scanWithCompanies(ctx, rows)
+}
+
+// ListByIDs ดึงผู้ใช้ตามรายการ id (เรียงตาม id) พร้อมบริษัท
+func (r *Repo) ListByIDs(ctx context.Context, userIDs []int) ([]User, error) {
+	if len(userIDs) == 0 {
+		return []User{}, nil
+	}
+	const q = `
+SELECT id, name, email, role, person_code, position, department, url_image,
+       start_date, confirm_date, years_of_work, created_at, updated_at, manager_id, active
+FROM dbo.users
+WHERE id IN (SELECT CAST(value AS int) FROM STRING_SPLIT(@p1, ','))
+ORDER BY id;
+`
+	rows, err := r.DB.QueryContext(ctx, q, joinIDs(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return r.scanWithCompanies(ctx, rows)
+}
+
+func (r *Repo) scanWithCompanies(ctx context.Context, rows *sql.Rows) ([]User, error) {
 	var out []User
+	var ids []int
 	for rows.Next() {
 		var u User
+		var mgr sql.NullInt64
 		if err := rows.Scan(
 			&u.ID, &u.Name, &u.Email, &u.Role,
 			&u.PersonCode, &u.Position, &u.Department,
 			&u.UrlImage, &u.StartDate, &u.ConfirmDate,
-			&u.YearsOfWork, &u.CreatedAt, &u.UpdatedAt,
+			&u.YearsOfWork, &u.CreatedAt, &u.UpdatedAt, &mgr, &u.Active,
 		); err != nil {
 			return nil, err
 		}
+		u.ManagerID = nullIntPtr(mgr)
+		out = append(out, u)
+		ids = append(ids, u.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		companies, _, _ := r.ListCompaniesByUser(ctx, u.ID)
-		u.Company = companies
+	// โหลดบริษัทของทั้งหน้าในคิวรีเดียว (กัน N+1)
+	byUser, err := r.LoadCompanies(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Company = byUser[out[i].ID]
+	}
+	return out, nil
+}
 
-		out = append(out, u)
+func (r *Repo) Update(ctx context.Context, actorID, id int, in UpdateUserInput) (User, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, err
 	}
-	return out, rows.Err()
+	defer func() { _ = tx.Rollback() }()
+
+	after, err := r.UpdateTx(ctx, tx, actorID, id, in)
+	if err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return after, nil
 }
 
-func (r *Repo) Update(ctx context.Context, id int, in UpdateUserInput) (User, error) {
-	u, err := r.GetByID(ctx, id)
+// UpdateTx แก้ไขผู้ใช้และบันทึกประวัติใน tx ของผู้เรียก (ผู้เรียก commit/rollback เอง)
+func (r *Repo) UpdateTx(ctx context.Context, tx *sql.Tx, actorID, id int, in UpdateUserInput) (User, error) {
+	u, err := getByID(ctx, tx, id)
 	if err != nil {
 		return User{}, err
 	}
@@ -156,6 +244,8 @@ func (r *Repo) Update(ctx context.Context, id int, in UpdateUserInput) (User, er
 	urlImage := u.UrlImage
 	startDate := u.StartDate
 	confirmDate := u.ConfirmDate
+	managerID := u.ManagerID
+	active := u.Active
 	var pwHash []byte = nil
 
 	if in.Name != nil {
@@ -185,6 +275,16 @@ func (r *Repo) Update(ctx context.Context, id int, in UpdateUserInput) (User, er
 	if in.ConfirmDate != nil {
 		confirmDate = *in.ConfirmDate
 	}
+	if in.ManagerID != nil {
+		if *in.ManagerID == 0 {
+			managerID = nil
+		} else {
+			managerID = in.ManagerID
+		}
+	}
+	if in.Active != nil {
+		active = *in.Active
+	}
 	if in.Password != nil && strings.TrimSpace(*in.Password) != "" {
 		pwHash, err = hash(*in.Password)
 		if err != nil {
@@ -198,64 +298,245 @@ SET name=@p1, email=@p2, role=@p3,
     person_code=@p4, position=@p5, department=@p6, url_image=@p7,
     start_date=@p8, confirm_date=@p9,
     password_hash=COALESCE(@p10, password_hash),
+    manager_id=@p12, active=@p13,
     updated_at=SYSUTCDATETIME()
 WHERE id=@p11;
 `
-	_, err = r.DB.ExecContext(ctx, q,
+	_, err = tx.ExecContext(ctx, q,
 		name, email, role,
 		personCode, position, department, urlImage,
-		startDate, confirmDate, pwHash, id,
+		startDate, confirmDate, pwHash, id, managerID, active,
 	)
 	if err != nil {
 		return User{}, err
 	}
 
 	if in.CompanyIDs != nil {
-		_, _ = r.DB.ExecContext(ctx, "DELETE FROM dbo.user_companies WHERE user_id=@p1;", id)
+		_, _ = tx.ExecContext(ctx, "DELETE FROM dbo.user_companies WHERE user_id=@p1;", id)
 		for _, cid := range *in.CompanyIDs {
-			_, _ = r.DB.ExecContext(ctx,
+			_, _ = tx.ExecContext(ctx,
 				"INSERT INTO dbo.user_companies(user_id, company_id) VALUES(@p1,@p2);",
 				id, cid,
 			)
 		}
 	}
 
-	return r.GetByID(ctx, id)
+	after, err := getByID(ctx, tx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if changes := diffUser(u, after, pwHash != nil); len(changes) > 0 {
+		if err := recordHistory(ctx, tx, id, actorID, ActionUpdate, changes); err != nil {
+			return User{}, err
+		}
+	}
+	return after, nil
 }
 
-func (r *Repo) Delete(ctx context.Context, id int) error {
+func (r *Repo) Delete(ctx context.Context, actorID, id int) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := getByID(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// ลูกน้องที่ชี้มาที่คนนี้ → ล้าง manager_id ก่อน (FK ชี้ตัวเองใช้ SET NULL ไม่ได้)
+	if _, err := tx.ExecContext(ctx, `UPDATE dbo.users SET manager_id=NULL WHERE manager_id=@p1;`, id); err != nil {
+		return err
+	}
+	for _, hook := range r.BeforeDelete {
+		if err := hook(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+
 	const q = `DELETE FROM dbo.users WHERE id=@p1;`
-	res, err := r.DB.ExecContext(ctx, q, id)
+	res, err := tx.ExecContext(ctx, q, id)
 	if err != nil {
 		return err
 	}
 	aff, _ := res.RowsAffected()
 	if aff == 0 {
-		return errors.New("not found")
+		return ErrNotFound
 	}
-	return nil
+	if err := recordHistory(ctx, tx, id, actorID, ActionDelete, diffUser(before, User{}, false)); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (r *Repo) ListCompaniesByUser(ctx context.Context, userID int) ([]Company, int, error) {
+	byUser, err := r.LoadCompanies(ctx, []int{userID})
+	if err != nil {
+		return nil, 0, err
+	}
+	out := byUser[userID]
+	return out, len(out), nil
+}
+
+// LoadCompanies ดึงบริษัทของผู้ใช้หลายคนในคิวรีเดียว คืนค่าเป็น map[user_id][]Company
+func (r *Repo) LoadCompanies(ctx context.Context, userIDs []int) (map[int][]Company, error) {
+	return loadCompanies(ctx, r.DB, userIDs)
+}
+
+func loadCompanies(ctx context.Context, db querier, userIDs []int) (map[int][]Company, error) {
+	out := make(map[int][]Company, len(userIDs))
+	if len(userIDs) == 0 {
+		return out, nil
+	}
+
 	const q = `
-SELECT c.id, c.code, c.name, c.image
+SELECT uc.user_id, c.id, c.code, c.name, ISNULL(c.image,'')
 FROM dbo.companies c
 JOIN dbo.user_companies uc ON uc.company_id=c.id
-WHERE uc.user_id=@p1;
+WHERE uc.user_id IN (SELECT CAST(value AS int) FROM STRING_SPLIT(@p1, ','))
+ORDER BY uc.user_id, c.code;
 `
-	rows, err := r.DB.QueryContext(ctx, q, userID)
+	rows, err := db.QueryContext(ctx, q, joinIDs(userIDs))
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
-	var out []Company
 	for rows.Next() {
+		var uid int
 		var c Company
-		if err := rows.Scan(&c.ID, &c.Code, &c.Name, &c.Image); err != nil {
-			return nil, 0, err
+		if err := rows.Scan(&uid, &c.ID, &c.Code, &c.Name, &c.Image); err != nil {
+			return nil, err
+		}
+		out[uid] = append(out[uid], c)
+	}
+	return out, rows.Err()
+}
+
+// joinIDs แปลง []int เป็น "1,2,3" สำหรับ STRING_SPLIT
+func joinIDs(ids []int) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.Itoa(id))
+	}
+	return strings.Join(parts, ",")
+}
+
+func nullIntPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+func derefInt(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// ===== History =====
+
+// userSnapshot คืนค่าฟิลด์ที่ต้องการติดตามประวัติ (ไม่รวม password / timestamps)
+func userSnapshot(u User) map[string]any {
+	companies := make([]string, 0, len(u.Company))
+	for _, c := range u.Company {
+		companies = append(companies, c.Code)
+	}
+	sort.Strings(companies)
+	return map[string]any{
+		"name":         u.Name,
+		"email":        u.Email,
+		"role":         u.Role,
+		"personcode":   u.PersonCode,
+		"position":     u.Position,
+		"department":   u.Department,
+		"urlimage":     u.UrlImage,
+		"start_date":   u.StartDate,
+		"confirm_date": u.ConfirmDate,
+		"company":      strings.Join(companies, ","),
+		"manager_id":   derefInt(u.ManagerID),
+		"active":       u.Active,
+	}
+}
+
+// diffUser เทียบค่า before/after ทีละฟิลด์ (User{} = ไม่มีค่า ใช้กับ create/delete)
+func diffUser(before, after User, passwordChanged bool) map[string]FieldChange {
+	b, a := userSnapshot(before), userSnapshot(after)
+	out := make(map[string]FieldChange)
+	for k, bv := range b {
+		if av := a[k]; av != bv {
+			out[k] = FieldChange{Before: bv, After: av}
 		}
-		out = append(out, c)
 	}
-	return out, len(out), rows.Err()
+	if passwordChanged {
+		out["password"] = FieldChange{Before: "***", After: "***"}
+	}
+	return out
+}
+
+func recordHistory(ctx context.Context, db querier, userID, actorID int, action string, changes map[string]FieldChange) error {
+	raw, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	var actor any
+	if actorID > 0 {
+		actor = actorID
+	}
+	_, err = db.ExecContext(ctx, `
+INSERT INTO dbo.user_history(user_id, actor_id, action, changes)
+VALUES(@p1,@p2,@p3,@p4);`, userID, actor, action, string(raw))
+	return err
+}
+
+// ListHistory ประวัติของผู้ใช้ ใหม่สุดก่อน
+func (r *Repo) ListHistory(ctx context.Context, userID, limit, offset int) ([]HistoryEntry, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	const q = `
+SELECT h.id, h.user_id, h.actor_id, ISNULL(u.name,''), h.action, h.changes, h.created_at
+FROM dbo.user_history h
+LEFT JOIN dbo.users u ON u.id = h.actor_id
+WHERE h.user_id=@p1
+ORDER BY h.id DESC
+OFFSET @p2 ROWS FETCH NEXT @p3 ROWS ONLY;
+`
+	rows, err := r.DB.QueryContext(ctx, q, userID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]HistoryEntry, 0)
+	for rows.Next() {
+		var (
+			h     HistoryEntry
+			actor sql.NullInt64
+			raw   string
+		)
+		if err := rows.Scan(&h.ID, &h.UserID, &actor, &h.ActorName, &h.Action, &raw, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		if actor.Valid {
+			id := int(actor.Int64)
+			h.ActorID = &id
+		}
+		if err := json.Unmarshal([]byte(raw), &h.Changes); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
 }
@@ -0,0 +1,254 @@
+// Package xlsx เขียนไฟล์ Excel (.xlsx) แบบ stream: เขียนทีละแถวลง zip ทันที
+// ใช้ inline string (ไม่มี sharedStrings) จึงไม่ต้องเก็บทั้ง workbook ไว้ในหน่วยความจำ
+package xlsx
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrClosed = errors.New("xlsx: writer closed")
+
+// style id ใน styles.xml
+const (
+	styleDefault = 0
+	styleHeader  = 1
+)
+
+type Writer struct {
+	zw     *zip.Writer
+	sheets []string
+	cur    *bufio.Writer
+	row    int
+	closed bool
+}
+
+func NewWriter(w io.Writer) *Writer { return &Writer{zw: zip.NewWriter(w)} }
+
+// StartSheet ปิดชีตก่อนหน้าแล้วเริ่มชีตใหม่ (header เป็นตัวหนา + ตรึงแถวแรก)
+func (w *Writer) StartSheet(name string, header ...string) error {
+	if w.closed {
+		return ErrClosed
+	}
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+	w.sheets = append(w.sheets, sheetName(name, len(w.sheets)+1))
+	f, err := w.zw.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(w.sheets)))
+	if err != nil {
+		return err
+	}
+	w.cur = bufio.NewWriter(f)
+	w.row = 0
+
+	w.cur.WriteString(xmlHeader + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	if len(header) > 0 {
+		w.cur.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+		w.cur.WriteString(`<cols>`)
+		for i, h := range header {
+			width := max(10, min(60, len([]rune(h))+4))
+			fmt.Fprintf(w.cur, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, width)
+		}
+		w.cur.WriteString(`</cols>`)
+	}
+	w.cur.WriteString(`<sheetData>`)
+	if len(header) > 0 {
+		cells := make([]any, len(header))
+		for i, h := range header {
+			cells[i] = h
+		}
+		return w.writeRow(styleHeader, cells)
+	}
+	return nil
+}
+
+// WriteRow รองรับ string/[]byte, int/int64, float64, bool, time.Time (เป็นข้อความ yyyy-mm-dd), nil = ช่องว่าง
+func (w *Writer) WriteRow(cells ...any) error {
+	if w.closed {
+		return ErrClosed
+	}
+	if w.cur == nil {
+		return errors.New("xlsx: StartSheet must be called first")
+	}
+	return w.writeRow(styleDefault, cells)
+}
+
+func (w *Writer) writeRow(style int, cells []any) error {
+	w.row++
+	fmt.Fprintf(w.cur, `<row r="%d">`, w.row)
+	for i, v := range cells {
+		ref := ColName(i) + strconv.Itoa(w.row)
+		s := ""
+		if style != styleDefault {
+			s = fmt.Sprintf(` s="%d"`, style)
+		}
+		switch x := v.(type) {
+		case nil:
+			continue
+		case string:
+			if x == "" {
+				continue
+			}
+			fmt.Fprintf(w.cur, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, ref, s)
+			escape(w.cur, x)
+			w.cur.WriteString(`</t></is></c>`)
+		case []byte:
+			fmt.Fprintf(w.cur, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, ref, s)
+			escape(w.cur, string(x))
+			w.cur.WriteString(`</t></is></c>`)
+		case int:
+			fmt.Fprintf(w.cur, `<c r="%s"%s><v>%d</v></c>`, ref, s, x)
+		case int64:
+			fmt.Fprintf(w.cur, `<c r="%s"%s><v>%d</v></c>`, ref, s, x)
+		case float64:
+			fmt.Fprintf(w.cur, `<c r="%s"%s><v>%s</v></c>`, ref, s, strconv.FormatFloat(x, 'f', -1, 64))
+		case bool:
+			b := 0
+			if x {
+				b = 1
+			}
+			fmt.Fprintf(w.cur, `<c r="%s"%s t="b"><v>%d</v></c>`, ref, s, b)
+		case time.Time:
+			fmt.Fprintf(w.cur, `<c r="%s"%s t="inlineStr"><is><t>%s</t></is></c>`, ref, s, x.Format("2006-01-02"))
+		default:
+			fmt.Fprintf(w.cur, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, ref, s)
+			escape(w.cur, fmt.Sprint(x))
+			w.cur.WriteString(`</t></is></c>`)
+		}
+	}
+	_, err := w.cur.WriteString(`</row>`)
+	return err
+}
+
+func (w *Writer) endSheet() error {
+	if w.cur == nil {
+		return nil
+	}
+	w.cur.WriteString(`</sheetData></worksheet>`)
+	err := w.cur.Flush()
+	w.cur = nil
+	return err
+}
+
+// Close ปิดชีตสุดท้าย แล้วเขียน workbook / rels / content types / styles
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	if len(w.sheets) == 0 {
+		if err := w.StartSheet("Sheet1"); err != nil {
+			return err
+		}
+	}
+	if err := w.endSheet(); err != nil {
+		return err
+	}
+	w.closed = true
+
+	var ct, wb, rels strings.Builder
+	ct.WriteString(xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	wb.WriteString(xmlHeader + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	rels.WriteString(xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, name := range w.sheets {
+		n := i + 1
+		fmt.Fprintf(&ct, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		wb.WriteString(`<sheet name="`)
+		escape(&wb, name)
+		fmt.Fprintf(&wb, `" sheetId="%d" r:id="rId%d"/>`, n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+	ct.WriteString(`</Types>`)
+	wb.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(w.sheets)+1)
+
+	files := []struct{ name, body string }{
+		{"[Content_Types].xml", ct.String()},
+		{"_rels/.rels", xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", wb.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		{"xl/styles.xml", stylesXML},
+	}
+	for _, f := range files {
+		fw, err := w.zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.body); err != nil {
+			return err
+		}
+	}
+	return w.zw.Close()
+}
+
+// ColName 0 → A, 25 → Z, 26 → AA
+func ColName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// sheetName ตัดอักขระต้องห้าม และยาวไม่เกิน 31 ตัว
+func sheetName(name string, n int) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if rs := []rune(name); len(rs) > 31 {
+		name = string(rs[:31])
+	}
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", n)
+	}
+	return name
+}
+
+// escape เขียนข้อความแบบ XML escape และตัดอักขระควบคุมที่ XML ไม่ยอมรับ
+func escape(w io.StringWriter, s string) {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r == '"':
+			b.WriteString("&quot;")
+		case r == '\t' || r == '\n' || r == '\r':
+			b.WriteRune(r)
+		case r < 0x20 || r == 0xFFFE || r == 0xFFFF:
+			// ข้าม
+		default:
+			b.WriteRune(r)
+		}
+	}
+	w.WriteString(b.String())
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const stylesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+	`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
@@ -52,11 +52,19 @@ func main() {
 		jwtTTL = 15 * time.Minute
 	}
 
+	scoreDecimals, err := strconv.Atoi(mustEnv("SCORE_DECIMALS", "2"))
+	if err != nil {
+		scoreDecimals = 2
+	}
+
 	routes.Register(app, routes.Options{
 		DB:        sqlDB,
 		JWTSecret: jwtSecret,
 		JWTIssuer: jwtIssuer,
 		JWTTTL:    jwtTTL,
+		SCIMToken: mustEnv("SCIM_TOKEN", ""),
+
+		ScoreDecimals: scoreDecimals,
 	})
 
 	addr := ":" + mustEnv("APP_PORT", "8080")